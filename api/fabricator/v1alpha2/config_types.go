@@ -0,0 +1,259 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// FabricatorConfig is the component configs in the same format as in config.yaml, commonly changed settings (refs,
+// TLS, K3s CIDRs, DHCP mode) are typed so they're validated by the API server, the rest of the fields are preserved
+// as is and validated by the components themselves
+type FabricatorConfig struct {
+	Base *BaseConfig `json:"base,omitempty"`
+	//+kubebuilder:pruning:PreserveUnknownFields
+	//+kubebuilder:validation:Type=object
+	Preflight *runtime.RawExtension `json:"preflight,omitempty"`
+	//+kubebuilder:pruning:PreserveUnknownFields
+	//+kubebuilder:validation:Type=object
+	ControlOS *runtime.RawExtension `json:"control-os,omitempty"`
+	K3s       *K3sConfig            `json:"k3s,omitempty"`
+	Zot       *ZotConfig            `json:"zot,omitempty"`
+	//+kubebuilder:pruning:PreserveUnknownFields
+	//+kubebuilder:validation:Type=object
+	Misc    *runtime.RawExtension `json:"misc,omitempty"`
+	DasBoot *DasBootConfig        `json:"das-boot,omitempty"`
+	Fabric  *FabricConfig         `json:"fabric,omitempty"`
+	//+kubebuilder:pruning:PreserveUnknownFields
+	//+kubebuilder:validation:Type=object
+	VLAB *runtime.RawExtension `json:"vlab,omitempty"`
+	//+kubebuilder:pruning:PreserveUnknownFields
+	//+kubebuilder:validation:Type=object
+	ServerOS *runtime.RawExtension `json:"server-os,omitempty"`
+}
+
+// Ref is the artifact reference, same as in config.yaml
+type Ref struct {
+	Repo string `json:"repo,omitempty"`
+	Name string `json:"name,omitempty"`
+	Tag  string `json:"tag,omitempty"`
+	// Digest pins the ref to the specific manifest
+	//+kubebuilder:validation:Pattern=`^sha256:[a-f0-9]{64}$`
+	Digest string `json:"digest,omitempty"`
+}
+
+// KeyPair is the TLS cert and key, key is encrypted if encryption is set
+type KeyPair struct {
+	Cert       string `json:"cert,omitempty"`
+	Key        string `json:"key,omitempty"`
+	Chain      string `json:"chain,omitempty"`
+	Imported   bool   `json:"imported,omitempty"`
+	PendingKey string `json:"pendingKey,omitempty"`
+}
+
+//+kubebuilder:pruning:PreserveUnknownFields
+
+// BaseConfig is the typed part of the base component config
+type BaseConfig struct {
+	Source          *Ref   `json:"source,omitempty"`
+	Target          *Ref   `json:"target,omitempty"`
+	TargetInCluster *Ref   `json:"targetInCluster,omitempty"`
+	Subnet          string `json:"subnet,omitempty"`
+	ControlVIP      string `json:"controlVIP,omitempty"`
+
+	// Raw is the full config including fields that aren't typed
+	Raw []byte `json:"-"`
+}
+
+//+kubebuilder:pruning:PreserveUnknownFields
+
+// K3sConfig is the typed part of the k3s component config
+type K3sConfig struct {
+	Ref         *Ref     `json:"ref,omitempty"`
+	ClusterCIDR string   `json:"clusterCIDR,omitempty"`
+	ServiceCIDR string   `json:"serviceCIDR,omitempty"`
+	ClusterDNS  string   `json:"clusterDNS,omitempty"`
+	TLSSAN      []string `json:"tlsSAN,omitempty"`
+
+	// Raw is the full config including fields that aren't typed
+	Raw []byte `json:"-"`
+}
+
+//+kubebuilder:pruning:PreserveUnknownFields
+
+// ZotConfig is the typed part of the zot component config
+type ZotConfig struct {
+	Ref *Ref    `json:"ref,omitempty"`
+	TLS *ZotTLS `json:"tls,omitempty"`
+
+	// Raw is the full config including fields that aren't typed
+	Raw []byte `json:"-"`
+}
+
+// ZotTLS is the zot registry TLS config
+type ZotTLS struct {
+	CA     *KeyPair `json:"ca,omitempty"`
+	Server *KeyPair `json:"server,omitempty"`
+}
+
+//+kubebuilder:pruning:PreserveUnknownFields
+
+// DasBootConfig is the typed part of the das-boot component config
+type DasBootConfig struct {
+	Ref *Ref        `json:"ref,omitempty"`
+	TLS *DasBootTLS `json:"tls,omitempty"`
+
+	// Raw is the full config including fields that aren't typed
+	Raw []byte `json:"-"`
+}
+
+// DasBootTLS is the das-boot TLS config
+type DasBootTLS struct {
+	ServerCA *KeyPair `json:"serverCA,omitempty"`
+	Server   *KeyPair `json:"server,omitempty"`
+	ClientCA *KeyPair `json:"clientCA,omitempty"`
+	ConfigCA *KeyPair `json:"configCA,omitempty"`
+	Config   *KeyPair `json:"config,omitempty"`
+}
+
+//+kubebuilder:pruning:PreserveUnknownFields
+
+// FabricConfig is the typed part of the fabric component config
+type FabricConfig struct {
+	Ref *Ref `json:"ref,omitempty"`
+	//+kubebuilder:validation:Enum=isc;hedgehog
+	DHCPServer      string   `json:"dhcpServer,omitempty"`
+	ReservedSubnets []string `json:"reservedSubnets,omitempty"`
+	//+kubebuilder:validation:Minimum=1500
+	//+kubebuilder:validation:Maximum=9216
+	FabricMTU uint16 `json:"fabricMTU,omitempty"`
+
+	// Raw is the full config including fields that aren't typed
+	Raw []byte `json:"-"`
+}
+
+func (cfg *BaseConfig) UnmarshalJSON(data []byte) error {
+	type plain BaseConfig
+
+	return unmarshalConfig(data, (*plain)(cfg), &cfg.Raw)
+}
+
+func (cfg BaseConfig) MarshalJSON() ([]byte, error) {
+	type plain BaseConfig
+
+	return marshalConfig(cfg.Raw, plain(cfg))
+}
+
+func (cfg *K3sConfig) UnmarshalJSON(data []byte) error {
+	type plain K3sConfig
+
+	return unmarshalConfig(data, (*plain)(cfg), &cfg.Raw)
+}
+
+func (cfg K3sConfig) MarshalJSON() ([]byte, error) {
+	type plain K3sConfig
+
+	return marshalConfig(cfg.Raw, plain(cfg))
+}
+
+func (cfg *ZotConfig) UnmarshalJSON(data []byte) error {
+	type plain ZotConfig
+
+	return unmarshalConfig(data, (*plain)(cfg), &cfg.Raw)
+}
+
+func (cfg ZotConfig) MarshalJSON() ([]byte, error) {
+	type plain ZotConfig
+
+	return marshalConfig(cfg.Raw, plain(cfg))
+}
+
+func (cfg *DasBootConfig) UnmarshalJSON(data []byte) error {
+	type plain DasBootConfig
+
+	return unmarshalConfig(data, (*plain)(cfg), &cfg.Raw)
+}
+
+func (cfg DasBootConfig) MarshalJSON() ([]byte, error) {
+	type plain DasBootConfig
+
+	return marshalConfig(cfg.Raw, plain(cfg))
+}
+
+func (cfg *FabricConfig) UnmarshalJSON(data []byte) error {
+	type plain FabricConfig
+
+	return unmarshalConfig(data, (*plain)(cfg), &cfg.Raw)
+}
+
+func (cfg FabricConfig) MarshalJSON() ([]byte, error) {
+	type plain FabricConfig
+
+	return marshalConfig(cfg.Raw, plain(cfg))
+}
+
+// unmarshalConfig decodes the typed fields and keeps the full config, so fields that aren't typed aren't lost
+func unmarshalConfig(data []byte, typed any, raw *[]byte) error {
+	if err := json.Unmarshal(data, typed); err != nil {
+		return errors.Wrapf(err, "error unmarshaling config")
+	}
+
+	*raw = append([]byte{}, data...)
+
+	return nil
+}
+
+// marshalConfig encodes the typed fields over the full config, typed fields that are unset are removed from it
+func marshalConfig(raw []byte, typed any) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling raw config")
+		}
+	}
+
+	t := reflect.TypeOf(typed)
+	for idx := 0; idx < t.NumField(); idx++ {
+		name, _, _ := strings.Cut(t.Field(idx).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			delete(fields, name)
+		}
+	}
+
+	data, err := json.Marshal(typed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling config")
+	}
+
+	set := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling config")
+	}
+	for name, value := range set {
+		fields[name] = value
+	}
+
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling merged config")
+	}
+
+	return data, nil
+}
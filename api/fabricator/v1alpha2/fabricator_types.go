@@ -18,26 +18,90 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+const (
+	// ConditionApplied is set when the spec (or the component config) is applied to the cluster
+	ConditionApplied = "Applied"
 
-// FabricatorSpec defines the desired state of Fabricator
+	// ConditionPushed is set on the component when its artifacts are pushed to the in-cluster registry
+	ConditionPushed = "Pushed"
+
+	ReasonApplied           = "Applied"
+	ReasonInvalidConfig     = "InvalidConfig"
+	ReasonApplyFailed       = "ApplyFailed"
+	ReasonMissingIdentity   = "MissingIdentity"
+	ReasonPushed            = "Pushed"
+	ReasonSourceUnreachable = "SourceUnreachable"
+)
+
+// FabricatorSpec defines the desired state of Fabricator, it's the same config that hhfab saves into config.yaml
 type FabricatorSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Preset is the fabricator preset, e.g. lab or vlab
+	Preset string `json:"preset,omitempty"`
+	// FabricMode is the fabric mode, e.g. spine-leaf or collapsed-core
+	FabricMode string `json:"fabricMode,omitempty"`
+	// Encryption is the data key wrapped for the recipients, it's set if secret config fields are encrypted
+	Encryption *Encryption `json:"encryption,omitempty"`
+	// Config is the component configs by component name in the same format as in config.yaml
+	Config *FabricatorConfig `json:"config,omitempty"`
+}
+
+// Encryption is the config secrets encryption, same as in config.yaml
+type Encryption struct {
+	Recipients []EncryptionRecipient `json:"recipients,omitempty"`
+}
 
-	// Foo is an example field of Fabricator. Edit fabricator_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+// EncryptionRecipient is the data key wrapped for x25519 recipient or passphrase
+type EncryptionRecipient struct {
+	// Type is the recipient type, e.g. x25519 or passphrase
+	Type string `json:"type,omitempty"`
+	// PublicKey is base64 encoded x25519 public key of the recipient
+	PublicKey string `json:"publicKey,omitempty"`
+	// Salt is base64 encoded scrypt salt for the passphrase recipient
+	Salt string `json:"salt,omitempty"`
+	// WrappedKey is base64 encoded data key encrypted for the recipient
+	WrappedKey string `json:"wrappedKey,omitempty"`
+}
+
+// ComponentStatus is the observed state of a single component
+type ComponentStatus struct {
+	// Name is the component name, e.g. k3s, zot or fabric
+	Name string `json:"name"`
+	// Versions are tags of the component artifacts by artifact name
+	Versions map[string]string `json:"versions,omitempty"`
+	// Conditions of the component, e.g. Applied
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // FabricatorStatus defines the observed state of Fabricator
 type FabricatorStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the last spec generation processed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastAppliedTime is the time the spec was last applied to the cluster
+	LastAppliedTime metav1.Time `json:"lastAppliedTime,omitempty"`
+	// Components is the per component state, only components with in-cluster parts are listed
+	Components []ComponentStatus `json:"components,omitempty"`
+	// Conditions of the whole Fabricator, e.g. Applied
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// Component returns status of the component by name or nil
+func (status *FabricatorStatus) Component(name string) *ComponentStatus {
+	for idx := range status.Components {
+		if status.Components[idx].Name == name {
+			return &status.Components[idx]
+		}
+	}
+
+	return nil
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Preset",type=string,JSONPath=`.spec.preset`,priority=0
+//+kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.fabricMode`,priority=0
+//+kubebuilder:printcolumn:name="Applied",type=string,JSONPath=`.status.conditions[?(@.type=="Applied")].status`,priority=0
+//+kubebuilder:printcolumn:name="LastApplied",type=date,JSONPath=`.status.lastAppliedTime`,priority=0
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`,priority=0
 
 // Fabricator is the Schema for the fabricators API
 type Fabricator struct {
@@ -20,16 +20,216 @@
 package v1alpha2
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BaseConfig) DeepCopyInto(out *BaseConfig) {
+	*out = *in
+	if in.Source != nil {
+		in, out := &in.Source, &out.Source
+		*out = new(Ref)
+		**out = **in
+	}
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(Ref)
+		**out = **in
+	}
+	if in.TargetInCluster != nil {
+		in, out := &in.TargetInCluster, &out.TargetInCluster
+		*out = new(Ref)
+		**out = **in
+	}
+	if in.Raw != nil {
+		in, out := &in.Raw, &out.Raw
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BaseConfig.
+func (in *BaseConfig) DeepCopy() *BaseConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(BaseConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComponentStatus) DeepCopyInto(out *ComponentStatus) {
+	*out = *in
+	if in.Versions != nil {
+		in, out := &in.Versions, &out.Versions
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComponentStatus.
+func (in *ComponentStatus) DeepCopy() *ComponentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ComponentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DasBootConfig) DeepCopyInto(out *DasBootConfig) {
+	*out = *in
+	if in.Ref != nil {
+		in, out := &in.Ref, &out.Ref
+		*out = new(Ref)
+		**out = **in
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(DasBootTLS)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Raw != nil {
+		in, out := &in.Raw, &out.Raw
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DasBootConfig.
+func (in *DasBootConfig) DeepCopy() *DasBootConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DasBootConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DasBootTLS) DeepCopyInto(out *DasBootTLS) {
+	*out = *in
+	if in.ServerCA != nil {
+		in, out := &in.ServerCA, &out.ServerCA
+		*out = new(KeyPair)
+		**out = **in
+	}
+	if in.Server != nil {
+		in, out := &in.Server, &out.Server
+		*out = new(KeyPair)
+		**out = **in
+	}
+	if in.ClientCA != nil {
+		in, out := &in.ClientCA, &out.ClientCA
+		*out = new(KeyPair)
+		**out = **in
+	}
+	if in.ConfigCA != nil {
+		in, out := &in.ConfigCA, &out.ConfigCA
+		*out = new(KeyPair)
+		**out = **in
+	}
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = new(KeyPair)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DasBootTLS.
+func (in *DasBootTLS) DeepCopy() *DasBootTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(DasBootTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Encryption) DeepCopyInto(out *Encryption) {
+	*out = *in
+	if in.Recipients != nil {
+		in, out := &in.Recipients, &out.Recipients
+		*out = make([]EncryptionRecipient, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Encryption.
+func (in *Encryption) DeepCopy() *Encryption {
+	if in == nil {
+		return nil
+	}
+	out := new(Encryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EncryptionRecipient) DeepCopyInto(out *EncryptionRecipient) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EncryptionRecipient.
+func (in *EncryptionRecipient) DeepCopy() *EncryptionRecipient {
+	if in == nil {
+		return nil
+	}
+	out := new(EncryptionRecipient)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FabricConfig) DeepCopyInto(out *FabricConfig) {
+	*out = *in
+	if in.Ref != nil {
+		in, out := &in.Ref, &out.Ref
+		*out = new(Ref)
+		**out = **in
+	}
+	if in.ReservedSubnets != nil {
+		in, out := &in.ReservedSubnets, &out.ReservedSubnets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Raw != nil {
+		in, out := &in.Raw, &out.Raw
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FabricConfig.
+func (in *FabricConfig) DeepCopy() *FabricConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(FabricConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Fabricator) DeepCopyInto(out *Fabricator) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Fabricator.
@@ -50,6 +250,71 @@ func (in *Fabricator) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FabricatorConfig) DeepCopyInto(out *FabricatorConfig) {
+	*out = *in
+	if in.Base != nil {
+		in, out := &in.Base, &out.Base
+		*out = new(BaseConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Preflight != nil {
+		in, out := &in.Preflight, &out.Preflight
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ControlOS != nil {
+		in, out := &in.ControlOS, &out.ControlOS
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.K3s != nil {
+		in, out := &in.K3s, &out.K3s
+		*out = new(K3sConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Zot != nil {
+		in, out := &in.Zot, &out.Zot
+		*out = new(ZotConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Misc != nil {
+		in, out := &in.Misc, &out.Misc
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DasBoot != nil {
+		in, out := &in.DasBoot, &out.DasBoot
+		*out = new(DasBootConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Fabric != nil {
+		in, out := &in.Fabric, &out.Fabric
+		*out = new(FabricConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VLAB != nil {
+		in, out := &in.VLAB, &out.VLAB
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ServerOS != nil {
+		in, out := &in.ServerOS, &out.ServerOS
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FabricatorConfig.
+func (in *FabricatorConfig) DeepCopy() *FabricatorConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(FabricatorConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FabricatorList) DeepCopyInto(out *FabricatorList) {
 	*out = *in
@@ -85,6 +350,16 @@ func (in *FabricatorList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FabricatorSpec) DeepCopyInto(out *FabricatorSpec) {
 	*out = *in
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(Encryption)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = new(FabricatorConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FabricatorSpec.
@@ -100,6 +375,21 @@ func (in *FabricatorSpec) DeepCopy() *FabricatorSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FabricatorStatus) DeepCopyInto(out *FabricatorStatus) {
 	*out = *in
+	in.LastAppliedTime.DeepCopyInto(&out.LastAppliedTime)
+	if in.Components != nil {
+		in, out := &in.Components, &out.Components
+		*out = make([]ComponentStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FabricatorStatus.
@@ -111,3 +401,118 @@ func (in *FabricatorStatus) DeepCopy() *FabricatorStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *K3sConfig) DeepCopyInto(out *K3sConfig) {
+	*out = *in
+	if in.Ref != nil {
+		in, out := &in.Ref, &out.Ref
+		*out = new(Ref)
+		**out = **in
+	}
+	if in.TLSSAN != nil {
+		in, out := &in.TLSSAN, &out.TLSSAN
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Raw != nil {
+		in, out := &in.Raw, &out.Raw
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new K3sConfig.
+func (in *K3sConfig) DeepCopy() *K3sConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(K3sConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyPair) DeepCopyInto(out *KeyPair) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyPair.
+func (in *KeyPair) DeepCopy() *KeyPair {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyPair)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Ref) DeepCopyInto(out *Ref) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Ref.
+func (in *Ref) DeepCopy() *Ref {
+	if in == nil {
+		return nil
+	}
+	out := new(Ref)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZotConfig) DeepCopyInto(out *ZotConfig) {
+	*out = *in
+	if in.Ref != nil {
+		in, out := &in.Ref, &out.Ref
+		*out = new(Ref)
+		**out = **in
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(ZotTLS)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Raw != nil {
+		in, out := &in.Raw, &out.Raw
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZotConfig.
+func (in *ZotConfig) DeepCopy() *ZotConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ZotConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZotTLS) DeepCopyInto(out *ZotTLS) {
+	*out = *in
+	if in.CA != nil {
+		in, out := &in.CA, &out.CA
+		*out = new(KeyPair)
+		**out = **in
+	}
+	if in.Server != nil {
+		in, out := &in.Server, &out.Server
+		*out = new(KeyPair)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZotTLS.
+func (in *ZotTLS) DeepCopy() *ZotTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(ZotTLS)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/lmittmann/tint"
@@ -30,10 +32,13 @@ import (
 
 var version = "(devel)"
 
-func setupLogger(verbose, brief bool) error {
+func setupLogger(verbose, brief bool, output string) error {
 	if verbose && brief {
 		return cli.Exit("verbose and brief are mutually exclusive", 1)
 	}
+	if !slices.Contains(cnc.Outputs, output) {
+		return cli.Exit("unknown output format: "+output, 1)
+	}
 
 	logLevel := slog.LevelInfo
 	if verbose {
@@ -42,16 +47,30 @@ func setupLogger(verbose, brief bool) error {
 		logLevel = slog.LevelWarn
 	}
 
-	logW := os.Stdout
-	logger := slog.New(
-		tint.NewHandler(logW, &tint.Options{
+	logW := cnc.SetupOutput(output)
+
+	var handler slog.Handler
+	if output == cnc.OutputJSON {
+		handler = slog.NewJSONHandler(logW, &slog.HandlerOptions{
+			Level: logLevel,
+		})
+	} else {
+		handler = tint.NewHandler(logW, &tint.Options{
 			Level:      logLevel,
 			TimeFormat: time.TimeOnly,
-			NoColor:    !isatty.IsTerminal(logW.Fd()),
-		}),
-	)
+			NoColor:    !isatty.IsTerminal(os.Stdout.Fd()),
+		})
+	}
+	logger := slog.New(handler)
 	slog.SetDefault(logger)
 
+	if output != cnc.OutputJSON {
+		fmt.Println(motd)
+		fmt.Println("Version:", version)
+	} else {
+		slog.Info("hhfab-recipe", "version", version)
+	}
+
 	return nil
 }
 
@@ -59,10 +78,16 @@ func setupLogger(verbose, brief bool) error {
 var motd string
 
 func main() {
-	fmt.Println(motd)
-	fmt.Println("Version:", version)
-
 	var verbose, brief bool
+	var output string
+	outputFlag := &cli.StringFlag{
+		Name:        "output",
+		Aliases:     []string{"o"},
+		Usage:       "output format (one of: " + strings.Join(cnc.Outputs, ", ") + "), json emits newline-delimited events and logs",
+		EnvVars:     []string{"HHFAB_OUTPUT"},
+		Value:       cnc.OutputText,
+		Destination: &output,
+	}
 	verboseFlag := &cli.BoolFlag{
 		Name:        "verbose",
 		Aliases:     []string{"v"},
@@ -93,6 +118,28 @@ func main() {
 		Destination: &dryRun,
 	}
 
+	var force bool
+	forceFlag := &cli.BoolFlag{
+		Name:        "force",
+		Aliases:     []string{"f"},
+		Usage:       "run actions even if they are already completed according to the journal",
+		Destination: &force,
+	}
+
+	var from string
+	fromFlag := &cli.StringFlag{
+		Name:        "from",
+		Usage:       "(re-)run all actions starting from `ACTION` ignoring the journal",
+		Destination: &from,
+	}
+
+	var sums string
+	sumsFlag := &cli.StringFlag{
+		Name:        "sums",
+		Usage:       "verify archive using checksums from `FILE` (sha256sum format) before extracting",
+		Destination: &sums,
+	}
+
 	cli.VersionFlag.(*cli.BoolFlag).Aliases = []string{"V"}
 	app := &cli.App{
 		Name:                   "hhfab-recipe",
@@ -101,22 +148,86 @@ func main() {
 		Suggest:                true,
 		UseShortOptionHandling: true,
 		EnableBashCompletion:   true,
+		Flags: []cli.Flag{
+			outputFlag,
+		},
 		Commands: []*cli.Command{
 			{
 				Name:      "run",
 				Usage:     "run steps from recipe.yaml in the basedir",
-				UsageText: "Empty or 'all' for all actions (default) or list actions as args to run",
+				UsageText: "Empty or 'all' for all actions (default) or list actions as args to run, completed actions are skipped unless --force or --from is used",
+				Flags: []cli.Flag{
+					basedirFlag,
+					verboseFlag,
+					briefFlag,
+					dryRunFlag,
+					forceFlag,
+					fromFlag,
+				},
+				Before: func(_ *cli.Context) error {
+					return setupLogger(verbose, brief, output)
+				},
+				Action: func(cCtx *cli.Context) error {
+					return errors.Wrapf(cnc.RunRecipe(basedir, cCtx.Args().Slice(), dryRun, force, from), "error running recipe")
+				},
+			},
+			{
+				Name:      "uninstall",
+				Usage:     "undo completed actions from the journal in reverse order",
+				UsageText: "Empty or 'all' for all completed actions (default) or list actions as args to undo, undone actions are removed from the journal",
 				Flags: []cli.Flag{
 					basedirFlag,
 					verboseFlag,
 					briefFlag,
 					dryRunFlag,
+					&cli.BoolFlag{
+						Name:        "force",
+						Aliases:     []string{"f"},
+						Usage:       "undo actions even if they were changed in the recipe since completed or skip unknown ones",
+						Destination: &force,
+					},
+				},
+				Before: func(_ *cli.Context) error {
+					return setupLogger(verbose, brief, output)
+				},
+				Action: func(cCtx *cli.Context) error {
+					return errors.Wrapf(cnc.UninstallRecipe(basedir, cCtx.Args().Slice(), dryRun, force), "error uninstalling recipe")
+				},
+			},
+			{
+				Name:      "unpack",
+				Usage:     "verify and unpack bundle archive (tgz, tar.zst or tar.xz) into the basedir",
+				ArgsUsage: "ARCHIVE",
+				Flags: []cli.Flag{
+					basedirFlag,
+					verboseFlag,
+					briefFlag,
+					sumsFlag,
 				},
 				Before: func(_ *cli.Context) error {
-					return setupLogger(verbose, brief)
+					return setupLogger(verbose, brief, output)
 				},
 				Action: func(cCtx *cli.Context) error {
-					return errors.Wrapf(cnc.RunRecipe(basedir, cCtx.Args().Slice(), dryRun), "error running recipe")
+					if cCtx.NArg() != 1 {
+						return cli.Exit("exactly one archive expected", 1)
+					}
+
+					return errors.Wrapf(cnc.UnpackArchive(cCtx.Args().First(), sums, basedir), "error unpacking")
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "show status of the actions from recipe.yaml based on the journal in the basedir",
+				Flags: []cli.Flag{
+					basedirFlag,
+					verboseFlag,
+					briefFlag,
+				},
+				Before: func(_ *cli.Context) error {
+					return setupLogger(verbose, brief, output)
+				},
+				Action: func(_ *cli.Context) error {
+					return errors.Wrapf(cnc.RecipeStatus(basedir), "error getting recipe status")
 				},
 			},
 		},
@@ -17,6 +17,7 @@ package main
 import (
 	"context"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
@@ -31,6 +32,7 @@ import (
 	"go.githedgehog.com/fabric/api/meta"
 	"go.githedgehog.com/fabricator/pkg/fab"
 	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	"go.githedgehog.com/fabricator/pkg/fab/remote"
 	"go.githedgehog.com/fabricator/pkg/fab/vlab"
 	"go.githedgehog.com/fabricator/pkg/fab/wiring"
 )
@@ -41,10 +43,13 @@ const (
 	CategoryWiringGen = "wiring generator options:"
 )
 
-func setupLogger(verbose, brief bool) error {
+func setupLogger(verbose, brief bool, output string) error {
 	if verbose && brief {
 		return cli.Exit("verbose and brief are mutually exclusive", 1)
 	}
+	if !slices.Contains(cnc.Outputs, output) {
+		return cli.Exit("unknown output format: "+output, 1)
+	}
 
 	logLevel := slog.LevelInfo
 	if verbose {
@@ -53,14 +58,21 @@ func setupLogger(verbose, brief bool) error {
 		logLevel = slog.LevelWarn
 	}
 
-	logW := os.Stdout
-	logger := slog.New(
-		tint.NewHandler(logW, &tint.Options{
+	logW := cnc.SetupOutput(output)
+
+	var handler slog.Handler
+	if output == cnc.OutputJSON {
+		handler = slog.NewJSONHandler(logW, &slog.HandlerOptions{
+			Level: logLevel,
+		})
+	} else {
+		handler = tint.NewHandler(logW, &tint.Options{
 			Level:      logLevel,
 			TimeFormat: time.TimeOnly,
-			NoColor:    !isatty.IsTerminal(logW.Fd()),
-		}),
-	)
+			NoColor:    !isatty.IsTerminal(os.Stdout.Fd()),
+		})
+	}
+	logger := slog.New(handler)
 	slog.SetDefault(logger)
 
 	slog.Debug("\n" + motd)
@@ -74,6 +86,15 @@ var motd string
 
 func main() {
 	var verbose, brief bool
+	var output string
+	outputFlag := &cli.StringFlag{
+		Name:        "output",
+		Aliases:     []string{"o"},
+		Usage:       "output format (one of: " + strings.Join(cnc.Outputs, ", ") + "), json emits newline-delimited events and logs",
+		EnvVars:     []string{"HHFAB_OUTPUT"},
+		Value:       cnc.OutputText,
+		Destination: &output,
+	}
 	verboseFlag := &cli.BoolFlag{
 		Name:        "verbose",
 		Aliases:     []string{"v"},
@@ -87,7 +108,7 @@ func main() {
 		Destination: &brief,
 	}
 
-	var basedir, fromConfig, preset string
+	var basedir, fromConfig, fromCRD, componentsDir, preset string
 	var wiringPath cli.StringSlice
 	basedirFlag := &cli.StringFlag{
 		Name:        "basedir",
@@ -97,12 +118,71 @@ func main() {
 		Destination: &basedir,
 	}
 
+	var encryptTo cli.StringSlice
+	var encryptPassphrase bool
+	encryptToFlag := &cli.StringSliceFlag{
+		Name:        "encrypt-to",
+		Usage:       "encrypt config secrets for x25519 recipient `PUBKEY` (see 'hhfab config keygen'), could be repeated",
+		EnvVars:     []string{"HHFAB_ENCRYPT_TO"},
+		Destination: &encryptTo,
+	}
+	encryptPassphraseFlag := &cli.BoolFlag{
+		Name:        "encrypt-passphrase",
+		Usage:       "encrypt config secrets with passphrase from " + cnc.EnvPassphrase + " env var",
+		Destination: &encryptPassphrase,
+	}
+	encryptionPassphrase := func(envs ...string) (string, error) {
+		if !encryptPassphrase {
+			return "", nil
+		}
+
+		for _, env := range envs {
+			if passphrase := os.Getenv(env); passphrase != "" {
+				return passphrase, nil
+			}
+		}
+
+		return "", errors.Errorf("passphrase encryption requested but none of %s is set", strings.Join(envs, ", "))
+	}
+
 	var presets []string
 	for _, p := range fab.Presets {
 		presets = append(presets, string(p))
 	}
 
-	var dryRun, hydrate, nopack bool
+	var dryRun, hydrate, nopack, updateLock bool
+	var parallel int
+	parallelFlag := &cli.IntFlag{
+		Name:        "parallel",
+		Aliases:     []string{"j"},
+		Usage:       "run up to `N` build ops (downloads) in parallel",
+		Value:       4,
+		Destination: &parallel,
+	}
+	updateLockFlag := &cli.BoolFlag{
+		Name:        "update-lock",
+		Usage:       "accept resolved artifact digests that don't match the lock file and update it",
+		Destination: &updateLock,
+	}
+
+	var offlineSource, upgradeFrom string
+
+	packFormats := []string{}
+	for _, f := range cnc.PackFormats {
+		packFormats = append(packFormats, string(f))
+	}
+
+	var packFormat string
+	packFormatFlag := &cli.StringFlag{
+		Name:        "pack-format",
+		Usage:       "bundle archive format (one of: " + strings.Join(packFormats, ", ") + ")",
+		Value:       string(cnc.PackFormatTarGz),
+		Destination: &packFormat,
+	}
+
+	var installTarget, installBundle, installSSHKey string
+	var installJump cli.StringSlice
+	var installNoHostKeyCheck bool
 
 	var vm string
 	vmFlag := &cli.StringFlag{
@@ -113,7 +193,7 @@ func main() {
 
 	var fabricMode string
 	var wgChainControlLink bool
-	var wgControlLinksCount, wgSpinesCount, wgFabricLinksCount, wgMCLAGLeafsCount, wgOrphanLeafsCount, wgMCLAGSessionLinks, wgMCLAGPeerLinks, wgVPCLoopbacks uint
+	var wgControlLinksCount, wgControlNodesCount, wgSpinesCount, wgFabricLinksCount, wgMCLAGLeafsCount, wgOrphanLeafsCount, wgMCLAGSessionLinks, wgMCLAGPeerLinks, wgVPCLoopbacks uint
 	var wgESLAGLeafGroups string
 	var wgExternal bool
 
@@ -142,6 +222,12 @@ func main() {
 			Usage:       "number of control links if chain-control-link is enabled",
 			Destination: &wgControlLinksCount,
 		},
+		&cli.UintFlag{
+			Category:    CategoryWiringGen,
+			Name:        "control-nodes-count",
+			Usage:       "number of control nodes, switches are attached to them round-robin (default: 1)",
+			Destination: &wgControlNodesCount,
+		},
 		&cli.UintFlag{
 			Category:    CategoryWiringGen,
 			Name:        "spines-count",
@@ -199,6 +285,7 @@ func main() {
 	}
 
 	mngr := fab.NewCNCManager()
+	mngr.SetVersion(version)
 
 	extraInitFlags := append(wiringGenFlags, mngr.Flags()...)
 
@@ -210,6 +297,9 @@ func main() {
 		Suggest:                true,
 		UseShortOptionHandling: true,
 		EnableBashCompletion:   true,
+		Flags: []cli.Flag{
+			outputFlag,
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "init",
@@ -224,6 +314,11 @@ func main() {
 						Usage:       "start from existing config `FILE`",
 						Destination: &fromConfig,
 					},
+					&cli.StringFlag{
+						Name:        "from-crd",
+						Usage:       "start from Fabricator object in `FILE` (e.g. from 'hhfab config export --as-crd' or 'kubectl get fabricator -o yaml')",
+						Destination: &fromCRD,
+					},
 					&cli.StringFlag{
 						Name:        "preset",
 						Aliases:     []string{"p"},
@@ -237,15 +332,22 @@ func main() {
 						Usage:       "use wiring diagram from `FILE` (or dir), use '-' to read from stdin, use multiple times to merge",
 						Destination: &wiringPath,
 					},
+					&cli.StringFlag{
+						Name:        "components-dir",
+						Usage:       "load custom components from YAML files in `DIR`, they are copied into basedir",
+						Destination: &componentsDir,
+					},
 					&cli.BoolFlag{
 						Name:        "hydrate",
 						Usage:       "automatically hydrate wiring diagram if needed (if some IPs/ASN/etc missing)",
 						Value:       true,
 						Destination: &hydrate,
 					},
+					encryptToFlag,
+					encryptPassphraseFlag,
 				}, extraInitFlags...),
 				Before: func(_ *cli.Context) error {
-					return setupLogger(verbose, brief)
+					return setupLogger(verbose, brief, output)
 				},
 				Action: func(_ *cli.Context) error {
 					if fabricMode == "" {
@@ -255,11 +357,20 @@ func main() {
 						return errors.Errorf("invalid fabric mode %s (supported: %s)", fabricMode, strings.Join(fabricModes, ", "))
 					}
 
+					// Fabricator object is detected and converted when config is loaded
+					if fromCRD != "" {
+						if fromConfig != "" {
+							return errors.New("only one of config and from-crd could be specified")
+						}
+						fromConfig = fromCRD
+					}
+
 					wiringGen := &wiring.Builder{
 						FabricMode:        meta.FabricMode(fabricMode),
 						ChainControlLink:  wgChainControlLink,
 						External:          wgExternal,
 						ControlLinksCount: uint8(wgControlLinksCount),
+						ControlNodesCount: uint8(wgControlNodesCount),
 						SpinesCount:       uint8(wgSpinesCount),
 						FabricLinksCount:  uint8(wgFabricLinksCount),
 						MCLAGLeafsCount:   uint8(wgMCLAGLeafsCount),
@@ -269,11 +380,23 @@ func main() {
 						MCLAGPeerLinks:    uint8(wgMCLAGPeerLinks),
 						VPCLoopbacks:      uint8(wgVPCLoopbacks),
 					}
-					err := mngr.Init(basedir, fromConfig, cnc.Preset(preset), meta.FabricMode(fabricMode), wiringPath.Value(), wiringGen, hydrate)
+					err := mngr.Init(basedir, fromConfig, componentsDir, cnc.Preset(preset), meta.FabricMode(fabricMode), wiringPath.Value(), wiringGen, hydrate)
 					if err != nil {
 						return errors.Wrap(err, "error initializing")
 					}
 
+					// encryption of the config loaded from file is kept unless new one is requested
+					if len(encryptTo.Value()) > 0 || encryptPassphrase {
+						passphrase, err := encryptionPassphrase(cnc.EnvPassphrase)
+						if err != nil {
+							return err
+						}
+
+						if err := mngr.SetEncryption(encryptTo.Value(), passphrase); err != nil {
+							return errors.Wrap(err, "error setting encryption")
+						}
+					}
+
 					return errors.Wrap(mngr.Save(), "error saving")
 				},
 			},
@@ -289,6 +412,19 @@ func main() {
 						Usage:       "do not pack bundles",
 						Destination: &nopack,
 					},
+					packFormatFlag,
+					parallelFlag,
+					updateLockFlag,
+					&cli.StringFlag{
+						Name:        "offline-source",
+						Usage:       "use OCI image layout in `DIR` (created by mirror export) instead of remote registries",
+						Destination: &offlineSource,
+					},
+					&cli.StringFlag{
+						Name:        "upgrade-from",
+						Usage:       "additionally build " + fab.BundleControlUpgrade.Name + " bundle with changes since the previous build in basedir `DIR` (or its lock file)",
+						Destination: &upgradeFrom,
+					},
 					// TODO support reset before build
 					// &cli.BoolFlag{
 					// 	Name:        "reset",
@@ -297,7 +433,7 @@ func main() {
 					// },
 				},
 				Before: func(_ *cli.Context) error {
-					return setupLogger(verbose, brief)
+					return setupLogger(verbose, brief, output)
 				},
 				Action: func(_ *cli.Context) error {
 					err := mngr.Load(basedir)
@@ -305,7 +441,52 @@ func main() {
 						return errors.Wrap(err, "error loading")
 					}
 
-					return errors.Wrap(mngr.Build(!nopack), "error building bundles")
+					opts := cnc.BuildOpts{
+						Pack:          !nopack,
+						Parallel:      parallel,
+						UpdateLock:    updateLock,
+						OfflineSource: offlineSource,
+						PackFormat:    cnc.PackFormat(packFormat),
+					}
+					if upgradeFrom != "" {
+						opts.Upgrade = fab.ControlUpgradeOpts(upgradeFrom)
+					}
+
+					return errors.Wrap(mngr.Build(opts), "error building bundles")
+				},
+			},
+			{
+				Name:  "mirror",
+				Usage: "mirror artifacts for offline builds",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "export",
+						Usage: "fetch all artifacts needed for the current config into OCI image layout",
+						Flags: []cli.Flag{
+							basedirFlag,
+							verboseFlag,
+							briefFlag,
+							parallelFlag,
+							updateLockFlag,
+							&cli.StringFlag{
+								Name:        "dir",
+								Usage:       "export artifacts to OCI image layout in `DIR`",
+								Required:    true,
+								Destination: &offlineSource,
+							},
+						},
+						Before: func(_ *cli.Context) error {
+							return setupLogger(verbose, brief, output)
+						},
+						Action: func(_ *cli.Context) error {
+							err := mngr.Load(basedir)
+							if err != nil {
+								return errors.Wrap(err, "error loading")
+							}
+
+							return errors.Wrap(mngr.MirrorExport(offlineSource, parallel, updateLock), "error exporting mirror")
+						},
+					},
 				},
 			},
 			{
@@ -315,9 +496,10 @@ func main() {
 					basedirFlag,
 					verboseFlag,
 					briefFlag,
+					packFormatFlag,
 				},
 				Before: func(_ *cli.Context) error {
-					return setupLogger(verbose, brief)
+					return setupLogger(verbose, brief, output)
 				},
 				Action: func(_ *cli.Context) error {
 					err := mngr.Load(basedir)
@@ -325,7 +507,358 @@ func main() {
 						return errors.Wrap(err, "error loading")
 					}
 
-					return errors.Wrap(mngr.Pack(), "error packing bundles")
+					return errors.Wrap(mngr.Pack(cnc.PackFormat(packFormat)), "error packing bundles")
+				},
+			},
+			{
+				Name:  "install",
+				Usage: "install bundle on the bare-metal node over ssh (upload, verify, unpack and run recipe)",
+				Flags: []cli.Flag{
+					basedirFlag,
+					verboseFlag,
+					briefFlag,
+					&cli.StringFlag{
+						Name:        "target",
+						Aliases:     []string{"t"},
+						Usage:       "ssh target `USER@HOST[:PORT]` (user defaults to " + remote.DefaultUser + ")",
+						Required:    true,
+						Destination: &installTarget,
+					},
+					&cli.StringFlag{
+						Name:        "bundle",
+						Usage:       "installer bundle `NAME` to install",
+						Value:       fab.BundleControlInstall.Name,
+						Destination: &installBundle,
+					},
+					&cli.StringFlag{
+						Name:        "ssh-key",
+						Aliases:     []string{"i"},
+						Usage:       "use ssh identity `FILE` (ssh defaults and agent are used if not set)",
+						Destination: &installSSHKey,
+					},
+					&cli.StringSliceFlag{
+						Name:        "jump",
+						Aliases:     []string{"J"},
+						Usage:       "connect through jump `HOST` (user@host[:port]), could be repeated",
+						Destination: &installJump,
+					},
+					&cli.BoolFlag{
+						Name:        "no-host-key-check",
+						Usage:       "don't check and don't save target host keys",
+						Destination: &installNoHostKeyCheck,
+					},
+				},
+				Before: func(_ *cli.Context) error {
+					return setupLogger(verbose, brief, output)
+				},
+				Action: func(cCtx *cli.Context) error {
+					target, err := remote.ParseTarget(installTarget)
+					if err != nil {
+						return errors.Wrap(err, "error parsing target")
+					}
+
+					target.Key = installSSHKey
+					target.Jump = installJump.Value()
+					if installNoHostKeyCheck {
+						target.Options = vlab.SSHQuietFlags
+					}
+
+					kubeconfig := installBundle == fab.BundleControlInstall.Name
+
+					return errors.Wrap(remote.Install(cCtx.Context, basedir, target, installBundle, kubeconfig), "error installing")
+				},
+			},
+			{
+				Name:  "certs",
+				Usage: "manage certificates generated by fabricator",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "status",
+						Usage: "show expiration and validity of all certificates against the current config",
+						Flags: []cli.Flag{
+							basedirFlag,
+							verboseFlag,
+							briefFlag,
+						},
+						Before: func(_ *cli.Context) error {
+							return setupLogger(verbose, brief, output)
+						},
+						Action: func(_ *cli.Context) error {
+							err := mngr.Load(basedir)
+							if err != nil {
+								return errors.Wrap(err, "error loading")
+							}
+
+							if cnc.IsJSONOutput() {
+								return errors.Wrap(json.NewEncoder(os.Stdout).Encode(mngr.CertsStatus(time.Now())), "error encoding certs status")
+							}
+
+							return errors.Wrap(mngr.PrintCertsStatus(os.Stdout), "error showing certs status")
+						},
+					},
+					{
+						Name:  "rotate",
+						Usage: "regenerate leaf certificates (and CAs only if expiring or invalid), rebuild and install " + fab.BundleControlCertsUpdate.Name + " bundle to apply",
+						Flags: []cli.Flag{
+							basedirFlag,
+							verboseFlag,
+							briefFlag,
+							&cli.StringSliceFlag{
+								Name:  "component",
+								Usage: "rotate only certificates of the component `NAME`, could be repeated (default: all)",
+							},
+						},
+						Before: func(_ *cli.Context) error {
+							return setupLogger(verbose, brief, output)
+						},
+						Action: func(cCtx *cli.Context) error {
+							err := mngr.Load(basedir)
+							if err != nil {
+								return errors.Wrap(err, "error loading")
+							}
+
+							if err := mngr.RotateCerts(cCtx.StringSlice("component")); err != nil {
+								return errors.Wrap(err, "error rotating certs")
+							}
+
+							if err := mngr.Save(); err != nil {
+								return errors.Wrap(err, "error saving")
+							}
+
+							slog.Info("Certs rotated, run build and install the bundle to apply them", "bundle", fab.BundleControlCertsUpdate.Name,
+								"joinedNodes", fab.BundleControlJoinCertsUpdate("<node>").Name)
+
+							return nil
+						},
+					},
+					{
+						Name:  "csr",
+						Usage: "create CSRs for the CAs to be signed by external CA as intermediates, import signed certs using import command",
+						Flags: []cli.Flag{
+							basedirFlag,
+							verboseFlag,
+							briefFlag,
+							&cli.StringFlag{
+								Name:     "dir",
+								Usage:    "write CSRs to `DIR`",
+								Required: true,
+							},
+							&cli.StringSliceFlag{
+								Name:  "component",
+								Usage: "create CSRs only for the CAs of the component `NAME`, could be repeated (default: all)",
+							},
+						},
+						Before: func(_ *cli.Context) error {
+							return setupLogger(verbose, brief, output)
+						},
+						Action: func(cCtx *cli.Context) error {
+							err := mngr.Load(basedir)
+							if err != nil {
+								return errors.Wrap(err, "error loading")
+							}
+
+							if err := mngr.CertsCSR(cCtx.String("dir"), cCtx.StringSlice("component")); err != nil {
+								return errors.Wrap(err, "error creating CSRs")
+							}
+
+							return errors.Wrap(mngr.Save(), "error saving")
+						},
+					},
+					{
+						Name:  "import",
+						Usage: "import CA signed by external CA (for the key from CSR or provided one) and re-issue certs signed by it",
+						Flags: []cli.Flag{
+							basedirFlag,
+							verboseFlag,
+							briefFlag,
+							&cli.StringFlag{
+								Name:     "component",
+								Usage:    "component `NAME` owning the CA",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "id",
+								Usage:    "CA `ID` as shown by status command",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "cert",
+								Usage:    "signed CA certificate PEM `FILE`, could be followed by the issuer certs",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "key",
+								Usage: "CA private key PEM `FILE` (default: key generated for CSR)",
+							},
+							&cli.StringFlag{
+								Name:  "chain",
+								Usage: "issuer certs PEM `FILE`",
+							},
+						},
+						Before: func(_ *cli.Context) error {
+							return setupLogger(verbose, brief, output)
+						},
+						Action: func(cCtx *cli.Context) error {
+							err := mngr.Load(basedir)
+							if err != nil {
+								return errors.Wrap(err, "error loading")
+							}
+
+							files := map[string][]byte{}
+							for _, name := range []string{"cert", "key", "chain"} {
+								if path := cCtx.String(name); path != "" {
+									files[name], err = os.ReadFile(path)
+									if err != nil {
+										return errors.Wrapf(err, "error reading %s", name)
+									}
+								}
+							}
+
+							err = mngr.ImportCert(cCtx.String("component"), cCtx.String("id"), files["cert"], files["key"], files["chain"])
+							if err != nil {
+								return errors.Wrap(err, "error importing cert")
+							}
+
+							if err := mngr.Save(); err != nil {
+								return errors.Wrap(err, "error saving")
+							}
+
+							slog.Info("Cert imported, run build and install the bundle to apply it", "bundle", fab.BundleControlCertsUpdate.Name,
+								"joinedNodes", fab.BundleControlJoinCertsUpdate("<node>").Name)
+
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "manage config export and encryption",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "export",
+						Usage: "print saved config or Fabricator object with it (to apply to the cluster or to init from)",
+						Flags: []cli.Flag{
+							basedirFlag,
+							verboseFlag,
+							briefFlag,
+							&cli.BoolFlag{
+								Name:  "as-crd",
+								Usage: "export as Fabricator object instead of config file",
+							},
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "Fabricator object `NAME`",
+								Value: fab.FabricatorName,
+							},
+							&cli.StringFlag{
+								Name:  "namespace",
+								Usage: "Fabricator object `NAMESPACE`",
+								Value: fab.FabricatorNamespace,
+							},
+						},
+						Before: func(_ *cli.Context) error {
+							return setupLogger(verbose, brief, output)
+						},
+						Action: func(cCtx *cli.Context) error {
+							err := mngr.Load(basedir)
+							if err != nil {
+								return errors.Wrap(err, "error loading")
+							}
+
+							data, err := mngr.Export(cCtx.Bool("as-crd"), cCtx.String("name"), cCtx.String("namespace"))
+							if err != nil {
+								return errors.Wrap(err, "error exporting config")
+							}
+
+							fmt.Print(string(data))
+
+							return nil
+						},
+					},
+					{
+						Name:  "keygen",
+						Usage: "generate x25519 identity to encrypt config secrets for, public key (recipient) is printed",
+						Flags: []cli.Flag{
+							verboseFlag,
+							briefFlag,
+							&cli.StringFlag{
+								Name:     "out",
+								Usage:    "write identity (private key) to `FILE`",
+								Required: true,
+							},
+						},
+						Before: func(_ *cli.Context) error {
+							return setupLogger(verbose, brief, output)
+						},
+						Action: func(cCtx *cli.Context) error {
+							path := cCtx.String("out")
+							if _, err := os.Stat(path); err == nil {
+								return errors.Errorf("identity file %s already exists", path)
+							}
+
+							identity, recipient, err := cnc.GenerateIdentity()
+							if err != nil {
+								return errors.Wrap(err, "error generating identity")
+							}
+
+							if err := cnc.WriteIdentity(path, identity, recipient); err != nil {
+								return errors.Wrap(err, "error saving identity")
+							}
+
+							slog.Info("Identity created, use it with "+cnc.EnvIdentity+" env var", "path", path)
+							fmt.Println(recipient)
+
+							return nil
+						},
+					},
+					{
+						Name:  "reencrypt",
+						Usage: "re-encrypt config secrets with new data key for the new set of recipients",
+						Flags: []cli.Flag{
+							basedirFlag,
+							verboseFlag,
+							briefFlag,
+							encryptToFlag,
+							encryptPassphraseFlag,
+							&cli.BoolFlag{
+								Name:  "plaintext",
+								Usage: "remove encryption and store secrets in plaintext",
+							},
+						},
+						Before: func(_ *cli.Context) error {
+							return setupLogger(verbose, brief, output)
+						},
+						Action: func(cCtx *cli.Context) error {
+							plaintext := cCtx.Bool("plaintext")
+							if plaintext == (len(encryptTo.Value()) > 0 || encryptPassphrase) {
+								return errors.New("either recipients/passphrase or plaintext should be specified")
+							}
+
+							err := mngr.Load(basedir)
+							if err != nil {
+								return errors.Wrap(err, "error loading")
+							}
+
+							// new passphrase could be set separately to change it
+							passphrase, err := encryptionPassphrase(cnc.EnvNewPassphrase, cnc.EnvPassphrase)
+							if err != nil {
+								return err
+							}
+
+							if err := mngr.SetEncryption(encryptTo.Value(), passphrase); err != nil {
+								return errors.Wrap(err, "error setting encryption")
+							}
+
+							if err := mngr.Save(); err != nil {
+								return errors.Wrap(err, "error saving")
+							}
+
+							slog.Info("Config re-encrypted", "recipients", len(encryptTo.Value()), "passphrase", encryptPassphrase, "plaintext", plaintext)
+
+							return nil
+						},
+					},
 				},
 			},
 			{
@@ -337,7 +870,7 @@ func main() {
 					briefFlag,
 				},
 				Before: func(_ *cli.Context) error {
-					return setupLogger(verbose, brief)
+					return setupLogger(verbose, brief, output)
 				},
 				Action: func(_ *cli.Context) error {
 					err := mngr.Load(basedir)
@@ -348,6 +881,45 @@ func main() {
 					return errors.Wrap(mngr.Dump(), "error dumping hydrated config")
 				},
 			},
+			{
+				Name:  "version",
+				Usage: "print version, with --components print versions and digests of all artifacts per component",
+				Flags: []cli.Flag{
+					basedirFlag,
+					verboseFlag,
+					briefFlag,
+					&cli.BoolFlag{
+						Name:  "components",
+						Usage: "print release manifest for the loaded config, digests are only known for already built artifacts",
+					},
+				},
+				Before: func(_ *cli.Context) error {
+					return setupLogger(verbose, brief, output)
+				},
+				Action: func(cCtx *cli.Context) error {
+					if !cCtx.Bool("components") {
+						fmt.Println(version)
+
+						return nil
+					}
+
+					err := mngr.Load(basedir)
+					if err != nil {
+						return errors.Wrap(err, "error loading")
+					}
+
+					release, err := mngr.Release()
+					if err != nil {
+						return errors.Wrap(err, "error resolving release")
+					}
+
+					if cnc.IsJSONOutput() {
+						return errors.Wrap(json.NewEncoder(os.Stdout).Encode(release), "error encoding release")
+					}
+
+					return release.Print(os.Stdout)
+				},
+			},
 			{
 				Name:  "vlab",
 				Usage: "fully virtual or hybrid lab (VLAB) management",
@@ -402,7 +974,7 @@ func main() {
 							},
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(cCtx *cli.Context) error {
 							err := mngr.Load(basedir)
@@ -434,7 +1006,7 @@ func main() {
 							vmFlag,
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(cCtx *cli.Context) error {
 							err := mngr.Load(basedir)
@@ -460,7 +1032,7 @@ func main() {
 							vmFlag,
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(_ *cli.Context) error {
 							err := mngr.Load(basedir)
@@ -486,7 +1058,7 @@ func main() {
 							vmFlag,
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(_ *cli.Context) error {
 							err := mngr.Load(basedir)
@@ -512,7 +1084,7 @@ func main() {
 							briefFlag,
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(_ *cli.Context) error {
 							err := mngr.Load(basedir)
@@ -543,7 +1115,7 @@ func main() {
 							},
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(cCtx *cli.Context) error {
 							err := mngr.Load(basedir)
@@ -614,7 +1186,7 @@ func main() {
 							},
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(cCtx *cli.Context) error {
 							err := mngr.Load(basedir)
@@ -685,7 +1257,7 @@ func main() {
 							},
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(cCtx *cli.Context) error {
 							err := mngr.Load(basedir)
@@ -729,7 +1301,7 @@ func main() {
 							briefFlag,
 						}, wiringGenFlags...),
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(_ *cli.Context) error {
 							if fabricMode == "" {
@@ -743,6 +1315,7 @@ func main() {
 								FabricMode:        meta.FabricMode(fabricMode),
 								ChainControlLink:  wgChainControlLink,
 								ControlLinksCount: uint8(wgControlLinksCount),
+								ControlNodesCount: uint8(wgControlNodesCount),
 								SpinesCount:       uint8(wgSpinesCount),
 								FabricLinksCount:  uint8(wgFabricLinksCount),
 								MCLAGLeafsCount:   uint8(wgMCLAGLeafsCount),
@@ -772,7 +1345,7 @@ func main() {
 							},
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(cCtx *cli.Context) error {
 							err := wiring.HydratePath(cCtx.String("wiring"))
@@ -796,7 +1369,7 @@ func main() {
 							},
 						},
 						Before: func(_ *cli.Context) error {
-							return setupLogger(verbose, brief)
+							return setupLogger(verbose, brief, output)
 						},
 						Action: func(cCtx *cli.Context) error {
 							data, err := wiring.Visualize(cCtx.String("wiring"))
@@ -806,6 +1379,51 @@ func main() {
 
 							fmt.Println(data)
 
+							return nil
+						},
+					},
+					{
+						Name:  "diff",
+						Usage: "compare wiring diagrams (files or basedirs), flag changes that can't be applied live and show apply plan",
+						Flags: []cli.Flag{
+							verboseFlag,
+							briefFlag,
+							&cli.StringFlag{
+								Name:     "from",
+								Usage:    "current wiring `FILE` (or basedir)",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "to",
+								Usage:    "new wiring `FILE` (or basedir)",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "fail-unsafe",
+								Usage: "exit with error if there are changes that can't be applied live",
+							},
+						},
+						Before: func(_ *cli.Context) error {
+							return setupLogger(verbose, brief, output)
+						},
+						Action: func(cCtx *cli.Context) error {
+							diff, err := wiring.DiffPaths(cCtx.String("from"), cCtx.String("to"))
+							if err != nil {
+								return errors.Wrap(err, "error comparing wiring")
+							}
+
+							if cnc.IsJSONOutput() {
+								if err := json.NewEncoder(os.Stdout).Encode(diff); err != nil {
+									return errors.Wrap(err, "error encoding diff")
+								}
+							} else if err := diff.Print(os.Stdout); err != nil {
+								return err
+							}
+
+							if cCtx.Bool("fail-unsafe") && diff.IsUnsafe() {
+								return errors.New("wiring changes can't be applied live")
+							}
+
 							return nil
 						},
 					},
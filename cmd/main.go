@@ -17,6 +17,7 @@ package main
 import (
 	"flag"
 	"os"
+	"path/filepath"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -29,7 +30,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	vpcapi "go.githedgehog.com/fabric/api/vpc/v1alpha2"
+	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
 	fabricatorv1alpha2 "go.githedgehog.com/fabricator/api/fabricator/v1alpha2"
+	fabricatorcontroller "go.githedgehog.com/fabricator/internal/controller/fabricator"
+	fabricatorwebhook "go.githedgehog.com/fabricator/internal/webhook/fabricator"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -42,6 +47,8 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(fabricatorv1alpha2.AddToScheme(scheme))
+	utilruntime.Must(wiringapi.AddToScheme(scheme))
+	utilruntime.Must(vpcapi.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -49,11 +56,14 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var workdir string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&workdir, "workdir", filepath.Join(os.TempDir(), "fabricator"),
+		"The scratch dir for the artifacts pushed to the in-cluster registry.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -86,6 +96,28 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := os.MkdirAll(workdir, 0o755); err != nil {
+		setupLog.Error(err, "unable to create workdir", "workdir", workdir)
+		os.Exit(1)
+	}
+
+	if err = (&fabricatorcontroller.FabricatorReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Workdir: workdir,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Fabricator")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&fabricatorwebhook.FabricatorWebhook{
+			Client:  mgr.GetClient(),
+			Workdir: workdir,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Fabricator")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
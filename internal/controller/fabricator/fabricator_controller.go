@@ -0,0 +1,262 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabricator
+
+import (
+	"context"
+	"io"
+	"maps"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	fabapi "go.githedgehog.com/fabricator/api/fabricator/v1alpha2"
+	"go.githedgehog.com/fabricator/pkg/fab"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	fabwiring "go.githedgehog.com/fabricator/pkg/fab/wiring"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	kyaml "k8s.io/apimachinery/pkg/util/yaml"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// FieldOwner is the server-side apply field manager used for the in-cluster components
+const FieldOwner = "fabricator"
+
+// FabricatorReconciler applies in-cluster parts of the components (helm charts, config maps and etc.) built from the
+// Fabricator spec and pushes changed artifacts into the in-cluster registry
+type FabricatorReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Workdir is a scratch dir for the artifacts pushed to the in-cluster registry
+	Workdir string
+}
+
+//+kubebuilder:rbac:groups=fabricator.githedgehog.com,resources=fabricators,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=fabricator.githedgehog.com,resources=fabricators/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=fabricator.githedgehog.com,resources=fabricators/finalizers,verbs=update
+//+kubebuilder:rbac:groups=helm.cattle.io,resources=helmcharts,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=core,resources=configmaps;secrets;services,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=wiring.githedgehog.com,resources=racks;switchgroups;switches;servers;connections;vlannamespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=vpc.githedgehog.com,resources=ipv4namespaces,verbs=get;list;watch
+
+func (r *FabricatorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+
+	f := &fabapi.Fabricator{}
+	if err := r.Get(ctx, req.NamespacedName, f); err != nil {
+		return ctrl.Result{}, errors.Wrapf(client.IgnoreNotFound(err), "error getting fabricator")
+	}
+
+	if f.Status.ObservedGeneration == f.Generation && apimeta.IsStatusConditionTrue(f.Status.Conditions, fabapi.ConditionApplied) {
+		return ctrl.Result{}, nil
+	}
+
+	l.Info("Reconciling", "generation", f.Generation)
+
+	// controller is restarted once identity secret is mounted, so there is no point in retrying until then
+	if f.Spec.Encryption != nil && !cnc.CanUnlock() {
+		err := errors.Errorf("config is encrypted, mount identity to %s or set %s", cnc.EnvIdentity, cnc.EnvPassphrase)
+		l.Error(err, "Can't decrypt config")
+
+		f.Status.ObservedGeneration = f.Generation
+		setApplied(&f.Status.Conditions, f.Generation, fabapi.ReasonMissingIdentity, err)
+
+		return ctrl.Result{}, r.updateStatus(ctx, f)
+	}
+
+	data, err := fabwiring.LoadFromCluster(ctx, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrapf(err, "error loading wiring")
+	}
+
+	comps, err := r.plan(f, data)
+	if err != nil {
+		// there is no point in retrying until spec is changed
+		l.Error(err, "Invalid config")
+
+		f.Status.ObservedGeneration = f.Generation
+		setApplied(&f.Status.Conditions, f.Generation, fabapi.ReasonInvalidConfig, err)
+
+		return ctrl.Result{}, r.updateStatus(ctx, f)
+	}
+
+	var applyErr error
+	statuses := []fabapi.ComponentStatus{}
+	for _, comp := range comps {
+		prev := f.Status.Component(comp.Name)
+
+		status := fabapi.ComponentStatus{Name: comp.Name, Versions: comp.Versions}
+		if prev != nil {
+			status.Conditions = prev.Conditions
+		}
+
+		err := r.apply(ctx, comp, prev, &status.Conditions, f.Generation)
+		if err != nil {
+			l.Error(err, "Error applying component", "component", comp.Name)
+
+			// previous versions are most probably still running
+			if prev != nil {
+				status.Versions = prev.Versions
+			}
+			if applyErr == nil {
+				applyErr = errors.Wrapf(err, "error applying component %s", comp.Name)
+			}
+		}
+		setApplied(&status.Conditions, f.Generation, fabapi.ReasonApplyFailed, err)
+
+		statuses = append(statuses, status)
+	}
+
+	f.Status.ObservedGeneration = f.Generation
+	f.Status.Components = statuses
+	setApplied(&f.Status.Conditions, f.Generation, fabapi.ReasonApplyFailed, applyErr)
+
+	if applyErr != nil {
+		if err := r.updateStatus(ctx, f); err != nil {
+			l.Error(err, "Error updating status")
+		}
+
+		return ctrl.Result{}, applyErr
+	}
+
+	f.Status.LastAppliedTime = metav1.Now()
+
+	l.Info("Applied", "generation", f.Generation, "components", len(comps))
+
+	return ctrl.Result{}, r.updateStatus(ctx, f)
+}
+
+// plan builds components from the spec and the wiring and returns their in-cluster parts
+func (r *FabricatorReconciler) plan(f *fabapi.Fabricator, data *wiring.Data) ([]cnc.ClusterComponent, error) {
+	saver, err := cnc.ManagerSaverFromFabricator(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error converting spec")
+	}
+
+	mngr := fab.NewCNCManager()
+	if err := mngr.LoadCluster(r.Workdir, saver, data); err != nil {
+		return nil, errors.Wrapf(err, "error loading config")
+	}
+
+	opts := fab.ClusterOpts()
+	opts.Resolve = true
+
+	comps, err := mngr.ClusterComponents(opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error building components")
+	}
+
+	return comps, nil
+}
+
+// apply pushes component artifacts if its versions changed since the last successful apply and applies its manifests,
+// nothing is pushed if some of the artifacts aren't reachable in the source registry (e.g. offline install)
+func (r *FabricatorReconciler) apply(ctx context.Context, comp cnc.ClusterComponent, prev *fabapi.ComponentStatus, conditions *[]metav1.Condition, generation int64) error {
+	changed := prev == nil || !maps.Equal(prev.Versions, comp.Versions) ||
+		!apimeta.IsStatusConditionTrue(prev.Conditions, fabapi.ConditionApplied) ||
+		len(comp.Pushes) > 0 && !apimeta.IsStatusConditionTrue(prev.Conditions, fabapi.ConditionPushed)
+
+	switch {
+	case len(comp.Unreachable) > 0:
+		log.FromContext(ctx).Info("Skipping push, source registry unreachable", "component", comp.Name, "artifacts", comp.Unreachable)
+
+		apimeta.SetStatusCondition(conditions, metav1.Condition{
+			Type:               fabapi.ConditionPushed,
+			Status:             metav1.ConditionFalse,
+			Reason:             fabapi.ReasonSourceUnreachable,
+			Message:            "Artifacts can't be resolved in the source registry, use upgrade bundle to deliver them: " + strings.Join(comp.Unreachable, ", "),
+			ObservedGeneration: generation,
+		})
+	case changed && len(comp.Pushes) > 0:
+		for _, push := range comp.Pushes {
+			if err := push.Run(r.Workdir); err != nil {
+				return errors.Wrapf(err, "error pushing %s", push.Name)
+			}
+		}
+
+		apimeta.SetStatusCondition(conditions, metav1.Condition{
+			Type:               fabapi.ConditionPushed,
+			Status:             metav1.ConditionTrue,
+			Reason:             fabapi.ReasonPushed,
+			ObservedGeneration: generation,
+		})
+	}
+
+	for _, manifest := range comp.Manifests {
+		if err := r.applyManifest(ctx, manifest); err != nil {
+			return errors.Wrapf(err, "error applying %s", manifest.Name)
+		}
+	}
+
+	return nil
+}
+
+// applyManifest server-side applies all objects from the multi-document yaml
+func (r *FabricatorReconciler) applyManifest(ctx context.Context, manifest cnc.ClusterManifest) error {
+	decoder := kyaml.NewYAMLOrJSONDecoder(strings.NewReader(manifest.Content), 4096)
+
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return errors.Wrapf(err, "error decoding object")
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		if err := r.Patch(ctx, obj, client.Apply, client.FieldOwner(FieldOwner), client.ForceOwnership); err != nil {
+			return errors.Wrapf(err, "error applying %s %s", obj.GetKind(), obj.GetName())
+		}
+	}
+}
+
+func (r *FabricatorReconciler) updateStatus(ctx context.Context, f *fabapi.Fabricator) error {
+	return errors.Wrapf(r.Status().Update(ctx, f), "error updating fabricator status")
+}
+
+// setApplied sets applied condition to true if err is nil or to false with the reason and err as a message otherwise
+func setApplied(conditions *[]metav1.Condition, generation int64, reason string, err error) {
+	cond := metav1.Condition{
+		Type:               fabapi.ConditionApplied,
+		Status:             metav1.ConditionTrue,
+		Reason:             fabapi.ReasonApplied,
+		ObservedGeneration: generation,
+	}
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reason
+		cond.Message = err.Error()
+	}
+
+	apimeta.SetStatusCondition(conditions, cond)
+}
+
+// SetupWithManager sets up the controller with the Manager, only spec changes are reconciled
+func (r *FabricatorReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return errors.Wrapf(ctrl.NewControllerManagedBy(mgr).
+		For(&fabapi.Fabricator{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r), "error setting up fabricator controller")
+}
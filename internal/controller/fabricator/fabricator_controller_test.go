@@ -0,0 +1,235 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabricator
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	fabapi "go.githedgehog.com/fabricator/api/fabricator/v1alpha2"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// startEnvtest starts the API server with the Fabricator CRD installed, test is skipped if envtest isn't available
+func startEnvtest(t *testing.T) client.Client {
+	t.Helper()
+
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS isn't set, skipping envtest")
+	}
+
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("error starting envtest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("error stopping envtest: %v", err)
+		}
+	})
+
+	scheme := runtime.NewScheme()
+	if err := fabapi.AddToScheme(scheme); err != nil {
+		t.Fatalf("error adding fabricator API to scheme: %v", err)
+	}
+
+	kube, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	return kube
+}
+
+func Test_FabricatorReconcile(t *testing.T) {
+	t.Setenv(cnc.EnvIdentity, "")
+	t.Setenv(cnc.EnvPassphrase, "")
+
+	kube := startEnvtest(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		spec    *fabapi.FabricatorSpec
+		applied bool
+		reason  string
+	}{
+		{
+			name: "not-found",
+		},
+		{
+			name: "missing-identity",
+			spec: &fabapi.FabricatorSpec{
+				Preset: "lab",
+				Encryption: &fabapi.Encryption{
+					Recipients: []fabapi.EncryptionRecipient{{Type: "x25519", PublicKey: "pub", WrappedKey: "wrapped"}},
+				},
+			},
+			reason: fabapi.ReasonMissingIdentity,
+		},
+		{
+			// wiring CRDs aren't installed, so anything beyond the observed generation check would fail
+			name:    "already-applied",
+			spec:    &fabapi.FabricatorSpec{Preset: "lab"},
+			applied: true,
+			reason:  fabapi.ReasonApplied,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.spec != nil {
+				f := &fabapi.Fabricator{
+					ObjectMeta: metav1.ObjectMeta{Name: test.name, Namespace: "default"},
+					Spec:       *test.spec,
+				}
+				if err := kube.Create(ctx, f); err != nil {
+					t.Fatalf("error creating fabricator: %v", err)
+				}
+
+				if test.applied {
+					f.Status.ObservedGeneration = f.Generation
+					setApplied(&f.Status.Conditions, f.Generation, fabapi.ReasonApplyFailed, nil)
+					if err := kube.Status().Update(ctx, f); err != nil {
+						t.Fatalf("error updating status: %v", err)
+					}
+				}
+			}
+
+			r := &FabricatorReconciler{Client: kube, Scheme: kube.Scheme(), Workdir: t.TempDir()}
+			key := types.NamespacedName{Name: test.name, Namespace: "default"}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("error reconciling: %v", err)
+			}
+
+			if test.spec == nil {
+				return
+			}
+
+			f := &fabapi.Fabricator{}
+			if err := kube.Get(ctx, key, f); err != nil {
+				t.Fatalf("error getting fabricator: %v", err)
+			}
+
+			if f.Status.ObservedGeneration != f.Generation {
+				t.Errorf("observed generation %d, want %d", f.Status.ObservedGeneration, f.Generation)
+			}
+
+			cond := apimeta.FindStatusCondition(f.Status.Conditions, fabapi.ConditionApplied)
+			if cond == nil {
+				t.Fatalf("applied condition isn't set")
+			}
+			if cond.Reason != test.reason || (cond.Status == metav1.ConditionTrue) != test.applied {
+				t.Errorf("applied condition %s (%s), want reason %s", cond.Status, cond.Reason, test.reason)
+			}
+		})
+	}
+}
+
+func Test_FabricatorConfigValidation(t *testing.T) {
+	kube := startEnvtest(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		config string
+		error  bool
+	}{
+		{
+			name:   "typed-and-untyped",
+			config: `{"k3s": {"clusterCIDR": "172.28.0.0/16", "token": "ENC[v1,abcd]"}, "misc": {"ntpServers": ["1.1.1.1"]}}`,
+		},
+		{
+			name:   "dhcp-mode",
+			config: `{"fabric": {"dhcpServer": "dnsmasq"}}`,
+			error:  true,
+		},
+		{
+			name:   "fabric-mtu",
+			config: `{"fabric": {"fabricMTU": 100}}`,
+			error:  true,
+		},
+		{
+			name:   "ref-digest",
+			config: `{"zot": {"ref": {"tag": "v1", "digest": "latest"}}}`,
+			error:  true,
+		},
+		{
+			name:   "tls-key-pair",
+			config: `{"das-boot": {"tls": {"server": "cert"}}}`,
+			error:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := map[string]any{}
+			if err := json.Unmarshal([]byte(test.config), &config); err != nil {
+				t.Fatalf("error unmarshaling config: %v", err)
+			}
+
+			// unstructured is used so invalid values are sent to the API server as is
+			obj := &unstructured.Unstructured{}
+			obj.SetAPIVersion(fabapi.GroupVersion.String())
+			obj.SetKind(cnc.KindFabricator)
+			obj.SetNamespace("default")
+			obj.SetName(test.name)
+			if err := unstructured.SetNestedField(obj.Object, config, "spec", "config"); err != nil {
+				t.Fatalf("error setting config: %v", err)
+			}
+
+			err := kube.Create(ctx, obj)
+			if test.error && err == nil {
+				t.Errorf("expected error, got nil")
+			}
+			if !test.error && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if test.error || err != nil {
+				return
+			}
+
+			// fields that aren't typed in the API shouldn't be pruned
+			f := &fabapi.Fabricator{}
+			if err := kube.Get(ctx, types.NamespacedName{Name: test.name, Namespace: "default"}, f); err != nil {
+				t.Fatalf("error getting fabricator: %v", err)
+			}
+
+			saver, err := cnc.ManagerSaverFromFabricator(f)
+			if err != nil {
+				t.Fatalf("error converting fabricator: %v", err)
+			}
+			if !reflect.DeepEqual(saver.Config, config) {
+				t.Errorf("config mismatch:\ngot  %#v\nwant %#v", saver.Config, config)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabricator
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	fabapi "go.githedgehog.com/fabricator/api/fabricator/v1alpha2"
+	"go.githedgehog.com/fabricator/pkg/fab"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	fabwiring "go.githedgehog.com/fabricator/pkg/fab/wiring"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// FabricatorWebhook defaults and validates Fabricator objects the same way as hhfab does on init: components are
+// hydrated and validated against the wiring from the cluster and all ops are built to check refs
+type FabricatorWebhook struct {
+	Client client.Reader
+	// Workdir is a scratch dir, nothing is downloaded into it during validation
+	Workdir string
+}
+
+//+kubebuilder:webhook:path=/mutate-fabricator-githedgehog-com-v1alpha2-fabricator,mutating=true,failurePolicy=fail,sideEffects=None,groups=fabricator.githedgehog.com,resources=fabricators,verbs=create;update,versions=v1alpha2,name=mfabricator.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-fabricator-githedgehog-com-v1alpha2-fabricator,mutating=false,failurePolicy=fail,sideEffects=None,groups=fabricator.githedgehog.com,resources=fabricators,verbs=create;update,versions=v1alpha2,name=vfabricator.kb.io,admissionReviewVersions=v1
+
+var (
+	_ webhook.CustomDefaulter = (*FabricatorWebhook)(nil)
+	_ webhook.CustomValidator = (*FabricatorWebhook)(nil)
+)
+
+func (w *FabricatorWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return errors.Wrapf(ctrl.NewWebhookManagedBy(mgr).
+		For(&fabapi.Fabricator{}).
+		WithDefaulter(w).
+		WithValidator(w).
+		Complete(), "error setting up fabricator webhook")
+}
+
+// load hydrates and validates components config from the spec, it's the same as the CLI does on init, secrets
+// generated on hydration (e.g. missing cert keys or k3s token) are returned as an error as they can't be stored in the
+// spec in plaintext and would be re-generated on each load otherwise
+func (w *FabricatorWebhook) load(ctx context.Context, f *fabapi.Fabricator) (*cnc.Manager, error) {
+	data, err := fabwiring.LoadFromCluster(ctx, w.Client)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading wiring")
+	}
+
+	saver, err := cnc.ManagerSaverFromFabricator(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error converting spec")
+	}
+
+	mngr := fab.NewCNCManager()
+	if err := mngr.LoadCluster(w.Workdir, saver, data); err != nil {
+		return nil, errors.Wrapf(err, "error loading config")
+	}
+
+	generated, err := mngr.GeneratedSecrets(saver)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error checking secrets")
+	}
+	if len(generated) > 0 {
+		return nil, errors.Errorf("secrets should be set in the spec (use hhfab init and config export): %s", strings.Join(generated, ", "))
+	}
+
+	return mngr, nil
+}
+
+// Default sets the spec to the hydrated config, so all defaults (e.g. refs) are stored and used by the controller,
+// invalid config (including the one missing secrets) is left as is to be rejected by validation
+func (w *FabricatorWebhook) Default(ctx context.Context, obj runtime.Object) error {
+	f, ok := obj.(*fabapi.Fabricator)
+	if !ok {
+		return errors.Errorf("expected a Fabricator but got a %T", obj)
+	}
+
+	// secrets could only be re-encrypted with the identity which isn't always available in cluster
+	if f.Spec.Encryption != nil {
+		return nil
+	}
+
+	mngr, err := w.load(ctx, f)
+	if err != nil {
+		log.FromContext(ctx).Info("Skipping defaulting of invalid fabricator", "name", f.Name, "err", err.Error())
+
+		return nil
+	}
+
+	hydrated, err := mngr.Fabricator(f.Name, f.Namespace)
+	if err != nil {
+		return errors.Wrapf(err, "error converting hydrated config")
+	}
+
+	f.Spec = hydrated.Spec
+
+	return nil
+}
+
+func (w *FabricatorWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	f, ok := obj.(*fabapi.Fabricator)
+	if !ok {
+		return nil, errors.Errorf("expected a Fabricator but got a %T", obj)
+	}
+
+	return w.validate(ctx, f)
+}
+
+func (w *FabricatorWebhook) ValidateUpdate(ctx context.Context, oldObj runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	oldF, ok := oldObj.(*fabapi.Fabricator)
+	if !ok {
+		return nil, errors.Errorf("expected a Fabricator but got a %T", oldObj)
+	}
+	newF, ok := newObj.(*fabapi.Fabricator)
+	if !ok {
+		return nil, errors.Errorf("expected a Fabricator but got a %T", newObj)
+	}
+
+	// preset and fabric mode are baked into the control node and switches on install
+	if oldF.Spec.Preset != newF.Spec.Preset {
+		return nil, errors.Errorf("preset is immutable: %s != %s", newF.Spec.Preset, oldF.Spec.Preset)
+	}
+	if oldF.Spec.FabricMode != newF.Spec.FabricMode {
+		return nil, errors.Errorf("fabric mode is immutable: %s != %s", newF.Spec.FabricMode, oldF.Spec.FabricMode)
+	}
+
+	return w.validate(ctx, newF)
+}
+
+func (w *FabricatorWebhook) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate runs components hydration and validation (including wiring validation) and builds all ops to validate refs,
+// encrypted config is only validated partially if there is no identity to decrypt it
+func (w *FabricatorWebhook) validate(ctx context.Context, f *fabapi.Fabricator) (admission.Warnings, error) {
+	if f.Spec.Encryption != nil && !cnc.CanUnlock() {
+		saver, err := cnc.ManagerSaverFromFabricator(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid fabricator config")
+		}
+		if err := fab.NewCNCManager().CheckSaver(saver); err != nil {
+			return nil, errors.Wrapf(err, "invalid fabricator config")
+		}
+
+		return admission.Warnings{"config is encrypted and there is no identity to decrypt it, only preset and fabric mode are validated"}, nil
+	}
+
+	mngr, err := w.load(ctx, f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid fabricator config")
+	}
+
+	if _, err := mngr.ClusterComponents(fab.ClusterOpts()); err != nil {
+		return nil, errors.Wrapf(err, "invalid fabricator config")
+	}
+
+	return nil, nil
+}
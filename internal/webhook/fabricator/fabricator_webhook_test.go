@@ -0,0 +1,172 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabricator
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.githedgehog.com/fabric/api/meta"
+	vpcapi "go.githedgehog.com/fabric/api/vpc/v1alpha2"
+	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
+	fabapi "go.githedgehog.com/fabricator/api/fabricator/v1alpha2"
+	"go.githedgehog.com/fabricator/pkg/fab"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	fabwiring "go.githedgehog.com/fabricator/pkg/fab/wiring"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestWebhook returns webhook with the VLAB wiring in the fake cluster and the config hydrated by hhfab for it
+func newTestWebhook(t *testing.T) (*FabricatorWebhook, *fabapi.Fabricator) {
+	t.Helper()
+
+	data, err := (&fabwiring.Builder{
+		Defaulted:  true,
+		Hydrated:   true,
+		FabricMode: meta.FabricModeSpineLeaf,
+	}).Build()
+	if err != nil {
+		t.Fatalf("error building wiring: %v", err)
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(wiringapi.AddToScheme(scheme))
+	utilruntime.Must(vpcapi.AddToScheme(scheme))
+
+	objs := []client.Object{}
+	for _, obj := range data.IPv4Namespace.All() {
+		objs = append(objs, obj)
+	}
+	for _, obj := range data.VLANNamespace.All() {
+		objs = append(objs, obj)
+	}
+	for _, obj := range data.Rack.All() {
+		objs = append(objs, obj)
+	}
+	for _, obj := range data.SwitchGroup.All() {
+		objs = append(objs, obj)
+	}
+	for _, obj := range data.Switch.All() {
+		objs = append(objs, obj)
+	}
+	for _, obj := range data.Server.All() {
+		objs = append(objs, obj)
+	}
+	for _, obj := range data.Connection.All() {
+		objs = append(objs, obj)
+	}
+
+	mngr := fab.NewCNCManager()
+	if err := mngr.LoadCluster(t.TempDir(), &cnc.ManagerSaver{Preset: fab.PresetVLAB, FabricMode: meta.FabricModeSpineLeaf}, data); err != nil {
+		t.Fatalf("error hydrating config: %v", err)
+	}
+
+	f, err := mngr.Fabricator("default", "default")
+	if err != nil {
+		t.Fatalf("error converting config: %v", err)
+	}
+
+	return &FabricatorWebhook{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Workdir: t.TempDir(),
+	}, f
+}
+
+func Test_FabricatorWebhookSecrets(t *testing.T) {
+	w, hydrated := newTestWebhook(t)
+
+	tests := []struct {
+		name    string
+		spec    fabapi.FabricatorSpec
+		changed bool
+		err     string
+	}{
+		{
+			name: "hydrated",
+			spec: hydrated.Spec,
+		},
+		{
+			name: "missing-secrets",
+			spec: fabapi.FabricatorSpec{Preset: hydrated.Spec.Preset, FabricMode: hydrated.Spec.FabricMode},
+			err:  "secrets should be set in the spec",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fabapi.Fabricator{ObjectMeta: hydrated.ObjectMeta, Spec: *test.spec.DeepCopy()}
+
+			if err := w.Default(context.Background(), f); err != nil {
+				t.Fatalf("error defaulting: %v", err)
+			}
+			if !reflect.DeepEqual(f.Spec, test.spec) {
+				t.Errorf("defaulting shouldn't change the spec")
+			}
+
+			_, err := w.ValidateCreate(context.Background(), f)
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func Test_FabricatorWebhookImmutable(t *testing.T) {
+	w, hydrated := newTestWebhook(t)
+
+	tests := []struct {
+		name   string
+		modify func(spec *fabapi.FabricatorSpec)
+		err    string
+	}{
+		{
+			name:   "same",
+			modify: func(*fabapi.FabricatorSpec) {},
+		},
+		{
+			name:   "preset",
+			modify: func(spec *fabapi.FabricatorSpec) { spec.Preset = string(fab.PresetBM) },
+			err:    "preset is immutable",
+		},
+		{
+			name:   "fabric-mode",
+			modify: func(spec *fabapi.FabricatorSpec) { spec.FabricMode = string(meta.FabricModeCollapsedCore) },
+			err:    "fabric mode is immutable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := hydrated.DeepCopy()
+			test.modify(&f.Spec)
+
+			_, err := w.ValidateUpdate(context.Background(), hydrated, f)
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
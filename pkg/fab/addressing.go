@@ -0,0 +1,271 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fab
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.githedgehog.com/fabric/api/meta"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+)
+
+const (
+	MinVLAN      = 1
+	MaxVLAN      = 4094
+	MinFabricMTU = 1500
+	MaxFabricMTU = 9216
+)
+
+var esiPrefixRegex = regexp.MustCompile(`^([0-9a-f]{2}:){4}$`)
+
+func vlanRangeString(r meta.VLANRange) string {
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// parseVLANRanges parses VLAN ranges in form of from-to or single VLAN
+func parseVLANRanges(values []string) ([]meta.VLANRange, error) {
+	res := []meta.VLANRange{}
+
+	for _, value := range values {
+		from, to, isRange := strings.Cut(strings.TrimSpace(value), "-")
+		if !isRange {
+			to = from
+		}
+
+		fromVLAN, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid vlan range %q", value)
+		}
+		toVLAN, err := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid vlan range %q", value)
+		}
+
+		res = append(res, meta.VLANRange{From: uint16(fromVLAN), To: uint16(toVLAN)})
+	}
+
+	return res, nil
+}
+
+// validateVLANRanges checks that all ranges are valid and there are no overlaps between any of them
+func validateVLANRanges(ranges map[string][]meta.VLANRange) error {
+	type named struct {
+		name string
+		meta.VLANRange
+	}
+
+	all := []named{}
+	for name, list := range ranges {
+		for _, r := range list {
+			if r.From < MinVLAN || r.To > MaxVLAN || r.From > r.To {
+				return errors.Errorf("invalid %s vlan range %s, should be within %d-%d", name, vlanRangeString(r), MinVLAN, MaxVLAN)
+			}
+
+			all = append(all, named{name: name, VLANRange: r})
+		}
+	}
+
+	for i := range all {
+		for j := i + 1; j < len(all); j++ {
+			a, b := all[i], all[j]
+			if a.From <= b.To && b.From <= a.To {
+				return errors.Errorf("%s vlan range %s overlaps with %s vlan range %s", a.name, vlanRangeString(a.VLANRange), b.name, vlanRangeString(b.VLANRange))
+			}
+		}
+	}
+
+	return nil
+}
+
+type namedPrefix struct {
+	name   string
+	prefix netip.Prefix
+}
+
+func parsePrefix(name, value string) (namedPrefix, error) {
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return namedPrefix{}, errors.Wrapf(err, "invalid %s %q", name, value)
+	}
+	if prefix.Masked() != prefix {
+		return namedPrefix{}, errors.Errorf("invalid %s %q, host bits are set", name, value)
+	}
+
+	return namedPrefix{name: name, prefix: prefix}, nil
+}
+
+// parseAddr parses IP address with optional prefix length
+func parseAddr(value string) (netip.Addr, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Addr(), nil
+	}
+
+	return netip.ParseAddr(value) //nolint:wrapcheck
+}
+
+// validateAddressing checks that all configured subnets, VLAN ranges and wiring IPs are consistent and not overlapping
+func validateAddressing(get cnc.GetComponent, fabric *Fabric, data *wiring.Data) error {
+	base := BaseConfig(get)
+	k3s := K3sConfig(get)
+
+	subnet, err := parsePrefix("fabric subnet", base.Subnet)
+	if err != nil {
+		return err
+	}
+	if subnet.prefix.Bits() != 16 || !subnet.prefix.Addr().Is4() {
+		return errors.Errorf("fabric subnet %s should be an IPv4 /16", base.Subnet)
+	}
+
+	vip, err := netip.ParseAddr(base.ControlVIP)
+	if err != nil {
+		return errors.Wrapf(err, "invalid control vip %q", base.ControlVIP)
+	}
+	if !subnet.prefix.Contains(vip) {
+		return errors.Errorf("control vip %s should be inside of the fabric subnet %s", vip, subnet.prefix)
+	}
+
+	clusterCIDR, err := parsePrefix("k3s cluster cidr", k3s.ClusterCIDR)
+	if err != nil {
+		return err
+	}
+	serviceCIDR, err := parsePrefix("k3s service cidr", k3s.ServiceCIDR)
+	if err != nil {
+		return err
+	}
+
+	clusterDNS, err := netip.ParseAddr(k3s.ClusterDNS)
+	if err != nil {
+		return errors.Wrapf(err, "invalid k3s cluster dns %q", k3s.ClusterDNS)
+	}
+	if !serviceCIDR.prefix.Contains(clusterDNS) {
+		return errors.Errorf("k3s cluster dns %s should be inside of the service cidr %s", clusterDNS, serviceCIDR.prefix)
+	}
+
+	if dasBoot := DasBootConfig(get); dasBoot.ClusterIP != "" {
+		seederIP, err := netip.ParseAddr(dasBoot.ClusterIP)
+		if err != nil {
+			return errors.Wrapf(err, "invalid das boot seeder cluster ip %q", dasBoot.ClusterIP)
+		}
+		if !serviceCIDR.prefix.Contains(seederIP) {
+			return errors.Errorf("das boot seeder cluster ip %s should be inside of the service cidr %s", seederIP, serviceCIDR.prefix)
+		}
+	}
+
+	vpcLoopback, err := parsePrefix("vpc loopback subnet", fabric.VPCLoopbackSubnet)
+	if err != nil {
+		return err
+	}
+
+	reserved := []namedPrefix{}
+	for _, value := range fabric.ReservedSubnets {
+		prefix, err := parsePrefix("reserved subnet", value)
+		if err != nil {
+			return err
+		}
+
+		reserved = append(reserved, prefix)
+	}
+
+	// fabric subnet contains vpc loopback subnet by default, so it's only checked against the rest
+	nonOverlapping := append([]namedPrefix{subnet, clusterCIDR, serviceCIDR}, reserved...)
+	for i := range nonOverlapping {
+		for j := i + 1; j < len(nonOverlapping); j++ {
+			a, b := nonOverlapping[i], nonOverlapping[j]
+			if a.prefix.Overlaps(b.prefix) {
+				return errors.Errorf("%s %s overlaps with %s %s", a.name, a.prefix, b.name, b.prefix)
+			}
+		}
+	}
+	for _, other := range nonOverlapping[1:] {
+		if vpcLoopback.prefix.Overlaps(other.prefix) {
+			return errors.Errorf("%s %s overlaps with %s %s", vpcLoopback.name, vpcLoopback.prefix, other.name, other.prefix)
+		}
+	}
+
+	if err := validateVLANRanges(map[string][]meta.VLANRange{
+		"vpc irb":     fabric.VPCIRBVLANRanges,
+		"vpc peering": fabric.VPCPeeringVLANRanges,
+	}); err != nil {
+		return err
+	}
+
+	if fabric.FabricMTU < MinFabricMTU || fabric.FabricMTU > MaxFabricMTU {
+		return errors.Errorf("fabric mtu %d should be within %d-%d", fabric.FabricMTU, MinFabricMTU, MaxFabricMTU)
+	}
+
+	if _, err := net.ParseMAC(fabric.ESLAGMACBase); err != nil {
+		return errors.Wrapf(err, "invalid eslag mac base %q", fabric.ESLAGMACBase)
+	}
+	if !esiPrefixRegex.MatchString(fabric.ESLAGESIPrefix) {
+		return errors.Errorf("invalid eslag esi prefix %q, expected 4 lowercase hex bytes followed by colon, e.g. %s", fabric.ESLAGESIPrefix, ESLAGESIPrefix)
+	}
+
+	// switch and management IPs shouldn't collide with anything that's routed differently
+	forbidden := append([]namedPrefix{clusterCIDR, serviceCIDR, vpcLoopback}, reserved...)
+	checkIP := func(owner, value string, inSubnet bool) error {
+		if value == "" {
+			return nil
+		}
+
+		addr, err := parseAddr(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid ip %q for %s", value, owner)
+		}
+
+		if inSubnet && !subnet.prefix.Contains(addr) {
+			return errors.Errorf("ip %s for %s is outside of the fabric subnet %s, wiring may need to be re-hydrated", addr, owner, subnet.prefix)
+		}
+		if addr == vip {
+			return errors.Errorf("ip %s for %s is the control vip", addr, owner)
+		}
+		for _, other := range forbidden {
+			if other.prefix.Contains(addr) {
+				return errors.Errorf("ip %s for %s is inside of the %s %s", addr, owner, other.name, other.prefix)
+			}
+		}
+
+		return nil
+	}
+
+	for _, sw := range data.Switch.All() {
+		for _, ip := range []string{sw.Spec.IP, sw.Spec.ProtocolIP, sw.Spec.VTEPIP} {
+			if err := checkIP("switch "+sw.Name, ip, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, conn := range data.Connection.All() {
+		if conn.Spec.Management == nil {
+			continue
+		}
+
+		link := conn.Spec.Management.Link
+		for _, ip := range []string{link.Server.IP, link.Switch.IP} {
+			if err := checkIP("connection "+conn.Name, ip, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fab
+
+import (
+	"reflect"
+	"testing"
+
+	"go.githedgehog.com/fabric/api/meta"
+	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ParseVLANRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		result []meta.VLANRange
+		err    bool
+	}{
+		{
+			name:   "range",
+			values: []string{"100-200"},
+			result: []meta.VLANRange{{From: 100, To: 200}},
+		},
+		{
+			name:   "single",
+			values: []string{"100"},
+			result: []meta.VLANRange{{From: 100, To: 100}},
+		},
+		{
+			name:   "multiple-with-spaces",
+			values: []string{" 100 - 200 ", "300"},
+			result: []meta.VLANRange{{From: 100, To: 200}, {From: 300, To: 300}},
+		},
+		{
+			name:   "empty",
+			result: []meta.VLANRange{},
+		},
+		{
+			name:   "not-a-number",
+			values: []string{"abc"},
+			err:    true,
+		},
+		{
+			name:   "missing-to",
+			values: []string{"100-"},
+			err:    true,
+		},
+		{
+			name:   "negative",
+			values: []string{"-100"},
+			err:    true,
+		},
+		{
+			name:   "too-large",
+			values: []string{"100-70000"},
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parseVLANRanges(test.values)
+			if test.err && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.err && !reflect.DeepEqual(result, test.result) {
+				t.Errorf("parseVLANRanges() = %v, want %v", result, test.result)
+			}
+		})
+	}
+}
+
+func Test_ValidateAddressing(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(base *Base, k3s *K3s, fabric *Fabric)
+		switchIP string
+		err      bool
+	}{
+		{
+			name:     "valid",
+			switchIP: "172.30.8.0/32",
+		},
+		{
+			name:   "subnet-not-16",
+			modify: func(base *Base, _ *K3s, _ *Fabric) { base.Subnet = "172.30.0.0/24" },
+			err:    true,
+		},
+		{
+			name:   "subnet-host-bits",
+			modify: func(base *Base, _ *K3s, _ *Fabric) { base.Subnet = "172.30.0.1/16" },
+			err:    true,
+		},
+		{
+			name:   "vip-outside-subnet",
+			modify: func(base *Base, _ *K3s, _ *Fabric) { base.ControlVIP = "172.31.1.1" },
+			err:    true,
+		},
+		{
+			name:   "cluster-cidr-overlaps-subnet",
+			modify: func(_ *Base, k3s *K3s, _ *Fabric) { k3s.ClusterCIDR = "172.30.128.0/17" },
+			err:    true,
+		},
+		{
+			name: "service-cidr-overlaps-cluster-cidr",
+			modify: func(_ *Base, k3s *K3s, _ *Fabric) {
+				k3s.ServiceCIDR = "172.28.0.0/16"
+				k3s.ClusterDNS = "172.28.0.10"
+			},
+			err: true,
+		},
+		{
+			name:   "cluster-dns-outside-service-cidr",
+			modify: func(_ *Base, k3s *K3s, _ *Fabric) { k3s.ClusterDNS = "172.28.0.10" },
+			err:    true,
+		},
+		{
+			name:   "reserved-overlaps-service-cidr",
+			modify: func(_ *Base, _ *K3s, fabric *Fabric) { fabric.ReservedSubnets = []string{"172.29.0.0/20"} },
+			err:    true,
+		},
+		{
+			name:   "vpc-loopback-overlaps-reserved",
+			modify: func(_ *Base, _ *K3s, fabric *Fabric) { fabric.VPCLoopbackSubnet = "172.31.0.0/20" },
+			err:    true,
+		},
+		{
+			name: "irb-vlans-overlap-peering",
+			modify: func(_ *Base, _ *K3s, fabric *Fabric) {
+				fabric.VPCIRBVLANRanges = []meta.VLANRange{{From: 900, To: 1999}}
+			},
+			err: true,
+		},
+		{
+			name: "vlan-range-out-of-bounds",
+			modify: func(_ *Base, _ *K3s, fabric *Fabric) {
+				fabric.VPCIRBVLANRanges = []meta.VLANRange{{From: 4000, To: 4095}}
+			},
+			err: true,
+		},
+		{
+			name: "vlan-range-reversed",
+			modify: func(_ *Base, _ *K3s, fabric *Fabric) {
+				fabric.VPCIRBVLANRanges = []meta.VLANRange{{From: 3999, To: 3000}}
+			},
+			err: true,
+		},
+		{
+			name:   "mtu-too-large",
+			modify: func(_ *Base, _ *K3s, fabric *Fabric) { fabric.FabricMTU = 9300 },
+			err:    true,
+		},
+		{
+			name:     "switch-ip-outside-subnet",
+			switchIP: "10.0.0.1/32",
+			err:      true,
+		},
+		{
+			name:     "switch-ip-is-vip",
+			switchIP: "172.30.1.1/32",
+			err:      true,
+		},
+		{
+			name:     "switch-ip-in-vpc-loopback",
+			switchIP: "172.30.240.1/32",
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := &Base{Subnet: HHSubnet, ControlVIP: ControlVIP}
+			k3s := &K3s{ClusterCIDR: ControlKubeClusterCIDR, ServiceCIDR: ControlKubeServiceCIDR, ClusterDNS: ControlKubeClusterDNS}
+			dasBoot := &DasBoot{ClusterIP: DasBootSeederClusterIP}
+			fabric := &Fabric{
+				VPCIRBVLANRanges:     []meta.VLANRange{VPCIRBVLANRange},
+				VPCPeeringVLANRanges: []meta.VLANRange{VPCPeeringVLANRange},
+				ReservedSubnets:      []string{VLABSubnet},
+				VPCLoopbackSubnet:    VPCLoopbackSubnet,
+				FabricMTU:            FabricMTU,
+				ESLAGMACBase:         ESLAGMACBase,
+				ESLAGESIPrefix:       ESLAGESIPrefix,
+			}
+			if test.modify != nil {
+				test.modify(base, k3s, fabric)
+			}
+
+			get := func(name string) cnc.Component {
+				for _, comp := range []cnc.Component{base, k3s, dasBoot, fabric} {
+					if comp.Name() == name {
+						return comp
+					}
+				}
+
+				return nil
+			}
+
+			data, err := wiring.New()
+			if err != nil {
+				t.Fatalf("error creating wiring data: %v", err)
+			}
+
+			if test.switchIP != "" {
+				err = data.Add(&wiringapi.Switch{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       wiringapi.KindSwitch,
+						APIVersion: wiringapi.GroupVersion.String(),
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "switch-01",
+					},
+					Spec: wiringapi.SwitchSpec{
+						IP: test.switchIP,
+					},
+				})
+				if err != nil {
+					t.Fatalf("error adding switch: %v", err)
+				}
+			}
+
+			err = validateAddressing(get, fabric, data)
+			if test.err && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -16,7 +16,6 @@ package fab
 
 import (
 	"crypto/x509"
-	"fmt"
 	"os/user"
 	"path/filepath"
 
@@ -28,15 +27,22 @@ import (
 )
 
 var (
+	// Defaults for the addressing plan, all of them could be changed in config
 	HHSubnet                      = "172.30.0.0/16" // All Hedgehog Fabric IPs assignment will happen from this subnet
+	VLABSubnet                    = "172.31.0.0/16"
 	ControlKubeClusterCIDR        = "172.28.0.0/16"
 	ControlKubeServiceCIDR        = "172.29.0.0/16"
 	ControlKubeClusterDNS         = "172.29.0.10"
 	ControlVIP                    = "172.30.1.1"
 	ControlVIPMask                = "/32"
+	VPCLoopbackSubnet             = "172.30.240.0/20"
+	VPCIRBVLANRange               = meta.VLANRange{From: 3000, To: 3999}
+	VPCPeeringVLANRange           = meta.VLANRange{From: 100, To: 999}
+	FabricMTU              uint16 = 9100
+	ESLAGMACBase                  = "f2:00:00:00:00:00"
+	ESLAGESIPrefix                = "00:f2:00:00:"
 	ASNSpine               uint32 = 65100
 	ASNLeafStart           uint32 = 65101
-	ZotCheckURL                   = fmt.Sprintf("https://%s:%d/v2/_catalog", ControlVIP, ZotNodePort)
 	K3sAPIPort                    = 6443
 	ZotNodePort                   = 31000
 	DasBootNTPNodePort            = 30123
@@ -60,12 +66,11 @@ var (
 	OCIScheme = "oci://"
 
 	// Base
-	RefSource          = cnc.Ref{Repo: "ghcr.io/githedgehog"}
-	RefTarget          = cnc.Ref{Repo: fmt.Sprintf("%s:%d/githedgehog", ControlVIP, ZotNodePort)}
-	RefTargetInCluster = RefTarget
+	RefSource = cnc.Ref{Repo: "ghcr.io/githedgehog"}
 
 	// K3s
-	RefK3s = cnc.Ref{Name: "k3s", Tag: "v1.29.1-k3s2"}
+	RefK3s     = cnc.Ref{Name: "k3s", Tag: "v1.29.1-k3s2"}
+	RefKubeVIP = cnc.Ref{Name: "kube-vip", Tag: "v0.7.2"}
 
 	// Zot
 	RefZot            = cnc.Ref{Name: "zot", Tag: "v1.4.3"}
@@ -88,48 +93,8 @@ var (
 	RefDasBootNTPImage = cnc.Ref{Name: "das-boot/ntp", Tag: "latest"}
 
 	// ONIE
-	RefHONIEVersion        = cnc.Ref{Tag: "0.1.3"}
-	RefONIETargetVersion   = cnc.Ref{Tag: "latest"} // the target tag currently *must* always be "latest" as this is hardcoded in DAS BOOT
-	RefONIESrcTargetsPairs = []struct {
-		src     cnc.Ref
-		targets []cnc.Ref
-	}{
-		{
-			src:     cnc.Ref{Name: "honie/onie-updater-x86_64-kvm_x86_64-r0"},
-			targets: []cnc.Ref{{Name: "onie/onie-updater-x86_64-kvm_x86_64-r0"}},
-		},
-		// Technically there are more platforms within the AS4630 family.
-		// However, our HONIE image will only work on the AS4630-54NPE.
-		// The other platforms have even different lane mapping etc. and need to be prepared for
-		// first within the platform-accton repository before we can use them.
-		// This is why we are creating tags *only* for the 54NPE.
-		{
-			src:     cnc.Ref{Name: "honie/onie-updater-x86_64-accton_as4630-r0"},
-			targets: []cnc.Ref{{Name: "onie/onie-updater-x86_64-accton_as4630_54npe-r0"}},
-		},
-		{
-			src:     cnc.Ref{Name: "honie/onie-updater-x86_64-accton_as7326_56x-r0"},
-			targets: []cnc.Ref{{Name: "onie/onie-updater-x86_64-accton_as7326_56x-r0"}},
-		},
-		{
-			src:     cnc.Ref{Name: "honie/onie-updater-x86_64-accton_as7726_32x-r0"},
-			targets: []cnc.Ref{{Name: "onie/onie-updater-x86_64-accton_as7726_32x-r0"}},
-		},
-		// Technically the HONIE image is prepared for *all* the devices in the S5200 family.
-		// This is why we are creating tags for all of the platforms already.
-		// However, officially we are only supporting the 5232 and 5248.
-		{
-			src: cnc.Ref{Name: "honie/onie-updater-x86_64-dellemc_s5200_c3538-r0"},
-			targets: []cnc.Ref{
-				// {Name: "onie/onie-updater-x86_64-dellemc_s5200_c3538-r0"},
-				// {Name: "onie/onie-updater-x86_64-dellemc_s5212f_c3538-r0"},
-				// {Name: "onie/onie-updater-x86_64-dellemc_s5224f_c3538-r0"},
-				{Name: "onie/onie-updater-x86_64-dellemc_s5232f_c3538-r0"},
-				{Name: "onie/onie-updater-x86_64-dellemc_s5248f_c3538-r0"},
-				// {Name: "onie/onie-updater-x86_64-dellemc_s5296f_c3538-r0"},
-			},
-		},
-	}
+	RefHONIEVersion      = cnc.Ref{Tag: "0.1.3"}
+	RefONIETargetVersion = cnc.Ref{Tag: "latest"} // the target tag currently *must* always be "latest" as this is hardcoded in DAS BOOT
 
 	// SONiC
 	RefSonicBCMBase   = cnc.Ref{Name: "sonic-bcom-private", Tag: "base-bin-4.2.0"}
@@ -137,22 +102,6 @@ var (
 	RefSonicBCMVS     = cnc.Ref{Name: "sonic-bcom-private", Tag: "vs-bin-4.2.0"}
 
 	RefSonicTargetVersion = cnc.Ref{Tag: "latest"}
-	RefSonicTargetsBase   = []cnc.Ref{
-		{Name: "sonic/x86_64-dellemc_s5248f_c3538-r0"}, // Dell S5248
-		{Name: "sonic/x86_64-dellemc_s5232f_c3538-r0"}, // Dell S5232
-		{Name: "sonic/x86_64-cel_questone_2-r0"},       // Celestica DS2000
-		{Name: "sonic/x86_64-cel_seastone_2-r0"},       // Celestica DS3000
-		{Name: "sonic/x86_64-cel_silverstone-r0"},      // Celestica DS4000
-		{Name: "sonic/x86_64-accton_as7726_32x-r0"},    // EdgeCore DCS204
-		{Name: "sonic/x86_64-accton_as7326_56x-r0"},    // EdgeCore DCS203
-		{Name: "sonic/x86_64-accton_as7712_32x-r0"},    // Edgecore AS7712-32X
-	}
-	RefSonicTargetsCampus = []cnc.Ref{
-		{Name: "sonic/x86_64-accton_as4630_54npe-r0"}, // EdgeCore EPS202
-	}
-	RefSonicTargetsVS = []cnc.Ref{
-		{Name: "sonic/x86_64-kvm_x86_64-r0"}, // VS
-	}
 
 	// Fabric
 	RefFabricVersion         = cnc.Ref{Tag: "v0.33.0"}
@@ -210,6 +159,14 @@ var (
 	BundleControlOS = cnc.Bundle{
 		Name: "control-os",
 	}
+	BundleControlCertsUpdate = cnc.Bundle{ // Minimal update of the installed control node after certs rotation
+		Name:        "control-certs-update",
+		IsInstaller: true,
+	}
+	BundleControlUpgrade = cnc.Bundle{ // Day-2 upgrade of the installed control node, only built on request
+		Name:        "control-upgrade",
+		IsInstaller: true,
+	}
 	BundleServerInstall = cnc.Bundle{
 		Name:        "server-install",
 		IsInstaller: true,
@@ -228,26 +185,72 @@ var (
 	}
 )
 
+// BundleControlJoin is an installer bundle for the control node joining the cluster initialized by the first one
+func BundleControlJoin(name string) cnc.Bundle {
+	return cnc.Bundle{
+		Name:        "control-join-" + name,
+		IsInstaller: true,
+	}
+}
+
+// BundleControlJoinCertsUpdate is a minimal update of the joined control node after certs rotation, it only refreshes
+// node-local trust as the cluster-wide secrets are updated by the control certs update bundle
+func BundleControlJoinCertsUpdate(name string) cnc.Bundle {
+	return cnc.Bundle{
+		Name:        "control-certs-update-" + name,
+		IsInstaller: true,
+	}
+}
+
+// ControlUpgradeOpts returns options to build the control upgrade bundle with artifacts and manifests changed since
+// the previous build (basedir or artifacts lock)
+func ControlUpgradeOpts(from string) *cnc.UpgradeOpts {
+	return &cnc.UpgradeOpts{
+		From:            from,
+		Source:          BundleControlInstall,
+		Target:          BundleControlUpgrade,
+		ManifestsTarget: K3sManifestsDir,
+	}
+}
+
+// Fabricator object with the as-installed config
+const (
+	FabricatorName      = "default"
+	FabricatorNamespace = "default"
+)
+
+// ClusterOpts returns options to select the control install ops that the controller applies to the running cluster,
+// wiring is skipped as the cluster is the source of truth for it
+func ClusterOpts() cnc.ClusterOpts {
+	return cnc.ClusterOpts{
+		Source:          BundleControlInstall,
+		ManifestsTarget: K3sManifestsDir,
+		Skip:            []string{"fabric-wiring"},
+	}
+}
+
 // We expect services installed during the stage to be available at the end of it
 const (
-	Stage                cnc.Stage = iota // Just a placeholder stage
-	StageInstall0Prep                     // Preparation for K3s and Zot installation
-	StageInstall1K3sZot                   // Kube and Registry Installation, wait for registry available
-	StageInstall2Misc                     // Install misc services and wait for them to be ready
-	StageInstall3Fabric                   // Install Fabric and wait for it to be ready
-	StageInstall4DasBoot                  // Install Das Boot and wait for it to be ready
+	Stage                 cnc.Stage = iota // Just a placeholder stage
+	StageInstallPreflight                  // Check the host before changing anything on it
+	StageInstall0Prep                      // Preparation for K3s and Zot installation
+	StageInstall1K3sZot                    // Kube and Registry Installation, wait for registry available
+	StageInstall2Misc                      // Install misc services and wait for them to be ready
+	StageInstall3Fabric                    // Install Fabric and wait for it to be ready
+	StageInstall4DasBoot                   // Install Das Boot and wait for it to be ready
 	StageInstall9Reloader
 
 	StageMax // Keep it last so we can iterate over all stages
 )
 
 func NewCNCManager() *cnc.Manager {
-	return cnc.New(
+	mngr := cnc.New(
 		Presets,
-		[]cnc.Bundle{BundleControlInstall, BundleControlOS, BundleServerInstall, BundleServerOS, BundleVlabFiles},
+		[]cnc.Bundle{BundleControlInstall, BundleControlOS, BundleControlCertsUpdate, BundleServerInstall, BundleServerOS, BundleVlabFiles},
 		StageMax,
 		[]cnc.Component{
 			&Base{},
+			&Preflight{},
 			&ControlOS{},
 			&K3s{},
 			&Zot{},
@@ -263,6 +266,20 @@ func NewCNCManager() *cnc.Manager {
 			LeafASNStart: ASNLeafStart,
 		},
 	)
+	mngr.SetCustomComponentsLoader(LoadCustomComponents)
+	mngr.SetConfigInstall(&cnc.ConfigInstall{
+		Bundle:    BundleControlInstall,
+		Stage:     StageInstall2Misc,
+		Target:    K3sManifestsDir,
+		Name:      FabricatorName,
+		Namespace: FabricatorNamespace,
+	})
+	mngr.SetReleaseInstall(&cnc.ReleaseInstall{
+		Target: "/opt/hedgehog",
+		Skip:   []cnc.Bundle{BundleServerInstall},
+	})
+
+	return mngr
 }
 
 const (
@@ -284,19 +301,28 @@ func LoadVLAB(basedir string, mngr *cnc.Manager, dryRun bool, size string, restr
 		sudoSwtpm = true
 	}
 
+	controlJoinIgnitions := map[string]string{}
+	controlJoinInstallers := map[string]string{}
+	for _, name := range vlab.ControlNodes(mngr.Wiring())[1:] {
+		controlJoinIgnitions[name] = filepath.Join(basedir, BundleControlOS.Name, ControlOSIgnitionFor(name, false))
+		controlJoinInstallers[name] = filepath.Join(basedir, BundleControlJoin(name).Name)
+	}
+
 	svc, err := vlab.Load(&vlab.ServiceConfig{
-		DryRun:            dryRun,
-		Size:              size,
-		SudoSwtpm:         sudoSwtpm,
-		Basedir:           filepath.Join(basedir, BundleVlabVMs.Name),
-		Wiring:            mngr.Wiring(),
-		ControlIgnition:   filepath.Join(basedir, BundleControlOS.Name, ControlOSIgnition),
-		ServerIgnitionDir: filepath.Join(basedir, BundleServerOS.Name),
-		ControlInstaller:  filepath.Join(basedir, BundleControlInstall.Name),
-		ServerInstaller:   filepath.Join(basedir, BundleServerInstall.Name),
-		RestrictServers:   restrictServers,
-		FilesDir:          filepath.Join(basedir, BundleVlabFiles.Name),
-		SSHKey:            filepath.Join(basedir, DefaultVLABSSHKey),
+		DryRun:                dryRun,
+		Size:                  size,
+		SudoSwtpm:             sudoSwtpm,
+		Basedir:               filepath.Join(basedir, BundleVlabVMs.Name),
+		Wiring:                mngr.Wiring(),
+		ControlIgnition:       filepath.Join(basedir, BundleControlOS.Name, ControlOSIgnition),
+		ControlJoinIgnitions:  controlJoinIgnitions,
+		ServerIgnitionDir:     filepath.Join(basedir, BundleServerOS.Name),
+		ControlInstaller:      filepath.Join(basedir, BundleControlInstall.Name),
+		ControlJoinInstallers: controlJoinInstallers,
+		ServerInstaller:       filepath.Join(basedir, BundleServerInstall.Name),
+		RestrictServers:       restrictServers,
+		FilesDir:              filepath.Join(basedir, BundleVlabFiles.Name),
+		SSHKey:                filepath.Join(basedir, DefaultVLABSSHKey),
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "error loading VLAB")
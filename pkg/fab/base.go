@@ -15,6 +15,7 @@
 package fab
 
 import (
+	"fmt"
 	"log/slog"
 
 	"github.com/pkg/errors"
@@ -22,22 +23,29 @@ import (
 	"go.githedgehog.com/fabric/api/meta"
 	"go.githedgehog.com/fabric/pkg/wiring"
 	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	fabwiring "go.githedgehog.com/fabricator/pkg/fab/wiring"
 	"golang.org/x/exp/slices"
 )
 
 type Base struct {
-	cnc.NoValidationComponent
-
-	Source          cnc.Ref  `json:"source,omitempty"`
-	Target          cnc.Ref  `json:"target,omitempty"`
-	TargetInCluster cnc.Ref  `json:"targetInCluster,omitempty"`
-	AuthorizedKeys  []string `json:"authorizedKeys,omitempty"`
-	Dev             bool     `json:"dev,omitempty"`
+	Source          cnc.Ref          `json:"source,omitempty"`
+	Target          cnc.Ref          `json:"target,omitempty"`
+	TargetInCluster cnc.Ref          `json:"targetInCluster,omitempty"`
+	AuthorizedKeys  []string         `json:"authorizedKeys,omitempty"`
+	Dev             bool             `json:"dev,omitempty"`
+	Trust           *cnc.TrustPolicy `json:"trust,omitempty"`
+	Subnet          string           `json:"subnet,omitempty"`
+	ControlVIP      string           `json:"controlVIP,omitempty"`
+	CertSubject     cnc.CertSubject  `json:"certSubject,omitempty"`
 
 	authorizedKeysFlag cli.StringSlice
 }
 
-var _ cnc.Component = (*Base)(nil)
+var (
+	_ cnc.Component               = (*Base)(nil)
+	_ cnc.TrustProvider           = (*Base)(nil)
+	_ cnc.WiringHydrateConfigurer = (*Base)(nil)
+)
 
 func (cfg *Base) Name() string {
 	return "base"
@@ -70,13 +78,67 @@ func (cfg *Base) Flags() []cli.Flag {
 			EnvVars:     []string{"HHFAB_DEV"},
 			Destination: &cfg.Dev,
 		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "subnet",
+			Usage:       "Fabric `SUBNET` (x.y.0.0/16) to assign switch, control and fabric link IPs from (default: " + HHSubnet + ")",
+			EnvVars:     []string{"HHFAB_SUBNET"},
+			Destination: &cfg.Subnet,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "control-vip",
+			Usage:       "Control node virtual `IP` used by switches to reach control plane and registry (default: " + ControlVIP + ")",
+			EnvVars:     []string{"HHFAB_CONTROL_VIP"},
+			Destination: &cfg.ControlVIP,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "cert-org",
+			Usage:       "Organization for the generated certificates and CSRs (default: " + cnc.DefaultCertSubject.Organization + ")",
+			Destination: &cfg.CertSubject.Organization,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "cert-org-unit",
+			Usage:       "Organizational unit for the generated certificates and CSRs",
+			Destination: &cfg.CertSubject.OrganizationalUnit,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "cert-country",
+			Usage:       "Country `CODE` for the generated certificates and CSRs (default: " + cnc.DefaultCertSubject.Country + ")",
+			Destination: &cfg.CertSubject.Country,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "cert-province",
+			Usage:       "Province or state for the generated certificates and CSRs (default: " + cnc.DefaultCertSubject.Province + ")",
+			Destination: &cfg.CertSubject.Province,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "cert-locality",
+			Usage:       "Locality for the generated certificates and CSRs (default: " + cnc.DefaultCertSubject.Locality + ")",
+			Destination: &cfg.CertSubject.Locality,
+		},
 	}
 }
 
-func (cfg *Base) Hydrate(preset cnc.Preset, _ meta.FabricMode) error {
+func (cfg *Base) Hydrate(preset cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent) error {
+	if cfg.Subnet == "" {
+		cfg.Subnet = HHSubnet
+	}
+	if cfg.ControlVIP == "" {
+		cfg.ControlVIP = ControlVIP
+	}
+	cfg.CertSubject = cfg.CertSubject.Fallback(cnc.DefaultCertSubject)
+
+	refTarget := cnc.Ref{Repo: fmt.Sprintf("%s:%d/githedgehog", cfg.ControlVIP, ZotNodePort)}
+
 	cfg.Source = cfg.Source.Fallback(RefSource)
-	cfg.Target = cfg.Target.Fallback(RefTarget)
-	cfg.TargetInCluster = cfg.TargetInCluster.Fallback(RefTargetInCluster)
+	cfg.Target = cfg.Target.Fallback(refTarget)
+	cfg.TargetInCluster = cfg.TargetInCluster.Fallback(refTarget)
 
 	for _, val := range cfg.authorizedKeysFlag.Value() {
 		if val == "" {
@@ -97,6 +159,21 @@ func (cfg *Base) Hydrate(preset cnc.Preset, _ meta.FabricMode) error {
 	return nil
 }
 
+func (cfg *Base) Validate(_ string, _ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent, _ *wiring.Data) error {
+	return errors.Wrapf(cfg.Trust.Validate(), "error validating trust policy")
+}
+
+// ConfigureWiringHydrate makes wiring hydration use the configured fabric subnet
+func (cfg *Base) ConfigureWiringHydrate(hydrateCfg *fabwiring.HydrateConfig) {
+	if cfg.Subnet != "" {
+		hydrateCfg.Subnet = cfg.Subnet
+	}
+}
+
+func (cfg *Base) TrustPolicy() *cnc.TrustPolicy {
+	return cfg.Trust
+}
+
 func (cfg *Base) Build(basedir string, preset cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent, _ *wiring.Data, _ cnc.AddBuildOp, _ cnc.AddRunOp) error {
 	if cfg.Dev {
 		slog.Warn("Attention! Development mode enabled - this is not secure! Default users and keys will be created.")
@@ -0,0 +1,46 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fab
+
+import (
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+)
+
+const (
+	K3sKubectl      = "/opt/bin/kubectl"
+	K3sManifestsDir = "/var/lib/rancher/k3s/server/manifests"
+)
+
+// addCertsUpdate adds ops to the certs update bundle to apply the already installed manifest with the rotated certs
+// right away (instead of waiting for k3s to pick it up) and to restart the workload using them
+func addCertsUpdate(install cnc.AddRunOp, stage cnc.Stage, prefix string, manifest string, workload string) {
+	install(BundleControlCertsUpdate, stage, prefix+"-certs-apply",
+		&cnc.ExecCommand{
+			Name: K3sKubectl,
+			Args: []string{"apply", "-f", K3sManifestsDir + "/" + manifest},
+		})
+
+	install(BundleControlCertsUpdate, stage, prefix+"-restart",
+		&cnc.ExecCommand{
+			Name: K3sKubectl,
+			Args: []string{"rollout", "restart", workload},
+		})
+
+	install(BundleControlCertsUpdate, stage, prefix+"-restart-wait",
+		&cnc.ExecCommand{
+			Name: K3sKubectl,
+			Args: []string{"rollout", "status", workload, "--timeout=10m"},
+		})
+}
@@ -0,0 +1,241 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+	"github.com/pkg/errors"
+)
+
+const ChecksumsFile = "SHA256SUMS"
+
+type PackFormat string
+
+const (
+	PackFormatTarGz  PackFormat = "tar.gz"
+	PackFormatTarZst PackFormat = "tar.zst"
+	PackFormatTarXz  PackFormat = "tar.xz"
+)
+
+var PackFormats = []PackFormat{
+	PackFormatTarGz,
+	PackFormatTarZst,
+	PackFormatTarXz,
+}
+
+// Extension returns archive file extension for the format, tar.gz is kept as .tgz for compatibility
+func (f PackFormat) Extension() string {
+	if f == PackFormatTarGz {
+		return ".tgz"
+	}
+
+	return "." + string(f)
+}
+
+func (f PackFormat) archiver() (archiver.CompressedArchive, error) {
+	format := archiver.CompressedArchive{
+		Archival: archiver.Tar{},
+	}
+
+	switch f {
+	case PackFormatTarGz:
+		format.Compression = archiver.Gz{
+			Multithreaded:    true,
+			CompressionLevel: gzip.BestSpeed,
+		}
+	case PackFormatTarZst:
+		format.Compression = archiver.Zstd{}
+	case PackFormatTarXz:
+		format.Compression = archiver.Xz{}
+	default:
+		return format, errors.Errorf("unknown pack format %s", f)
+	}
+
+	return format, nil
+}
+
+// FindBundleArchive returns the archive path and format for the bundle packed in the basedir
+func FindBundleArchive(basedir, bundle string) (string, PackFormat, error) {
+	for _, format := range PackFormats {
+		path := filepath.Join(basedir, bundle+format.Extension())
+		if _, err := os.Stat(path); err == nil {
+			return path, format, nil
+		}
+	}
+
+	return "", "", errors.Errorf("no archive found for bundle %s in %s", bundle, basedir)
+}
+
+func archiveFormatFor(path string) (PackFormat, error) {
+	for _, format := range PackFormats {
+		if strings.HasSuffix(path, format.Extension()) {
+			return format, nil
+		}
+	}
+
+	return "", errors.Errorf("unknown archive format for %s", path)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "error opening %s", path)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "error reading %s", path)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteChecksums writes sha256sum compatible checksums file for the files (relative to basedir)
+func WriteChecksums(basedir string, files []string) error {
+	content := ""
+	for _, name := range files {
+		sum, err := fileSHA256(filepath.Join(basedir, name))
+		if err != nil {
+			return err
+		}
+
+		content += fmt.Sprintf("%s  %s\n", sum, name)
+	}
+
+	return errors.Wrapf(os.WriteFile(filepath.Join(basedir, ChecksumsFile), []byte(content), 0o644), "error writing checksums")
+}
+
+// VerifyChecksum checks file against its entry in the sha256sum compatible checksums file
+func VerifyChecksum(path, sumsPath string) error {
+	f, err := os.Open(sumsPath)
+	if err != nil {
+		return errors.Wrapf(err, "error opening checksums")
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	expected := ""
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sum, file, ok := strings.Cut(scanner.Text(), "  ")
+		if ok && strings.TrimPrefix(file, "*") == name {
+			expected = sum
+
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "error reading checksums")
+	}
+	if expected == "" {
+		return errors.Errorf("no checksum for %s in %s", name, sumsPath)
+	}
+
+	actual, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return errors.Errorf("checksum mismatch for %s: expected %s, got %s", name, expected, actual)
+	}
+
+	slog.Debug("Checksum verified", "file", name, "sha256", actual)
+
+	return nil
+}
+
+// UnpackArchive verifies archive using checksums file (if not empty) and extracts it into the target dir
+func UnpackArchive(path, sumsPath, target string) error {
+	if sumsPath != "" {
+		if err := VerifyChecksum(path, sumsPath); err != nil {
+			return errors.Wrapf(err, "error verifying archive")
+		}
+	} else {
+		slog.Warn("Archive isn't verified as no checksums file provided", "archive", path)
+	}
+
+	packFormat, err := archiveFormatFor(path)
+	if err != nil {
+		return err
+	}
+	format, err := packFormat.archiver()
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "error opening archive")
+	}
+	defer in.Close()
+
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return errors.Wrapf(err, "error getting absolute target path")
+	}
+
+	return errors.Wrapf(format.Extract(context.Background(), in, nil, func(_ context.Context, f archiver.File) error {
+		dest := filepath.Join(target, f.NameInArchive) //nolint:gosec
+		if filepath.IsAbs(f.NameInArchive) || dest != target && !strings.HasPrefix(dest, target+string(os.PathSeparator)) {
+			return errors.Errorf("illegal file path in archive: %s", f.NameInArchive)
+		}
+
+		if f.IsDir() {
+			return errors.Wrapf(os.MkdirAll(dest, f.Mode().Perm()|0o700), "error creating dir %s", dest)
+		}
+
+		if f.LinkTarget != "" {
+			return errors.Errorf("links aren't supported in bundles: %s", f.NameInArchive)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return errors.Wrapf(err, "error creating dir for %s", dest)
+		}
+
+		src, err := f.Open()
+		if err != nil {
+			return errors.Wrapf(err, "error opening %s in archive", f.NameInArchive)
+		}
+		defer src.Close()
+
+		out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode().Perm())
+		if err != nil {
+			return errors.Wrapf(err, "error creating %s", dest)
+		}
+
+		_, err = io.Copy(out, src) //nolint:gosec
+		if err != nil {
+			_ = out.Close()
+
+			return errors.Wrapf(err, "error writing %s", dest)
+		}
+
+		return errors.Wrapf(out.Close(), "error closing %s", dest)
+	}), "error extracting archive %s", path)
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_UnpackArchive(t *testing.T) {
+	type entry struct {
+		name     string
+		typeflag byte
+		content  string
+		link     string
+	}
+
+	tests := []struct {
+		name    string
+		entries []entry
+		error   bool
+	}{
+		{
+			name: "valid",
+			entries: []entry{
+				{name: "dir/", typeflag: tar.TypeDir},
+				{name: "dir/file", typeflag: tar.TypeReg, content: "content"},
+				{name: "dir/sub/../other", typeflag: tar.TypeReg, content: "other"},
+			},
+		},
+		{
+			name:    "parent",
+			entries: []entry{{name: "../evil", typeflag: tar.TypeReg, content: "evil"}},
+			error:   true,
+		},
+		{
+			name:    "nested-parent",
+			entries: []entry{{name: "dir/../../evil", typeflag: tar.TypeReg, content: "evil"}},
+			error:   true,
+		},
+		{
+			name:    "absolute",
+			entries: []entry{{name: "/evil", typeflag: tar.TypeReg, content: "evil"}},
+			error:   true,
+		},
+		{
+			name:    "symlink",
+			entries: []entry{{name: "evil", typeflag: tar.TypeSymlink, link: "/etc/passwd"}},
+			error:   true,
+		},
+		{
+			name: "hardlink",
+			entries: []entry{
+				{name: "file", typeflag: tar.TypeReg, content: "content"},
+				{name: "evil", typeflag: tar.TypeLink, link: "file"},
+			},
+			error: true,
+		},
+	}
+
+	for _, packFormat := range PackFormats {
+		for _, test := range tests {
+			t.Run(string(packFormat)+"/"+test.name, func(t *testing.T) {
+				dir := t.TempDir()
+				path := filepath.Join(dir, "bundle"+packFormat.Extension())
+
+				format, err := packFormat.archiver()
+				if err != nil {
+					t.Fatalf("error getting archiver: %v", err)
+				}
+
+				file, err := os.Create(path)
+				if err != nil {
+					t.Fatalf("error creating archive: %v", err)
+				}
+				compressed, err := format.Compression.OpenWriter(file)
+				if err != nil {
+					t.Fatalf("error opening compressor: %v", err)
+				}
+				tw := tar.NewWriter(compressed)
+				for _, e := range test.entries {
+					hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.link, Mode: 0o644, Size: int64(len(e.content))}
+					if e.typeflag == tar.TypeDir {
+						hdr.Mode = 0o755
+					}
+					if err := tw.WriteHeader(hdr); err != nil {
+						t.Fatalf("error writing header %s: %v", e.name, err)
+					}
+					if _, err := tw.Write([]byte(e.content)); err != nil {
+						t.Fatalf("error writing %s: %v", e.name, err)
+					}
+				}
+				if err := tw.Close(); err != nil {
+					t.Fatalf("error closing tar: %v", err)
+				}
+				if err := compressed.Close(); err != nil {
+					t.Fatalf("error closing compressor: %v", err)
+				}
+				if err := file.Close(); err != nil {
+					t.Fatalf("error closing archive: %v", err)
+				}
+
+				target := filepath.Join(dir, "target")
+				err = UnpackArchive(path, "", target)
+				if test.error && err == nil {
+					t.Errorf("UnpackArchive() expected error, got nil")
+				}
+				if !test.error && err != nil {
+					t.Errorf("UnpackArchive() expected no error, got %v", err)
+				}
+
+				if _, err := os.Lstat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+					t.Errorf("file created outside of the target dir")
+				}
+				if _, err := os.Lstat(filepath.Join(target, "evil")); !os.IsNotExist(err) {
+					t.Errorf("link or absolute path extracted into the target dir")
+				}
+
+				for _, e := range test.entries {
+					if test.error || e.typeflag != tar.TypeReg {
+						continue
+					}
+
+					data, err := os.ReadFile(filepath.Join(target, e.name))
+					if err != nil {
+						t.Errorf("error reading %s: %v", e.name, err)
+					} else if string(data) != e.content {
+						t.Errorf("content mismatch for %s: got %q, want %q", e.name, data, e.content)
+					}
+				}
+			})
+		}
+	}
+}
@@ -29,7 +29,7 @@ import (
 	"time"
 
 	"github.com/containers/image/v5/copy"
-	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/transports/alltransports"
 	"github.com/containers/image/v5/types"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -40,6 +40,7 @@ import (
 	"github.com/vbauerster/mpb/v8/decor"
 	"oras.land/oras-go/v2"
 	"oras.land/oras-go/v2/content/file"
+	"oras.land/oras-go/v2/content/oci"
 	"oras.land/oras-go/v2/registry/remote"
 	"oras.land/oras-go/v2/registry/remote/auth"
 	"oras.land/oras-go/v2/registry/remote/retry"
@@ -58,6 +59,68 @@ type File struct {
 	InstallMkdirMode os.FileMode
 }
 
+var buildLocks = sync.Map{}
+
+// lockBuild locks the build for the specified key (usually target path) and returns unlock func
+func lockBuild(key string) func() {
+	mu, _ := buildLocks.LoadOrStore(key, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+
+	return mu.(*sync.Mutex).Unlock
+}
+
+// newORASRepo creates remote repository for the ref using credentials from the docker credential store
+func newORASRepo(ref Ref) (*remote.Repository, error) {
+	repo, err := remote.NewRepository(ref.RepoName())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating oras remote repo %s", ref.RepoName())
+	}
+
+	if ref.IsLocalhost() {
+		repo.PlainHTTP = true
+	}
+
+	// Get credentials from the docker credential store
+	storeOpts := credentials.StoreOptions{}
+	credStore, err := credentials.NewStoreFromDocker(storeOpts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating docker credential store")
+	}
+
+	repo.Client = &auth.Client{
+		Client:     retry.DefaultClient,
+		Cache:      auth.DefaultCache,
+		Credential: credentials.Credential(credStore),
+	}
+
+	return repo, nil
+}
+
+// newORASSource returns source for copying ref from and reference to use, it's either remote repo or OCI layout if
+// offline source is set, artifacts are stored in the layout under the full ref name (repo/name:tag) and pinned refs
+// are always read by digest
+func newORASSource(ref Ref, offlineSource string) (oras.ReadOnlyTarget, string, error) {
+	if offlineSource != "" {
+		store, err := oci.NewFromFS(context.Background(), os.DirFS(offlineSource))
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "error opening offline source %s", offlineSource)
+		}
+
+		if ref.Digest != "" {
+			return store, ref.Digest, nil
+		}
+
+		return store, ref.String(), nil
+	}
+
+	repo, err := newORASRepo(ref)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return repo, ref.Reference(), nil
+}
+
 //
 // BuildOp FilesORAS
 //
@@ -66,6 +129,9 @@ type FilesORAS struct {
 	Ref    Ref
 	Unpack []string
 	Files  []File
+	Trust  *TrustPolicy
+	// OfflineSource is a path to the OCI image layout to use instead of the remote registry
+	OfflineSource string
 }
 
 var _ BuildOp = (*FilesORAS)(nil)
@@ -89,7 +155,32 @@ func (op *FilesORAS) Hydrate() error {
 	return nil
 }
 
-func (op *FilesORAS) Build(basedir string) error {
+func (op *FilesORAS) Artifact() *Ref {
+	return &op.Ref
+}
+
+func (op *FilesORAS) SetOfflineSource(path string) {
+	op.OfflineSource = path
+}
+
+func (op *FilesORAS) SetTrust(trust *TrustPolicy) {
+	op.Trust = trust
+}
+
+func (op *FilesORAS) Inputs() ([]any, error) {
+	return []any{op.Ref, op.Unpack, op.Files, op.Trust.Enabled(), op.Trust.Match(op.Ref)}, nil
+}
+
+func (op *FilesORAS) Outputs() []string {
+	outputs := []string{}
+	for _, f := range op.Files {
+		outputs = append(outputs, f.Name)
+	}
+
+	return outputs
+}
+
+func (op *FilesORAS) Build(basedir string, pb *mpb.Progress) error {
 	skip := true
 
 	for _, f := range op.Files {
@@ -113,6 +204,11 @@ func (op *FilesORAS) Build(basedir string) error {
 		return nil
 	}
 
+	err := verifyArtifact(op.Ref, op.Trust, op.OfflineSource)
+	if err != nil {
+		return errors.Wrapf(err, "error verifying %s", op.Ref)
+	}
+
 	slog.Info("Downloading", "name", op.Ref, "to", basedir)
 
 	fs, err := file.New(basedir)
@@ -121,29 +217,11 @@ func (op *FilesORAS) Build(basedir string) error {
 	}
 	defer fs.Close()
 
-	repo, err := remote.NewRepository(op.Ref.Repo + "/" + op.Ref.Name)
-	if err != nil {
-		return errors.Wrapf(err, "error creating oras remote repo %s", op.Ref.Repo+"/"+op.Ref.Name)
-	}
-
-	if op.Ref.IsLocalhost() {
-		repo.PlainHTTP = true
-	}
-
-	// Get credentials from the docker credential store
-	storeOpts := credentials.StoreOptions{}
-	credStore, err := credentials.NewStoreFromDocker(storeOpts)
+	src, srcRef, err := newORASSource(op.Ref, op.OfflineSource)
 	if err != nil {
-		return errors.Wrapf(err, "error creating docker credential store")
-	}
-
-	repo.Client = &auth.Client{
-		Client:     retry.DefaultClient,
-		Cache:      auth.DefaultCache,
-		Credential: credentials.Credential(credStore),
+		return err
 	}
 
-	pb := mpb.New(mpb.WithWidth(5))
 	bars := sync.Map{}
 
 	complete := func(_ context.Context, desc ocispec.Descriptor) error {
@@ -154,7 +232,7 @@ func (op *FilesORAS) Build(basedir string) error {
 		return nil
 	}
 
-	_, err = oras.Copy(context.Background(), repo, op.Ref.Tag, fs, op.Ref.Tag, oras.CopyOptions{
+	_, err = oras.Copy(context.Background(), src, srcRef, fs, op.Ref.Tag, oras.CopyOptions{
 		CopyGraphOptions: oras.CopyGraphOptions{
 			Concurrency: 3,
 			PreCopy: func(ctx context.Context, desc ocispec.Descriptor) error {
@@ -169,6 +247,7 @@ func (op *FilesORAS) Build(basedir string) error {
 				name = "Copying " + name
 
 				bars.Store(desc.Digest.String(), pb.AddSpinner(desc.Size,
+					mpb.BarWidth(5),
 					mpb.PrependDecorators(
 						decor.Name(name, decor.WCSyncSpaceR),
 						decor.Counters(decor.SizeB1024(0), "% .2f / % .2f", decor.WCSyncSpace),
@@ -190,10 +269,8 @@ func (op *FilesORAS) Build(basedir string) error {
 		return errors.Wrapf(err, "error copying files from %s", op.Ref.String())
 	}
 
-	pb.Wait()
-
 	for _, f := range op.Unpack {
-		err := UnpackFile(basedir, f)
+		err := UnpackFile(basedir, f, pb)
 		if err != nil {
 			return errors.Wrap(err, "error unpacking file")
 		}
@@ -211,7 +288,7 @@ func (op *FilesORAS) Build(basedir string) error {
 	return nil
 }
 
-func UnpackFile(basedir string, name string) error { // TODO validate we've got files we've been looking for?
+func UnpackFile(basedir string, name string, pb *mpb.Progress) error { // TODO validate we've got files we've been looking for?
 	fromPath := filepath.Join(basedir, name)
 	from, err := os.Open(fromPath)
 	if err != nil {
@@ -246,10 +323,6 @@ func UnpackFile(basedir string, name string) error { // TODO validate we've got
 	writer := bufio.NewWriter(to)
 	defer writer.Flush()
 
-	p := mpb.New(
-		mpb.WithWidth(60),
-	)
-
 	info, err := from.Stat()
 	if err != nil {
 		return errors.Wrapf(err, "error statting file %s", fromPath)
@@ -257,7 +330,8 @@ func UnpackFile(basedir string, name string) error { // TODO validate we've got
 
 	var bar *mpb.Bar
 	if slog.Default().Enabled(context.Background(), slog.LevelInfo) && info.Size() > 10_000_000 {
-		bar = p.AddBar(info.Size(),
+		bar = pb.AddBar(info.Size(),
+			mpb.BarWidth(60),
 			mpb.PrependDecorators(
 				decor.Counters(decor.SizeB1024(0), "% .2f / % .2f", decor.WCSyncSpace),
 			),
@@ -283,8 +357,6 @@ func UnpackFile(basedir string, name string) error { // TODO validate we've got
 		bar.SetCurrent(info.Size())
 	}
 
-	p.Wait()
-
 	err = os.Remove(fromPath)
 	if err != nil {
 		return errors.Wrapf(err, "error removing file %s", fromPath)
@@ -332,7 +404,20 @@ func (op *FileGenerate) Hydrate() error {
 	return nil
 }
 
-func (op *FileGenerate) Build(basedir string) error {
+func (op *FileGenerate) Inputs() ([]any, error) {
+	content, err := op.Content()
+	if err != nil {
+		return nil, err
+	}
+
+	return []any{op.File, content}, nil
+}
+
+func (op *FileGenerate) Outputs() []string {
+	return []string{op.File.Name}
+}
+
+func (op *FileGenerate) Build(basedir string, _ *mpb.Progress) error {
 	content, err := op.Content()
 	if err != nil {
 		return err
@@ -375,6 +460,9 @@ func (op *FileGenerate) RunOps() []RunOp {
 type SyncOCI struct {
 	Ref    Ref
 	Target Ref
+	Trust  *TrustPolicy
+	// OfflineSource is a path to the OCI image layout to use instead of the remote registry
+	OfflineSource string
 }
 
 var _ BuildOp = (*SyncOCI)(nil)
@@ -404,7 +492,40 @@ func (op *SyncOCI) filePath() string {
 	return strings.ReplaceAll(fmt.Sprintf("%s@%s", op.Ref.Name, op.Ref.Tag), "/", "_") + ".oci"
 }
 
-func (op *SyncOCI) Build(basedir string) error {
+func (op *SyncOCI) Artifact() *Ref {
+	return &op.Ref
+}
+
+func (op *SyncOCI) SetOfflineSource(path string) {
+	op.OfflineSource = path
+}
+
+func (op *SyncOCI) SetTrust(trust *TrustPolicy) {
+	op.Trust = trust
+}
+
+func (op *SyncOCI) attachmentsPath() string {
+	return strings.TrimSuffix(op.filePath(), ".oci") + ".attachments.oci"
+}
+
+func (op *SyncOCI) copyAttachments() bool {
+	return op.Trust != nil && op.Trust.CopyAttachments
+}
+
+// Inputs doesn't include target as it only affects run ops, so the same image synced for multiple targets is shared
+func (op *SyncOCI) Inputs() ([]any, error) {
+	return []any{op.Ref, op.Trust.Enabled(), op.Trust.Match(op.Ref), op.copyAttachments()}, nil
+}
+
+func (op *SyncOCI) Outputs() []string {
+	if op.copyAttachments() {
+		return []string{op.filePath(), op.attachmentsPath()}
+	}
+
+	return []string{op.filePath()}
+}
+
+func (op *SyncOCI) Build(basedir string, pb *mpb.Progress) error {
 	path := filepath.Join(basedir, op.filePath())
 
 	skip := true
@@ -426,25 +547,44 @@ func (op *SyncOCI) Build(basedir string) error {
 	} else {
 		slog.Info("Downloading", "ref", op.Ref, "to", path)
 
-		err = copyOCI("docker://"+op.Ref.String(), "oci:"+path, op.Ref.IsLocalhost())
+		err = copyOCI("docker://"+op.Ref.Pinned(), "oci:"+path, op.Ref.IsLocalhost(), op.OfflineSource, op.Trust, pb)
 		if err != nil {
 			return err
 		}
 	}
 
+	if op.copyAttachments() {
+		err = copyAttachments(op.Ref, filepath.Join(basedir, op.attachmentsPath()), op.OfflineSource)
+		if err != nil {
+			return errors.Wrapf(err, "error copying attachments for %s", op.Ref)
+		}
+	}
+
 	return nil
 }
 
 func (op *SyncOCI) RunOps() []RunOp {
-	return []RunOp{
+	ops := []RunOp{
 		&PushOCI{
 			Name:   op.filePath(),
 			Target: op.Target,
 		},
 	}
+
+	if op.copyAttachments() {
+		ops = append(ops, &PushOCIAttachments{
+			Name:   op.attachmentsPath(),
+			Target: op.Target,
+		})
+	}
+
+	return ops
 }
 
-func copyOCI(from, to string, insecureSource bool) error {
+// copyOCI copies image from one ref to another, new progress container is created if pb is nil
+// source is verified using trust policy (nil means accept anything) and digests are preserved if attachments are copied
+// if offline source is set, docker source and its signatures are read from it instead of the remote registry
+func copyOCI(from, to string, insecureSource bool, offlineSource string, trust *TrustPolicy, pb *mpb.Progress) error {
 	srcRef, err := alltransports.ParseImageName(from)
 	if err != nil {
 		return errors.Wrapf(err, "error parsing source ref %s", from)
@@ -454,16 +594,19 @@ func copyOCI(from, to string, insecureSource bool) error {
 		return errors.Wrapf(err, "error parsing dest ref %s", to)
 	}
 
-	policyCtx, err := signature.NewPolicyContext(&signature.Policy{
-		Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()},
-	})
+	policyCtx, err := trust.newPolicyContext()
 	if err != nil {
-		return errors.Wrapf(err, "error creating policy context")
+		return err
 	}
 
 	progressChan := make(chan types.ProgressProperties)
 
-	pb := mpb.New(mpb.WithWidth(64))
+	wait := false
+	if pb == nil {
+		pb = mpb.New(mpb.WithWidth(64), mpb.WithOutput(ProgressOutput()))
+		wait = true
+	}
+
 	bars := map[string]*mpb.Bar{}
 	barStart := map[string]time.Time{}
 	go func() {
@@ -506,14 +649,35 @@ func copyOCI(from, to string, insecureSource bool) error {
 		sourceInsecure = types.OptionalBoolTrue
 	}
 
+	sourceCtx := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: sourceInsecure,
+		DockerAuthConfig:            getDockerAuthConfigOrNil(srcRef),
+	}
+	if trust.Enabled() {
+		var cleanup func()
+		sourceCtx, cleanup, err = newTrustSystemContext(sourceCtx)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+	if offlineSource != "" && srcRef.DockerReference() != nil {
+		var cleanup func()
+		sourceCtx, cleanup, err = newOfflineSystemContext(sourceCtx, reference.Domain(srcRef.DockerReference()), offlineSource)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
 	_, err = copy.Image(context.Background(), policyCtx, destRef, srcRef, &copy.Options{
 		ProgressInterval:   1 * time.Second,
 		Progress:           progressChan,
 		ImageListSelection: copy.CopyAllImages,
-		SourceCtx: &types.SystemContext{
-			DockerInsecureSkipTLSVerify: sourceInsecure,
-			DockerAuthConfig:            getDockerAuthConfigOrNil(srcRef),
-		},
+		// signatures are verified using policy, but OCI layout can't store them, so they are copied separately
+		RemoveSignatures: trust.Enabled(),
+		PreserveDigests:  trust != nil && trust.CopyAttachments,
+		SourceCtx:        sourceCtx,
 		DestinationCtx: &types.SystemContext{
 			DockerAuthConfig: getDockerAuthConfigOrNil(destRef),
 		},
@@ -522,7 +686,9 @@ func copyOCI(from, to string, insecureSource bool) error {
 		return errors.Wrapf(err, "error copying image from %s to %s", from, to)
 	}
 
-	pb.Wait()
+	if wait {
+		pb.Wait()
+	}
 
 	return nil
 }
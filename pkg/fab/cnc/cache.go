@@ -39,16 +39,31 @@ func (c *Cache) Save(basedir string) error {
 	return errors.Wrapf(os.WriteFile(filepath.Join(basedir, CacheFile), data, 0o600), "error writing cache")
 }
 
+// Load reads cache from the basedir, missing cache file is the same as an empty cache
 func (c *Cache) Load(basedir string) error {
+	c.Hashes = map[string]uint64{}
+
 	data, err := os.ReadFile(filepath.Join(basedir, CacheFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return errors.Wrapf(err, "error reading cache")
 	}
 
-	return errors.Wrapf(yaml.UnmarshalStrict(data, c), "error unmarshalling cache")
+	err = yaml.UnmarshalStrict(data, c)
+	if err != nil {
+		return errors.Wrapf(err, "error unmarshalling cache")
+	}
+
+	if c.Hashes == nil {
+		c.Hashes = map[string]uint64{}
+	}
+
+	return nil
 }
 
-func (c *Cache) hash(values ...any) (uint64, error) {
+func hashValues(values ...any) (uint64, error) {
 	hash, err := hashstructure.Hash(values, hashstructure.FormatV2, &hashstructure.HashOptions{
 		Hasher: fnv.New64(),
 	})
@@ -60,7 +75,7 @@ func (c *Cache) hash(values ...any) (uint64, error) {
 }
 
 func (c *Cache) IsActual(name string, values ...any) (bool, error) {
-	hash, err := c.hash(values...)
+	hash, err := hashValues(values...)
 	if err != nil {
 		return false, err
 	}
@@ -71,11 +86,15 @@ func (c *Cache) IsActual(name string, values ...any) (bool, error) {
 }
 
 func (c *Cache) Add(name string, values ...any) error {
-	hash, err := c.hash(values...)
+	hash, err := hashValues(values...)
 	if err != nil {
 		return err
 	}
 
+	if c.Hashes == nil {
+		c.Hashes = map[string]uint64{}
+	}
+
 	c.Hashes[name] = hash
 
 	return nil
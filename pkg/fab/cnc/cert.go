@@ -15,29 +15,101 @@
 package cnc
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	cryptorand "crypto/rand"
+	"crypto/sha1" //nolint:gosec
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"fmt"
 	"math/big"
-	mathrand "math/rand"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
 )
 
 const (
 	BlockTypeCert = "CERTIFICATE"
 	BlockTypeKey  = "EC PRIVATE KEY"
+
+	// CertRenewBefore is how long before the expiration certificate is considered expiring and should be rotated
+	CertRenewBefore = 30 * 24 * time.Hour
 )
 
 type KeyPair struct {
 	Cert string `json:"cert,omitempty"`
 	Key  string `json:"key,omitempty"`
+	// Chain is PEM encoded issuer certs up to (but not including) the root, only set for certs chained to external CA
+	Chain string `json:"chain,omitempty"`
+	// Imported is set for CAs provided by user or signed by external CA, they are never regenerated by fabricator
+	Imported bool `json:"imported,omitempty"`
+	// PendingKey is a private key generated for the CSR, it's used once signed cert is imported
+	PendingKey string `json:"pendingKey,omitempty"`
+}
+
+// CertSubject is a subject of the generated certificates and CSRs, common name is set per certificate
+type CertSubject struct {
+	Country            string `json:"country,omitempty"`
+	Province           string `json:"province,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+	Organization       string `json:"organization,omitempty"`
+	OrganizationalUnit string `json:"organizationalUnit,omitempty"`
+}
+
+var DefaultCertSubject = CertSubject{
+	Country:      "US",
+	Province:     "Washington",
+	Locality:     "Seattle",
+	Organization: "Hedgehog SONiC Foundation",
+}
+
+// maxSerial is an upper bound for the random certificate serial numbers (128 bits)
+var maxSerial = new(big.Int).Lsh(big.NewInt(1), 128)
+
+func (s CertSubject) Fallback(def CertSubject) CertSubject {
+	if s.Country == "" {
+		s.Country = def.Country
+	}
+	if s.Province == "" {
+		s.Province = def.Province
+	}
+	if s.Locality == "" {
+		s.Locality = def.Locality
+	}
+	if s.Organization == "" {
+		s.Organization = def.Organization
+	}
+	if s.OrganizationalUnit == "" {
+		s.OrganizationalUnit = def.OrganizationalUnit
+	}
+
+	return s
+}
+
+func (s CertSubject) PKIXName(cn string) pkix.Name {
+	name := pkix.Name{CommonName: cn}
+
+	if s.Country != "" {
+		name.Country = []string{s.Country}
+	}
+	if s.Province != "" {
+		name.Province = []string{s.Province}
+	}
+	if s.Locality != "" {
+		name.Locality = []string{s.Locality}
+	}
+	if s.Organization != "" {
+		name.Organization = []string{s.Organization}
+	}
+	if s.OrganizationalUnit != "" {
+		name.OrganizationalUnit = []string{s.OrganizationalUnit}
+	}
+
+	return name
 }
 
 func (kp *KeyPair) PCert() (*x509.Certificate, error) {
@@ -59,7 +131,16 @@ func (kp *KeyPair) PCert() (*x509.Certificate, error) {
 }
 
 func (kp *KeyPair) PKey() (*ecdsa.PrivateKey, error) {
-	block, _ := pem.Decode([]byte(kp.Key))
+	return parseKey(kp.Key)
+}
+
+// FullChain returns cert followed by the issuer certs if any, that's what servers should present
+func (kp *KeyPair) FullChain() string {
+	return kp.Cert + kp.Chain
+}
+
+func parseKey(data string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
 	if block == nil {
 		return nil, errors.New("failed to parse certificate PEM")
 	}
@@ -76,7 +157,60 @@ func (kp *KeyPair) PKey() (*ecdsa.PrivateKey, error) {
 	return key, nil
 }
 
-func (kp *KeyPair) Ensure(cn string, parent *KeyPair, keyUsage x509.KeyUsage, extKeyUsage []x509.ExtKeyUsage, ips []string, dnsNames []string) error {
+// CertSpec describes the certificate expected by the component, it's used to generate, check and rotate it
+type CertSpec struct {
+	Name        string
+	KeyPair     *KeyPair
+	Parent      *KeyPair // nil for CA
+	CN          string
+	Subject     CertSubject // defaults to DefaultCertSubject
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	IPs         []string
+	DNSNames    []string
+}
+
+// CertProvider is a component that owns certificates, CAs should go before the certs signed by them
+type CertProvider interface {
+	Certs(get GetComponent) []CertSpec
+}
+
+type CertStatus struct {
+	Component  string    `json:"component,omitempty"`
+	ID         string    `json:"id,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	CN         string    `json:"cn,omitempty"`
+	IsCA       bool      `json:"isCA,omitempty"`
+	Imported   bool      `json:"imported,omitempty"`
+	CSRPending bool      `json:"csrPending,omitempty"`
+	NotAfter   time.Time `json:"notAfter,omitempty"`
+	// Expiring is true if certificate expires within CertRenewBefore
+	Expiring bool `json:"expiring,omitempty"`
+	// Problems are making certificate unusable with the current config
+	Problems []string `json:"problems,omitempty"`
+}
+
+func (s *CertStatus) NeedsRotation() bool {
+	return s.Expiring || len(s.Problems) > 0
+}
+
+func (spec *CertSpec) IsCA() bool {
+	return spec.Parent == nil
+}
+
+// ID is a short name of the certificate to be used in file names and CLI
+func (spec *CertSpec) ID() string {
+	return strings.ReplaceAll(strings.ToLower(spec.Name), " ", "-")
+}
+
+// Ensure generates the certificate if it's missing
+func (spec *CertSpec) Ensure() error {
+	return errors.Wrapf(spec.ensure(), "error ensuring %s", spec.Name)
+}
+
+func (spec *CertSpec) ensure() error {
+	kp := spec.KeyPair
+
 	if kp.Cert != "" && kp.Key != "" {
 		_, err := kp.PCert()
 		if err != nil {
@@ -87,44 +221,53 @@ func (kp *KeyPair) Ensure(cn string, parent *KeyPair, keyUsage x509.KeyUsage, ex
 			return errors.Wrap(err, "error parsing existing private key")
 		}
 
-		// TODO maybe do a bit more checks?
+		// the rest is checked by CertSpec.Check, so expired or mismatched certs could still be loaded and rotated
 
 		return nil
 	}
 
-	isCA := parent == nil
+	isCA := spec.IsCA()
+
+	if isCA && (len(spec.IPs) > 0 || len(spec.DNSNames) > 0) {
+		return errors.Errorf("CA certificate cannot have IP addresses or DNS names, cn: %s", spec.CN)
+	}
 
 	years := 1
 	if isCA {
 		years = 10
 	}
 
+	serial, err := cryptorand.Int(cryptorand.Reader, maxSerial)
+	if err != nil {
+		return errors.Wrapf(err, "error generating serial number")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		return errors.Wrapf(err, "error generating private key")
+	}
+
+	skid, err := subjectKeyID(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+
 	tmpl := &x509.Certificate{
-		SerialNumber: big.NewInt(mathrand.Int63()), //nolint:gosec
-		Subject: pkix.Name{
-			Country:      []string{"US"},
-			Province:     []string{"Washington"},
-			Locality:     []string{"Seattle"},
-			Organization: []string{"Hedgehog SONiC Foundation"},
-			CommonName:   cn,
-		},
+		SerialNumber:          serial,
+		Subject:               spec.Subject.Fallback(DefaultCertSubject).PKIXName(spec.CN),
 		NotBefore:             time.Now().Add(-15 * time.Minute),
 		NotAfter:              time.Now().AddDate(years, 0, 0),
 		IsCA:                  isCA,
-		ExtKeyUsage:           extKeyUsage,
-		KeyUsage:              keyUsage,
+		ExtKeyUsage:           spec.ExtKeyUsage,
+		KeyUsage:              spec.KeyUsage,
 		BasicConstraintsValid: isCA,
-		// TODO SubjectKeyId and AuthorityKeyId
-	}
-
-	if isCA && (len(ips) > 0 || len(dnsNames) > 0) {
-		return errors.Errorf("CA certificate cannot have IP addresses or DNS names, cn: %s", cn)
+		SubjectKeyId:          skid,
 	}
 
 	if !isCA {
-		tmpl.DNSNames = dnsNames
+		tmpl.DNSNames = spec.DNSNames
 
-		for _, ip := range ips {
+		for _, ip := range spec.IPs {
 			addr := net.ParseIP(ip)
 			if addr == nil {
 				return errors.Errorf("invalid IP address '%s'", ip)
@@ -133,52 +276,195 @@ func (kp *KeyPair) Ensure(cn string, parent *KeyPair, keyUsage x509.KeyUsage, ex
 		}
 	}
 
-	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
-	if err != nil {
-		return errors.Wrapf(err, "error generating private key")
-	}
-
 	parentCert := tmpl
 	parentKey := key
-	if parent != nil {
-		parentCert, err = parent.PCert()
+	chain := ""
+	if spec.Parent != nil {
+		parentCert, err = spec.Parent.PCert()
 		if err != nil {
 			return errors.Wrapf(err, "error parsing parent certificate")
 		}
-		parentKey, err = parent.PKey()
+		parentKey, err = spec.Parent.PKey()
 		if err != nil {
 			return errors.Wrapf(err, "error parsing parent private key")
 		}
+
+		tmpl.AuthorityKeyId = parentCert.SubjectKeyId
+
+		// cert shouldn't outlive its issuer, it's important for the imported CAs
+		if tmpl.NotAfter.After(parentCert.NotAfter) {
+			tmpl.NotAfter = parentCert.NotAfter
+		}
+
+		// issuer chained to external CA should be presented together with the cert
+		if spec.Parent.Chain != "" {
+			chain = spec.Parent.FullChain()
+		}
 	}
+
 	cert, err := x509.CreateCertificate(cryptorand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
 	if err != nil {
 		return errors.Wrapf(err, "error creating certificate")
 	}
 
-	certPem := new(bytes.Buffer)
-	err = pem.Encode(certPem, &pem.Block{
-		Type:  BlockTypeCert,
-		Bytes: cert,
-	})
+	keyPem, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	*kp = KeyPair{
+		Cert:  encodeCert(cert),
+		Key:   keyPem,
+		Chain: chain,
+	}
+
+	return nil
+}
+
+// subjectKeyID calculates key id as SHA-1 hash of the public key (RFC 5280, Section 4.2.1.2, method 1)
+func subjectKeyID(pub *ecdsa.PublicKey) ([]byte, error) {
+	ecdhPub, err := pub.ECDH()
 	if err != nil {
-		return errors.Wrapf(err, "error encoding certificate")
+		return nil, errors.Wrapf(err, "error converting public key")
 	}
 
-	keyPem := new(bytes.Buffer)
+	sum := sha1.Sum(ecdhPub.Bytes()) //nolint:gosec
+
+	return sum[:], nil
+}
+
+func encodeCert(der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  BlockTypeCert,
+		Bytes: der,
+	}))
+}
+
+func encodeKey(key *ecdsa.PrivateKey) (string, error) {
 	keyBytes, err := x509.MarshalECPrivateKey(key)
 	if err != nil {
-		return errors.Wrapf(err, "error encoding private key")
+		return "", errors.Wrapf(err, "error encoding private key")
 	}
-	err = pem.Encode(keyPem, &pem.Block{
+
+	return string(pem.EncodeToMemory(&pem.Block{
 		Type:  BlockTypeKey,
 		Bytes: keyBytes,
-	})
+	})), nil
+}
+
+// parseCerts parses all PEM encoded certificates in order, non-certificate blocks are ignored
+func parseCerts(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != BlockTypeCert {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse certificate")
+		}
+
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+// Check verifies existing certificate against the spec: validity period, key, key usage, SANs and the issuer
+func (spec *CertSpec) Check(now time.Time) CertStatus {
+	status := CertStatus{
+		ID:         spec.ID(),
+		Name:       spec.Name,
+		CN:         spec.CN,
+		IsCA:       spec.IsCA(),
+		Imported:   spec.KeyPair.Imported,
+		CSRPending: spec.KeyPair.PendingKey != "",
+	}
+
+	cert, err := spec.KeyPair.PCert()
 	if err != nil {
-		return errors.Wrapf(err, "error encoding private key")
+		status.Problems = append(status.Problems, "invalid certificate: "+err.Error())
+
+		return status
+	}
+	status.NotAfter = cert.NotAfter
+
+	if key, err := spec.KeyPair.PKey(); err != nil {
+		status.Problems = append(status.Problems, "invalid private key: "+err.Error())
+	} else if !key.PublicKey.Equal(cert.PublicKey) {
+		status.Problems = append(status.Problems, "private key doesn't match certificate")
 	}
 
-	kp.Cert = certPem.String()
-	kp.Key = keyPem.String()
+	if now.Before(cert.NotBefore) {
+		status.Problems = append(status.Problems, "not valid before "+cert.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		status.Problems = append(status.Problems, "expired at "+cert.NotAfter.Format(time.RFC3339))
+	} else if cert.NotAfter.Sub(now) < CertRenewBefore {
+		status.Expiring = true
+	}
+
+	// external CA could adjust subject of the imported CA
+	if cert.Subject.CommonName != spec.CN && !spec.KeyPair.Imported {
+		status.Problems = append(status.Problems, fmt.Sprintf("common name is %q, expected %q", cert.Subject.CommonName, spec.CN))
+	}
+	if cert.IsCA != spec.IsCA() {
+		status.Problems = append(status.Problems, fmt.Sprintf("is CA %t, expected %t", cert.IsCA, spec.IsCA()))
+	}
+	if cert.KeyUsage&spec.KeyUsage != spec.KeyUsage {
+		status.Problems = append(status.Problems, fmt.Sprintf("key usage %d doesn't include expected %d", cert.KeyUsage, spec.KeyUsage))
+	}
+	for _, usage := range spec.ExtKeyUsage {
+		if !slices.Contains(cert.ExtKeyUsage, usage) {
+			status.Problems = append(status.Problems, fmt.Sprintf("missing ext key usage %d", usage))
+		}
+	}
+
+	for _, ip := range spec.IPs {
+		addr := net.ParseIP(ip)
+		if slices.IndexFunc(cert.IPAddresses, addr.Equal) < 0 {
+			status.Problems = append(status.Problems, "missing IP SAN "+ip)
+		}
+	}
+	for _, name := range spec.DNSNames {
+		if !slices.Contains(cert.DNSNames, name) {
+			status.Problems = append(status.Problems, "missing DNS SAN "+name)
+		}
+	}
+
+	if spec.Parent != nil {
+		parent, err := spec.Parent.PCert()
+		if err != nil {
+			status.Problems = append(status.Problems, "invalid CA certificate: "+err.Error())
+		} else if err := cert.CheckSignatureFrom(parent); err != nil {
+			status.Problems = append(status.Problems, "not signed by the current CA")
+		}
+	} else if spec.KeyPair.Chain != "" {
+		chain, err := parseCerts([]byte(spec.KeyPair.Chain))
+		if err != nil || len(chain) == 0 {
+			status.Problems = append(status.Problems, "invalid issuer chain")
+		} else if err := cert.CheckSignatureFrom(chain[0]); err != nil {
+			status.Problems = append(status.Problems, "not signed by the first cert of the issuer chain")
+		}
+	}
+
+	return status
+}
+
+// EnsureCerts generates all missing certificates in order
+func EnsureCerts(specs []CertSpec) error {
+	for idx := range specs {
+		if err := specs[idx].Ensure(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,220 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"bytes"
+	"crypto/x509"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CertSpecCheck(t *testing.T) {
+	ca := &KeyPair{}
+	otherCA := &KeyPair{}
+	server := &KeyPair{}
+
+	caSpec := CertSpec{Name: "ca", KeyPair: ca, CN: "Test CA", KeyUsage: x509.KeyUsageCertSign}
+	serverSpec := CertSpec{
+		Name:     "server",
+		KeyPair:  server,
+		Parent:   ca,
+		CN:       "localhost",
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		IPs:      []string{"172.30.1.1"},
+		DNSNames: []string{"registry.local"},
+	}
+
+	if err := EnsureCerts([]CertSpec{
+		caSpec,
+		{Name: "other", KeyPair: otherCA, CN: "Other CA", KeyUsage: x509.KeyUsageCertSign},
+		serverSpec,
+	}); err != nil {
+		t.Fatalf("error ensuring certs: %v", err)
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		spec     func() CertSpec
+		now      time.Time
+		expiring bool
+		problems bool
+		problem  string
+	}{
+		{
+			name: "ca-ok",
+			spec: func() CertSpec { return caSpec },
+			now:  now,
+		},
+		{
+			name: "server-ok",
+			spec: func() CertSpec { return serverSpec },
+			now:  now,
+		},
+		{
+			name:     "server-expiring",
+			spec:     func() CertSpec { return serverSpec },
+			now:      now.AddDate(1, 0, -7),
+			expiring: true,
+		},
+		{
+			name:     "server-expired",
+			spec:     func() CertSpec { return serverSpec },
+			now:      now.AddDate(1, 0, 1),
+			problems: true,
+		},
+		{
+			name: "server-ip-changed",
+			spec: func() CertSpec {
+				spec := serverSpec
+				spec.IPs = []string{"172.30.1.2"}
+
+				return spec
+			},
+			now:      now,
+			problems: true,
+			problem:  "missing IP SAN 172.30.1.2",
+		},
+		{
+			name: "server-dns-added",
+			spec: func() CertSpec {
+				spec := serverSpec
+				spec.DNSNames = append([]string{"registry.default"}, spec.DNSNames...)
+
+				return spec
+			},
+			now:      now,
+			problems: true,
+			problem:  "missing DNS SAN registry.default",
+		},
+		{
+			name: "server-key-usage",
+			spec: func() CertSpec {
+				spec := serverSpec
+				spec.KeyUsage |= x509.KeyUsageKeyEncipherment
+
+				return spec
+			},
+			now:      now,
+			problems: true,
+		},
+		{
+			name: "server-other-ca",
+			spec: func() CertSpec {
+				spec := serverSpec
+				spec.Parent = otherCA
+
+				return spec
+			},
+			now:      now,
+			problems: true,
+		},
+		{
+			name: "server-as-ca",
+			spec: func() CertSpec {
+				spec := serverSpec
+				spec.Parent = nil
+
+				return spec
+			},
+			now:      now,
+			problems: true,
+		},
+		{
+			name: "empty",
+			spec: func() CertSpec {
+				return CertSpec{Name: "empty", KeyPair: &KeyPair{}, CN: "empty"}
+			},
+			now:      now,
+			problems: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := test.spec()
+			status := spec.Check(test.now)
+
+			if status.Expiring != test.expiring {
+				t.Errorf("expiring: got %t, want %t", status.Expiring, test.expiring)
+			}
+			if (len(status.Problems) > 0) != test.problems {
+				t.Errorf("problems: got %v, want any %t", status.Problems, test.problems)
+			}
+			if test.problem != "" && !slices.Contains(status.Problems, test.problem) {
+				t.Errorf("problems: got %v, want %q", status.Problems, test.problem)
+			}
+		})
+	}
+}
+
+func Test_CertSpecEnsure(t *testing.T) {
+	ca := &KeyPair{}
+	leaf := &KeyPair{}
+	chained := &KeyPair{}
+
+	subject := CertSubject{Organization: "Example Corp", OrganizationalUnit: "Network"}
+
+	if err := EnsureCerts([]CertSpec{
+		{Name: "ca", KeyPair: ca, CN: "Test CA", KeyUsage: x509.KeyUsageCertSign, Subject: subject},
+		{Name: "leaf", KeyPair: leaf, Parent: ca, CN: "localhost", Subject: subject},
+	}); err != nil {
+		t.Fatalf("error ensuring certs: %v", err)
+	}
+
+	caCert, err := ca.PCert()
+	if err != nil {
+		t.Fatalf("error parsing ca: %v", err)
+	}
+	leafCert, err := leaf.PCert()
+	if err != nil {
+		t.Fatalf("error parsing leaf: %v", err)
+	}
+
+	if len(caCert.SubjectKeyId) == 0 || len(leafCert.SubjectKeyId) == 0 {
+		t.Errorf("subject key id is missing")
+	}
+	if !bytes.Equal(leafCert.AuthorityKeyId, caCert.SubjectKeyId) {
+		t.Errorf("leaf authority key id doesn't match ca subject key id")
+	}
+	if caCert.SerialNumber.Cmp(leafCert.SerialNumber) == 0 {
+		t.Errorf("serial numbers are the same")
+	}
+	if got := leafCert.Subject.Organization; len(got) != 1 || got[0] != subject.Organization {
+		t.Errorf("organization: got %v, want %s", got, subject.Organization)
+	}
+	if got := leafCert.Subject.Country; len(got) != 1 || got[0] != DefaultCertSubject.Country {
+		t.Errorf("country: got %v, want default %s", got, DefaultCertSubject.Country)
+	}
+	if leaf.Chain != "" {
+		t.Errorf("leaf of self-signed ca shouldn't have chain")
+	}
+
+	// pretend ca is an intermediate chained to external one
+	ca.Chain = leaf.Cert
+	if err := EnsureCerts([]CertSpec{{Name: "chained", KeyPair: chained, Parent: ca, CN: "localhost"}}); err != nil {
+		t.Fatalf("error ensuring chained cert: %v", err)
+	}
+	if chained.Chain != ca.Cert+ca.Chain {
+		t.Errorf("chained cert should have issuer and its chain")
+	}
+	if !strings.HasPrefix(chained.FullChain(), chained.Cert) {
+		t.Errorf("full chain should start with the cert")
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	BlockTypeCSR      = "CERTIFICATE REQUEST"
+	BlockTypePKCS8Key = "PRIVATE KEY"
+)
+
+type componentCerts struct {
+	component string
+	specs     []CertSpec
+}
+
+// certs returns certificates of the specified (or all if empty) enabled components
+func (mngr *Manager) certs(components []string) ([]componentCerts, error) {
+	res := []componentCerts{}
+	known := []string{}
+
+	for _, comp := range mngr.components {
+		provider, ok := comp.(CertProvider)
+		if !ok || !comp.IsEnabled(mngr.preset) {
+			continue
+		}
+
+		known = append(known, comp.Name())
+		if len(components) > 0 && !slices.Contains(components, comp.Name()) {
+			continue
+		}
+
+		res = append(res, componentCerts{
+			component: comp.Name(),
+			specs:     provider.Certs(mngr.getComponent),
+		})
+	}
+
+	for _, name := range components {
+		if !slices.Contains(known, name) {
+			return nil, errors.Errorf("unknown component %s, certs are owned by: %s", name, strings.Join(known, ", "))
+		}
+	}
+
+	return res, nil
+}
+
+// CertsStatus returns status of all certificates owned by the enabled components
+func (mngr *Manager) CertsStatus(now time.Time) []CertStatus {
+	res := []CertStatus{}
+
+	all, _ := mngr.certs(nil)
+	for _, comp := range all {
+		for _, spec := range comp.specs {
+			status := spec.Check(now)
+			status.Component = comp.component
+
+			res = append(res, status)
+		}
+	}
+
+	return res
+}
+
+// PrintCertsStatus prints status of all certificates as a table
+func (mngr *Manager) PrintCertsStatus(w io.Writer) error {
+	now := time.Now()
+
+	return printCertsStatus(w, mngr.CertsStatus(now), now)
+}
+
+func printCertsStatus(w io.Writer, statuses []CertStatus, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPONENT\tID\tTYPE\tEXPIRES\tSTATUS")
+	for _, status := range statuses {
+		state := "ok"
+		if len(status.Problems) > 0 {
+			state = strings.Join(status.Problems, ", ")
+		} else if status.Expiring {
+			state = "expiring"
+		}
+		if status.CSRPending {
+			state += " (csr pending)"
+		}
+
+		certType := "leaf"
+		if status.IsCA && status.Imported {
+			certType = "imported ca"
+		} else if status.IsCA {
+			certType = "ca"
+		}
+
+		expires := "-"
+		if !status.NotAfter.IsZero() {
+			expires = fmt.Sprintf("%s (%dd)", status.NotAfter.Format(time.DateOnly), int(status.NotAfter.Sub(now).Hours()/24))
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", status.Component, status.ID, certType, expires, state)
+	}
+
+	return errors.Wrapf(tw.Flush(), "error printing certs status")
+}
+
+// checkCerts warns about expiring certificates and fails if any of them is unusable
+func (mngr *Manager) checkCerts() error {
+	for _, status := range mngr.CertsStatus(time.Now()) {
+		if len(status.Problems) > 0 {
+			return errors.Errorf("certificate %s of %s is invalid (%s), run 'hhfab certs rotate' to fix it",
+				status.Name, status.Component, strings.Join(status.Problems, ", "))
+		}
+
+		if status.Expiring {
+			slog.Warn("Certificate is expiring, run 'hhfab certs rotate' to renew it",
+				"component", status.Component, "name", status.Name, "notAfter", status.NotAfter)
+		}
+	}
+
+	return nil
+}
+
+// RotateCerts regenerates all leaf certificates of the specified (or all if empty) components, CAs are only
+// regenerated if they are expiring or invalid, in such case all certs they've signed should be trusted again
+func (mngr *Manager) RotateCerts(components []string) error {
+	all, err := mngr.certs(components)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, comp := range all {
+		for idx := range comp.specs {
+			spec := &comp.specs[idx]
+
+			if spec.IsCA() {
+				status := spec.Check(now)
+				if !status.NeedsRotation() {
+					slog.Info("Keeping CA", "component", comp.component, "name", spec.Name, "notAfter", status.NotAfter)
+
+					continue
+				}
+
+				if spec.KeyPair.Imported {
+					return errors.Errorf("imported CA %s of %s should be renewed using 'hhfab certs csr' and 'hhfab certs import'", spec.Name, comp.component)
+				}
+
+				slog.Warn("Rotating CA, everything trusting it should be updated", "component", comp.component, "name", spec.Name)
+			} else {
+				slog.Info("Rotating", "component", comp.component, "name", spec.Name)
+			}
+
+			*spec.KeyPair = KeyPair{}
+			if err := spec.Ensure(); err != nil {
+				return errors.Wrapf(err, "error rotating certs for component %s", comp.component)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CertsCSR writes CSRs for all CAs of the specified (or all if empty) components into the dir, so they could be
+// signed by external CA as intermediates and imported back, private keys are kept in config until then
+func (mngr *Manager) CertsCSR(dir string, components []string) error {
+	all, err := mngr.certs(components)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrapf(err, "error creating dir %s", dir)
+	}
+
+	for _, comp := range all {
+		for idx := range comp.specs {
+			spec := &comp.specs[idx]
+			if !spec.IsCA() {
+				continue
+			}
+
+			// same key is reused until cert is imported, so CSR could be re-generated without invalidating the old one
+			if spec.KeyPair.PendingKey == "" {
+				key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+				if err != nil {
+					return errors.Wrapf(err, "error generating private key")
+				}
+
+				spec.KeyPair.PendingKey, err = encodeKey(key)
+				if err != nil {
+					return err
+				}
+			}
+
+			key, err := parseKey(spec.KeyPair.PendingKey)
+			if err != nil {
+				return errors.Wrapf(err, "error parsing pending key for %s", spec.Name)
+			}
+
+			csr, err := x509.CreateCertificateRequest(cryptorand.Reader, &x509.CertificateRequest{
+				Subject: spec.Subject.Fallback(DefaultCertSubject).PKIXName(spec.CN),
+			}, key)
+			if err != nil {
+				return errors.Wrapf(err, "error creating CSR for %s", spec.Name)
+			}
+
+			path := filepath.Join(dir, comp.component+"-"+spec.ID()+".csr")
+			err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: BlockTypeCSR, Bytes: csr}), 0o644) //nolint:gosec
+			if err != nil {
+				return errors.Wrapf(err, "error writing CSR %s", path)
+			}
+
+			slog.Info("CSR created, it should be signed as CA", "component", comp.component, "id", spec.ID(), "path", path)
+		}
+	}
+
+	return nil
+}
+
+// ImportCert imports CA cert signed by external CA for the key from CSR (or provided key) and regenerates all certs
+// signed by it, issuer certs following the cert itself and the ones from chain data are kept as its chain
+func (mngr *Manager) ImportCert(component, id string, certData, keyData, chainData []byte) error {
+	all, err := mngr.certs([]string{component})
+	if err != nil {
+		return err
+	}
+
+	specs := all[0].specs
+	idx := slices.IndexFunc(specs, func(spec CertSpec) bool { return spec.ID() == id })
+	if idx < 0 {
+		ids := []string{}
+		for _, spec := range specs {
+			if spec.IsCA() {
+				ids = append(ids, spec.ID())
+			}
+		}
+
+		return errors.Errorf("unknown cert %s of %s, CAs are: %s", id, component, strings.Join(ids, ", "))
+	}
+
+	spec := &specs[idx]
+	if !spec.IsCA() {
+		return errors.Errorf("only CAs could be imported, %s is signed by fabricator CA", spec.Name)
+	}
+
+	certs, err := parseCerts(append(append([]byte{}, certData...), chainData...))
+	if err != nil {
+		return errors.Wrapf(err, "error parsing certs")
+	}
+	if len(certs) == 0 {
+		return errors.New("no certificates found")
+	}
+
+	cert := certs[0]
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.Errorf("certificate %q isn't a CA or can't sign certificates", cert.Subject.CommonName)
+	}
+
+	keyPem := spec.KeyPair.PendingKey
+	if len(keyData) > 0 {
+		keyPem, err = normalizeKey(keyData)
+		if err != nil {
+			return err
+		}
+	}
+	if keyPem == "" {
+		return errors.Errorf("no private key provided and no CSR pending for %s", spec.Name)
+	}
+
+	key, err := parseKey(keyPem)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing private key")
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return errors.New("private key doesn't match certificate")
+	}
+
+	if len(certs) > 1 {
+		if err := cert.CheckSignatureFrom(certs[1]); err != nil {
+			return errors.Wrapf(err, "certificate isn't signed by the first cert of the chain")
+		}
+	}
+
+	chain := ""
+	for _, issuer := range certs[1:] {
+		// root is expected to be trusted by clients, so it's not part of the chain
+		if issuer.CheckSignatureFrom(issuer) == nil {
+			continue
+		}
+
+		chain += encodeCert(issuer.Raw)
+	}
+
+	*spec.KeyPair = KeyPair{
+		Cert:     encodeCert(cert.Raw),
+		Key:      keyPem,
+		Chain:    chain,
+		Imported: true,
+	}
+
+	if status := spec.Check(time.Now()); len(status.Problems) > 0 {
+		return errors.Errorf("imported certificate is invalid: %s", strings.Join(status.Problems, ", "))
+	}
+
+	slog.Info("CA imported", "component", component, "id", id, "subject", cert.Subject.String(), "notAfter", cert.NotAfter)
+
+	for idx := range specs {
+		leaf := &specs[idx]
+		if leaf.Parent != spec.KeyPair {
+			continue
+		}
+
+		slog.Info("Re-issuing", "component", component, "name", leaf.Name)
+
+		*leaf.KeyPair = KeyPair{}
+		if err := leaf.Ensure(); err != nil {
+			return errors.Wrapf(err, "error re-issuing certs signed by imported CA")
+		}
+	}
+
+	return nil
+}
+
+// normalizeKey converts EC private key in SEC 1 or PKCS #8 form into the SEC 1 form used in config
+func normalizeKey(data []byte) (string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", errors.New("failed to parse private key PEM")
+	}
+
+	switch block.Type {
+	case BlockTypeKey:
+		return string(pem.EncodeToMemory(block)), nil
+	case BlockTypePKCS8Key:
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to parse private key")
+		}
+
+		key, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", errors.Errorf("only EC private keys are supported, got %T", parsed)
+		}
+
+		return encodeKey(key)
+	default:
+		return "", errors.Errorf("invalid block type '%s' while expected '%s' or '%s'", block.Type, BlockTypeKey, BlockTypePKCS8Key)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PrintCertsStatus(t *testing.T) {
+	ca := &KeyPair{}
+	server := &KeyPair{}
+
+	serverSpec := CertSpec{
+		Name:     "Server",
+		KeyPair:  server,
+		Parent:   ca,
+		CN:       "localhost",
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		IPs:      []string{"172.30.1.1"},
+	}
+
+	if err := EnsureCerts([]CertSpec{
+		{Name: "CA", KeyPair: ca, CN: "Test CA", KeyUsage: x509.KeyUsageCertSign},
+		serverSpec,
+	}); err != nil {
+		t.Fatalf("error ensuring certs: %v", err)
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		spec  func() CertSpec
+		now   time.Time
+		state string
+	}{
+		{
+			name:  "ok",
+			spec:  func() CertSpec { return serverSpec },
+			now:   now,
+			state: "ok",
+		},
+		{
+			name:  "expiring",
+			spec:  func() CertSpec { return serverSpec },
+			now:   now.AddDate(1, 0, -7),
+			state: "expiring",
+		},
+		{
+			name: "san-mismatch",
+			spec: func() CertSpec {
+				spec := serverSpec
+				spec.IPs = []string{"172.30.1.2"}
+
+				return spec
+			},
+			now:   now,
+			state: "missing IP SAN 172.30.1.2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := test.spec()
+			status := spec.Check(test.now)
+			status.Component = "zot"
+
+			buf := &bytes.Buffer{}
+			if err := printCertsStatus(buf, []CertStatus{status}, test.now); err != nil {
+				t.Fatalf("error printing certs status: %v", err)
+			}
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("expected header and one row, got %q", buf.String())
+			}
+			if !strings.HasPrefix(lines[1], "zot ") || !strings.HasSuffix(lines[1], "  "+test.state) {
+				t.Errorf("unexpected row %q, want status %q", lines[1], test.state)
+			}
+		})
+	}
+}
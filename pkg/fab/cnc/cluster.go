@@ -0,0 +1,238 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"go.githedgehog.com/fabric/api/meta"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	"golang.org/x/exp/slices"
+	"sigs.k8s.io/yaml"
+)
+
+// ClusterOpts selects the ops of the installer bundle that could be applied to the running cluster by the controller
+type ClusterOpts struct {
+	// Source is the installer bundle to take ops from
+	Source Bundle
+	// ManifestsTarget is the install target of the manifests (e.g. k3s auto-deploy dir) that are applied to the cluster
+	ManifestsTarget string
+	// Skip is the names of the ops that shouldn't be applied from inside the cluster (e.g. wiring)
+	Skip []string
+	// Resolve enables resolving digests of the artifacts in the source registry, so re-pushed tags are detected
+	Resolve bool
+}
+
+// ClusterComponent is the in-cluster part of a single component: kube objects to apply and artifacts to push into the
+// in-cluster registry
+type ClusterComponent struct {
+	Name string
+	// Versions are tags of all component artifacts pinned to the digests (if resolved) by repo/name, including the ones
+	// that aren't pushed
+	Versions map[string]string
+	// Manifests are multi-document kube objects yaml, in the build order
+	Manifests []ClusterManifest
+	// Pushes are the artifacts synced to the in-cluster registry
+	Pushes []ClusterPush
+	// Unreachable are the artifacts that couldn't be resolved in the source registry (e.g. for offline installs), so
+	// nothing could be pushed from inside the cluster and they should be delivered using upgrade bundle instead
+	Unreachable []string
+}
+
+type ClusterManifest struct {
+	Name    string
+	Content string
+}
+
+type ClusterPush struct {
+	Name string
+	Op   BuildOp
+}
+
+// LoadCluster loads config from the saver (e.g. taken from the Fabricator object) and uses provided wiring (e.g. loaded
+// from the cluster), basedir is only used as a scratch dir for the artifacts
+func (mngr *Manager) LoadCluster(basedir string, saver *ManagerSaver, data *wiring.Data) error {
+	mngr.basedir = basedir
+
+	if err := mngr.CheckSaver(saver); err != nil {
+		return err
+	}
+
+	if err := mngr.applyConfig(saver); err != nil {
+		return errors.Wrapf(err, "error loading config")
+	}
+
+	mngr.wiring = data
+
+	return errors.Wrapf(mngr.prepare(), "error preparing")
+}
+
+// CheckSaver validates only the parts of the saved config that don't need decryption, it's used to validate encrypted
+// config when it can't be unlocked
+func (mngr *Manager) CheckSaver(saver *ManagerSaver) error {
+	if !slices.Contains(mngr.presets, saver.Preset) {
+		return errors.Errorf("unknown preset: %s", saver.Preset)
+	}
+	if !slices.Contains(meta.FabricModes, saver.FabricMode) {
+		return errors.Errorf("unknown fabric mode: %s", saver.FabricMode)
+	}
+	if saver.Encryption == nil && isEncrypted(saver.Config) {
+		return errors.New("config contains encrypted values but no encryption recipients")
+	}
+
+	return nil
+}
+
+// GeneratedSecrets returns secret fields (component name and field path) that were generated or changed on hydration
+// compared to the saver the config was loaded from, such config can't be used in cluster as generated keys would only
+// be known to the process that hydrated it
+func (mngr *Manager) GeneratedSecrets(saver *ManagerSaver) ([]string, error) {
+	res := []string{}
+
+	for _, comp := range mngr.components {
+		provider, ok := comp.(SecretsProvider)
+		if !ok || !comp.IsEnabled(mngr.preset) {
+			continue
+		}
+
+		data, err := yaml.Marshal(comp)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error marshaling config for component %s", comp.Name())
+		}
+
+		hydrated := map[string]any{}
+		if err := yaml.Unmarshal(data, &hydrated); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling config for component %s", comp.Name())
+		}
+
+		saved, _ := saver.Config[comp.Name()].(map[string]any)
+
+		for _, field := range provider.SecretFields() {
+			if fieldValue(hydrated, field) != fieldValue(saved, field) {
+				res = append(res, comp.Name()+"."+field)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// ClusterComponents builds all enabled components and returns in-cluster parts of the source bundle grouped by component
+func (mngr *Manager) ClusterComponents(opts ClusterOpts) ([]ClusterComponent, error) {
+	builds, _, err := mngr.collectOps(mngr.trustPolicy(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	res := []ClusterComponent{}
+	get := func(name string) *ClusterComponent {
+		for idx := range res {
+			if res[idx].Name == name {
+				return &res[idx]
+			}
+		}
+
+		res = append(res, ClusterComponent{Name: name, Versions: map[string]string{}})
+
+		return &res[len(res)-1]
+	}
+
+	// same artifact could be used by multiple ops, so it's resolved only once
+	resolved := map[string]string{}
+
+	for _, build := range builds {
+		if build.bundle != opts.Source || slices.Contains(opts.Skip, build.name) {
+			continue
+		}
+
+		if artifact, ok := build.op.(ArtifactBuildOp); ok {
+			ref := artifact.Artifact()
+			comp := get(build.component)
+
+			if opts.Resolve && ref.Digest == "" {
+				digest, exist := resolved[ref.String()]
+				if !exist {
+					var err error
+					digest, err = artifactDigest(*ref, "")
+					if err != nil {
+						slog.Warn("Artifact can't be resolved in the source registry", "name", build.name, "ref", ref.String(), "err", err)
+					}
+					resolved[ref.String()] = digest
+				}
+				if digest == "" && !slices.Contains(comp.Unreachable, ref.String()) {
+					comp.Unreachable = append(comp.Unreachable, ref.String())
+				}
+				ref.Digest = digest
+			}
+
+			version := ref.Tag
+			if ref.Digest != "" {
+				version += "@" + ref.Digest
+			}
+			comp.Versions[ref.RepoName()] = version
+		}
+
+		switch op := build.op.(type) {
+		case *SyncOCI:
+			comp := get(build.component)
+			comp.Pushes = append(comp.Pushes, ClusterPush{Name: build.name, Op: op})
+		case *FileGenerate:
+			if op.File.InstallTarget != opts.ManifestsTarget {
+				continue
+			}
+
+			content, err := op.Content()
+			if err != nil {
+				return nil, errors.Wrapf(err, "error generating content for %s", build.name)
+			}
+
+			comp := get(build.component)
+			comp.Manifests = append(comp.Manifests, ClusterManifest{Name: build.name, Content: content})
+		}
+	}
+
+	return res, nil
+}
+
+// Run downloads the artifact into the basedir and pushes it to the in-cluster registry, downloaded files are removed
+func (push *ClusterPush) Run(basedir string) error {
+	slog.Info("Pushing", "name", push.Name)
+
+	defer func() {
+		for _, output := range push.Op.Outputs() {
+			if err := os.RemoveAll(filepath.Join(basedir, output)); err != nil {
+				slog.Warn("Error removing pushed artifact", "name", push.Name, "output", output, "err", err)
+			}
+		}
+	}()
+
+	if err := push.Op.Build(basedir, nil); err != nil {
+		return errors.Wrapf(err, "error downloading %s", push.Name)
+	}
+
+	for _, runOp := range push.Op.RunOps() {
+		if err := runOp.Hydrate(); err != nil {
+			return errors.Wrapf(err, "error hydrating run op for %s", push.Name)
+		}
+		if err := runOp.Run(basedir); err != nil {
+			return errors.Wrapf(err, "error running %s for %s", runOp.Summary(), push.Name)
+		}
+	}
+
+	return nil
+}
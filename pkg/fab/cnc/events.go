@@ -0,0 +1,191 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+var Outputs = []string{OutputText, OutputJSON}
+
+type EventKind string
+
+const (
+	EventKindBuild     EventKind = "build"
+	EventKindBuildOp   EventKind = "build-op"
+	EventKindPack      EventKind = "pack"
+	EventKindRecipe    EventKind = "recipe"
+	EventKindAction    EventKind = "action"
+	EventKindUndo      EventKind = "undo"
+	EventKindVLAB      EventKind = "vlab"
+	EventKindVM        EventKind = "vm"
+	EventKindVMInstall EventKind = "vm-install"
+	EventKindInstall   EventKind = "install"
+)
+
+type EventStatus string
+
+const (
+	EventStatusStart  EventStatus = "start"
+	EventStatusFinish EventStatus = "finish"
+	EventStatusSkip   EventStatus = "skip"
+	EventStatusError  EventStatus = "error"
+)
+
+// Event is a single machine readable progress event emitted as a JSON line in the JSON output mode
+type Event struct {
+	Time   time.Time   `json:"time"`
+	Kind   EventKind   `json:"kind"`
+	Status EventStatus `json:"status"`
+	Bundle string      `json:"bundle,omitempty"`
+	Stage  *Stage      `json:"stage,omitempty"`
+	Name   string      `json:"name,omitempty"`
+	Op     string      `json:"op,omitempty"`
+	Took   float64     `json:"tookSeconds,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	return lw.w.Write(p)
+}
+
+var (
+	eventsOut *lockedWriter
+	stdout    = &lockedWriter{w: os.Stdout}
+)
+
+// SetupOutput configures output mode and returns the writer that should be used for logs, in JSON mode events are
+// enabled and progress bars are suppressed
+func SetupOutput(output string) io.Writer {
+	if output == OutputJSON {
+		eventsOut = stdout
+	} else {
+		eventsOut = nil
+	}
+
+	return stdout
+}
+
+func IsJSONOutput() bool {
+	return eventsOut != nil
+}
+
+// ProgressOutput returns writer for the progress bars, it discards everything in JSON mode
+func ProgressOutput() io.Writer {
+	if IsJSONOutput() {
+		return io.Discard
+	}
+
+	return os.Stdout
+}
+
+// EmitEvent writes event as a JSON line if JSON output is enabled and does nothing otherwise
+func EmitEvent(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	EmitJSON(event)
+}
+
+// EmitJSON writes any object (e.g. event or status) as a JSON line if JSON output is enabled and does nothing otherwise
+func EmitJSON(obj any) {
+	if eventsOut == nil {
+		return
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		slog.Warn("Error marshalling JSON output", "err", err)
+
+		return
+	}
+
+	if _, err := eventsOut.Write(append(data, '\n')); err != nil {
+		slog.Warn("Error writing JSON output", "err", err)
+	}
+}
+
+// CommandOutput returns writer for the subprocess output, it's stdout or logs line by line in JSON output mode, so
+// the output stays valid JSON lines (subprocess JSON lines are passed as is), returned func should be called once the command is done to flush the output
+func CommandOutput(name string) (io.Writer, func()) {
+	if !IsJSONOutput() {
+		return os.Stdout, func() {}
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			// JSON lines (e.g. events from the remote recipe runner) are passed as is
+			if line := scanner.Bytes(); len(line) > 0 && line[0] == '{' && json.Valid(line) {
+				if _, err := eventsOut.Write(append(slices.Clone(line), '\n')); err != nil {
+					slog.Warn("Error writing JSON output", "err", err)
+				}
+
+				continue
+			}
+
+			slog.Info("Output", "cmd", name, "line", scanner.Text())
+		}
+		// drain the rest (e.g. too long line) so command isn't blocked
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+
+	return pw, func() {
+		pw.Close()
+		<-done
+	}
+}
+
+// EmitDone emits finish or error event (if err isn't nil) with the time since start
+func EmitDone(event Event, start time.Time, err error) {
+	event.Status = EventStatusFinish
+	event.Took = time.Since(start).Seconds()
+	if err != nil {
+		event.Status = EventStatusError
+		event.Error = err.Error()
+	}
+
+	EmitEvent(event)
+}
+
+func stagePtr(stage Stage) *Stage {
+	return &stage
+}
@@ -0,0 +1,234 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/pkg/errors"
+	"go.githedgehog.com/fabric/api/meta"
+	fabapi "go.githedgehog.com/fabricator/api/fabricator/v1alpha2"
+	"go.githedgehog.com/fabricator/config/crd"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	KindFabricator = "Fabricator"
+
+	configInstallComponent = "fabricator"
+)
+
+// ConfigInstall describes installation of the config into the cluster as a Fabricator object (together with its CRD),
+// so the as-installed config is queryable with kubectl, it's only installed if encryption is set as the object is
+// readable by anyone with access to the Fabricator API and secrets would be stored in plaintext otherwise
+type ConfigInstall struct {
+	Bundle Bundle
+	Stage  Stage
+	// Target is the install target of the manifests, e.g. k3s auto-deploy dir
+	Target    string
+	Name      string
+	Namespace string
+}
+
+func (mngr *Manager) SetConfigInstall(install *ConfigInstall) {
+	mngr.configInstall = install
+}
+
+// Fabricator returns the saved config as a Fabricator object, secrets are encrypted if encryption is set and custom
+// components aren't included as they are saved as their own files
+func (mngr *Manager) Fabricator(name, namespace string) (*fabapi.Fabricator, error) {
+	saver, err := mngr.saver(secretsEncrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	return saver.ToFabricator(name, namespace)
+}
+
+// Export returns the saved config (same as config.yaml) or the Fabricator object yaml if asCRD is set
+func (mngr *Manager) Export(asCRD bool, name, namespace string) ([]byte, error) {
+	if !asCRD {
+		return mngr.configData(secretsEncrypt)
+	}
+
+	f, err := mngr.Fabricator(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling %s", KindFabricator)
+	}
+
+	return data, nil
+}
+
+// ToFabricator converts the saved config into the Fabricator object, component configs are converted into the typed API
+// config with untyped fields kept as is
+func (saver *ManagerSaver) ToFabricator(name, namespace string) (*fabapi.Fabricator, error) {
+	f := &fabapi.Fabricator{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: fabapi.GroupVersion.String(),
+			Kind:       KindFabricator,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: fabapi.FabricatorSpec{
+			Preset:     string(saver.Preset),
+			FabricMode: string(saver.FabricMode),
+		},
+	}
+
+	if saver.Encryption != nil {
+		f.Spec.Encryption = &fabapi.Encryption{}
+		for _, recipient := range saver.Encryption.Recipients {
+			f.Spec.Encryption.Recipients = append(f.Spec.Encryption.Recipients, fabapi.EncryptionRecipient(recipient))
+		}
+	}
+
+	if len(saver.Config) > 0 {
+		data, err := json.Marshal(saver.Config)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error marshaling component configs")
+		}
+
+		// only known components are part of the Fabricator object, fields not typed in the API are kept as is
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		f.Spec.Config = &fabapi.FabricatorConfig{}
+		if err := dec.Decode(f.Spec.Config); err != nil {
+			return nil, errors.Wrapf(err, "error converting component configs")
+		}
+	}
+
+	return f, nil
+}
+
+// ManagerSaverFromFabricator converts the Fabricator object back into the saved config
+func ManagerSaverFromFabricator(f *fabapi.Fabricator) (*ManagerSaver, error) {
+	saver := &ManagerSaver{
+		Preset:     Preset(f.Spec.Preset),
+		FabricMode: meta.FabricMode(f.Spec.FabricMode),
+	}
+
+	if f.Spec.Encryption != nil {
+		saver.Encryption = &Encryption{}
+		for _, recipient := range f.Spec.Encryption.Recipients {
+			saver.Encryption.Recipients = append(saver.Encryption.Recipients, EncryptionRecipient(recipient))
+		}
+	}
+
+	if f.Spec.Config != nil {
+		data, err := json.Marshal(f.Spec.Config)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error marshaling component configs")
+		}
+
+		if err := json.Unmarshal(data, &saver.Config); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling component configs")
+		}
+	}
+
+	return saver, nil
+}
+
+// parseSaver parses the saved config or the Fabricator object (e.g. exported or taken from the cluster)
+func parseSaver(data []byte) (*ManagerSaver, error) {
+	typeMeta := &metav1.TypeMeta{}
+	if err := yaml.Unmarshal(data, typeMeta); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling config type")
+	}
+
+	if typeMeta.Kind != KindFabricator {
+		saver := &ManagerSaver{}
+		if err := yaml.UnmarshalStrict(data, saver); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling config")
+		}
+
+		return saver, nil
+	}
+
+	if typeMeta.APIVersion != fabapi.GroupVersion.String() {
+		return nil, errors.Errorf("unsupported %s api version %s", KindFabricator, typeMeta.APIVersion)
+	}
+
+	f := &fabapi.Fabricator{}
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling %s", KindFabricator)
+	}
+
+	return ManagerSaverFromFabricator(f)
+}
+
+// buildConfigInstall adds ops to install the Fabricator CRD and the object with the current config, nothing is
+// installed without encryption so secrets never get into the cluster in plaintext
+func (mngr *Manager) buildConfigInstall(run AddBuildOp, install AddRunOp) error {
+	cfg := mngr.configInstall
+
+	if mngr.encryption == nil {
+		slog.Warn("Config isn't installed as " + KindFabricator + " object without encryption as it contains secrets, use init with --encrypt-to to enable it")
+
+		return nil
+	}
+
+	f, err := mngr.Fabricator(cfg.Name, cfg.Namespace)
+	if err != nil {
+		return errors.Wrapf(err, "error converting config to %s", KindFabricator)
+	}
+
+	run(cfg.Bundle, cfg.Stage, "fabricator-crd",
+		&FileGenerate{
+			File: File{
+				Name:          "fabricator-crd.yaml",
+				InstallTarget: cfg.Target,
+				InstallName:   "hh-fabricator-crd.yaml",
+			},
+			Content: FromValue(crd.Fabricators),
+		})
+
+	install(cfg.Bundle, cfg.Stage, "fabricator-crd-wait",
+		&WaitKube{
+			Name: "crd/fabricators." + fabapi.GroupVersion.Group,
+		})
+
+	// config contains encrypted secrets
+	run(cfg.Bundle, cfg.Stage, "fabricator-config",
+		&FileGenerate{
+			File: File{
+				Name:          "fabricator-config.yaml",
+				InstallTarget: cfg.Target,
+				InstallName:   "hh-fabricator-config.yaml",
+				InstallMode:   0o600,
+			},
+			Content: FromKubeObjects(KubeObjectProvider{Obj: f}),
+		})
+
+	install(cfg.Bundle, cfg.Stage, "fabricator-config-wait",
+		&WaitKube{
+			Name:       cfg.Name,
+			Namespace:  cfg.Namespace,
+			APIVersion: fabapi.GroupVersion.String(),
+			Kind:       KindFabricator,
+			JSONPath:   ".metadata.name",
+		})
+
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func Test_FabricatorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		saver *ManagerSaver
+	}{
+		{
+			name: "empty",
+			saver: &ManagerSaver{
+				Preset:     "lab",
+				FabricMode: "spine-leaf",
+			},
+		},
+		{
+			name: "config",
+			saver: &ManagerSaver{
+				Preset:     "vlab",
+				FabricMode: "collapsed-core",
+				Config: map[string]any{
+					"k3s": map[string]any{
+						"clusterCIDR": "172.28.0.0/16",
+						"token":       "ENC[v1,abcd]",
+					},
+					"fabric": map[string]any{
+						"dhcpServer": "hedgehog",
+						"fabricMTU":  float64(9100),
+						"reservedSubnets": []any{
+							"172.31.0.0/16",
+						},
+						"vpcIRBVLANRanges": []any{
+							map[string]any{"from": float64(3000), "to": float64(3999)},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "encryption",
+			saver: &ManagerSaver{
+				Preset:     "lab",
+				FabricMode: "spine-leaf",
+				Encryption: &Encryption{
+					Recipients: []EncryptionRecipient{
+						{Type: RecipientTypeX25519, PublicKey: "pub", WrappedKey: "wrapped"},
+						{Type: RecipientTypePassphrase, Salt: "salt", WrappedKey: "wrapped2"},
+					},
+				},
+				Config: map[string]any{
+					"zot": map[string]any{},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := test.saver.ToFabricator("default", "default")
+			if err != nil {
+				t.Fatalf("error converting to fabricator: %v", err)
+			}
+
+			data, err := yaml.Marshal(f)
+			if err != nil {
+				t.Fatalf("error marshaling fabricator: %v", err)
+			}
+
+			saver, err := parseSaver(data)
+			if err != nil {
+				t.Fatalf("error parsing fabricator: %v", err)
+			}
+
+			if !reflect.DeepEqual(saver, test.saver) {
+				t.Errorf("round trip mismatch:\ngot  %#v\nwant %#v", saver, test.saver)
+			}
+		})
+	}
+}
+
+func Test_FabricatorTypedConfig(t *testing.T) {
+	saver := &ManagerSaver{
+		Preset:     "lab",
+		FabricMode: "spine-leaf",
+		Config: map[string]any{
+			"k3s": map[string]any{
+				"clusterCIDR": "172.28.0.0/16",
+				"token":       "ENC[v1,abcd]",
+			},
+			"fabric": map[string]any{
+				"dhcpServer": "isc",
+				"vpcIRBVLANRanges": []any{
+					map[string]any{"from": float64(3000), "to": float64(3999)},
+				},
+			},
+		},
+	}
+
+	f, err := saver.ToFabricator("default", "default")
+	if err != nil {
+		t.Fatalf("error converting to fabricator: %v", err)
+	}
+
+	if f.Spec.Config.K3s == nil || f.Spec.Config.K3s.ClusterCIDR != "172.28.0.0/16" {
+		t.Fatalf("k3s cluster CIDR isn't typed: %#v", f.Spec.Config.K3s)
+	}
+	if f.Spec.Config.Fabric == nil || f.Spec.Config.Fabric.DHCPServer != "isc" {
+		t.Fatalf("fabric dhcp server isn't typed: %#v", f.Spec.Config.Fabric)
+	}
+
+	f.Spec.Config.Fabric.DHCPServer = "hedgehog"
+	f.Spec.Config.K3s.ClusterCIDR = ""
+
+	got, err := ManagerSaverFromFabricator(f)
+	if err != nil {
+		t.Fatalf("error converting from fabricator: %v", err)
+	}
+
+	want := map[string]any{
+		"k3s": map[string]any{
+			"token": "ENC[v1,abcd]",
+		},
+		"fabric": map[string]any{
+			"dhcpServer": "hedgehog",
+			"vpcIRBVLANRanges": []any{
+				map[string]any{"from": float64(3000), "to": float64(3999)},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got.Config, want) {
+		t.Errorf("config mismatch:\ngot  %#v\nwant %#v", got.Config, want)
+	}
+
+	saver.Config["unknown"] = map[string]any{}
+	if _, err := saver.ToFabricator("default", "default"); err == nil {
+		t.Errorf("expected error for unknown component")
+	}
+}
+
+func Test_BuildConfigInstall(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption *Encryption
+		want       []string
+	}{
+		{
+			name: "no-encryption",
+		},
+		{
+			name: "encryption",
+			encryption: &Encryption{
+				Recipients: []EncryptionRecipient{{Type: RecipientTypeX25519, PublicKey: "pub", WrappedKey: "wrapped"}},
+			},
+			want: []string{"fabricator-crd", "fabricator-crd-wait", "fabricator-config", "fabricator-config-wait"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mngr := &Manager{
+				encryption:    test.encryption,
+				configInstall: &ConfigInstall{Name: "default", Namespace: "default"},
+			}
+
+			ops := []string{}
+			err := mngr.buildConfigInstall(
+				func(_ Bundle, _ Stage, name string, _ BuildOp) { ops = append(ops, name) },
+				func(_ Bundle, _ Stage, name string, _ RunOp) { ops = append(ops, name) },
+			)
+			if err != nil {
+				t.Fatalf("error building config install: %v", err)
+			}
+
+			if len(ops) != len(test.want) || len(ops) > 0 && !reflect.DeepEqual(ops, test.want) {
+				t.Errorf("ops mismatch: got %v, want %v", ops, test.want)
+			}
+		})
+	}
+}
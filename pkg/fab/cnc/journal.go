@@ -0,0 +1,103 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const JournalFile = "journal.yaml"
+
+// Journal is a record of the recipe actions completed on the current host, it's stored next to the recipe.yaml
+type Journal struct {
+	Entries []JournalEntry `json:"actions,omitempty"`
+}
+
+type JournalEntry struct {
+	Name      string        `json:"name,omitempty"`
+	Type      string        `json:"action,omitempty"`
+	Hash      uint64        `json:"hash,omitempty"`
+	Completed time.Time     `json:"completed,omitempty"`
+	Took      time.Duration `json:"took,omitempty"`
+}
+
+func (j *Journal) Save(basedir string) error {
+	data, err := yaml.Marshal(j)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling journal")
+	}
+
+	return errors.Wrapf(os.WriteFile(filepath.Join(basedir, JournalFile), data, 0o600), "error writing journal")
+}
+
+// Load reads journal from the basedir, missing journal file is the same as an empty journal
+func (j *Journal) Load(basedir string) error {
+	data, err := os.ReadFile(filepath.Join(basedir, JournalFile))
+	if os.IsNotExist(err) {
+		j.Entries = nil
+
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error reading journal")
+	}
+
+	// empty journal is saved without entries, so they should be reset before unmarshalling
+	j.Entries = nil
+
+	return errors.Wrapf(yaml.UnmarshalStrict(data, j), "error unmarshalling journal")
+}
+
+// Completed returns the journal entry for the action with the same name and params hash or nil if there is no such entry
+func (j *Journal) Completed(name string, hash uint64) *JournalEntry {
+	for idx := range j.Entries {
+		if j.Entries[idx].Name == name && j.Entries[idx].Hash == hash {
+			return &j.Entries[idx]
+		}
+	}
+
+	return nil
+}
+
+func (j *Journal) Record(entry JournalEntry) {
+	if existing := j.Completed(entry.Name, entry.Hash); existing != nil {
+		*existing = entry
+
+		return
+	}
+
+	j.Entries = append(j.Entries, entry)
+}
+
+// Remove removes the journal entry for the action with the same name and params hash
+func (j *Journal) Remove(name string, hash uint64) {
+	entries := []JournalEntry{}
+	for _, entry := range j.Entries {
+		if entry.Name != name || entry.Hash != hash {
+			entries = append(entries, entry)
+		}
+	}
+
+	j.Entries = entries
+}
+
+func actionHash(action RecipeAction) (uint64, error) {
+	return hashValues(getShortTypeName(action.Op), action.Op)
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func Test_RunRecipeJournal(t *testing.T) {
+	tests := []struct {
+		name string
+		// modify changes the recipe after the first run
+		modify func(recipe *Recipe)
+		force  bool
+		from   string
+		rerun  []string
+	}{
+		{
+			name: "completed",
+		},
+		{
+			name:   "changed-hash",
+			modify: func(recipe *Recipe) { recipe.Actions[1].Op.(*InstallFile).Mode = 0o600 },
+			rerun:  []string{"b"},
+		},
+		{
+			name:  "from",
+			from:  "b",
+			rerun: []string{"b"},
+		},
+		{
+			name:  "force",
+			force: true,
+			rerun: []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			basedir, target := t.TempDir(), t.TempDir()
+
+			recipe := &Recipe{}
+			for _, name := range []string{"a", "b"} {
+				if err := os.WriteFile(filepath.Join(basedir, name), []byte(name), 0o644); err != nil {
+					t.Fatalf("error writing file %s: %v", name, err)
+				}
+
+				op := &InstallFile{Name: name, Target: target}
+				if err := op.Hydrate(); err != nil {
+					t.Fatalf("error hydrating op: %v", err)
+				}
+				recipe.Actions = append(recipe.Actions, RecipeAction{Name: name, Op: op})
+			}
+			if err := recipe.Save(basedir); err != nil {
+				t.Fatalf("error saving recipe: %v", err)
+			}
+
+			if err := RunRecipe(basedir, nil, false, false, ""); err != nil {
+				t.Fatalf("error running recipe: %v", err)
+			}
+
+			// installed files are removed to detect which actions are re-run
+			for _, name := range []string{"a", "b"} {
+				if err := os.Remove(filepath.Join(target, name)); err != nil {
+					t.Fatalf("error removing installed file %s: %v", name, err)
+				}
+			}
+
+			if test.modify != nil {
+				test.modify(recipe)
+				if err := recipe.Save(basedir); err != nil {
+					t.Fatalf("error saving recipe: %v", err)
+				}
+			}
+
+			if err := RunRecipe(basedir, nil, false, test.force, test.from); err != nil {
+				t.Fatalf("error re-running recipe: %v", err)
+			}
+
+			for _, name := range []string{"a", "b"} {
+				_, err := os.Stat(filepath.Join(target, name))
+				rerun := slices.Contains(test.rerun, name)
+				if rerun && err != nil {
+					t.Errorf("action %s should be re-run", name)
+				}
+				if !rerun && err == nil {
+					t.Errorf("action %s should be skipped", name)
+				}
+			}
+		})
+	}
+}
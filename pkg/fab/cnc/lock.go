@@ -0,0 +1,205 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"sigs.k8s.io/yaml"
+)
+
+const LockFile = "artifacts.lock.yaml"
+
+// ArtifactBuildOp is a build op that downloads an artifact, its ref is pinned to the digest before build
+type ArtifactBuildOp interface {
+	BuildOp
+	Artifact() *Ref
+	SetOfflineSource(path string)
+}
+
+// ArtifactsLock is a mapping from the artifact ref (repo/name:tag) to the resolved manifest digest
+type ArtifactsLock struct {
+	Artifacts map[string]string `json:"artifacts,omitempty"`
+}
+
+func (l *ArtifactsLock) Save(basedir string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling artifacts lock")
+	}
+
+	return errors.Wrapf(os.WriteFile(filepath.Join(basedir, LockFile), data, 0o644), "error writing artifacts lock")
+}
+
+// Load reads artifacts lock from the basedir, missing lock file is the same as an empty lock
+func (l *ArtifactsLock) Load(basedir string) error {
+	return l.LoadFile(filepath.Join(basedir, LockFile))
+}
+
+// LoadFile reads artifacts lock from the file, missing file is the same as an empty lock
+func (l *ArtifactsLock) LoadFile(path string) error {
+	l.Artifacts = map[string]string{}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error reading artifacts lock")
+	}
+
+	err = yaml.UnmarshalStrict(data, l)
+	if err != nil {
+		return errors.Wrapf(err, "error unmarshalling artifacts lock")
+	}
+
+	if l.Artifacts == nil {
+		l.Artifacts = map[string]string{}
+	}
+
+	return nil
+}
+
+// artifactDigest resolves the manifest digest for the ref, it's replaced in tests to avoid reaching registries
+var artifactDigest = resolveDigest
+
+// resolveDigest returns the manifest digest for the ref tag from the remote registry or offline source
+func resolveDigest(ref Ref, offlineSource string) (string, error) {
+	ref.Digest = ""
+
+	src, srcRef, err := newORASSource(ref, offlineSource)
+	if err != nil {
+		return "", err
+	}
+
+	desc, err := src.Resolve(context.Background(), srcRef)
+	if err != nil {
+		return "", errors.Wrapf(err, "error resolving %s", ref)
+	}
+
+	return desc.Digest.String(), nil
+}
+
+// pinArtifacts resolves digests for all artifacts used by build ops and pins refs to them, resolved digests are
+// checked against the lock file and build is refused on mismatch unless updateLock is set, for offline source all
+// artifacts should be locked and lock can't be updated
+func (mngr *Manager) pinArtifacts(builds []buildContext, parallel int, updateLock bool, offlineSource string) error {
+	if offlineSource != "" && updateLock {
+		return errors.Errorf("%s can't be updated for offline source", LockFile)
+	}
+
+	lock := &ArtifactsLock{}
+	if err := lock.Load(mngr.basedir); err != nil {
+		return errors.Wrapf(err, "error loading artifacts lock")
+	}
+
+	refs := map[string][]*Ref{}
+	for _, build := range builds {
+		op, ok := build.op.(ArtifactBuildOp)
+		if !ok {
+			continue
+		}
+
+		ref := op.Artifact()
+		refs[ref.String()] = append(refs[ref.String()], ref)
+	}
+
+	slog.Info("Resolving artifacts", "artifacts", len(refs))
+
+	resolved := map[string]string{}
+	resolvedMu := sync.Mutex{}
+
+	eg := errgroup.Group{}
+	eg.SetLimit(max(parallel, 1))
+
+	for name, same := range refs {
+		name, ref := name, *same[0]
+
+		eg.Go(func() error {
+			digest := ref.Digest
+			if digest == "" {
+				var err error
+				digest, err = artifactDigest(ref, offlineSource)
+				if err != nil {
+					return err
+				}
+			}
+
+			slog.Debug("Resolved", "ref", name, "digest", digest)
+
+			resolvedMu.Lock()
+			defer resolvedMu.Unlock()
+
+			resolved[name] = digest
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return errors.Wrapf(err, "error resolving artifacts")
+	}
+
+	names := []string{}
+	for name := range resolved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mismatch := false
+	for _, name := range names {
+		locked, exist := lock.Artifacts[name]
+		if !exist && offlineSource != "" {
+			slog.Error("Artifact isn't locked, offline source requires all artifacts to be locked", "ref", name)
+			mismatch = true
+
+			continue
+		}
+		if !exist || locked == resolved[name] {
+			continue
+		}
+
+		if updateLock {
+			slog.Info("Updating locked digest", "ref", name, "locked", locked, "resolved", resolved[name])
+		} else {
+			slog.Error("Resolved digest doesn't match locked", "ref", name, "locked", locked, "resolved", resolved[name])
+			mismatch = true
+		}
+	}
+	if mismatch && offlineSource != "" {
+		return errors.Errorf("resolved digests don't match %s, export mirror for the locked artifacts", LockFile)
+	}
+	if mismatch {
+		return errors.Errorf("resolved digests don't match %s, use --update-lock to accept new ones", LockFile)
+	}
+
+	for name, same := range refs {
+		for _, ref := range same {
+			ref.Digest = resolved[name]
+		}
+	}
+
+	// only artifacts used by the current config are kept in the lock
+	lock.Artifacts = resolved
+
+	return errors.Wrapf(lock.Save(mngr.basedir), "error saving artifacts lock")
+}
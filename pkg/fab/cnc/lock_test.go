@@ -0,0 +1,180 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func Test_PinArtifacts(t *testing.T) {
+	const (
+		digestA = "sha256:aaaa"
+		digestB = "sha256:bbbb"
+		offline = "/mirror"
+	)
+
+	ref := Ref{Repo: "ghcr.io/githedgehog", Name: "fabricator/k3s", Tag: "v1"}
+	name := ref.String()
+
+	tests := []struct {
+		name       string
+		lock       map[string]string
+		remote     string
+		pinned     string
+		updateLock bool
+		offline    bool
+		error      bool
+		want       map[string]string
+	}{
+		{
+			name:   "not-locked",
+			remote: digestA,
+			want:   map[string]string{name: digestA},
+		},
+		{
+			name:   "locked",
+			lock:   map[string]string{name: digestA},
+			remote: digestA,
+			want:   map[string]string{name: digestA},
+		},
+		{
+			name:   "stale-dropped",
+			lock:   map[string]string{name: digestA, "ghcr.io/githedgehog/old:v0": digestB},
+			remote: digestA,
+			want:   map[string]string{name: digestA},
+		},
+		{
+			name:   "already-pinned",
+			lock:   map[string]string{name: digestB},
+			pinned: digestB,
+			want:   map[string]string{name: digestB},
+		},
+		{
+			name:   "mismatch",
+			lock:   map[string]string{name: digestA},
+			remote: digestB,
+			error:  true,
+			want:   map[string]string{name: digestA},
+		},
+		{
+			name:       "mismatch-update-lock",
+			lock:       map[string]string{name: digestA},
+			remote:     digestB,
+			updateLock: true,
+			want:       map[string]string{name: digestB},
+		},
+		{
+			name:    "offline-locked",
+			lock:    map[string]string{name: digestA},
+			remote:  digestA,
+			offline: true,
+			want:    map[string]string{name: digestA},
+		},
+		{
+			name:    "offline-not-locked",
+			remote:  digestA,
+			offline: true,
+			error:   true,
+			want:    map[string]string{},
+		},
+		{
+			name:    "offline-mismatch",
+			lock:    map[string]string{name: digestA},
+			remote:  digestB,
+			offline: true,
+			error:   true,
+			want:    map[string]string{name: digestA},
+		},
+		{
+			name:       "offline-update-lock",
+			lock:       map[string]string{name: digestA},
+			remote:     digestB,
+			offline:    true,
+			updateLock: true,
+			error:      true,
+			want:       map[string]string{name: digestA},
+		},
+	}
+
+	resolve := artifactDigest
+	defer func() { artifactDigest = resolve }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			basedir := t.TempDir()
+
+			offlineSource := ""
+			if test.offline {
+				offlineSource = offline
+			}
+
+			artifactDigest = func(got Ref, source string) (string, error) {
+				if got.String() != name || source != offlineSource {
+					return "", errors.Errorf("unexpected resolve of %s from %q", got, source)
+				}
+				if test.remote == "" {
+					return "", errors.Errorf("%s shouldn't be resolved", got)
+				}
+
+				return test.remote, nil
+			}
+
+			if test.lock != nil {
+				if err := (&ArtifactsLock{Artifacts: test.lock}).Save(basedir); err != nil {
+					t.Fatalf("error saving lock: %v", err)
+				}
+			}
+
+			// same artifact used by multiple ops should be resolved once and pinned everywhere
+			pinnedRef := ref
+			pinnedRef.Digest = test.pinned
+			ops := []*FilesORAS{{Ref: pinnedRef}, {Ref: pinnedRef}}
+			builds := []buildContext{}
+			for _, op := range ops {
+				builds = append(builds, buildContext{name: "files", op: op})
+			}
+
+			mngr := &Manager{basedir: basedir}
+			err := mngr.pinArtifacts(builds, 2, test.updateLock, offlineSource)
+			if test.error && err == nil {
+				t.Errorf("pinArtifacts() expected error, got nil")
+			}
+			if !test.error && err != nil {
+				t.Errorf("pinArtifacts() expected no error, got %v", err)
+			}
+
+			lock := &ArtifactsLock{}
+			if err := lock.Load(basedir); err != nil {
+				t.Fatalf("error loading lock: %v", err)
+			}
+			if !maps.Equal(lock.Artifacts, test.want) {
+				t.Errorf("lock mismatch: got %v, want %v", lock.Artifacts, test.want)
+			}
+
+			for _, op := range ops {
+				want := test.want[name]
+				if test.error {
+					want = test.pinned
+				}
+				if op.Ref.Digest != want {
+					t.Errorf("ref pinned to %q, want %q", op.Ref.Digest, want)
+				}
+			}
+		})
+	}
+}
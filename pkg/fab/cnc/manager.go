@@ -15,23 +15,26 @@
 package cnc
 
 import (
-	"compress/gzip"
 	"context"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mholt/archiver/v4"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
+	"github.com/vbauerster/mpb/v8"
 	"go.githedgehog.com/fabric/api/meta"
 	"go.githedgehog.com/fabric/pkg/wiring"
 	"go.githedgehog.com/fabricator/pkg/fab/cnc/bin"
 	fabwiring "go.githedgehog.com/fabricator/pkg/fab/wiring"
 	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
 	"sigs.k8s.io/yaml"
 )
 
@@ -48,6 +51,16 @@ type ComponentValidate interface {
 	Validate(basedir string, preset Preset, fabricMode meta.FabricMode, get GetComponent, wiring *wiring.Data) error
 }
 
+// WiringHydrateConfigurer is a component that adjusts wiring hydration config (e.g. subnet) based on its own config
+type WiringHydrateConfigurer interface {
+	ConfigureWiringHydrate(hydrateCfg *fabwiring.HydrateConfig)
+}
+
+// BundleProvider is a component that adds bundles depending on the wiring, e.g. per control node installers
+type BundleProvider interface {
+	Bundles(preset Preset, wiring *wiring.Data) ([]Bundle, error)
+}
+
 type Component interface {
 	Name() string
 	IsEnabled(preset Preset) bool
@@ -60,7 +73,8 @@ type Component interface {
 	// e.g. if we want other components to be able to use some values of that component config - it should be here
 	// e.g. generate TLS certificates
 	// if we want to make sure that same value is used on every build - it should be set here
-	Hydrate(preset Preset, fabricMode meta.FabricMode) error
+	// components are hydrated in order, so get could be used to access config of the already hydrated ones
+	Hydrate(preset Preset, fabricMode meta.FabricMode, get GetComponent) error
 
 	ComponentValidate
 
@@ -84,7 +98,11 @@ type (
 
 type BuildOp interface {
 	Hydrate() error
-	Build(basedir string) error
+	// Inputs returns all values the build result depends on, they are hashed to detect if op should be rebuilt
+	Inputs() ([]any, error)
+	// Outputs returns names of the files or dirs created by the op in the bundle dir
+	Outputs() []string
+	Build(basedir string, pb *mpb.Progress) error
 	RunOps() []RunOp
 }
 
@@ -94,6 +112,12 @@ type RunOp interface {
 	Run(basedir string) error
 }
 
+// ReversibleRunOp is a run op that declares an inverse op used by the recipe uninstall, nil means nothing to undo
+type ReversibleRunOp interface {
+	RunOp
+	Inverse() RunOp
+}
+
 type Manager struct {
 	basedir    string
 	preset     Preset
@@ -104,6 +128,17 @@ type Manager struct {
 	components []Component
 	hydrateCfg *fabwiring.HydrateConfig
 	fabricMode meta.FabricMode
+	encryption *Encryption
+	dataKey    []byte
+	sealed     map[string]sealedValue
+
+	configInstall  *ConfigInstall
+	releaseInstall *ReleaseInstall
+	version        string
+
+	customLoader CustomComponentsLoader
+	customDir    string
+	custom       []string
 
 	addedBuildOps map[string]any
 	addedRunOps   map[string]any
@@ -121,6 +156,80 @@ func New(presets []Preset, bundles []Bundle, maxStage Stage, components []Compon
 	return mngr
 }
 
+// CustomComponentsDir is a dir in the basedir with user-defined components, they are loaded on each init and load and
+// aren't saved into config
+const CustomComponentsDir = "components"
+
+// CustomComponentsLoader loads user-defined components from the dir, missing dir means no components
+type CustomComponentsLoader func(dir string) ([]Component, error)
+
+func (mngr *Manager) SetCustomComponentsLoader(loader CustomComponentsLoader) {
+	mngr.customLoader = loader
+}
+
+// loadCustomComponents adds user-defined components from the dir after the built-in ones
+func (mngr *Manager) loadCustomComponents(dir string) error {
+	if mngr.customLoader == nil || dir == "" {
+		return nil
+	}
+
+	comps, err := mngr.customLoader(dir)
+	if err != nil {
+		return errors.Wrapf(err, "error loading custom components from %s", dir)
+	}
+
+	for _, comp := range comps {
+		if slices.ContainsFunc(mngr.components, func(c Component) bool { return c.Name() == comp.Name() }) {
+			return errors.Errorf("custom component %s conflicts with existing one", comp.Name())
+		}
+
+		slog.Info("Loaded custom component", "name", comp.Name())
+
+		mngr.components = append(mngr.components, comp)
+		mngr.custom = append(mngr.custom, comp.Name())
+	}
+
+	mngr.customDir = dir
+
+	return nil
+}
+
+// saveCustomComponents copies custom components definitions into the basedir if they were loaded from elsewhere
+func (mngr *Manager) saveCustomComponents() error {
+	target := filepath.Join(mngr.basedir, CustomComponentsDir)
+	if mngr.customDir == "" || filepath.Clean(mngr.customDir) == target {
+		return nil
+	}
+
+	entries, err := os.ReadDir(mngr.customDir)
+	if err != nil {
+		return errors.Wrapf(err, "error reading custom components dir %s", mngr.customDir)
+	}
+
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return errors.Wrapf(err, "error creating custom components dir %s", target)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(mngr.customDir, entry.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "error reading custom component %s", entry.Name())
+		}
+
+		if err := os.WriteFile(filepath.Join(target, entry.Name()), data, 0o644); err != nil { //nolint:gosec
+			return errors.Wrapf(err, "error writing custom component %s", entry.Name())
+		}
+	}
+
+	mngr.customDir = target
+
+	return nil
+}
+
 func (mngr *Manager) Flags() []cli.Flag {
 	res := []cli.Flag{}
 	for _, comp := range mngr.components {
@@ -149,7 +258,7 @@ func (mngr *Manager) prepare() error {
 			continue
 		}
 
-		err := comp.Hydrate(mngr.preset, mngr.fabricMode)
+		err := comp.Hydrate(mngr.preset, mngr.fabricMode, mngr.getComponent)
 		if err != nil {
 			return errors.Wrapf(err, "error hydrating component %s", comp.Name())
 		}
@@ -166,10 +275,30 @@ func (mngr *Manager) prepare() error {
 		}
 	}
 
+	for _, comp := range mngr.components {
+		provider, ok := comp.(BundleProvider)
+		if !ok || !comp.IsEnabled(mngr.preset) {
+			continue
+		}
+
+		bundles, err := provider.Bundles(mngr.preset, mngr.wiring)
+		if err != nil {
+			return errors.Wrapf(err, "error getting bundles for component %s", comp.Name())
+		}
+
+		for _, bundle := range bundles {
+			if slices.IndexFunc(mngr.bundles, func(b Bundle) bool { return b.Name == bundle.Name }) >= 0 {
+				continue
+			}
+
+			mngr.bundles = append(mngr.bundles, bundle)
+		}
+	}
+
 	return nil
 }
 
-func (mngr *Manager) Init(basedir string, fromConfig string, preset Preset, fabricMode meta.FabricMode, wiringPath []string, wiringGen *fabwiring.Builder, hydrate bool) error {
+func (mngr *Manager) Init(basedir string, fromConfig string, componentsDir string, preset Preset, fabricMode meta.FabricMode, wiringPath []string, wiringGen *fabwiring.Builder, hydrate bool) error {
 	if _, err := os.Stat(basedir); err == nil {
 		if !os.IsNotExist(err) {
 			return errors.Errorf("basedir %s already exists, please, remove it first", basedir)
@@ -182,6 +311,10 @@ func (mngr *Manager) Init(basedir string, fromConfig string, preset Preset, fabr
 
 	mngr.basedir = basedir
 
+	if err := mngr.loadCustomComponents(componentsDir); err != nil {
+		return err
+	}
+
 	// TODO detect both wiring files and gen flags are set
 
 	if len(wiringPath) > 0 {
@@ -238,7 +371,14 @@ func (mngr *Manager) Init(basedir string, fromConfig string, preset Preset, fabr
 		if hydrate {
 			slog.Warn("Wiring is not hydrated, hydrating", "reason", err.Error())
 
-			if err := fabwiring.Hydrate(mngr.wiring, mngr.hydrateCfg); err != nil {
+			hydrateCfg := *mngr.hydrateCfg
+			for _, comp := range mngr.components {
+				if configurer, ok := comp.(WiringHydrateConfigurer); ok && comp.IsEnabled(mngr.preset) {
+					configurer.ConfigureWiringHydrate(&hydrateCfg)
+				}
+			}
+
+			if err := fabwiring.Hydrate(mngr.wiring, &hydrateCfg); err != nil {
 				return errors.Wrapf(err, "error hydrating wiring")
 			}
 		} else {
@@ -260,16 +400,47 @@ func (mngr *Manager) Init(basedir string, fromConfig string, preset Preset, fabr
 type ManagerSaver struct {
 	Preset     Preset          `json:"preset,omitempty"`
 	FabricMode meta.FabricMode `json:"fabricMode,omitempty"`
+	Encryption *Encryption     `json:"encryption,omitempty"`
 	Config     map[string]any  `json:"config,omitempty"`
 }
 
+type secretsMode uint8
+
+const (
+	secretsEncrypt secretsMode = iota
+	secretsRedact
+)
+
+// SetEncryption generates new data key and wraps it for the recipients (x25519 public keys) and passphrase (if set)
+// to be used to encrypt secret config fields on save, config will be saved in plaintext if there are no recipients
+func (mngr *Manager) SetEncryption(recipients []string, passphrase string) error {
+	if len(recipients) == 0 && passphrase == "" {
+		mngr.encryption = nil
+		mngr.dataKey = nil
+		mngr.sealed = nil
+
+		return nil
+	}
+
+	enc, dataKey, err := newEncryption(recipients, passphrase)
+	if err != nil {
+		return errors.Wrapf(err, "error setting up encryption")
+	}
+
+	mngr.encryption = enc
+	mngr.dataKey = dataKey
+	mngr.sealed = nil
+
+	return nil
+}
+
 func (mngr *Manager) Save() error {
 	err := os.MkdirAll(mngr.basedir, 0o755)
 	if err != nil {
 		return errors.Wrapf(err, "error creating basedir %s", mngr.basedir)
 	}
 
-	data, err := mngr.configData()
+	data, err := mngr.configData(secretsEncrypt)
 	if err != nil {
 		return errors.Wrapf(err, "error getting config data")
 	}
@@ -284,30 +455,110 @@ func (mngr *Manager) Save() error {
 		return errors.Wrapf(err, "error saving wiring")
 	}
 
-	return nil
+	return errors.Wrapf(mngr.saveCustomComponents(), "error saving custom components")
 }
 
-func (mngr *Manager) configData() ([]byte, error) {
+func (mngr *Manager) configData(secrets secretsMode) ([]byte, error) {
+	saver, err := mngr.saver(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := yaml.Marshal(saver)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling config")
+	}
+
+	return data, nil
+}
+
+// saver returns config of all enabled components with secrets encrypted (if encryption is set) or redacted
+func (mngr *Manager) saver(secrets secretsMode) (*ManagerSaver, error) {
 	saver := &ManagerSaver{
 		Preset:     mngr.preset,
 		FabricMode: mngr.fabricMode,
 		Config:     map[string]any{},
 	}
 
+	transform := func(string, string) (string, error) { return redactedValue, nil }
+	if secrets == secretsEncrypt {
+		saver.Encryption = mngr.encryption
+		transform = mngr.encryptSecret
+	}
+
 	for _, comp := range mngr.components {
 		if !comp.IsEnabled(mngr.preset) {
 			continue
 		}
 
-		saver.Config[comp.Name()] = comp
+		// custom components are saved as their own files
+		if secrets == secretsEncrypt && slices.Contains(mngr.custom, comp.Name()) {
+			continue
+		}
+
+		provider, ok := comp.(SecretsProvider)
+		if !ok || secrets == secretsEncrypt && mngr.encryption == nil {
+			saver.Config[comp.Name()] = comp
+
+			continue
+		}
+
+		data, err := yaml.Marshal(comp)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error marshaling config for component %s", comp.Name())
+		}
+
+		parsed := map[string]any{}
+		if err := yaml.Unmarshal(data, &parsed); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling config for component %s", comp.Name())
+		}
+
+		for _, field := range provider.SecretFields() {
+			path := comp.Name() + "." + field
+			if err := transformField(parsed, field, func(value string) (string, error) { return transform(path, value) }); err != nil {
+				return nil, errors.Wrapf(err, "error processing secrets for component %s", comp.Name())
+			}
+		}
+
+		saver.Config[comp.Name()] = parsed
 	}
 
-	data, err := yaml.Marshal(saver)
+	return saver, nil
+}
+
+// encryptSecret encrypts the secret value at the path reusing the ciphertext loaded from the config if the value is the
+// same, so saving unchanged config produces the same output
+func (mngr *Manager) encryptSecret(path, value string) (string, error) {
+	if sealed, exist := mngr.sealed[path]; exist && sealed.plaintext == value {
+		return sealed.ciphertext, nil
+	}
+
+	res, err := encryptValue(mngr.dataKey, path, value)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error marshaling config")
+		return "", err
 	}
 
-	return data, nil
+	if mngr.sealed == nil {
+		mngr.sealed = map[string]sealedValue{}
+	}
+	mngr.sealed[path] = sealedValue{plaintext: value, ciphertext: res}
+
+	return res, nil
+}
+
+// decryptSecret decrypts the value at the path and keeps its ciphertext to be reused on save, see encryptSecret
+func (mngr *Manager) decryptSecret(path, value string) (string, error) {
+	res, err := decryptValue(mngr.dataKey, path, value)
+	if err != nil || !strings.HasPrefix(value, encryptedPrefix) {
+		return res, err
+	}
+
+	if mngr.sealed == nil {
+		mngr.sealed = map[string]sealedValue{}
+	}
+	mngr.sealed[path] = sealedValue{plaintext: res, ciphertext: value}
+
+	return res, nil
 }
 
 func (mngr *Manager) loadConfig(fromConfig string) error {
@@ -316,14 +567,40 @@ func (mngr *Manager) loadConfig(fromConfig string) error {
 		return errors.Wrapf(err, "error reading config")
 	}
 
-	saver := &ManagerSaver{}
-	err = yaml.UnmarshalStrict(data, saver)
+	saver, err := parseSaver(data)
 	if err != nil {
-		return errors.Wrapf(err, "error unmarshaling config")
+		return err
 	}
 
+	return mngr.applyConfig(saver)
+}
+
+// applyConfig decrypts the saved config if needed and loads it into the enabled components
+func (mngr *Manager) applyConfig(saver *ManagerSaver) error {
+	var err error
+
 	mngr.preset = saver.Preset
 	mngr.fabricMode = saver.FabricMode
+	mngr.encryption = saver.Encryption
+	mngr.dataKey = nil
+	mngr.sealed = nil
+
+	if saver.Encryption != nil {
+		mngr.dataKey, err = saver.Encryption.unlock()
+		if err != nil {
+			return errors.Wrapf(err, "error decrypting config")
+		}
+
+		for name, parsed := range saver.Config {
+			parsed, err = transformStrings(parsed, name, mngr.decryptSecret)
+			if err != nil {
+				return errors.Wrapf(err, "error decrypting config for component %s", name)
+			}
+			saver.Config[name] = parsed
+		}
+	} else if isEncrypted(saver.Config) {
+		return errors.New("config contains encrypted values but no encryption recipients")
+	}
 
 	for idx, comp := range mngr.components {
 		if !comp.IsEnabled(mngr.preset) {
@@ -348,6 +625,10 @@ func (mngr *Manager) loadConfig(fromConfig string) error {
 func (mngr *Manager) Load(basedir string) error {
 	mngr.basedir = basedir
 
+	if err := mngr.loadCustomComponents(filepath.Join(basedir, CustomComponentsDir)); err != nil {
+		return err
+	}
+
 	err := mngr.loadConfig(filepath.Join(basedir, "config.yaml"))
 	if err != nil {
 		return errors.Wrapf(err, "error loading config")
@@ -371,13 +652,40 @@ func (mngr *Manager) Load(basedir string) error {
 	return nil
 }
 
-func (mngr *Manager) Build(pack bool) error {
+type BuildOpts struct {
+	Pack     bool
+	Parallel int
+	// UpdateLock accepts resolved digests that don't match the artifacts lock
+	UpdateLock bool
+	// OfflineSource is a path to the OCI image layout used instead of the remote registries
+	OfflineSource string
+	PackFormat    PackFormat
+	// Upgrade additionally builds the upgrade bundle with changes since the previous build
+	Upgrade *UpgradeOpts
+}
+
+func (mngr *Manager) Build(opts BuildOpts) error {
 	start := time.Now()
 
-	actions := map[Bundle][][]recipeContext{}
-	for _, bundle := range mngr.bundles {
-		actions[bundle] = make([][]recipeContext, mngr.maxStage)
+	EmitEvent(Event{Kind: EventKindBuild, Status: EventStatusStart})
+	err := mngr.build(opts)
+	EmitDone(Event{Kind: EventKindBuild}, start, err)
+
+	return err
+}
+
+func (mngr *Manager) build(opts BuildOpts) error {
+	start := time.Now()
+
+	if err := mngr.checkCerts(); err != nil {
+		return err
+	}
+
+	if opts.Upgrade != nil && !slices.Contains(mngr.bundles, opts.Upgrade.Target) {
+		mngr.bundles = append(mngr.bundles, opts.Upgrade.Target)
+	}
 
+	for _, bundle := range mngr.bundles {
 		basedir := filepath.Join(mngr.basedir, bundle.Name)
 		err := os.MkdirAll(basedir, 0o755)
 		if err != nil {
@@ -392,32 +700,48 @@ func (mngr *Manager) Build(pack bool) error {
 		}
 	}
 
-	for _, comp := range mngr.components {
-		if !comp.IsEnabled(mngr.preset) {
-			continue
-		}
+	trust := mngr.trustPolicy()
+	if opts.OfflineSource != "" {
+		slog.Info("Using offline source", "path", opts.OfflineSource)
+	}
+	if trust.Enabled() {
+		slog.Info("Artifacts will be verified using trust policy", "rules", len(trust.Rules), "strict", trust.Strict)
+	}
 
-		slog.Info("Building", "component", comp.Name())
+	builds, actions, err := mngr.collectOps(trust, opts.OfflineSource)
+	if err != nil {
+		return err
+	}
 
-		adder := &opAdder{mngr: mngr}
-		err := comp.Build(mngr.basedir, mngr.preset, mngr.fabricMode, mngr.getComponent, mngr.wiring, adder.addBuildOp, adder.addRunOp)
+	// previous build is loaded before the lock is updated, so the same basedir could be used
+	var upgrade *upgradeFrom
+	if opts.Upgrade != nil {
+		upgrade, err = opts.Upgrade.previous()
 		if err != nil {
-			return errors.Wrapf(err, "error building component %s", comp.Name())
-		}
-		if adder.err != nil {
-			return errors.Wrapf(adder.err, "error building component %s (adder)", comp.Name())
+			return errors.Wrapf(err, "error loading previous build")
 		}
+	}
 
-		for _, runOp := range adder.actions {
-			err = runOp.op.Hydrate()
-			if err != nil {
-				return errors.Wrapf(err, "error hydrating run op %s", runOp.name)
-			}
+	err = mngr.pinArtifacts(builds, opts.Parallel, opts.UpdateLock, opts.OfflineSource)
+	if err != nil {
+		return errors.Wrapf(err, "error pinning artifacts")
+	}
 
-			actions[runOp.bundle][int(runOp.stage)] = append(actions[runOp.bundle][int(runOp.stage)], runOp)
+	if opts.Upgrade != nil {
+		builds, err = mngr.addUpgradeOps(opts.Upgrade, upgrade, builds, actions)
+		if err != nil {
+			return errors.Wrapf(err, "error planning upgrade")
 		}
+	}
+
+	err = mngr.runBuildOps(builds, opts.Parallel)
+	if err != nil {
+		return errors.Wrapf(err, "error building bundles")
+	}
 
-		slog.Debug("Finished", "component", comp.Name())
+	err = mngr.writeRelease(mngr.newRelease(builds), actions)
+	if err != nil {
+		return errors.Wrapf(err, "error writing release")
 	}
 
 	for _, bundle := range mngr.bundles {
@@ -454,25 +778,291 @@ func (mngr *Manager) Build(pack bool) error {
 
 	slog.Info("Building done", "took", time.Since(start))
 
-	if pack {
-		return errors.Wrapf(mngr.Pack(), "error packing bundles")
+	if opts.Pack {
+		return errors.Wrapf(mngr.Pack(opts.PackFormat), "error packing bundles")
 	}
 
 	return nil
 }
 
-func (mngr *Manager) Pack() error {
+// collectOps runs build for all enabled components and collects build ops and run ops (grouped by bundle and stage)
+func (mngr *Manager) collectOps(trust *TrustPolicy, offlineSource string) ([]buildContext, map[Bundle][][]recipeContext, error) {
+	builds := []buildContext{}
+
+	actions := map[Bundle][][]recipeContext{}
+	for _, bundle := range mngr.bundles {
+		actions[bundle] = make([][]recipeContext, mngr.maxStage)
+	}
+
+	collect := func(name string, build func(run AddBuildOp, install AddRunOp) error) error {
+		slog.Info("Building", "component", name)
+
+		adder := &opAdder{mngr: mngr, component: name, trust: trust, offlineSource: offlineSource}
+		err := build(adder.addBuildOp, adder.addRunOp)
+		if err != nil {
+			return errors.Wrapf(err, "error building component %s", name)
+		}
+		if adder.err != nil {
+			return errors.Wrapf(adder.err, "error building component %s (adder)", name)
+		}
+
+		builds = append(builds, adder.builds...)
+
+		for _, runOp := range adder.actions {
+			err = runOp.op.Hydrate()
+			if err != nil {
+				return errors.Wrapf(err, "error hydrating run op %s", runOp.name)
+			}
+
+			actions[runOp.bundle][int(runOp.stage)] = append(actions[runOp.bundle][int(runOp.stage)], runOp)
+		}
+
+		slog.Debug("Finished", "component", name)
+
+		return nil
+	}
+
+	for _, comp := range mngr.components {
+		if !comp.IsEnabled(mngr.preset) {
+			continue
+		}
+
+		err := collect(comp.Name(), func(run AddBuildOp, install AddRunOp) error {
+			return comp.Build(mngr.basedir, mngr.preset, mngr.fabricMode, mngr.getComponent, mngr.wiring, run, install)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if mngr.configInstall != nil {
+		if err := collect(configInstallComponent, mngr.buildConfigInstall); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return builds, actions, nil
+}
+
+// runBuildOps runs collected build ops using up to parallel workers, all of them are sharing the same progress container
+// ops with the same inputs as recorded in the cache and existing outputs are skipped, unreferenced files are pruned
+func (mngr *Manager) runBuildOps(builds []buildContext, parallel int) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	slog.Info("Running build ops", "ops", len(builds), "parallel", parallel)
+
+	cache := &Cache{}
+	if err := cache.Load(mngr.basedir); err != nil {
+		return errors.Wrapf(err, "error loading cache")
+	}
+
+	// only hashes for the current ops are kept, so the cache doesn't grow forever
+	next := &Cache{Hashes: map[string]uint64{}}
+	cacheMu := sync.Mutex{}
+
+	pb := mpb.New(mpb.WithWidth(64), mpb.WithOutput(ProgressOutput()))
+
+	eg, ctx := errgroup.WithContext(context.Background())
+	eg.SetLimit(parallel)
+
+	for _, build := range builds {
+		build := build
+
+		eg.Go(func() error {
+			if ctx.Err() != nil { // some other op already failed
+				return nil
+			}
+
+			inputs, err := build.op.Inputs()
+			if err != nil {
+				return errors.Wrapf(err, "error getting inputs for build op %s", build.name)
+			}
+
+			bundleDir := filepath.Join(mngr.basedir, build.bundle.Name)
+			outputs := build.op.Outputs()
+			key := buildCacheKey(build.bundle, outputs)
+
+			// same outputs could be produced by multiple ops (e.g. same image for different targets), so we should only build it once
+			defer lockBuild(filepath.Join(bundleDir, key))()
+
+			cacheMu.Lock()
+			actual, err := cache.IsActual(key, inputs...)
+			cacheMu.Unlock()
+			if err != nil {
+				return errors.Wrapf(err, "error checking cache for build op %s", build.name)
+			}
+
+			event := Event{
+				Kind:   EventKindBuildOp,
+				Bundle: build.bundle.Name,
+				Stage:  stagePtr(build.stage),
+				Name:   build.name,
+				Op:     getShortTypeName(build.op),
+			}
+
+			if actual && outputsExist(bundleDir, outputs) {
+				slog.Debug("Build op is up to date", "bundle", build.bundle.Name, "name", build.name)
+
+				event.Status = EventStatusSkip
+				EmitEvent(event)
+			} else {
+				if !actual {
+					// inputs changed or unknown, so we can't trust existing outputs
+					for _, output := range outputs {
+						if err := os.RemoveAll(filepath.Join(bundleDir, output)); err != nil {
+							return errors.Wrapf(err, "error removing stale output %s", output)
+						}
+					}
+				}
+
+				opStart := time.Now()
+				slog.Debug("Running build op", "bundle", build.bundle.Name, "stage", build.stage, "name", build.name)
+
+				event.Status = EventStatusStart
+				EmitEvent(event)
+
+				err = build.op.Build(bundleDir, pb)
+				EmitDone(event, opStart, err)
+				if err != nil {
+					return errors.Wrapf(err, "error building op %s", build.name)
+				}
+
+				slog.Debug("Build op done", "bundle", build.bundle.Name, "name", build.name, "took", time.Since(opStart))
+			}
+
+			cacheMu.Lock()
+			defer cacheMu.Unlock()
+
+			err = cache.Add(key, inputs...)
+			if err != nil {
+				return errors.Wrapf(err, "error adding build op %s to cache", build.name)
+			}
+
+			return errors.Wrapf(next.Add(key, inputs...), "error adding build op %s to cache", build.name)
+		})
+	}
+
+	err := eg.Wait()
+	if err != nil {
+		pb.Shutdown()
+
+		// keep hashes of everything that was built successfully, so it isn't rebuilt next time
+		if err := cache.Save(mngr.basedir); err != nil {
+			slog.Warn("Error saving cache", "err", err)
+		}
+
+		return errors.Wrapf(err, "error running build ops")
+	}
+
+	pb.Wait()
+
+	err = next.Save(mngr.basedir)
+	if err != nil {
+		return errors.Wrapf(err, "error saving cache")
+	}
+
+	return errors.Wrapf(mngr.pruneBundles(builds), "error pruning bundles")
+}
+
+func buildCacheKey(bundle Bundle, outputs []string) string {
+	return bundle.Name + "/" + strings.Join(outputs, ",")
+}
+
+func outputsExist(basedir string, outputs []string) bool {
+	for _, output := range outputs {
+		if _, err := os.Stat(filepath.Join(basedir, output)); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bundleKeepFiles are files in the bundle dirs that aren't produced by build ops but shouldn't be pruned
+var bundleKeepFiles = []string{bin.RecipeBinName, RecipeFile, JournalFile, ReleaseFile}
+
+// pruneBundles removes all files and dirs from the bundle dirs that aren't referenced by any build op
+func (mngr *Manager) pruneBundles(builds []buildContext) error {
+	referenced := map[string]map[string]bool{}
+	for _, bundle := range mngr.bundles {
+		referenced[bundle.Name] = map[string]bool{}
+		for _, name := range bundleKeepFiles {
+			referenced[bundle.Name][name] = true
+		}
+	}
+	for _, build := range builds {
+		for _, output := range build.op.Outputs() {
+			// only top level entry matters as we're pruning on that level
+			referenced[build.bundle.Name][strings.SplitN(filepath.ToSlash(output), "/", 2)[0]] = true
+		}
+	}
+
+	for _, bundle := range mngr.bundles {
+		bundleDir := filepath.Join(mngr.basedir, bundle.Name)
+
+		entries, err := os.ReadDir(bundleDir)
+		if err != nil {
+			return errors.Wrapf(err, "error reading bundle dir %s", bundleDir)
+		}
+
+		for _, entry := range entries {
+			if referenced[bundle.Name][entry.Name()] {
+				continue
+			}
+
+			slog.Info("Pruning unreferenced", "bundle", bundle.Name, "name", entry.Name())
+
+			err = os.RemoveAll(filepath.Join(bundleDir, entry.Name()))
+			if err != nil {
+				return errors.Wrapf(err, "error pruning %s", entry.Name())
+			}
+		}
+	}
+
+	return nil
+}
+
+func (mngr *Manager) Pack(packFormat PackFormat) error {
 	start := time.Now()
 
+	if packFormat == "" {
+		packFormat = PackFormatTarGz
+	}
+
+	format, err := packFormat.archiver()
+	if err != nil {
+		return err
+	}
+
+	targets := []string{}
+
 	for _, bundle := range mngr.bundles {
 		if !bundle.IsInstaller {
 			continue
 		}
 
-		target := bundle.Name + ".tgz"
+		target := bundle.Name + packFormat.Extension()
+
+		// archives in other formats are removed so it's always clear which one is current
+		for _, other := range PackFormats {
+			if other == packFormat {
+				continue
+			}
+
+			err := os.Remove(filepath.Join(mngr.basedir, bundle.Name+other.Extension()))
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "error removing stale archive for bundle %s", bundle.Name)
+			}
+		}
 
 		slog.Info("Packing", "bundle", bundle.Name, "target", target)
 
+		packStart := time.Now()
+		event := Event{Kind: EventKindPack, Status: EventStatusStart, Bundle: bundle.Name, Name: target}
+		EmitEvent(event)
+
 		files, err := archiver.FilesFromDisk(nil, map[string]string{
 			filepath.Join(mngr.basedir, bundle.Name): bundle.Name,
 		})
@@ -480,31 +1070,40 @@ func (mngr *Manager) Pack() error {
 			return errors.Wrapf(err, "error getting files for bundle %s", bundle.Name)
 		}
 
-		out, err := os.Create(filepath.Join(mngr.basedir, target))
+		err = packArchive(filepath.Join(mngr.basedir, target), format, files)
+		EmitDone(event, packStart, err)
 		if err != nil {
-			return errors.Wrapf(err, "error creating target %s", target)
+			return errors.Wrapf(err, "error archiving bundle %s", bundle.Name)
 		}
-		defer out.Close()
 
-		format := archiver.CompressedArchive{
-			Compression: archiver.Gz{
-				Multithreaded:    true,
-				CompressionLevel: gzip.BestSpeed,
-			},
-			Archival: archiver.Tar{},
-		}
+		targets = append(targets, target)
+	}
 
-		err = format.Archive(context.Background(), out, files)
-		if err != nil {
-			return errors.Wrapf(err, "error archiving bundle %s", bundle.Name)
-		}
+	err = WriteChecksums(mngr.basedir, targets)
+	if err != nil {
+		return errors.Wrapf(err, "error writing checksums")
 	}
 
-	slog.Info("Packing done", "took", time.Since(start))
+	slog.Info("Packing done", "took", time.Since(start), "checksums", filepath.Join(mngr.basedir, ChecksumsFile))
 
 	return nil
 }
 
+func packArchive(target string, format archiver.CompressedArchive, files []archiver.File) error {
+	out, err := os.Create(target)
+	if err != nil {
+		return errors.Wrapf(err, "error creating target %s", target)
+	}
+	defer out.Close()
+
+	err = format.Archive(context.Background(), out, files)
+	if err != nil {
+		return errors.Wrapf(err, "error archiving")
+	}
+
+	return errors.Wrapf(out.Close(), "error closing target %s", target)
+}
+
 func (mngr *Manager) getComponent(name string) Component {
 	for _, comp := range mngr.components {
 		if !comp.IsEnabled(mngr.preset) {
@@ -519,10 +1118,37 @@ func (mngr *Manager) getComponent(name string) Component {
 	return nil
 }
 
+// trustPolicy returns trust policy from the first enabled component providing it or nil
+func (mngr *Manager) trustPolicy() *TrustPolicy {
+	for _, comp := range mngr.components {
+		if !comp.IsEnabled(mngr.preset) {
+			continue
+		}
+
+		if provider, ok := comp.(TrustProvider); ok {
+			return provider.TrustPolicy()
+		}
+	}
+
+	return nil
+}
+
 type opAdder struct {
-	mngr    *Manager
-	err     error
-	actions []recipeContext
+	mngr          *Manager
+	component     string
+	trust         *TrustPolicy
+	offlineSource string
+	err           error
+	builds        []buildContext
+	actions       []recipeContext
+}
+
+type buildContext struct {
+	component string
+	bundle    Bundle
+	stage     Stage
+	name      string
+	op        BuildOp
 }
 
 type recipeContext struct {
@@ -592,13 +1218,22 @@ func (adder *opAdder) addBuildOp(bundle Bundle, stage Stage, name string, op Bui
 		return
 	}
 
-	err = op.Build(filepath.Join(adder.mngr.basedir, bundle.Name))
-	if err != nil {
-		adder.err = errors.Wrapf(err, "error building op %s", name)
-
-		return
+	if trusted, ok := op.(TrustedBuildOp); ok {
+		trusted.SetTrust(adder.trust)
+	}
+	if artifact, ok := op.(ArtifactBuildOp); ok {
+		artifact.SetOfflineSource(adder.offlineSource)
 	}
 
+	// build ops are collected and run later in parallel, while run ops are added in the original order
+	adder.builds = append(adder.builds, buildContext{
+		component: adder.component,
+		bundle:    bundle,
+		stage:     stage,
+		name:      name,
+		op:        op,
+	})
+
 	runOps := op.RunOps()
 	if len(runOps) > 0 && !bundle.IsInstaller {
 		adder.err = errors.Errorf("build op %s has run ops but bundle %s is not installer", name, bundle.Name)
@@ -645,7 +1280,7 @@ func (mngr *Manager) Dump() error {
 
 	mngr.wiring = nil
 
-	data, err := mngr.configData()
+	data, err := mngr.configData(secretsRedact)
 	if err != nil {
 		return errors.Wrapf(err, "error getting config data")
 	}
@@ -0,0 +1,105 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+// MirrorExport fetches all artifacts needed for the current config into the OCI image layout in dir, so it could be
+// used as an offline source for build, artifacts are tagged with the full ref name (repo/name:tag) and cosign
+// attachments are exported next to them (repo/name:<digest>.sig), so signatures could be verified on offline build
+func (mngr *Manager) MirrorExport(dir string, parallel int, updateLock bool) error {
+	start := time.Now()
+
+	trust := mngr.trustPolicy()
+
+	builds, _, err := mngr.collectOps(trust, "")
+	if err != nil {
+		return err
+	}
+
+	err = mngr.pinArtifacts(builds, parallel, updateLock, "")
+	if err != nil {
+		return errors.Wrapf(err, "error pinning artifacts")
+	}
+
+	refs := map[string]Ref{}
+	for _, build := range builds {
+		if op, ok := build.op.(ArtifactBuildOp); ok {
+			refs[op.Artifact().String()] = *op.Artifact()
+		}
+	}
+
+	store, err := oci.New(dir)
+	if err != nil {
+		return errors.Wrapf(err, "error creating oci layout %s", dir)
+	}
+
+	slog.Info("Exporting artifacts", "artifacts", len(refs), "to", dir)
+
+	eg := errgroup.Group{}
+	eg.SetLimit(max(parallel, 1))
+
+	for name, ref := range refs {
+		name, ref := name, ref
+
+		eg.Go(func() error {
+			err := verifyArtifact(ref, trust, "")
+			if err != nil {
+				return errors.Wrapf(err, "error verifying %s", name)
+			}
+
+			repo, err := newORASRepo(ref)
+			if err != nil {
+				return err
+			}
+
+			if desc, err := store.Resolve(context.Background(), name); err == nil && desc.Digest.String() == ref.Digest {
+				slog.Debug("Exporting SKIPPED (already exists)", "ref", name)
+			} else {
+				slog.Info("Exporting", "ref", name, "digest", ref.Digest)
+
+				_, err = oras.Copy(context.Background(), repo, ref.Digest, store, name, oras.DefaultCopyOptions)
+				if err != nil {
+					return errors.Wrapf(err, "error exporting %s", name)
+				}
+			}
+
+			// signatures are exported next to the artifact, so they could be verified on offline build
+			err = copyAttachmentTags(ref, repo, "", store, ref.RepoName()+":")
+			if err != nil {
+				return errors.Wrapf(err, "error exporting attachments for %s", name)
+			}
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return errors.Wrapf(err, "error exporting artifacts")
+	}
+
+	slog.Info("Export done", "took", time.Since(start))
+
+	return nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// offlineRegistry serves the OCI image layout as a read-only registry, manifests are looked up by digest or by the
+// full ref name (repo/name:tag) the same way they are tagged on mirror export, so the layout could be used as a
+// mirror for the upstream registry and artifacts are fetched and verified the same way as online
+type offlineRegistry struct {
+	path string
+	tags map[string]ocispec.Descriptor
+}
+
+func newOfflineRegistry(path string) (*offlineRegistry, error) {
+	data, err := os.ReadFile(filepath.Join(path, "index.json"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading offline source index")
+	}
+
+	index := ocispec.Index{}
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error unmarshalling offline source index")
+	}
+
+	reg := &offlineRegistry{
+		path: path,
+		tags: map[string]ocispec.Descriptor{},
+	}
+	for _, desc := range index.Manifests {
+		if tag := desc.Annotations[ocispec.AnnotationRefName]; tag != "" {
+			reg.tags[tag] = desc
+		}
+	}
+
+	return reg, nil
+}
+
+func (reg *offlineRegistry) blobPath(dgst digest.Digest) string {
+	return filepath.Join(reg.path, ocispec.ImageBlobsDir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func (reg *offlineRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == "" {
+		w.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	if name, ref, ok := strings.Cut(path, "/manifests/"); ok {
+		reg.serveManifest(w, r, name, ref)
+
+		return
+	}
+
+	if _, ref, ok := strings.Cut(path, "/blobs/"); ok {
+		dgst, err := digest.Parse(ref)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		reg.serveBlob(w, r, dgst, "application/octet-stream")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (reg *offlineRegistry) serveManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	if dgst, err := digest.Parse(ref); err == nil {
+		data, err := os.ReadFile(reg.blobPath(dgst))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		manifest := struct {
+			MediaType string `json:"mediaType,omitempty"`
+		}{}
+		if err := json.Unmarshal(data, &manifest); err != nil || manifest.MediaType == "" {
+			manifest.MediaType = ocispec.MediaTypeImageManifest
+		}
+
+		reg.serveBlob(w, r, dgst, manifest.MediaType)
+
+		return
+	}
+
+	desc, exist := reg.tags[name+":"+ref]
+	if !exist {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	reg.serveBlob(w, r, desc.Digest, desc.MediaType)
+}
+
+func (reg *offlineRegistry) serveBlob(w http.ResponseWriter, r *http.Request, dgst digest.Digest, mediaType string) {
+	f, err := os.Open(reg.blobPath(dgst))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// newOfflineSystemContext starts the read-only registry for the offline source on localhost and configures it as a
+// mirror for the registry, so pinned artifacts and their sigstore attachments are fetched from the offline source,
+// returned cleanup func should be called after it's not needed anymore
+func newOfflineSystemContext(sys *types.SystemContext, registry, offlineSource string) (*types.SystemContext, func(), error) {
+	reg, err := newOfflineRegistry(offlineSource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error listening for offline source registry")
+	}
+
+	srv := &http.Server{
+		Handler:           reg,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("Error serving offline source registry", "err", err)
+		}
+	}()
+
+	dir, err := os.MkdirTemp("", "hhfab-registries-conf-*")
+	if err != nil {
+		_ = srv.Close()
+
+		return nil, nil, errors.Wrapf(err, "error creating registries.conf dir")
+	}
+	cleanup := func() {
+		if err := srv.Close(); err != nil {
+			slog.Warn("Error stopping offline source registry", "err", err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("Error removing registries.conf dir", "err", err)
+		}
+	}
+
+	conf := fmt.Sprintf("[[registry]]\nlocation = %q\n\n[[registry.mirror]]\nlocation = %q\ninsecure = true\n",
+		registry, listener.Addr().String()+"/"+registry)
+	confPath := filepath.Join(dir, "registries.conf")
+	err = os.WriteFile(confPath, []byte(conf), 0o644)
+	if err != nil {
+		cleanup()
+
+		return nil, nil, errors.Wrapf(err, "error writing registries.conf")
+	}
+
+	res := types.SystemContext{}
+	if sys != nil {
+		res = *sys
+	}
+	res.SystemRegistriesConfPath = confPath
+	res.SystemRegistriesConfDirPath = filepath.Join(dir, "registries.conf.d")
+
+	return &res, cleanup, nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+func Test_OfflineRegistry(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := oci.New(dir)
+	if err != nil {
+		t.Fatalf("error creating layout: %s", err)
+	}
+
+	push := func(mediaType string, data []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+		if err := store.Push(ctx, desc, bytes.NewReader(data)); err != nil {
+			t.Fatalf("error pushing %s: %s", desc.Digest, err)
+		}
+
+		return desc
+	}
+
+	layer := push(ocispec.MediaTypeImageLayer, []byte("test"))
+	manifest, err := json.Marshal(ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    push(ocispec.MediaTypeImageConfig, []byte("{}")),
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatalf("error marshalling manifest: %s", err)
+	}
+	image := push(ocispec.MediaTypeImageManifest, manifest)
+	sigTag := strings.ReplaceAll(image.Digest.String(), ":", "-") + ".sig"
+
+	for _, tag := range []string{"ghcr.io/githedgehog/test:v1", "ghcr.io/githedgehog/test:" + sigTag} {
+		if err := store.Tag(ctx, image, tag); err != nil {
+			t.Fatalf("error tagging %s: %s", tag, err)
+		}
+	}
+
+	reg, err := newOfflineRegistry(dir)
+	if err != nil {
+		t.Fatalf("error creating offline registry: %s", err)
+	}
+
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	repo, err := remote.NewRepository(strings.TrimPrefix(srv.URL, "http://") + "/ghcr.io/githedgehog/test")
+	if err != nil {
+		t.Fatalf("error creating repo: %s", err)
+	}
+	repo.PlainHTTP = true
+
+	for _, test := range []struct {
+		name     string
+		ref      string
+		notFound bool
+	}{
+		{name: "tag", ref: "v1"},
+		{name: "digest", ref: image.Digest.String()},
+		{name: "attachment", ref: sigTag},
+		{name: "missing-tag", ref: "v2", notFound: true},
+		{name: "missing-attachment", ref: strings.TrimSuffix(sigTag, ".sig") + ".att", notFound: true},
+		{name: "missing-digest", ref: digest.FromString("missing").String(), notFound: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			desc, err := repo.Resolve(ctx, test.ref)
+			if test.notFound {
+				if !errors.Is(err, errdef.ErrNotFound) {
+					t.Fatalf("expected not found, got %v", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("error resolving: %s", err)
+			}
+			if desc.Digest != image.Digest || desc.MediaType != ocispec.MediaTypeImageManifest {
+				t.Fatalf("unexpected descriptor %v", desc)
+			}
+		})
+	}
+
+	if _, err := repo.Blobs().Resolve(ctx, layer.Digest.String()); err != nil {
+		t.Fatalf("error resolving blob: %s", err)
+	}
+}
+
+func Test_PinArtifactsOffline(t *testing.T) {
+	locked := "sha256:" + strings.Repeat("1", 64)
+
+	for _, test := range []struct {
+		name       string
+		digest     string
+		lock       map[string]string
+		updateLock bool
+		err        bool
+	}{
+		{name: "locked", digest: locked, lock: map[string]string{"ghcr.io/githedgehog/test:v1": locked}},
+		{name: "not-locked", digest: locked, lock: map[string]string{}, err: true},
+		{name: "mismatch", digest: "sha256:" + strings.Repeat("2", 64), lock: map[string]string{"ghcr.io/githedgehog/test:v1": locked}, err: true},
+		{name: "update-lock", digest: locked, lock: map[string]string{"ghcr.io/githedgehog/test:v1": locked}, updateLock: true, err: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mngr := &Manager{basedir: t.TempDir()}
+			if err := (&ArtifactsLock{Artifacts: test.lock}).Save(mngr.basedir); err != nil {
+				t.Fatalf("error saving lock: %s", err)
+			}
+
+			op := &FilesORAS{Ref: Ref{Repo: "ghcr.io/githedgehog", Name: "test", Tag: "v1", Digest: test.digest}}
+			err := mngr.pinArtifacts([]buildContext{{name: "test", op: op}}, 1, test.updateLock, t.TempDir())
+			if test.err && err == nil {
+				t.Fatalf("expected error")
+			}
+			if !test.err && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
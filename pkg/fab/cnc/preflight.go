@@ -0,0 +1,491 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+// PreflightRunOp is a run op that only checks the host, all preflight checks are run even if some of them fail so
+// the full report is available before the recipe is stopped
+type PreflightRunOp interface {
+	RunOp
+	Preflight()
+}
+
+var (
+	_ PreflightRunOp = (*PreflightDisk)(nil)
+	_ PreflightRunOp = (*PreflightResources)(nil)
+	_ PreflightRunOp = (*PreflightRoutes)(nil)
+	_ PreflightRunOp = (*PreflightInterfaces)(nil)
+	_ PreflightRunOp = (*PreflightTimeSync)(nil)
+	_ PreflightRunOp = (*PreflightPorts)(nil)
+)
+
+const (
+	procMeminfo  = "/proc/meminfo"
+	procNetRoute = "/proc/net/route"
+)
+
+//
+// RunOp PreflightDisk
+//
+
+// PreflightDisk checks that there is enough free space for the path (closest existing parent is used if it's missing)
+type PreflightDisk struct {
+	Path       string `json:"path,omitempty"`
+	MinFreeGiB uint64 `json:"minFreeGiB,omitempty"`
+}
+
+func (op *PreflightDisk) Preflight() {}
+
+func (op *PreflightDisk) Hydrate() error {
+	if op.Path == "" {
+		return errors.New("path is empty")
+	}
+	if op.MinFreeGiB == 0 {
+		return errors.New("min free space is empty")
+	}
+
+	return nil
+}
+
+func (op *PreflightDisk) Summary() string {
+	return fmt.Sprintf("check disk %s >= %dGiB free", op.Path, op.MinFreeGiB)
+}
+
+func (op *PreflightDisk) Run(_ string) error {
+	path := op.Path
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return errors.Wrapf(err, "error getting filesystem stats for %s", path)
+	}
+
+	free := uint64(stat.Bavail) * uint64(stat.Bsize) / (1 << 30) //nolint:unconvert
+	slog.Info("Disk", "path", op.Path, "checked", path, "freeGiB", free)
+
+	if free < op.MinFreeGiB {
+		return errors.Errorf("not enough free space for %s: %dGiB available, %dGiB required", op.Path, free, op.MinFreeGiB)
+	}
+
+	return nil
+}
+
+//
+// RunOp PreflightResources
+//
+
+// PreflightResources checks that host has enough memory (total reported by the kernel) and CPUs
+type PreflightResources struct {
+	MinMemoryMiB uint64 `json:"minMemoryMiB,omitempty"`
+	MinCPUs      int    `json:"minCPUs,omitempty"`
+}
+
+func (op *PreflightResources) Preflight() {}
+
+func (op *PreflightResources) Hydrate() error {
+	if op.MinMemoryMiB == 0 && op.MinCPUs == 0 {
+		return errors.New("min memory or cpus should be set")
+	}
+
+	return nil
+}
+
+func (op *PreflightResources) Summary() string {
+	return fmt.Sprintf("check memory >= %dMiB and cpus >= %d", op.MinMemoryMiB, op.MinCPUs)
+}
+
+func (op *PreflightResources) Run(_ string) error {
+	issues := []string{}
+
+	memory, err := totalMemoryMiB()
+	if err != nil {
+		return err
+	}
+
+	cpus := runtime.NumCPU()
+
+	slog.Info("Resources", "memoryMiB", memory, "cpus", cpus)
+
+	if memory < op.MinMemoryMiB {
+		issues = append(issues, fmt.Sprintf("not enough memory: %dMiB available, %dMiB required", memory, op.MinMemoryMiB))
+	}
+	if cpus < op.MinCPUs {
+		issues = append(issues, fmt.Sprintf("not enough cpus: %d available, %d required", cpus, op.MinCPUs))
+	}
+
+	return preflightIssues(issues)
+}
+
+func totalMemoryMiB() (uint64, error) {
+	f, err := os.Open(procMeminfo)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error opening %s", procMeminfo)
+	}
+	defer f.Close()
+
+	return parseMemTotal(f)
+}
+
+// parseMemTotal parses total memory in MiB from the /proc/meminfo content
+func parseMemTotal(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "error parsing total memory")
+		}
+
+		return kb / 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, errors.Wrapf(err, "error reading %s", procMeminfo)
+	}
+
+	return 0, errors.Errorf("no total memory in %s", procMeminfo)
+}
+
+//
+// RunOp PreflightRoutes
+//
+
+// PreflightRoutes checks that there are no existing (non-default) routes colliding with the subnets and IPs that will
+// be used by the installation, loopback routes are ignored as control VIP is expected to be on the loopback, routes on
+// the ignored interfaces (e.g. management ones configured by the installer itself) are ignored as well
+type PreflightRoutes struct {
+	Subnets          []string `json:"subnets,omitempty"`
+	IPs              []string `json:"ips,omitempty"`
+	IgnoreInterfaces []string `json:"ignoreInterfaces,omitempty"`
+}
+
+func (op *PreflightRoutes) Preflight() {}
+
+func (op *PreflightRoutes) Hydrate() error {
+	for _, subnet := range op.Subnets {
+		if _, _, err := net.ParseCIDR(subnet); err != nil {
+			return errors.Wrapf(err, "invalid subnet %s", subnet)
+		}
+	}
+	for _, ip := range op.IPs {
+		if net.ParseIP(ip) == nil {
+			return errors.Errorf("invalid ip %s", ip)
+		}
+	}
+
+	return nil
+}
+
+func (op *PreflightRoutes) Summary() string {
+	return fmt.Sprintf("check routes for %s", strings.Join(append(append([]string{}, op.Subnets...), op.IPs...), ", "))
+}
+
+func (op *PreflightRoutes) Run(_ string) error {
+	routes, err := hostRoutes()
+	if err != nil {
+		return err
+	}
+
+	return preflightIssues(op.collisions(routes))
+}
+
+// collisions returns issues for the routes colliding with the subnets and IPs
+func (op *PreflightRoutes) collisions(routes []hostRoute) []string {
+	issues := []string{}
+	for _, route := range routes {
+		ones, _ := route.dst.Mask.Size()
+		if ones == 0 || route.iface == "lo" || slices.Contains(op.IgnoreInterfaces, route.iface) {
+			continue
+		}
+
+		for _, subnet := range op.Subnets {
+			_, ipNet, _ := net.ParseCIDR(subnet)
+			if ipNet.Contains(route.dst.IP) || route.dst.Contains(ipNet.IP) {
+				issues = append(issues, fmt.Sprintf("route %s dev %s collides with %s", route.dst, route.iface, subnet))
+			}
+		}
+
+		for _, ip := range op.IPs {
+			if route.dst.Contains(net.ParseIP(ip)) {
+				issues = append(issues, fmt.Sprintf("route %s dev %s collides with %s", route.dst, route.iface, ip))
+			}
+		}
+	}
+
+	return issues
+}
+
+type hostRoute struct {
+	iface string
+	dst   *net.IPNet
+}
+
+// hostRoutes parses IPv4 routes from the main routing table
+func hostRoutes() ([]hostRoute, error) {
+	f, err := os.Open(procNetRoute)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %s", procNetRoute)
+	}
+	defer f.Close()
+
+	return parseRoutes(f)
+}
+
+// parseRoutes parses IPv4 routes from the /proc/net/route content
+func parseRoutes(r io.Reader) ([]hostRoute, error) {
+	parseIP := func(s string) (net.IP, error) {
+		raw, err := hex.DecodeString(s)
+		if err != nil || len(raw) != 4 {
+			return nil, errors.Errorf("invalid address %s", s)
+		}
+
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+
+		return ip, nil
+	}
+
+	routes := []hostRoute{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+
+		dst, err := parseIP(fields[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing route destination")
+		}
+		mask, err := parseIP(fields[7])
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing route mask")
+		}
+
+		routes = append(routes, hostRoute{
+			iface: fields[0],
+			dst:   &net.IPNet{IP: dst, Mask: net.IPMask(mask)},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", procNetRoute)
+	}
+
+	return routes, nil
+}
+
+//
+// RunOp PreflightInterfaces
+//
+
+type PreflightInterface struct {
+	Name string `json:"name,omitempty"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+// PreflightInterfaces checks that interfaces exist and have expected MACs (if specified)
+type PreflightInterfaces struct {
+	Interfaces []PreflightInterface `json:"interfaces,omitempty"`
+}
+
+func (op *PreflightInterfaces) Preflight() {}
+
+func (op *PreflightInterfaces) Hydrate() error {
+	for _, iface := range op.Interfaces {
+		if iface.Name == "" {
+			return errors.New("interface name is empty")
+		}
+		if iface.MAC != "" {
+			if _, err := net.ParseMAC(iface.MAC); err != nil {
+				return errors.Wrapf(err, "invalid mac %s for interface %s", iface.MAC, iface.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (op *PreflightInterfaces) Summary() string {
+	names := []string{}
+	for _, iface := range op.Interfaces {
+		names = append(names, iface.Name)
+	}
+
+	return fmt.Sprintf("check interfaces %s", strings.Join(names, ", "))
+}
+
+func (op *PreflightInterfaces) Run(_ string) error {
+	issues := []string{}
+
+	for _, expected := range op.Interfaces {
+		iface, err := net.InterfaceByName(expected.Name)
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("interface %s not found", expected.Name))
+
+			continue
+		}
+
+		actual := iface.HardwareAddr.String()
+		slog.Info("Interface", "name", iface.Name, "mac", actual)
+
+		if expected.MAC == "" {
+			continue
+		}
+
+		mac, _ := net.ParseMAC(expected.MAC)
+		if actual != mac.String() {
+			// the permanent address could differ from the current one (e.g. for bonds)
+			if permanent := permanentMAC(expected.Name); permanent == mac.String() {
+				continue
+			}
+
+			issues = append(issues, fmt.Sprintf("interface %s has mac %s, expected %s", expected.Name, actual, mac))
+		}
+	}
+
+	return preflightIssues(issues)
+}
+
+func permanentMAC(name string) string {
+	data, err := exec.Command("ethtool", "-P", name).Output()
+	if err != nil {
+		return ""
+	}
+
+	_, mac, _ := strings.Cut(strings.TrimSpace(string(data)), ": ")
+	if parsed, err := net.ParseMAC(mac); err == nil {
+		return parsed.String()
+	}
+
+	return ""
+}
+
+//
+// RunOp PreflightTimeSync
+//
+
+// PreflightTimeSync checks that system clock is synchronized using timedatectl
+type PreflightTimeSync struct{}
+
+func (op *PreflightTimeSync) Preflight() {}
+
+func (op *PreflightTimeSync) Hydrate() error {
+	return nil
+}
+
+func (op *PreflightTimeSync) Summary() string {
+	return "check time sync"
+}
+
+func (op *PreflightTimeSync) Run(_ string) error {
+	data, err := exec.Command("timedatectl", "show", "--property=NTPSynchronized", "--value").Output()
+	if err != nil {
+		return errors.Wrapf(err, "error getting time sync status")
+	}
+
+	if synced := strings.TrimSpace(string(data)); synced != "yes" {
+		return errors.Errorf("system clock isn't synchronized (NTPSynchronized=%s), certificates could be invalid", synced)
+	}
+
+	return nil
+}
+
+//
+// RunOp PreflightPorts
+//
+
+// PreflightPorts checks that TCP ports are free to listen on
+type PreflightPorts struct {
+	Ports []int `json:"ports,omitempty"`
+}
+
+func (op *PreflightPorts) Preflight() {}
+
+func (op *PreflightPorts) Hydrate() error {
+	for _, port := range op.Ports {
+		if port <= 0 || port > 65535 {
+			return errors.Errorf("invalid port %d", port)
+		}
+	}
+
+	return nil
+}
+
+func (op *PreflightPorts) Summary() string {
+	ports := []string{}
+	for _, port := range op.Ports {
+		ports = append(ports, strconv.Itoa(port))
+	}
+
+	return fmt.Sprintf("check ports %s are free", strings.Join(ports, ", "))
+}
+
+func (op *PreflightPorts) Run(_ string) error {
+	issues := []string{}
+
+	for _, port := range op.Ports {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("port %d is in use: %s", port, err))
+
+			continue
+		}
+		l.Close()
+	}
+
+	return preflightIssues(issues)
+}
+
+func preflightIssues(issues []string) error {
+	if len(issues) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(issues, "; "))
+}
@@ -0,0 +1,226 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ParseMemTotal(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		result uint64
+		err    bool
+	}{
+		{
+			name:   "valid",
+			data:   "MemTotal:       16318448 kB\nMemFree:         1204184 kB\n",
+			result: 15935,
+		},
+		{
+			name:   "not-first",
+			data:   "MemFree:         1204184 kB\nMemTotal:        8388608 kB\n",
+			result: 8192,
+		},
+		{
+			name: "missing",
+			data: "MemFree:         1204184 kB\n",
+			err:  true,
+		},
+		{
+			name: "invalid",
+			data: "MemTotal:       lots kB\n",
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parseMemTotal(strings.NewReader(test.data))
+			if test.err && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if result != test.result {
+				t.Errorf("parseMemTotal() = %d, want %d", result, test.result)
+			}
+		})
+	}
+}
+
+const testRoutesHeader = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"
+
+func Test_ParseRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		result []string
+		err    bool
+	}{
+		{
+			name: "valid",
+			data: testRoutesHeader +
+				"eth0\t00000000\t0100A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+				"eth0\t0000A8C0\t00000000\t0001\t0\t0\t100\t00FFFFFF\t0\t0\t0\n" +
+				"docker0\t000011AC\t00000000\t0001\t0\t0\t0\t0000FFFF\t0\t0\t0\n",
+			result: []string{"eth0 0.0.0.0/0", "eth0 192.168.0.0/24", "docker0 172.17.0.0/16"},
+		},
+		{
+			name:   "header-only",
+			data:   testRoutesHeader,
+			result: []string{},
+		},
+		{
+			name:   "short-line",
+			data:   testRoutesHeader + "eth0\t00000000\n",
+			result: []string{},
+		},
+		{
+			name: "invalid-destination",
+			data: testRoutesHeader + "eth0\tXX00A8C0\t00000000\t0001\t0\t0\t100\t00FFFFFF\t0\t0\t0\n",
+			err:  true,
+		},
+		{
+			name: "invalid-mask",
+			data: testRoutesHeader + "eth0\t0000A8C0\t00000000\t0001\t0\t0\t100\t00FF\t0\t0\t0\n",
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, err := parseRoutes(strings.NewReader(test.data))
+			if test.err && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if test.err {
+				return
+			}
+
+			result := []string{}
+			for _, route := range routes {
+				result = append(result, route.iface+" "+route.dst.String())
+			}
+			if !reflect.DeepEqual(result, test.result) {
+				t.Errorf("parseRoutes() = %v, want %v", result, test.result)
+			}
+		})
+	}
+}
+
+func Test_PreflightRoutesCollisions(t *testing.T) {
+	route := func(iface, dst string) hostRoute {
+		_, ipNet, err := net.ParseCIDR(dst)
+		if err != nil {
+			t.Fatalf("invalid route %s: %v", dst, err)
+		}
+
+		return hostRoute{iface: iface, dst: ipNet}
+	}
+
+	op := &PreflightRoutes{
+		Subnets:          []string{"172.30.0.0/16"},
+		IPs:              []string{"172.28.0.1"},
+		IgnoreInterfaces: []string{"enp2s1"},
+	}
+
+	tests := []struct {
+		name   string
+		route  hostRoute
+		issues int
+	}{
+		{name: "unrelated", route: route("eth0", "192.168.0.0/24")},
+		{name: "default", route: route("eth0", "0.0.0.0/0")},
+		{name: "inside-subnet", route: route("eth0", "172.30.1.0/24"), issues: 1},
+		{name: "covers-subnet", route: route("eth0", "172.16.0.0/12"), issues: 2},
+		{name: "same-subnet", route: route("eth0", "172.30.0.0/16"), issues: 1},
+		{name: "covers-ip", route: route("eth0", "172.28.0.0/24"), issues: 1},
+		{name: "loopback", route: route("lo", "172.30.0.0/16")},
+		{name: "ignored-iface", route: route("enp2s1", "172.30.0.0/16")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if issues := op.collisions([]hostRoute{test.route}); len(issues) != test.issues {
+				t.Errorf("collisions() = %v, want %d issues", issues, test.issues)
+			}
+		})
+	}
+}
+
+func Test_RunRecipePreflightInstalled(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed bool
+		err       bool
+	}{
+		{name: "fresh", err: true},
+		{name: "installed", installed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			basedir, target := t.TempDir(), t.TempDir()
+
+			if err := os.WriteFile(filepath.Join(basedir, "a"), []byte("a"), 0o644); err != nil {
+				t.Fatalf("error writing file: %v", err)
+			}
+
+			// can't be satisfied on any host
+			preflight := &PreflightDisk{Path: target, MinFreeGiB: 1 << 40}
+			file := &InstallFile{Name: "a", Target: target}
+			if err := file.Hydrate(); err != nil {
+				t.Fatalf("error hydrating op: %v", err)
+			}
+
+			recipe := &Recipe{Actions: []RecipeAction{{Name: "preflight-disk", Op: preflight}, {Name: "files", Op: file}}}
+			if err := recipe.Save(basedir); err != nil {
+				t.Fatalf("error saving recipe: %v", err)
+			}
+
+			if test.installed {
+				hash, err := actionHash(recipe.Actions[1])
+				if err != nil {
+					t.Fatalf("error hashing action: %v", err)
+				}
+
+				journal := &Journal{}
+				journal.Record(JournalEntry{Name: "files", Hash: hash})
+				if err := journal.Save(basedir); err != nil {
+					t.Fatalf("error saving journal: %v", err)
+				}
+			}
+
+			err := runRecipe(basedir, nil, false, true, "")
+			if test.err && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -15,11 +15,14 @@
 package cnc
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/pkg/errors"
@@ -27,6 +30,8 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
+const RecipeFile = "recipe.yaml"
+
 type Recipe struct {
 	Actions []RecipeAction
 }
@@ -84,7 +89,7 @@ func (r *Recipe) Save(basedir string) error {
 		return errors.Wrap(err, "error marshaling recipe")
 	}
 
-	err = os.WriteFile(filepath.Join(basedir, "recipe.yaml"), data, 0o600)
+	err = os.WriteFile(filepath.Join(basedir, RecipeFile), data, 0o600)
 	if err != nil {
 		return errors.Wrap(err, "error writing recipe")
 	}
@@ -93,7 +98,7 @@ func (r *Recipe) Save(basedir string) error {
 }
 
 func (r *Recipe) Load(basedir string) error {
-	data, err := os.ReadFile(filepath.Join(basedir, "recipe.yaml"))
+	data, err := os.ReadFile(filepath.Join(basedir, RecipeFile))
 	if err != nil {
 		return errors.Wrap(err, "error reading recipe")
 	}
@@ -136,12 +141,37 @@ func (r *Recipe) Load(basedir string) error {
 	return nil
 }
 
-func RunRecipe(basedir string, steps []string, dryRun bool) error {
+func RunRecipe(basedir string, steps []string, dryRun bool, force bool, from string) error {
+	start := time.Now()
+
+	EmitEvent(Event{Kind: EventKindRecipe, Status: EventStatusStart, Name: basedir})
+	err := runRecipe(basedir, steps, dryRun, force, from)
+	EmitDone(Event{Kind: EventKindRecipe, Name: basedir}, start, err)
+
+	return err
+}
+
+// recipeInstalled returns true if any of the non-preflight recipe actions was completed on the host before
+func recipeInstalled(recipe *Recipe, journal *Journal) bool {
+	for _, action := range recipe.Actions {
+		if _, preflight := action.Op.(PreflightRunOp); preflight {
+			continue
+		}
+
+		if slices.ContainsFunc(journal.Entries, func(entry JournalEntry) bool { return entry.Name == action.Name }) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func runRecipe(basedir string, steps []string, dryRun bool, force bool, from string) error {
 	if dryRun {
 		slog.Warn("Dry run, not actually running anything")
 	}
 
-	slog.Info("Running recipe", "basedir", basedir, "steps", strings.Join(steps, " "), "dryRun", dryRun)
+	slog.Info("Running recipe", "basedir", basedir, "steps", strings.Join(steps, " "), "dryRun", dryRun, "force", force, "from", from)
 
 	runStart := time.Now()
 
@@ -153,23 +183,339 @@ func RunRecipe(basedir string, steps []string, dryRun bool) error {
 
 	slog.Debug("Loaded recipe", "actions", len(recipe.Actions))
 
+	journal := &Journal{}
+	err = journal.Load(basedir)
+	if err != nil {
+		return errors.Wrapf(err, "error loading journal from %s", basedir)
+	}
+
+	started := from == ""
+	if !started && !slices.ContainsFunc(recipe.Actions, func(action RecipeAction) bool { return action.Name == from }) {
+		return errors.Errorf("unknown action to start from: %s", from)
+	}
+
+	// preflight checks are only enforced before the first install as they're expected to fail on the installed host
+	// (e.g. ports are taken by the installed services) when actions are re-run with --force or --from
+	installed := recipeInstalled(recipe, journal)
+
+	// failed preflight checks are collected to report all of them at once before running anything else
+	preflightFailed := []string{}
+	preflightReport := func() error {
+		if len(preflightFailed) == 0 {
+			return nil
+		}
+
+		slog.Error("Preflight checks failed, fix the issues above and re-run", "failed", strings.Join(preflightFailed, ", "))
+
+		return errors.Errorf("preflight checks failed: %s", strings.Join(preflightFailed, ", "))
+	}
+
 	for _, action := range recipe.Actions {
 		opStart := time.Now()
-		if len(steps) == 0 || len(steps) == 1 && steps[0] == "all" || len(steps) > 0 && slices.Contains(steps, action.Name) {
-			slog.Info("Running", "name", action.Name, "op", action.Op.Summary())
-			if !dryRun {
-				err = action.Op.Run(basedir)
-				if err != nil {
-					return errors.Wrapf(err, "error running action %s", action.Name)
+
+		if !started && action.Name == from {
+			started = true
+		}
+
+		selected := len(steps) == 0 || len(steps) == 1 && steps[0] == "all" || slices.Contains(steps, action.Name)
+		if !started || !selected {
+			slog.Debug("Skipping", "name", action.Name, "op", action.Op.Summary())
+
+			continue
+		}
+
+		hash, err := actionHash(action)
+		if err != nil {
+			return errors.Wrapf(err, "error hashing action %s", action.Name)
+		}
+
+		// --from implies re-running all actions starting from the specified one
+		event := Event{Kind: EventKindAction, Name: action.Name, Op: getShortTypeName(action.Op)}
+
+		if entry := journal.Completed(action.Name, hash); entry != nil && !force && from == "" {
+			slog.Info("Skipping (completed)", "name", action.Name, "op", action.Op.Summary(), "at", entry.Completed.Format(time.DateTime))
+
+			event.Status = EventStatusSkip
+			EmitEvent(event)
+
+			continue
+		}
+
+		_, preflight := action.Op.(PreflightRunOp)
+		if !preflight {
+			if err := preflightReport(); err != nil {
+				return err
+			}
+		}
+
+		slog.Info("Running", "name", action.Name, "op", action.Op.Summary())
+		event.Status = EventStatusStart
+		EmitEvent(event)
+
+		if !dryRun {
+			err = action.Op.Run(basedir)
+			if err != nil {
+				EmitDone(event, opStart, err)
+
+				if preflight && installed {
+					slog.Warn("Preflight check failed on the installed host, ignoring", "name", action.Name, "err", err.Error())
+
+					continue
+				}
+				if preflight {
+					slog.Error("Preflight check failed", "name", action.Name, "err", err.Error())
+					preflightFailed = append(preflightFailed, action.Name)
+
+					continue
 				}
+
+				return errors.Wrapf(err, "error running action %s", action.Name)
+			}
+
+			journal.Record(JournalEntry{
+				Name:      action.Name,
+				Type:      getShortTypeName(action.Op),
+				Hash:      hash,
+				Completed: time.Now(),
+				Took:      time.Since(opStart),
+			})
+
+			// saving after each action so we don't lose progress if next one fails
+			err = journal.Save(basedir)
+			if err != nil {
+				return errors.Wrapf(err, "error saving journal to %s", basedir)
 			}
-		} else {
-			slog.Debug("Skipping", "name", action.Name, "op", action.Op.Summary())
 		}
+		EmitDone(event, opStart, nil)
 		slog.Debug("Done", "name", action.Name, "op", action.Op.Summary(), "took", time.Since(opStart))
 	}
 
+	if err := preflightReport(); err != nil {
+		return err
+	}
+
 	slog.Info("Done", "took", time.Since(runStart))
 
 	return nil
 }
+
+// UninstallRecipe walks the journal in reverse order and runs inverse ops for the completed actions, actions are
+// removed from the journal once undone so they'll be run again by the next recipe run
+func UninstallRecipe(basedir string, steps []string, dryRun bool, force bool) error {
+	start := time.Now()
+
+	EmitEvent(Event{Kind: EventKindRecipe, Status: EventStatusStart, Name: basedir})
+	err := uninstallRecipe(basedir, steps, dryRun, force)
+	EmitDone(Event{Kind: EventKindRecipe, Name: basedir}, start, err)
+
+	return err
+}
+
+func uninstallRecipe(basedir string, steps []string, dryRun bool, force bool) error {
+	if dryRun {
+		slog.Warn("Dry run, not actually undoing anything")
+	}
+
+	slog.Info("Uninstalling recipe", "basedir", basedir, "steps", strings.Join(steps, " "), "dryRun", dryRun, "force", force)
+
+	runStart := time.Now()
+
+	recipe := &Recipe{}
+	err := recipe.Load(basedir)
+	if err != nil {
+		return errors.Wrapf(err, "error loading recipe from %s", basedir)
+	}
+
+	journal := &Journal{}
+	err = journal.Load(basedir)
+	if err != nil {
+		return errors.Wrapf(err, "error loading journal from %s", basedir)
+	}
+
+	// multiple actions could share the same name (e.g. per node or per file ops), so they are matched to the journal
+	// entries by name and hash
+	actions := map[string][]hashedAction{}
+	for _, action := range recipe.Actions {
+		hash, err := actionHash(action)
+		if err != nil {
+			return errors.Wrapf(err, "error hashing action %s", action.Name)
+		}
+
+		actions[action.Name] = append(actions[action.Name], hashedAction{action: action, hash: hash})
+	}
+
+	all := len(steps) == 0 || len(steps) == 1 && steps[0] == "all"
+	if !all {
+		for _, step := range steps {
+			if _, exist := actions[step]; !exist {
+				return errors.Errorf("unknown action to uninstall: %s", step)
+			}
+		}
+	}
+
+	entries := slices.Clone(journal.Entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Completed.After(entries[j].Completed)
+	})
+
+	for _, entry := range entries {
+		opStart := time.Now()
+
+		if !all && !slices.Contains(steps, entry.Name) {
+			continue
+		}
+
+		candidates, exist := actions[entry.Name]
+		if !exist {
+			if !force {
+				return errors.Errorf("completed action %s isn't in the recipe, use --force to skip it", entry.Name)
+			}
+
+			slog.Warn("Skipping (not in recipe)", "name", entry.Name)
+
+			continue
+		}
+
+		var action RecipeAction
+		if idx := slices.IndexFunc(candidates, func(c hashedAction) bool { return c.hash == entry.Hash }); idx >= 0 {
+			action = candidates[idx].action
+		} else {
+			if !force {
+				return errors.Errorf("action %s changed since it was completed, use --force to undo it anyway", entry.Name)
+			}
+			if len(candidates) > 1 {
+				slog.Warn("Skipping (changed and can't be matched to one of the actions with the same name)", "name", entry.Name)
+
+				continue
+			}
+
+			slog.Warn("Action changed since it was completed", "name", entry.Name)
+			action = candidates[0].action
+		}
+
+		event := Event{Kind: EventKindUndo, Name: action.Name, Op: getShortTypeName(action.Op)}
+
+		var inverse RunOp
+		if reversible, ok := action.Op.(ReversibleRunOp); ok {
+			inverse = reversible.Inverse()
+		}
+
+		if inverse == nil {
+			slog.Info("Nothing to undo", "name", action.Name, "op", action.Op.Summary())
+
+			event.Status = EventStatusSkip
+			EmitEvent(event)
+		} else {
+			err = inverse.Hydrate()
+			if err != nil {
+				return errors.Wrapf(err, "error hydrating inverse op for action %s", action.Name)
+			}
+
+			slog.Info("Undoing", "name", action.Name, "op", inverse.Summary())
+			event.Status = EventStatusStart
+			EmitEvent(event)
+
+			if !dryRun {
+				err = inverse.Run(basedir)
+				if err != nil {
+					EmitDone(event, opStart, err)
+
+					return errors.Wrapf(err, "error undoing action %s", action.Name)
+				}
+			}
+		}
+
+		if !dryRun {
+			journal.Remove(entry.Name, entry.Hash)
+
+			// saving after each action so we don't lose progress if next one fails
+			err = journal.Save(basedir)
+			if err != nil {
+				return errors.Wrapf(err, "error saving journal to %s", basedir)
+			}
+		}
+
+		if inverse != nil {
+			EmitDone(event, opStart, nil)
+		}
+	}
+
+	slog.Info("Uninstalled", "took", time.Since(runStart))
+
+	return nil
+}
+
+type hashedAction struct {
+	action RecipeAction
+	hash   uint64
+}
+
+// RecipeActionStatus is a status of the single recipe action emitted as a JSON line in the JSON output mode
+type RecipeActionStatus struct {
+	Name      string     `json:"name"`
+	Action    string     `json:"action"`
+	Status    string     `json:"status"`
+	Completed *time.Time `json:"completed,omitempty"`
+	Took      float64    `json:"tookSeconds,omitempty"`
+}
+
+func RecipeStatus(basedir string) error {
+	recipe := &Recipe{}
+	err := recipe.Load(basedir)
+	if err != nil {
+		return errors.Wrapf(err, "error loading recipe from %s", basedir)
+	}
+
+	journal := &Journal{}
+	err = journal.Load(basedir)
+	if err != nil {
+		return errors.Wrapf(err, "error loading journal from %s", basedir)
+	}
+
+	// tabwriter output would break JSON lines, so status is emitted per action instead
+	var w *tabwriter.Writer
+	if !IsJSONOutput() {
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tACTION\tSTATUS\tCOMPLETED\tTOOK")
+	}
+
+	completed := 0
+	for _, action := range recipe.Actions {
+		hash, err := actionHash(action)
+		if err != nil {
+			return errors.Wrapf(err, "error hashing action %s", action.Name)
+		}
+
+		actionStatus := RecipeActionStatus{Name: action.Name, Action: action.Op.Summary(), Status: "pending"}
+		status, at, took := "pending", "-", "-"
+		if entry := journal.Completed(action.Name, hash); entry != nil {
+			completed++
+			status = "completed"
+			at = entry.Completed.Format(time.DateTime)
+			took = entry.Took.Round(time.Millisecond).String()
+
+			actionStatus.Status = status
+			actionStatus.Completed = &entry.Completed
+			actionStatus.Took = entry.Took.Seconds()
+		}
+
+		if w == nil {
+			EmitJSON(actionStatus)
+
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", action.Name, action.Op.Summary(), status, at, took)
+	}
+
+	if w != nil {
+		err = w.Flush()
+		if err != nil {
+			return errors.Wrapf(err, "error writing status")
+		}
+	}
+
+	slog.Info("Recipe status", "basedir", basedir, "actions", len(recipe.Actions), "completed", completed)
+
+	return nil
+}
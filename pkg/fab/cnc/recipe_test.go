@@ -0,0 +1,119 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func Test_UninstallRecipeSameNames(t *testing.T) {
+	tests := []struct {
+		name string
+		// forget is a list of files which install isn't recorded in the journal
+		forget  []string
+		steps   []string
+		removed []string
+		kept    []string
+	}{
+		{
+			name:    "all",
+			removed: []string{"a", "b"},
+		},
+		{
+			name:    "step",
+			steps:   []string{"files"},
+			removed: []string{"a", "b"},
+		},
+		{
+			name:    "partially-completed",
+			forget:  []string{"a"},
+			removed: []string{"b"},
+			kept:    []string{"a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			basedir, target := t.TempDir(), t.TempDir()
+
+			recipe := &Recipe{}
+			for _, name := range []string{"a", "b"} {
+				if err := os.WriteFile(filepath.Join(basedir, name), []byte(name), 0o644); err != nil {
+					t.Fatalf("error writing file %s: %v", name, err)
+				}
+
+				op := &InstallFile{Name: name, Target: target}
+				if err := op.Hydrate(); err != nil {
+					t.Fatalf("error hydrating op: %v", err)
+				}
+				recipe.Actions = append(recipe.Actions, RecipeAction{Name: "files", Op: op})
+			}
+			if err := recipe.Save(basedir); err != nil {
+				t.Fatalf("error saving recipe: %v", err)
+			}
+
+			if err := RunRecipe(basedir, nil, false, false, ""); err != nil {
+				t.Fatalf("error running recipe: %v", err)
+			}
+
+			journal := &Journal{}
+			if err := journal.Load(basedir); err != nil {
+				t.Fatalf("error loading journal: %v", err)
+			}
+			if len(journal.Entries) != 2 {
+				t.Fatalf("journal entries: got %d, want 2", len(journal.Entries))
+			}
+			for _, action := range recipe.Actions {
+				if !slices.Contains(test.forget, action.Op.(*InstallFile).Name) {
+					continue
+				}
+
+				hash, err := actionHash(action)
+				if err != nil {
+					t.Fatalf("error hashing action: %v", err)
+				}
+				journal.Remove(action.Name, hash)
+			}
+			if err := journal.Save(basedir); err != nil {
+				t.Fatalf("error saving journal: %v", err)
+			}
+
+			if err := UninstallRecipe(basedir, test.steps, false, false); err != nil {
+				t.Fatalf("error uninstalling recipe: %v", err)
+			}
+
+			for _, name := range test.removed {
+				if _, err := os.Stat(filepath.Join(target, name)); !os.IsNotExist(err) {
+					t.Errorf("file %s should be removed, got err %v", name, err)
+				}
+			}
+			for _, name := range test.kept {
+				if _, err := os.Stat(filepath.Join(target, name)); err != nil {
+					t.Errorf("file %s should be kept: %v", name, err)
+				}
+			}
+
+			if err := journal.Load(basedir); err != nil {
+				t.Fatalf("error loading journal: %v", err)
+			}
+			if len(journal.Entries) != 0 {
+				t.Errorf("journal should be empty, got %v", journal.Entries)
+			}
+		})
+	}
+}
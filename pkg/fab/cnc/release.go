@@ -0,0 +1,183 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pkg/errors"
+	"go.githedgehog.com/fabric/api/meta"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ReleaseFile = "release.yaml"
+
+	releaseInstallName = "release"
+)
+
+// Release is a manifest with versions of all artifacts per component, it's embedded into the installer bundles and
+// installed next to the other files, so it's always known what's actually running
+type Release struct {
+	Version    string             `json:"version,omitempty"`
+	Preset     Preset             `json:"preset,omitempty"`
+	FabricMode meta.FabricMode    `json:"fabricMode,omitempty"`
+	Components []ReleaseComponent `json:"components,omitempty"`
+}
+
+type ReleaseComponent struct {
+	Name      string            `json:"name"`
+	Artifacts []ReleaseArtifact `json:"artifacts,omitempty"`
+}
+
+type ReleaseArtifact struct {
+	Ref    string `json:"ref"`
+	Tag    string `json:"tag,omitempty"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// ReleaseInstall describes installation of the release manifest by the installer bundles
+type ReleaseInstall struct {
+	Target string
+	// Skip is a list of installer bundles that don't install release manifest (e.g. server installers)
+	Skip []Bundle
+}
+
+// SetVersion sets the fabricator version recorded in the release manifest
+func (mngr *Manager) SetVersion(version string) {
+	mngr.version = version
+}
+
+func (mngr *Manager) SetReleaseInstall(install *ReleaseInstall) {
+	mngr.releaseInstall = install
+}
+
+// Release returns the release manifest for the loaded config, digests are taken from the artifacts lock, so they are
+// only available for the artifacts that were already built
+func (mngr *Manager) Release() (*Release, error) {
+	builds, _, err := mngr.collectOps(nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &ArtifactsLock{}
+	if err := lock.Load(mngr.basedir); err != nil {
+		return nil, errors.Wrapf(err, "error loading artifacts lock")
+	}
+
+	for _, build := range builds {
+		if op, ok := build.op.(ArtifactBuildOp); ok {
+			if ref := op.Artifact(); ref.Digest == "" {
+				ref.Digest = lock.Artifacts[ref.String()]
+			}
+		}
+	}
+
+	return mngr.newRelease(builds), nil
+}
+
+// newRelease collects artifacts from the build ops grouped by component in the build order, the same artifact used
+// by multiple ops (e.g. for different targets) is only listed once
+func (mngr *Manager) newRelease(builds []buildContext) *Release {
+	release := &Release{
+		Version:    mngr.version,
+		Preset:     mngr.preset,
+		FabricMode: mngr.fabricMode,
+	}
+
+	for _, build := range builds {
+		op, ok := build.op.(ArtifactBuildOp)
+		if !ok {
+			continue
+		}
+
+		ref := op.Artifact()
+		artifact := ReleaseArtifact{
+			Ref:    ref.RepoName(),
+			Tag:    ref.Tag,
+			Digest: ref.Digest,
+		}
+
+		idx := slices.IndexFunc(release.Components, func(comp ReleaseComponent) bool { return comp.Name == build.component })
+		if idx < 0 {
+			release.Components = append(release.Components, ReleaseComponent{Name: build.component})
+			idx = len(release.Components) - 1
+		}
+
+		comp := &release.Components[idx]
+		if !slices.Contains(comp.Artifacts, artifact) {
+			comp.Artifacts = append(comp.Artifacts, artifact)
+		}
+	}
+
+	return release
+}
+
+// Print writes release manifest as YAML
+func (release *Release) Print(w io.Writer) error {
+	data, err := yaml.Marshal(release)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling release")
+	}
+
+	_, err = w.Write(data)
+
+	return errors.Wrapf(err, "error printing release")
+}
+
+// writeRelease writes the release manifest into all installer bundles and adds its install to the last stage of the
+// ones that aren't skipped
+func (mngr *Manager) writeRelease(release *Release, actions map[Bundle][][]recipeContext) error {
+	data, err := yaml.Marshal(release)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling release")
+	}
+
+	for _, bundle := range mngr.bundles {
+		if !bundle.IsInstaller {
+			continue
+		}
+
+		err = os.WriteFile(filepath.Join(mngr.basedir, bundle.Name, ReleaseFile), data, 0o644)
+		if err != nil {
+			return errors.Wrapf(err, "error writing release for bundle %s", bundle.Name)
+		}
+
+		if mngr.releaseInstall == nil || slices.Contains(mngr.releaseInstall.Skip, bundle) {
+			continue
+		}
+
+		op := &InstallFile{
+			Name:   ReleaseFile,
+			Target: mngr.releaseInstall.Target,
+		}
+		if err := op.Hydrate(); err != nil {
+			return errors.Wrapf(err, "error hydrating release install")
+		}
+
+		stage := mngr.maxStage - 1
+		actions[bundle][stage] = append(actions[bundle][stage], recipeContext{
+			bundle: bundle,
+			stage:  stage,
+			name:   releaseInstallName,
+			op:     op,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_NewRelease(t *testing.T) {
+	ref := func(name, tag, digest string) Ref {
+		return Ref{Repo: "ghcr.io/githedgehog", Name: name, Tag: tag, Digest: digest}
+	}
+
+	tests := []struct {
+		name   string
+		builds []buildContext
+		want   []ReleaseComponent
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "grouped-by-component",
+			builds: []buildContext{
+				{component: "k3s", name: "k3s", op: &FilesORAS{Ref: ref("k3s", "v1", "sha256:a")}},
+				{component: "fabric", name: "fabric-image", op: &SyncOCI{Ref: ref("fabric/fabric", "v2", "sha256:b")}},
+				{component: "k3s", name: "kube-vip", op: &SyncOCI{Ref: ref("kube-vip", "v3", "")}},
+				{component: "fabric", name: "fabric-manifest", op: &FileGenerate{}},
+			},
+			want: []ReleaseComponent{
+				{Name: "k3s", Artifacts: []ReleaseArtifact{
+					{Ref: "ghcr.io/githedgehog/k3s", Tag: "v1", Digest: "sha256:a"},
+					{Ref: "ghcr.io/githedgehog/kube-vip", Tag: "v3"},
+				}},
+				{Name: "fabric", Artifacts: []ReleaseArtifact{
+					{Ref: "ghcr.io/githedgehog/fabric/fabric", Tag: "v2", Digest: "sha256:b"},
+				}},
+			},
+		},
+		{
+			name: "same-artifact-multiple-targets",
+			builds: []buildContext{
+				{component: "das-boot", name: "sonic-a", op: &SyncOCI{Ref: ref("sonic", "base", "sha256:c"), Target: Ref{Name: "sonic/a"}}},
+				{component: "das-boot", name: "sonic-b", op: &SyncOCI{Ref: ref("sonic", "base", "sha256:c"), Target: Ref{Name: "sonic/b"}}},
+			},
+			want: []ReleaseComponent{
+				{Name: "das-boot", Artifacts: []ReleaseArtifact{
+					{Ref: "ghcr.io/githedgehog/sonic", Tag: "base", Digest: "sha256:c"},
+				}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mngr := &Manager{version: "v0.1.0", preset: "lab"}
+
+			release := mngr.newRelease(test.builds)
+			if release.Version != "v0.1.0" || release.Preset != "lab" {
+				t.Errorf("release metadata: got %s %s", release.Version, release.Preset)
+			}
+			if !reflect.DeepEqual(release.Components, test.want) {
+				t.Errorf("components: got %+v, want %+v", release.Components, test.want)
+			}
+		})
+	}
+}
@@ -23,10 +23,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
-	"time"
 
 	"github.com/pkg/errors"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/errdef"
 )
 
 var RunOpsList = []RunOp{
@@ -34,7 +35,16 @@ var RunOpsList = []RunOp{
 	&ExecCommand{},
 	&WaitURL{},
 	&PushOCI{},
+	&PushOCIAttachments{},
 	&WaitKube{},
+	&RemoveFile{},
+	&DeleteOCI{},
+	&PreflightDisk{},
+	&PreflightResources{},
+	&PreflightRoutes{},
+	&PreflightInterfaces{},
+	&PreflightTimeSync{},
+	&PreflightPorts{},
 }
 
 //
@@ -49,7 +59,7 @@ type InstallFile struct {
 	MkdirMode  os.FileMode `json:"mkdirMode,omitempty"`
 }
 
-var _ RunOp = (*InstallFile)(nil)
+var _ ReversibleRunOp = (*InstallFile)(nil)
 
 func (op *InstallFile) Hydrate() error {
 	if op.Name == "" {
@@ -93,6 +103,12 @@ func (op *InstallFile) Run(basedir string) error {
 	return errors.Wrapf(os.WriteFile(op.TargetPath(), content, op.Mode), "failed to write file %s", op.TargetName)
 }
 
+func (op *InstallFile) Inverse() RunOp {
+	return &RemoveFile{
+		Path: op.TargetPath(),
+	}
+}
+
 //
 // RunOp ExecCommand
 //
@@ -102,14 +118,19 @@ type ExecCommand struct {
 	Args []string `json:"args,omitempty"`
 	Env  []string `json:"env,omitempty"`
 	Dir  string   `json:"dir,omitempty"`
+	// Undo is an optional command to revert the effect of this one, e.g. k3s-uninstall.sh for k3s-install.sh
+	Undo *ExecCommand `json:"undo,omitempty"`
 }
 
-var _ RunOp = (*ExecCommand)(nil)
+var _ ReversibleRunOp = (*ExecCommand)(nil)
 
 func (op *ExecCommand) Hydrate() error {
 	if op.Name == "" {
 		return errors.New("name is empty")
 	}
+	if op.Undo != nil {
+		return errors.Wrapf(op.Undo.Hydrate(), "invalid undo command")
+	}
 
 	return nil
 }
@@ -124,12 +145,23 @@ func (op *ExecCommand) Run(basedir string) error {
 	cmd.Dir = basedir
 	cmd.Env = append(os.Environ(), op.Env...)
 
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stdout
+	out, flush := CommandOutput(op.Name)
+	defer flush()
+
+	cmd.Stdout = out
+	cmd.Stderr = out
 
 	return errors.Wrapf(cmd.Run(), "failed to execute command %s", op.Name)
 }
 
+func (op *ExecCommand) Inverse() RunOp {
+	if op.Undo == nil {
+		return nil
+	}
+
+	return op.Undo
+}
+
 //
 // RunOp WaitURL
 //
@@ -183,7 +215,7 @@ type PushOCI struct {
 	Target Ref    `json:"target,omitempty"`
 }
 
-var _ RunOp = (*PushOCI)(nil)
+var _ ReversibleRunOp = (*PushOCI)(nil)
 
 func (op *PushOCI) Hydrate() error {
 	if op.Name == "" {
@@ -198,7 +230,7 @@ func (op *PushOCI) Summary() string {
 }
 
 func (op *PushOCI) Run(basedir string) error {
-	err := copyOCI("oci:"+filepath.Join(basedir, op.Name), "docker://"+op.Target.String(), false)
+	err := copyOCI("oci:"+filepath.Join(basedir, op.Name), "docker://"+op.Target.String(), false, "", nil, nil)
 	if err != nil {
 		return err
 	}
@@ -206,103 +238,141 @@ func (op *PushOCI) Run(basedir string) error {
 	return nil
 }
 
+func (op *PushOCI) Inverse() RunOp {
+	return &DeleteOCI{
+		Target: op.Target,
+	}
+}
+
 //
-// RunOp WaitKubeConditionReady
+// RunOp PushOCIAttachments
 //
 
-// temporary implementation, will be replaced with a native k8s go client
-
-type WaitKube struct {
-	Name            string        `json:"name,omitempty"`
-	Timeout         time.Duration `json:"timeout,omitempty"`
-	TimeoutResource time.Duration `json:"timeoutResource,omitempty"`
-	Interval        time.Duration `json:"interval,omitempty"`
+// PushOCIAttachments pushes all tags (cosign signatures, attestations and SBOMs) from the OCI layout to the target repo
+type PushOCIAttachments struct {
+	Name   string `json:"name,omitempty"`
+	Target Ref    `json:"target,omitempty"`
 }
 
-var _ RunOp = (*WaitKube)(nil)
+var _ RunOp = (*PushOCIAttachments)(nil)
 
-func (op *WaitKube) Hydrate() error {
+func (op *PushOCIAttachments) Hydrate() error {
 	if op.Name == "" {
 		return errors.New("name is empty")
 	}
-	if !strings.Contains(op.Name, "/") {
-		return errors.New("name should be in form resourcetype/name")
+
+	return op.Target.StrictValidate()
+}
+
+func (op *PushOCIAttachments) Summary() string {
+	return fmt.Sprintf("push attachments %s", op.Target.Name)
+}
+
+func (op *PushOCIAttachments) Run(basedir string) error {
+	path := filepath.Join(basedir, op.Name)
+
+	tags, err := attachmentTags(path)
+	if err != nil {
+		return errors.Wrapf(err, "error getting attachment tags from %s", op.Name)
+	}
+	if len(tags) == 0 {
+		slog.Info("No attachments to push", "name", op.Name)
+
+		return nil
 	}
-	if op.Timeout == 0 {
-		op.Timeout = 10 * time.Minute
+
+	store, err := oci.New(path)
+	if err != nil {
+		return errors.Wrapf(err, "error opening oci layout %s", op.Name)
 	}
-	if op.TimeoutResource == 0 {
-		op.TimeoutResource = 10 * time.Minute
+
+	repo, err := newORASRepo(op.Target)
+	if err != nil {
+		return err
 	}
-	if op.Interval == 0 {
-		op.Interval = 3 * time.Second
+
+	for _, tag := range tags {
+		slog.Info("Pushing attachment", "target", op.Target.RepoName(), "tag", tag)
+
+		_, err = oras.Copy(context.Background(), store, tag, repo, tag, oras.DefaultCopyOptions)
+		if err != nil {
+			return errors.Wrapf(err, "error pushing attachment %s:%s", op.Target.RepoName(), tag)
+		}
 	}
 
 	return nil
 }
 
-func (op *WaitKube) Summary() string {
-	return fmt.Sprintf("wait %s", op.Name)
+//
+// RunOp RemoveFile
+//
+
+// RemoveFile removes the file if it exists, it's an inverse of the InstallFile
+type RemoveFile struct {
+	Path string `json:"path,omitempty"`
 }
 
-func (op *WaitKube) waitForResource() error {
-	start := time.Now()
-	for {
-		if time.Since(start) > op.TimeoutResource {
-			return errors.Errorf("timeout")
-		}
+var _ RunOp = (*RemoveFile)(nil)
 
-		time.Sleep(op.Interval)
+func (op *RemoveFile) Hydrate() error {
+	if op.Path == "" {
+		return errors.New("path is empty")
+	}
 
-		cmd := exec.Command("kubectl", "get", op.Name) //nolint:gosec
+	return nil
+}
 
-		if slog.Default().Enabled(context.TODO(), slog.LevelDebug) {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stdout
-		}
+func (op *RemoveFile) Summary() string {
+	return fmt.Sprintf("remove %s", op.Path)
+}
 
-		if cmd.Run() == nil {
-			return nil
-		}
+func (op *RemoveFile) Run(_ string) error {
+	err := os.Remove(op.Path)
+	if os.IsNotExist(err) {
+		slog.Debug("File already removed", "path", op.Path)
+
+		return nil
 	}
+
+	return errors.Wrapf(err, "failed to remove file %s", op.Path)
 }
 
-func (op *WaitKube) Run(_ string) error {
-	// wait for resource existence first
-	err := op.waitForResource()
+//
+// RunOp DeleteOCI
+//
+
+// DeleteOCI deletes the manifest tagged in the target repo if it exists, it's an inverse of the PushOCI
+type DeleteOCI struct {
+	Target Ref `json:"target,omitempty"`
+}
+
+var _ RunOp = (*DeleteOCI)(nil)
+
+func (op *DeleteOCI) Hydrate() error {
+	return op.Target.StrictValidate()
+}
+
+func (op *DeleteOCI) Summary() string {
+	return fmt.Sprintf("delete %s", op.Target.Name+":"+op.Target.Tag)
+}
+
+func (op *DeleteOCI) Run(_ string) error {
+	repo, err := newORASRepo(op.Target)
 	if err != nil {
-		return errors.Wrapf(err, "error waiting for resource %s", op.Name)
+		return err
 	}
 
-	var cmd *exec.Cmd
-	if strings.HasPrefix(op.Name, "deployment") {
-		cmd = exec.Command("kubectl", //nolint:gosec
-			"wait",
-			"--for=condition=available",
-			"--timeout="+op.Timeout.String(), op.Name) //nolint:goconst
-	} else if strings.HasPrefix(op.Name, "job") {
-		cmd = exec.Command("kubectl", //nolint:gosec
-			"wait",
-			"--for=condition=complete",
-			"--timeout="+op.Timeout.String(), op.Name)
-	} else if strings.HasPrefix(op.Name, "daemonset") {
-		cmd = exec.Command("kubectl", //nolint:gosec
-			"rollout", "status",
-			"--timeout="+op.Timeout.String(), op.Name)
-	} else if strings.HasPrefix(op.Name, "controlagent") {
-		cmd = exec.Command("kubectl", //nolint:gosec
-			"wait",
-			"--for=condition=applied",
-			"--timeout="+op.Timeout.String(), op.Name)
-	}
-	// otherwise we've just waited for the resource to exist
+	ctx := context.Background()
 
-	if cmd != nil {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	desc, err := repo.Resolve(ctx, op.Target.Tag)
+	if errors.Is(err, errdef.ErrNotFound) {
+		slog.Debug("Already deleted", "target", op.Target.String())
 
-		return errors.Wrapf(cmd.Run(), "error waiting for condition %s", op.Name)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error resolving %s", op.Target.String())
 	}
 
-	return nil
+	return errors.Wrapf(repo.Delete(ctx, desc), "error deleting %s", op.Target.String())
 }
@@ -0,0 +1,468 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// EnvIdentity is a path to the identity file with private key of one of the x25519 recipients
+	EnvIdentity = "HHFAB_IDENTITY"
+	// EnvPassphrase is a passphrase for the passphrase recipient
+	EnvPassphrase = "HHFAB_PASSPHRASE"
+	// EnvNewPassphrase is a new passphrase used when re-encrypting config
+	EnvNewPassphrase = "HHFAB_NEW_PASSPHRASE"
+
+	RecipientTypeX25519     = "x25519"
+	RecipientTypePassphrase = "passphrase"
+
+	encryptedPrefix = "ENC[v1,"
+	encryptedSuffix = "]"
+	redactedValue   = "<redacted>"
+	dataKeySize     = 32
+	wrapInfo        = "hhfab config data key v1"
+	scryptN         = 1 << 15
+)
+
+// Encryption describes how secret config fields are encrypted at rest: each field is encrypted using the data key
+// and the data key is wrapped for each of the recipients, so any of them could decrypt the config
+type Encryption struct {
+	Recipients []EncryptionRecipient `json:"recipients,omitempty"`
+}
+
+type EncryptionRecipient struct {
+	Type string `json:"type,omitempty"`
+	// PublicKey is base64 encoded x25519 public key of the recipient
+	PublicKey string `json:"publicKey,omitempty"`
+	// Salt is base64 encoded scrypt salt for the passphrase recipient
+	Salt string `json:"salt,omitempty"`
+	// WrappedKey is base64 encoded data key encrypted for the recipient
+	WrappedKey string `json:"wrappedKey,omitempty"`
+}
+
+// sealedValue is a decrypted secret with its ciphertext as loaded from the config, ciphertext is reused on save while
+// the value is the same, so the saved config only changes if secrets are changed
+type sealedValue struct {
+	plaintext  string
+	ciphertext string
+}
+
+// SecretsProvider is a component with sensitive config fields (dot-separated json paths) to be encrypted at rest
+type SecretsProvider interface {
+	SecretFields() []string
+}
+
+// KeyPairSecretFields returns secret fields of the key pair stored at the path
+func KeyPairSecretFields(path string) []string {
+	return []string{path + ".key", path + ".pendingKey"}
+}
+
+// GenerateIdentity returns new x25519 private key (identity) and public key (recipient), both base64 encoded
+func GenerateIdentity() (string, string, error) {
+	key, err := ecdh.X25519().GenerateKey(cryptorand.Reader)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "error generating x25519 key")
+	}
+
+	return base64.StdEncoding.EncodeToString(key.Bytes()), base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// newEncryption generates new data key and wraps it for all recipients, passphrase recipient is added if it's set
+func newEncryption(recipients []string, passphrase string) (*Encryption, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(cryptorand.Reader, dataKey); err != nil {
+		return nil, nil, errors.Wrapf(err, "error generating data key")
+	}
+
+	enc := &Encryption{}
+
+	for _, recipient := range recipients {
+		pubBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(recipient))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid recipient %q", recipient)
+		}
+		pub, err := ecdh.X25519().NewPublicKey(pubBytes)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid recipient %q", recipient)
+		}
+
+		ephemeral, err := ecdh.X25519().GenerateKey(cryptorand.Reader)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error generating ephemeral key")
+		}
+
+		kek, err := x25519WrapKey(ephemeral, pub, ephemeral.PublicKey(), pub)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		wrapped, err := seal(kek, dataKey, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		enc.Recipients = append(enc.Recipients, EncryptionRecipient{
+			Type:       RecipientTypeX25519,
+			PublicKey:  base64.StdEncoding.EncodeToString(pubBytes),
+			WrappedKey: base64.StdEncoding.EncodeToString(append(ephemeral.PublicKey().Bytes(), wrapped...)),
+		})
+	}
+
+	if passphrase != "" {
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(cryptorand.Reader, salt); err != nil {
+			return nil, nil, errors.Wrapf(err, "error generating salt")
+		}
+
+		kek, err := scrypt.Key([]byte(passphrase), salt, scryptN, 8, 1, dataKeySize)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error deriving key from passphrase")
+		}
+
+		wrapped, err := seal(kek, dataKey, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		enc.Recipients = append(enc.Recipients, EncryptionRecipient{
+			Type:       RecipientTypePassphrase,
+			Salt:       base64.StdEncoding.EncodeToString(salt),
+			WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		})
+	}
+
+	if len(enc.Recipients) == 0 {
+		return nil, nil, errors.New("no recipients")
+	}
+
+	return enc, dataKey, nil
+}
+
+// CanUnlock returns true if existing identity file or passphrase is set in env to unlock encrypted config
+func CanUnlock() bool {
+	if path := os.Getenv(EnvIdentity); path != "" {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+
+	return os.Getenv(EnvPassphrase) != ""
+}
+
+// unlock unwraps the data key using identity file or passphrase from env
+func (enc *Encryption) unlock() ([]byte, error) {
+	var identity *ecdh.PrivateKey
+	if path := os.Getenv(EnvIdentity); path != "" {
+		var err error
+		identity, err = readIdentity(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	passphrase := os.Getenv(EnvPassphrase)
+
+	if identity == nil && passphrase == "" {
+		return nil, errors.Errorf("config is encrypted, set %s to the identity file or %s", EnvIdentity, EnvPassphrase)
+	}
+
+	for _, recipient := range enc.Recipients {
+		wrapped, err := base64.StdEncoding.DecodeString(recipient.WrappedKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid wrapped key")
+		}
+
+		switch recipient.Type {
+		case RecipientTypeX25519:
+			if identity == nil || recipient.PublicKey != base64.StdEncoding.EncodeToString(identity.PublicKey().Bytes()) {
+				continue
+			}
+			if len(wrapped) < 32 {
+				return nil, errors.New("invalid wrapped key")
+			}
+
+			ephemeral, err := ecdh.X25519().NewPublicKey(wrapped[:32])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid wrapped key")
+			}
+
+			kek, err := x25519WrapKey(identity, ephemeral, ephemeral, identity.PublicKey())
+			if err != nil {
+				return nil, err
+			}
+
+			return open(kek, wrapped[32:], nil)
+		case RecipientTypePassphrase:
+			if passphrase == "" {
+				continue
+			}
+
+			salt, err := base64.StdEncoding.DecodeString(recipient.Salt)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid salt")
+			}
+
+			kek, err := scrypt.Key([]byte(passphrase), salt, scryptN, 8, 1, dataKeySize)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error deriving key from passphrase")
+			}
+
+			dataKey, err := open(kek, wrapped, nil)
+			if err != nil {
+				return nil, errors.Wrapf(err, "wrong passphrase")
+			}
+
+			return dataKey, nil
+		default:
+			return nil, errors.Errorf("unknown recipient type %q", recipient.Type)
+		}
+	}
+
+	return nil, errors.New("config isn't encrypted for the provided identity or passphrase")
+}
+
+// x25519WrapKey derives key encryption key from the shared secret bound to the ephemeral and recipient public keys
+func x25519WrapKey(priv *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error computing shared secret")
+	}
+
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	kek := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(wrapInfo)), kek); err != nil {
+		return nil, errors.Wrapf(err, "error deriving key")
+	}
+
+	return kek, nil
+}
+
+func readIdentity(path string) (*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading identity file")
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyBytes, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid identity")
+		}
+
+		key, err := ecdh.X25519().NewPrivateKey(keyBytes)
+
+		return key, errors.Wrapf(err, "invalid identity")
+	}
+
+	return nil, errors.Errorf("no identity found in %s", path)
+}
+
+// WriteIdentity writes identity file with the private key, public key is added as a comment
+func WriteIdentity(path, identity, recipient string) error {
+	content := fmt.Sprintf("# hhfab config identity\n# recipient: %s\n%s\n", recipient, identity)
+
+	return errors.Wrapf(os.WriteFile(path, []byte(content), 0o600), "error writing identity file")
+}
+
+// seal encrypts plaintext with a random nonce, additional data isn't stored but required to open it
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(cryptorand.Reader, nonce); err != nil {
+		return nil, errors.Wrapf(err, "error generating nonce")
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(key, ciphertext, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additional)
+
+	return plaintext, errors.Wrapf(err, "error decrypting")
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	return aead, errors.Wrapf(err, "error creating gcm")
+}
+
+// encryptValue encrypts the value bound to its path in the config (component name and field path), so encrypted values
+// can't be moved between fields
+func encryptValue(dataKey []byte, path, value string) (string, error) {
+	if strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+
+	ciphertext, err := seal(dataKey, []byte(value), []byte(path))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(ciphertext) + encryptedSuffix, nil
+}
+
+func decryptValue(dataKey []byte, path, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	encoded, ok = strings.CutSuffix(encoded, encryptedSuffix)
+	if !ok {
+		return "", errors.New("invalid encrypted value")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid encrypted value")
+	}
+
+	plaintext, err := open(dataKey, ciphertext, []byte(path))
+
+	return string(plaintext), err
+}
+
+func isEncrypted(value any) bool {
+	switch value := value.(type) {
+	case string:
+		return strings.HasPrefix(value, encryptedPrefix)
+	case map[string]any:
+		for _, v := range value {
+			if isEncrypted(v) {
+				return true
+			}
+		}
+	case []any:
+		for _, v := range value {
+			if isEncrypted(v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// transformStrings applies fn to all string values in the parsed config tree, fn gets dot-separated path of the value
+func transformStrings(value any, path string, fn func(string, string) (string, error)) (any, error) {
+	switch value := value.(type) {
+	case string:
+		return fn(path, value)
+	case map[string]any:
+		for k, v := range value {
+			res, err := transformStrings(v, joinPath(path, k), fn)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error processing %s", k)
+			}
+			value[k] = res
+		}
+	case []any:
+		for idx, v := range value {
+			res, err := transformStrings(v, joinPath(path, strconv.Itoa(idx)), fn)
+			if err != nil {
+				return nil, err
+			}
+			value[idx] = res
+		}
+	}
+
+	return value, nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// transformField applies fn to the non-empty string field at dot-separated path in the parsed config, missing fields
+// are skipped
+func transformField(config map[string]any, path string, fn func(string) (string, error)) error {
+	parts := strings.Split(path, ".")
+
+	current := config
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = next
+	}
+
+	last := parts[len(parts)-1]
+	value, ok := current[last].(string)
+	if !ok || value == "" {
+		return nil
+	}
+
+	res, err := fn(value)
+	if err != nil {
+		return errors.Wrapf(err, "error processing %s", path)
+	}
+	current[last] = res
+
+	return nil
+}
+
+// fieldValue returns the string field at dot-separated path in the parsed config or empty string if it's missing
+func fieldValue(config map[string]any, path string) string {
+	res := ""
+	_ = transformField(config, path, func(value string) (string, error) {
+		res = value
+
+		return value, nil
+	})
+
+	return res
+}
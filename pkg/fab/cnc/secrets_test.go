@@ -0,0 +1,215 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_EncryptionUnlock(t *testing.T) {
+	identity, recipient, err := GenerateIdentity()
+	if err != nil {
+		t.Fatalf("error generating identity: %v", err)
+	}
+	_, otherRecipient, err := GenerateIdentity()
+	if err != nil {
+		t.Fatalf("error generating identity: %v", err)
+	}
+
+	identityPath := filepath.Join(t.TempDir(), "identity")
+	if err := WriteIdentity(identityPath, identity, recipient); err != nil {
+		t.Fatalf("error writing identity: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		recipients []string
+		passphrase string
+		identity   string
+		unlockWith string
+		err        bool
+	}{
+		{
+			name:       "identity",
+			recipients: []string{otherRecipient, recipient},
+			identity:   identityPath,
+		},
+		{
+			name:       "passphrase",
+			recipients: []string{otherRecipient},
+			passphrase: "secret",
+			unlockWith: "secret",
+		},
+		{
+			name:       "wrong-passphrase",
+			passphrase: "secret",
+			unlockWith: "wrong",
+			err:        true,
+		},
+		{
+			name:       "not-a-recipient",
+			recipients: []string{otherRecipient},
+			identity:   identityPath,
+			err:        true,
+		},
+		{
+			name:       "nothing-to-unlock-with",
+			recipients: []string{recipient},
+			err:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(EnvIdentity, test.identity)
+			t.Setenv(EnvPassphrase, test.unlockWith)
+
+			enc, dataKey, err := newEncryption(test.recipients, test.passphrase)
+			if err != nil {
+				t.Fatalf("error creating encryption: %v", err)
+			}
+
+			unlocked, err := enc.unlock()
+			if (err != nil) != test.err {
+				t.Fatalf("unlock: got err %v, want err %t", err, test.err)
+			}
+			if err == nil && !bytes.Equal(unlocked, dataKey) {
+				t.Errorf("unlocked data key doesn't match")
+			}
+		})
+	}
+}
+
+func Test_SecretFieldsRoundTrip(t *testing.T) {
+	_, dataKey, err := newEncryption(nil, "secret")
+	if err != nil {
+		t.Fatalf("error creating encryption: %v", err)
+	}
+
+	config := map[string]any{
+		"token": "k3s-token",
+		"tls": map[string]any{
+			"ca": map[string]any{
+				"cert": "ca-cert",
+				"key":  "ca-key",
+			},
+		},
+	}
+
+	for _, field := range []string{"token", "tls.ca.key", "tls.ca.pendingKey", "tls.server.key"} {
+		if err := transformField(config, field, func(value string) (string, error) { return encryptValue(dataKey, "k3s."+field, value) }); err != nil {
+			t.Fatalf("error encrypting %s: %v", field, err)
+		}
+	}
+
+	ca := config["tls"].(map[string]any)["ca"].(map[string]any)
+	if !strings.HasPrefix(config["token"].(string), encryptedPrefix) || !strings.HasPrefix(ca["key"].(string), encryptedPrefix) {
+		t.Fatalf("secret fields aren't encrypted: %v", config)
+	}
+	if ca["cert"] != "ca-cert" {
+		t.Errorf("non-secret field changed: %v", ca["cert"])
+	}
+	if _, exist := ca["pendingKey"]; exist {
+		t.Errorf("missing field added")
+	}
+	if !isEncrypted(config) {
+		t.Errorf("config should be detected as encrypted")
+	}
+
+	if _, err := transformStrings(config, "k3s", func(path, value string) (string, error) { return decryptValue(dataKey, path, value) }); err != nil {
+		t.Fatalf("error decrypting: %v", err)
+	}
+	if config["token"] != "k3s-token" || ca["key"] != "ca-key" {
+		t.Errorf("decrypted values don't match: %v", config)
+	}
+	if isEncrypted(config) {
+		t.Errorf("config shouldn't be detected as encrypted")
+	}
+}
+
+func Test_SecretValueBoundToPath(t *testing.T) {
+	_, dataKey, err := newEncryption(nil, "secret")
+	if err != nil {
+		t.Fatalf("error creating encryption: %v", err)
+	}
+
+	encrypted, err := encryptValue(dataKey, "k3s.token", "k3s-token")
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+
+	if _, err := decryptValue(dataKey, "zot.tls.server.key", encrypted); err == nil {
+		t.Errorf("value moved to another field shouldn't decrypt")
+	}
+	if value, err := decryptValue(dataKey, "k3s.token", encrypted); err != nil || value != "k3s-token" {
+		t.Errorf("decrypted value doesn't match: %q, %v", value, err)
+	}
+}
+
+func Test_EncryptSecretStable(t *testing.T) {
+	_, dataKey, err := newEncryption(nil, "secret")
+	if err != nil {
+		t.Fatalf("error creating encryption: %v", err)
+	}
+
+	encrypted, err := encryptValue(dataKey, "k3s.token", "k3s-token")
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		value string
+		same  bool
+	}{
+		{name: "unchanged", path: "k3s.token", value: "k3s-token", same: true},
+		{name: "changed", path: "k3s.token", value: "new-token"},
+		{name: "other-field", path: "k3s.other", value: "k3s-token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mngr := &Manager{dataKey: dataKey}
+			if _, err := mngr.decryptSecret("k3s.token", encrypted); err != nil {
+				t.Fatalf("error decrypting: %v", err)
+			}
+
+			res, err := mngr.encryptSecret(test.path, test.value)
+			if err != nil {
+				t.Fatalf("error encrypting: %v", err)
+			}
+			if (res == encrypted) != test.same {
+				t.Errorf("ciphertext reused: %t, want %t", res == encrypted, test.same)
+			}
+
+			again, err := mngr.encryptSecret(test.path, test.value)
+			if err != nil {
+				t.Fatalf("error encrypting: %v", err)
+			}
+			if again != res {
+				t.Errorf("saving the same value again should produce the same ciphertext")
+			}
+
+			value, err := decryptValue(dataKey, test.path, res)
+			if err != nil || value != test.value {
+				t.Errorf("decrypted value doesn't match: %q, %v", value, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// cosignAttachmentSuffixes are tag suffixes used by cosign to store signatures, attestations and SBOMs
+var cosignAttachmentSuffixes = []string{".sig", ".att", ".sbom"}
+
+// TrustPolicy defines how artifacts downloaded during build are verified
+type TrustPolicy struct {
+	// Rules are matched by the longest repo prefix
+	Rules []TrustRule `json:"rules,omitempty"`
+	// Strict rejects artifacts that don't match any rule, otherwise they are accepted without verification
+	Strict bool `json:"strict,omitempty"`
+	// CopyAttachments copies cosign signatures, attestations and SBOMs into the bundle next to the images
+	CopyAttachments bool `json:"copyAttachments,omitempty"`
+}
+
+type TrustRule struct {
+	// Prefix is a repo prefix, e.g. ghcr.io/githedgehog or ghcr.io/githedgehog/fabric
+	Prefix string `json:"prefix,omitempty"`
+	// Key is a path to the cosign public key
+	Key string `json:"key,omitempty"`
+	// Keyless is a Fulcio identity used for keyless signing
+	Keyless *TrustKeyless `json:"keyless,omitempty"`
+	// Insecure accepts matched artifacts without verification, could be used to exclude repos with strict policy
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+type TrustKeyless struct {
+	Issuer   string `json:"issuer,omitempty"`
+	Email    string `json:"email,omitempty"`
+	FulcioCA string `json:"fulcioCA,omitempty"`
+	RekorKey string `json:"rekorKey,omitempty"`
+}
+
+// TrustProvider is implemented by the component that owns the trust policy
+type TrustProvider interface {
+	TrustPolicy() *TrustPolicy
+}
+
+// TrustedBuildOp is a build op that verifies downloaded artifacts using the trust policy
+type TrustedBuildOp interface {
+	BuildOp
+	SetTrust(trust *TrustPolicy)
+}
+
+func (p *TrustPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+
+	prefixes := map[string]bool{}
+	for _, rule := range p.Rules {
+		if prefixes[rule.Prefix] {
+			return errors.Errorf("duplicate trust rule prefix %q", rule.Prefix)
+		}
+		prefixes[rule.Prefix] = true
+
+		methods := 0
+		if rule.Key != "" {
+			methods++
+		}
+		if rule.Keyless != nil {
+			methods++
+		}
+		if rule.Insecure {
+			methods++
+		}
+		if methods != 1 {
+			return errors.Errorf("trust rule %q should have exactly one of key, keyless or insecure", rule.Prefix)
+		}
+
+		if rule.Key != "" {
+			if _, err := os.Stat(rule.Key); err != nil {
+				return errors.Wrapf(err, "error checking key for trust rule %q", rule.Prefix)
+			}
+		}
+
+		if rule.Keyless != nil {
+			if rule.Keyless.Issuer == "" || rule.Keyless.Email == "" {
+				return errors.Errorf("trust rule %q keyless issuer and email are required", rule.Prefix)
+			}
+			if rule.Keyless.FulcioCA == "" || rule.Keyless.RekorKey == "" {
+				return errors.Errorf("trust rule %q keyless fulcio CA and rekor key are required", rule.Prefix)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Match returns the rule with the longest prefix matching ref repo or nil if there is no such rule
+func (p *TrustPolicy) Match(ref Ref) *TrustRule {
+	if p == nil {
+		return nil
+	}
+
+	var match *TrustRule
+	name := ref.RepoName()
+	for idx, rule := range p.Rules {
+		if rule.Prefix != "" && name != rule.Prefix && !strings.HasPrefix(name, rule.Prefix+"/") {
+			continue
+		}
+		if match == nil || len(rule.Prefix) > len(match.Prefix) {
+			match = &p.Rules[idx]
+		}
+	}
+
+	return match
+}
+
+// Enabled returns true if artifacts should be verified
+func (p *TrustPolicy) Enabled() bool {
+	return p != nil && (len(p.Rules) > 0 || p.Strict)
+}
+
+func (rule *TrustRule) requirement() (signature.PolicyRequirement, error) {
+	if rule.Insecure {
+		return signature.NewPRInsecureAcceptAnything(), nil
+	}
+
+	opts := []signature.PRSigstoreSignedOption{
+		signature.PRSigstoreSignedWithSignedIdentity(signature.NewPRMMatchRepoDigestOrExact()),
+	}
+
+	if rule.Key != "" {
+		opts = append(opts, signature.PRSigstoreSignedWithKeyPath(rule.Key))
+	} else {
+		fulcio, err := signature.NewPRSigstoreSignedFulcio(
+			signature.PRSigstoreSignedFulcioWithCAPath(rule.Keyless.FulcioCA),
+			signature.PRSigstoreSignedFulcioWithOIDCIssuer(rule.Keyless.Issuer),
+			signature.PRSigstoreSignedFulcioWithSubjectEmail(rule.Keyless.Email),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error creating fulcio requirement")
+		}
+
+		opts = append(opts,
+			signature.PRSigstoreSignedWithFulcio(fulcio),
+			signature.PRSigstoreSignedWithRekorPublicKeyPath(rule.Keyless.RekorKey),
+		)
+	}
+
+	req, err := signature.NewPRSigstoreSigned(opts...)
+
+	return req, errors.Wrapf(err, "error creating sigstore requirement")
+}
+
+// newPolicyContext creates signature policy context for copying images, nil policy accepts anything
+func (p *TrustPolicy) newPolicyContext() (*signature.PolicyContext, error) {
+	policy := &signature.Policy{
+		Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()},
+	}
+
+	if p.Enabled() {
+		if p.Strict {
+			policy.Default = []signature.PolicyRequirement{signature.NewPRReject()}
+		}
+
+		scopes := signature.PolicyTransportScopes{}
+		for _, rule := range p.Rules {
+			req, err := rule.requirement()
+			if err != nil {
+				return nil, errors.Wrapf(err, "error creating requirement for trust rule %q", rule.Prefix)
+			}
+
+			// empty prefix matches everything, so it's the same as default
+			if rule.Prefix == "" {
+				policy.Default = []signature.PolicyRequirement{req}
+
+				continue
+			}
+
+			scopes[rule.Prefix] = []signature.PolicyRequirement{req}
+		}
+
+		policy.Transports = map[string]signature.PolicyTransportScopes{
+			"docker": scopes,
+		}
+	}
+
+	policyCtx, err := signature.NewPolicyContext(policy)
+
+	return policyCtx, errors.Wrapf(err, "error creating policy context")
+}
+
+// newTrustSystemContext returns system context configured to read sigstore attachments from registries, returned
+// cleanup func should be called after it's not needed anymore
+func newTrustSystemContext(sys *types.SystemContext) (*types.SystemContext, func(), error) {
+	dir, err := os.MkdirTemp("", "hhfab-registries-d-*")
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error creating registries.d dir")
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("Error removing registries.d dir", "err", err)
+		}
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "default.yaml"), []byte("default-docker:\n  use-sigstore-attachments: true\n"), 0o644)
+	if err != nil {
+		cleanup()
+
+		return nil, nil, errors.Wrapf(err, "error writing registries.d config")
+	}
+
+	res := types.SystemContext{}
+	if sys != nil {
+		res = *sys
+	}
+	res.RegistriesDirPath = dir
+
+	return &res, cleanup, nil
+}
+
+// verifyArtifact checks the artifact signature using the trust policy without downloading it, if offline source is
+// set signatures are read from the attachments exported next to the artifact
+func verifyArtifact(ref Ref, trust *TrustPolicy, offlineSource string) error {
+	if !trust.Enabled() {
+		return nil
+	}
+
+	slog.Debug("Verifying", "ref", ref.Pinned())
+
+	imgRef, err := alltransports.ParseImageName("docker://" + ref.Pinned())
+	if err != nil {
+		return errors.Wrapf(err, "error parsing ref %s", ref.Pinned())
+	}
+
+	policyCtx, err := trust.newPolicyContext()
+	if err != nil {
+		return err
+	}
+
+	var insecure types.OptionalBool
+	if ref.IsLocalhost() {
+		insecure = types.OptionalBoolTrue
+	}
+
+	sys, cleanup, err := newTrustSystemContext(&types.SystemContext{
+		DockerInsecureSkipTLSVerify: insecure,
+		DockerAuthConfig:            getDockerAuthConfigOrNil(imgRef),
+	})
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if offlineSource != "" {
+		var cleanupOffline func()
+		sys, cleanupOffline, err = newOfflineSystemContext(sys, reference.Domain(imgRef.DockerReference()), offlineSource)
+		if err != nil {
+			return err
+		}
+		defer cleanupOffline()
+	}
+
+	ctx := context.Background()
+
+	src, err := imgRef.NewImageSource(ctx, sys)
+	if err != nil {
+		return errors.Wrapf(err, "error creating image source for %s", ref.Pinned())
+	}
+	defer src.Close()
+
+	allowed, err := policyCtx.IsRunningImageAllowed(ctx, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return errors.Wrapf(err, "error verifying %s", ref.Pinned())
+	}
+	if !allowed {
+		return errors.Errorf("artifact %s is rejected by trust policy", ref.Pinned())
+	}
+
+	return nil
+}
+
+// copyAttachments copies cosign attachments (signatures, attestations and SBOMs) for the pinned ref into the OCI
+// layout at path from the remote repo or offline source, missing attachments are skipped
+func copyAttachments(ref Ref, path, offlineSource string) error {
+	if ref.Digest == "" {
+		return errors.Errorf("ref %s isn't pinned to digest", ref)
+	}
+
+	store, err := oci.New(path)
+	if err != nil {
+		return errors.Wrapf(err, "error creating oci layout %s", path)
+	}
+
+	if offlineSource != "" {
+		src, err := oci.NewFromFS(context.Background(), os.DirFS(offlineSource))
+		if err != nil {
+			return errors.Wrapf(err, "error opening offline source %s", offlineSource)
+		}
+
+		return copyAttachmentTags(ref, src, ref.RepoName()+":", store, "")
+	}
+
+	repo, err := newORASRepo(ref)
+	if err != nil {
+		return err
+	}
+
+	return copyAttachmentTags(ref, repo, "", store, "")
+}
+
+// copyAttachmentTags copies cosign attachments for the pinned ref from src to dst, tags are prefixed with the
+// corresponding prefix (full repo name for the offline source), missing attachments are skipped
+func copyAttachmentTags(ref Ref, src oras.ReadOnlyTarget, srcPrefix string, dst oras.Target, dstPrefix string) error {
+	for _, suffix := range cosignAttachmentSuffixes {
+		tag := strings.ReplaceAll(ref.Digest, ":", "-") + suffix
+
+		_, err := oras.Copy(context.Background(), src, srcPrefix+tag, dst, dstPrefix+tag, oras.DefaultCopyOptions)
+		if errors.Is(err, errdef.ErrNotFound) {
+			slog.Debug("Attachment not found", "ref", ref.RepoName(), "tag", tag)
+
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error copying attachment %s:%s", ref.RepoName(), tag)
+		}
+
+		slog.Debug("Attachment copied", "ref", ref.RepoName(), "tag", tag)
+	}
+
+	return nil
+}
+
+// attachmentTags returns all tags stored in the OCI layout at path
+func attachmentTags(path string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(path, "index.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading index")
+	}
+
+	index := ocispec.Index{}
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error unmarshalling index")
+	}
+
+	tags := []string{}
+	for _, desc := range index.Manifests {
+		if tag := desc.Annotations[ocispec.AnnotationRefName]; tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags, nil
+}
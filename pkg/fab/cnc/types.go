@@ -24,6 +24,8 @@ type Ref struct {
 	Repo string `json:"repo,omitempty"`
 	Name string `json:"name,omitempty"`
 	Tag  string `json:"tag,omitempty"`
+	// Digest pins the ref to the specific manifest, it's not inherited on fallback as it only makes sense for the exact tag
+	Digest string `json:"digest,omitempty"`
 }
 
 func (ref Ref) StrictValidate() error {
@@ -65,6 +67,24 @@ func (ref Ref) String() string {
 	return ref.Repo + "/" + ref.Name + ":" + ref.Tag
 }
 
+// Reference returns digest if it's set or tag otherwise
+func (ref Ref) Reference() string {
+	if ref.Digest != "" {
+		return ref.Digest
+	}
+
+	return ref.Tag
+}
+
+// Pinned returns ref string pinned to the digest if it's set, tag is dropped in that case
+func (ref Ref) Pinned() string {
+	if ref.Digest != "" {
+		return ref.RepoName() + "@" + ref.Digest
+	}
+
+	return ref.String()
+}
+
 func (ref Ref) IsLocalhost() bool {
 	return strings.HasPrefix(ref.Repo, "127.0.0.1:")
 }
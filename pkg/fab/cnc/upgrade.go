@@ -0,0 +1,164 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// UpgradeOpts describes the upgrade bundle built from the ops of the installer bundle that changed since the previous
+// build: artifacts with new digests and manifests with new content, followed by the waits of the affected stages
+type UpgradeOpts struct {
+	// From is the previous basedir or its artifacts lock file, manifests are only compared if it's a basedir
+	From string
+	// Source is the installer bundle to take changed ops from
+	Source Bundle
+	// Target is the upgrade bundle, it's only built when upgrade is requested
+	Target Bundle
+	// ManifestsTarget is the install target of the manifests (e.g. k3s auto-deploy dir) that are applied on upgrade
+	ManifestsTarget string
+}
+
+// upgradeFrom is the previous build to compare with
+type upgradeFrom struct {
+	lock *ArtifactsLock
+	// manifests are the previous manifests by file name, nil if the previous bundle isn't available
+	manifests map[string]string
+}
+
+// previous loads the artifacts lock and the manifests (only if it's a basedir) of the previous build
+func (opts *UpgradeOpts) previous() (*upgradeFrom, error) {
+	lockPath, bundleDir := opts.From, ""
+
+	stat, err := os.Stat(opts.From)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error checking upgrade source %s", opts.From)
+	}
+	if stat.IsDir() {
+		lockPath = filepath.Join(opts.From, LockFile)
+		bundleDir = filepath.Join(opts.From, opts.Source.Name)
+	}
+
+	// missing lock would mean that everything has changed, so it's better to fail
+	if _, err := os.Stat(lockPath); err != nil {
+		return nil, errors.Wrapf(err, "error checking artifacts lock %s", lockPath)
+	}
+
+	prev := &upgradeFrom{lock: &ArtifactsLock{}}
+	if err := prev.lock.LoadFile(lockPath); err != nil {
+		return nil, errors.Wrapf(err, "error loading artifacts lock %s", lockPath)
+	}
+
+	if bundleDir == "" {
+		return prev, nil
+	}
+
+	entries, err := os.ReadDir(bundleDir)
+	if os.IsNotExist(err) {
+		slog.Warn("Previous bundle not found, all manifests will be re-applied", "bundle", opts.Source.Name)
+
+		return prev, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading previous bundle %s", bundleDir)
+	}
+
+	prev.manifests = map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".yaml" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(bundleDir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading previous %s", entry.Name())
+		}
+		prev.manifests[entry.Name()] = string(data)
+	}
+
+	return prev, nil
+}
+
+// addUpgradeOps adds changed build ops of the source bundle (artifacts should be already pinned) and their run ops to
+// the upgrade bundle keeping the original order, waits from the stages with changes are added as well
+func (mngr *Manager) addUpgradeOps(opts *UpgradeOpts, prev *upgradeFrom, builds []buildContext, actions map[Bundle][][]recipeContext) ([]buildContext, error) {
+	changed := map[string]bool{}
+	changedStages := map[Stage]bool{}
+	upgrades := []buildContext{}
+
+	for _, build := range builds {
+		if build.bundle != opts.Source {
+			continue
+		}
+
+		switch op := build.op.(type) {
+		case ArtifactBuildOp:
+			ref := op.Artifact()
+			prevDigest := prev.lock.Artifacts[ref.String()]
+			if prevDigest == ref.Digest {
+				continue
+			}
+
+			slog.Info("Artifact changed", "name", build.name, "ref", ref.String(), "from", prevDigest, "to", ref.Digest)
+		case *FileGenerate:
+			if op.File.InstallTarget != opts.ManifestsTarget {
+				continue
+			}
+
+			content, err := op.Content()
+			if err != nil {
+				return nil, errors.Wrapf(err, "error generating content for %s", build.name)
+			}
+
+			if prevContent, exist := prev.manifests[op.File.Name]; exist && prevContent == content {
+				continue
+			}
+
+			slog.Info("Manifest changed", "name", build.name, "file", op.File.Name)
+		default:
+			continue
+		}
+
+		changed[build.name] = true
+		changedStages[build.stage] = true
+
+		build.bundle = opts.Target
+		upgrades = append(upgrades, build)
+	}
+
+	for stage, stageActions := range actions[opts.Source] {
+		for _, action := range stageActions {
+			_, isWait := action.op.(*WaitKube)
+			if !changed[action.name] && !(isWait && changedStages[Stage(stage)]) {
+				continue
+			}
+
+			action.bundle = opts.Target
+			actions[opts.Target][stage] = append(actions[opts.Target][stage], action)
+		}
+	}
+
+	if len(upgrades) == 0 {
+		slog.Warn("Nothing changed since the previous build, upgrade bundle is empty", "bundle", opts.Target.Name)
+	} else {
+		slog.Info("Upgrade bundle planned", "bundle", opts.Target.Name, "changed", len(upgrades), "stages", len(changedStages))
+	}
+
+	return append(builds, upgrades...), nil
+}
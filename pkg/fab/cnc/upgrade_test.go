@@ -0,0 +1,217 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_AddUpgradeOps(t *testing.T) {
+	source := Bundle{Name: "control-install", IsInstaller: true}
+	target := Bundle{Name: "control-upgrade"}
+
+	digest1 := "sha256:" + strings.Repeat("1", 64)
+	digest2 := "sha256:" + strings.Repeat("2", 64)
+
+	prev := &upgradeFrom{
+		lock: &ArtifactsLock{Artifacts: map[string]string{
+			"ghcr.io/githedgehog/image:v1": digest1,
+		}},
+		manifests: map[string]string{
+			"manifest.yaml": "kind: Test\n",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		tag      string
+		digest   string
+		manifest string
+		builds   []string
+		want     []string
+	}{
+		{
+			name:     "unchanged",
+			tag:      "v1",
+			digest:   digest1,
+			manifest: "kind: Test\n",
+			builds:   []string{},
+			want:     []string{},
+		},
+		{
+			name:     "tag-bump",
+			tag:      "v2",
+			digest:   digest2,
+			manifest: "kind: Test\n",
+			builds:   []string{"image"},
+			want:     []string{"image", "image-wait"},
+		},
+		{
+			name:     "digest-only",
+			tag:      "v1",
+			digest:   digest2,
+			manifest: "kind: Test\n",
+			builds:   []string{"image"},
+			want:     []string{"image", "image-wait"},
+		},
+		{
+			name:     "manifest-changed",
+			tag:      "v1",
+			digest:   digest1,
+			manifest: "kind: Changed\n",
+			builds:   []string{"manifest"},
+			want:     []string{"manifest", "manifest-wait"},
+		},
+		{
+			name:     "all-changed",
+			tag:      "v2",
+			digest:   digest2,
+			manifest: "kind: Changed\n",
+			builds:   []string{"image", "manifest"},
+			want:     []string{"image", "image-wait", "manifest", "manifest-wait"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &UpgradeOpts{Source: source, Target: target, ManifestsTarget: "/manifests"}
+
+			builds := []buildContext{
+				{bundle: source, stage: 0, name: "image", op: &SyncOCI{
+					Ref: Ref{Repo: "ghcr.io/githedgehog", Name: "image", Tag: test.tag, Digest: test.digest},
+				}},
+				{bundle: source, stage: 1, name: "manifest", op: &FileGenerate{
+					File:    File{Name: "manifest.yaml", InstallTarget: "/manifests"},
+					Content: FromValue(test.manifest),
+				}},
+				{bundle: source, stage: 1, name: "config", op: &FileGenerate{
+					File:    File{Name: "config.yaml", InstallTarget: "/etc"},
+					Content: FromValue("changed"),
+				}},
+			}
+
+			actions := map[Bundle][][]recipeContext{
+				source: {
+					{
+						{bundle: source, stage: 0, name: "image", op: &PushOCI{}},
+						{bundle: source, stage: 0, name: "image-wait", op: &WaitKube{}},
+					},
+					{
+						{bundle: source, stage: 1, name: "manifest", op: &InstallFile{}},
+						{bundle: source, stage: 1, name: "config", op: &InstallFile{}},
+						{bundle: source, stage: 1, name: "manifest-wait", op: &WaitKube{}},
+					},
+				},
+				target: make([][]recipeContext, 2),
+			}
+
+			res, err := (&Manager{}).addUpgradeOps(opts, prev, builds, actions)
+			if err != nil {
+				t.Fatalf("error adding upgrade ops: %v", err)
+			}
+
+			upgraded := []string{}
+			for _, build := range res {
+				if build.bundle == target {
+					upgraded = append(upgraded, build.name)
+				}
+			}
+			if !reflect.DeepEqual(upgraded, test.builds) {
+				t.Errorf("upgrade build ops mismatch: got %v, want %v", upgraded, test.builds)
+			}
+
+			got := []string{}
+			for _, stageActions := range actions[target] {
+				for _, action := range stageActions {
+					if action.bundle != target {
+						t.Errorf("action %s isn't moved to the upgrade bundle", action.name)
+					}
+					got = append(got, action.name)
+				}
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("upgrade actions mismatch: got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func Test_UpgradePrevious(t *testing.T) {
+	source := Bundle{Name: "control-install", IsInstaller: true}
+	digest := "sha256:" + strings.Repeat("1", 64)
+
+	basedir := t.TempDir()
+	if err := (&ArtifactsLock{Artifacts: map[string]string{"ghcr.io/githedgehog/image:v1": digest}}).Save(basedir); err != nil {
+		t.Fatalf("error saving lock: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(basedir, source.Name), 0o755); err != nil {
+		t.Fatalf("error creating bundle dir: %v", err)
+	}
+	for name, content := range map[string]string{"manifest.yaml": "kind: Test\n", "hhfab-recipe": "binary"} {
+		if err := os.WriteFile(filepath.Join(basedir, source.Name, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("error writing %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		from      string
+		manifests map[string]string
+		err       bool
+	}{
+		{
+			name:      "basedir",
+			from:      basedir,
+			manifests: map[string]string{"manifest.yaml": "kind: Test\n"},
+		},
+		{
+			name: "lock-file",
+			from: filepath.Join(basedir, LockFile),
+		},
+		{
+			name: "missing-lock",
+			from: t.TempDir(),
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prev, err := (&UpgradeOpts{From: test.from, Source: source}).previous()
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("error loading previous build: %v", err)
+			}
+
+			if prev.lock.Artifacts["ghcr.io/githedgehog/image:v1"] != digest {
+				t.Errorf("unexpected lock %v", prev.lock.Artifacts)
+			}
+			if !reflect.DeepEqual(prev.manifests, test.manifests) {
+				t.Errorf("manifests mismatch: got %v, want %v", prev.manifests, test.manifests)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultKubeconfig is used if kubeconfig isn't specified for the op and KUBECONFIG env var isn't set
+const DefaultKubeconfig = "/etc/rancher/k3s/k3s.yaml"
+
+const (
+	WaitKubeRolloutNone = ""
+	WaitKubeRollout     = "rollout"
+	WaitKubeHelmChart   = "helmchart"
+)
+
+type waitKubeShortcut struct {
+	apiVersion string
+	kind       string
+	condition  string
+	special    string
+}
+
+// waitKubeShortcuts are used to keep recipes short and readable, name in form of "shortcut/name" is expanded to GVK
+// and default readiness check for it
+var waitKubeShortcuts = map[string]waitKubeShortcut{
+	"deployment":   {apiVersion: "apps/v1", kind: "Deployment", condition: "Available"},
+	"statefulset":  {apiVersion: "apps/v1", kind: "StatefulSet", special: WaitKubeRollout},
+	"daemonset":    {apiVersion: "apps/v1", kind: "DaemonSet", special: WaitKubeRollout},
+	"job":          {apiVersion: "batch/v1", kind: "Job", condition: "Complete"},
+	"helmchart":    {apiVersion: "helm.cattle.io/v1", kind: "HelmChart", special: WaitKubeHelmChart},
+	"controlagent": {apiVersion: "agent.githedgehog.com/v1alpha2", kind: "ControlAgent", condition: "Applied"},
+	"node":         {apiVersion: "v1", kind: "Node", condition: "Ready"},
+	"crd":          {apiVersion: "apiextensions.k8s.io/v1", kind: "CustomResourceDefinition", condition: "Established"},
+}
+
+//
+// RunOp WaitKube
+//
+
+// WaitKube waits for the kube object to exist and to be ready, readiness is defined by the condition (e.g. Available
+// or Ready=False), JSONPath expression with optional expected value, rollout status or HelmChart job completion
+type WaitKube struct {
+	// Name is object name or "shortcut/name", e.g. deployment/cert-manager
+	Name       string `json:"name,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	// Condition is a condition type with optional status, e.g. Available or Ready=False
+	Condition string `json:"condition,omitempty"`
+	// JSONPath is evaluated on the object and compared with JSONPathValue (any non-empty result if value is empty)
+	JSONPath      string `json:"jsonPath,omitempty"`
+	JSONPathValue string `json:"jsonPathValue,omitempty"`
+	// Special is one of the built-in readiness checks: rollout or helmchart
+	Special         string        `json:"special,omitempty"`
+	Kubeconfig      string        `json:"kubeconfig,omitempty"`
+	Timeout         time.Duration `json:"timeout,omitempty"`
+	TimeoutResource time.Duration `json:"timeoutResource,omitempty"`
+	Interval        time.Duration `json:"interval,omitempty"`
+}
+
+var _ RunOp = (*WaitKube)(nil)
+
+func (op *WaitKube) Hydrate() error {
+	if op.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	if shortcut, name, ok := strings.Cut(op.Name, "/"); ok {
+		defaults, exist := waitKubeShortcuts[strings.ToLower(shortcut)]
+		if !exist {
+			return errors.Errorf("unknown kind shortcut %s, use apiVersion and kind instead", shortcut)
+		}
+		if op.APIVersion != "" || op.Kind != "" {
+			return errors.New("apiVersion and kind shouldn't be set if name has kind shortcut")
+		}
+
+		op.Name = name
+		op.APIVersion = defaults.apiVersion
+		op.Kind = defaults.kind
+		if op.Condition == "" && op.JSONPath == "" && op.Special == "" {
+			op.Condition = defaults.condition
+			op.Special = defaults.special
+		}
+	}
+
+	if op.APIVersion == "" || op.Kind == "" {
+		return errors.New("apiVersion and kind are required")
+	}
+	if _, err := schema.ParseGroupVersion(op.APIVersion); err != nil {
+		return errors.Wrapf(err, "invalid apiVersion %s", op.APIVersion)
+	}
+
+	if op.Special != WaitKubeRolloutNone && op.Special != WaitKubeRollout && op.Special != WaitKubeHelmChart {
+		return errors.Errorf("unknown special check %s", op.Special)
+	}
+	if op.JSONPath != "" {
+		if _, err := parseWaitJSONPath(op.JSONPath); err != nil {
+			return err
+		}
+	}
+
+	if op.Namespace == "" {
+		op.Namespace = "default"
+	}
+	if op.Timeout == 0 {
+		op.Timeout = 10 * time.Minute
+	}
+	if op.TimeoutResource == 0 {
+		op.TimeoutResource = 10 * time.Minute
+	}
+	if op.Interval == 0 {
+		op.Interval = 3 * time.Second
+	}
+
+	return nil
+}
+
+func (op *WaitKube) Summary() string {
+	return fmt.Sprintf("wait %s %s/%s", strings.ToLower(op.Kind), op.Namespace, op.Name)
+}
+
+func (op *WaitKube) Run(_ string) error {
+	kubeconfig := op.Kubeconfig
+	if kubeconfig == "" {
+		kubeconfig = os.Getenv("KUBECONFIG")
+	}
+	if kubeconfig == "" {
+		kubeconfig = DefaultKubeconfig
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return errors.Wrapf(err, "error loading kubeconfig %s", kubeconfig)
+	}
+
+	kube, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return errors.Wrapf(err, "error creating kube client")
+	}
+
+	return op.Wait(context.Background(), kube)
+}
+
+// Wait waits for the object to exist and then to be ready, error includes last observed status and object events
+func (op *WaitKube) Wait(ctx context.Context, kube client.Client) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(op.APIVersion)
+	obj.SetKind(op.Kind)
+	key := client.ObjectKey{Namespace: op.Namespace, Name: op.Name}
+
+	slog.Debug("Waiting for object to exist", "kind", op.Kind, "namespace", op.Namespace, "name", op.Name)
+
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, op.Interval, op.TimeoutResource, true, func(ctx context.Context) (bool, error) {
+		lastErr = kube.Get(ctx, key, obj)
+		if apierrors.IsNotFound(lastErr) {
+			return false, nil
+		}
+
+		// CRD could be not installed yet, so it's ok to get no match for kind
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		if lastErr != nil {
+			err = errors.Wrapf(lastErr, "%s", err.Error())
+		}
+
+		return errors.Wrapf(err, "error waiting for %s %s to exist", op.Kind, key)
+	}
+
+	slog.Debug("Waiting for object to be ready", "kind", op.Kind, "namespace", op.Namespace, "name", op.Name)
+
+	reason := ""
+	err = wait.PollUntilContextTimeout(ctx, op.Interval, op.Timeout, true, func(ctx context.Context) (bool, error) {
+		if err := kube.Get(ctx, key, obj); err != nil {
+			reason = err.Error()
+
+			return false, nil
+		}
+
+		ready, msg, err := op.isReady(ctx, kube, obj)
+		if err != nil {
+			return false, err
+		}
+		reason = msg
+
+		return ready, nil
+	})
+	if err != nil {
+		return op.richError(ctx, kube, obj, errors.Wrapf(err, "error waiting for %s %s to be ready: %s", op.Kind, key, reason))
+	}
+
+	return nil
+}
+
+// isReady returns true if object is ready and the reason if it's not
+func (op *WaitKube) isReady(ctx context.Context, kube client.Client, obj *unstructured.Unstructured) (bool, string, error) {
+	if op.Condition != "" {
+		if ready, msg := checkCondition(obj, op.Condition); !ready {
+			return false, msg, nil
+		}
+	}
+
+	if op.JSONPath != "" {
+		value, err := evalWaitJSONPath(obj, op.JSONPath)
+		if err != nil {
+			return false, "", err
+		}
+
+		if (op.JSONPathValue == "" && value == "") || (op.JSONPathValue != "" && value != op.JSONPathValue) {
+			return false, fmt.Sprintf("jsonpath %s is %q, expected %q", op.JSONPath, value, op.JSONPathValue), nil
+		}
+	}
+
+	switch op.Special {
+	case WaitKubeRollout:
+		if ready, msg := checkRollout(obj); !ready {
+			return false, msg, nil
+		}
+	case WaitKubeHelmChart:
+		jobName, _, _ := unstructured.NestedString(obj.Object, "status", "jobName")
+		if jobName == "" {
+			return false, "helm chart job isn't created yet", nil
+		}
+
+		job := &unstructured.Unstructured{}
+		job.SetAPIVersion("batch/v1")
+		job.SetKind("Job")
+		if err := kube.Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: jobName}, job); err != nil {
+			return false, fmt.Sprintf("error getting helm chart job %s: %s", jobName, err), nil
+		}
+
+		if failed, _ := checkCondition(job, "Failed"); failed {
+			return false, fmt.Sprintf("helm chart job %s failed", jobName), nil
+		}
+		if ready, msg := checkCondition(job, "Complete"); !ready {
+			return false, fmt.Sprintf("helm chart job %s: %s", jobName, msg), nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// checkCondition checks that object has condition in form of Type or Type=Status (status is True if not specified)
+func checkCondition(obj *unstructured.Unstructured, condition string) (bool, string) {
+	condType, condStatus, ok := strings.Cut(condition, "=")
+	if !ok {
+		condStatus = "True"
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, raw := range conditions {
+		cond, ok := raw.(map[string]any)
+		if !ok || !strings.EqualFold(fmt.Sprint(cond["type"]), condType) {
+			continue
+		}
+
+		if strings.EqualFold(fmt.Sprint(cond["status"]), condStatus) {
+			return true, ""
+		}
+
+		return false, fmt.Sprintf("condition %s is %v (expected %s): %v %v", condType, cond["status"], condStatus, cond["reason"], cond["message"])
+	}
+
+	return false, fmt.Sprintf("condition %s not found", condType)
+}
+
+// checkRollout checks that all replicas of the deployment, statefulset or daemonset are updated and available
+func checkRollout(obj *unstructured.Unstructured) (bool, string) {
+	observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if observed < obj.GetGeneration() {
+		return false, "waiting for spec update to be observed"
+	}
+
+	status := func(field string) int64 {
+		val, _, _ := unstructured.NestedInt64(obj.Object, "status", field)
+
+		return val
+	}
+
+	if obj.GetKind() == "DaemonSet" {
+		desired := status("desiredNumberScheduled")
+		if updated := status("updatedNumberScheduled"); updated < desired {
+			return false, fmt.Sprintf("%d of %d updated pods are scheduled", updated, desired)
+		}
+		if available := status("numberAvailable"); available < desired {
+			return false, fmt.Sprintf("%d of %d updated pods are available", available, desired)
+		}
+
+		return true, ""
+	}
+
+	desired, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		desired = 1
+	}
+	if updated := status("updatedReplicas"); updated < desired {
+		return false, fmt.Sprintf("%d of %d replicas are updated", updated, desired)
+	}
+	if ready := status("readyReplicas"); ready < desired {
+		return false, fmt.Sprintf("%d of %d replicas are ready", ready, desired)
+	}
+
+	return true, ""
+}
+
+func parseWaitJSONPath(expr string) (*jsonpath.JSONPath, error) {
+	if !strings.HasPrefix(expr, "{") {
+		expr = "{" + expr + "}"
+	}
+
+	jp := jsonpath.New("wait").AllowMissingKeys(true)
+	if err := jp.Parse(expr); err != nil {
+		return nil, errors.Wrapf(err, "error parsing jsonpath %s", expr)
+	}
+
+	return jp, nil
+}
+
+func evalWaitJSONPath(obj *unstructured.Unstructured, expr string) (string, error) {
+	jp, err := parseWaitJSONPath(expr)
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := jp.Execute(buf, obj.Object); err != nil {
+		return "", errors.Wrapf(err, "error evaluating jsonpath %s", expr)
+	}
+
+	return buf.String(), nil
+}
+
+// richError adds last observed status and events for the object to the error
+func (op *WaitKube) richError(ctx context.Context, kube client.Client, obj *unstructured.Unstructured, err error) error {
+	// context could be already expired, but we still want to collect some details
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	details := ""
+
+	if status, found, _ := unstructured.NestedMap(obj.Object, "status"); found {
+		if data, err := yaml.Marshal(status); err == nil {
+			details += "\nLast observed status:\n" + string(data)
+		}
+	}
+
+	events := &core.EventList{}
+	if listErr := kube.List(ctx, events, client.InNamespace(op.Namespace)); listErr == nil {
+		items := []core.Event{}
+		for _, event := range events.Items {
+			if event.InvolvedObject.Name == op.Name && event.InvolvedObject.Kind == op.Kind {
+				items = append(items, event)
+			}
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].LastTimestamp.Before(&items[j].LastTimestamp)
+		})
+
+		if len(items) > 0 {
+			details += "Events:\n"
+			for _, event := range items {
+				details += fmt.Sprintf("  %s %s %s: %s\n", event.LastTimestamp.Format(time.DateTime), event.Type, event.Reason, event.Message)
+			}
+		}
+	} else {
+		slog.Debug("Error listing events", "err", listErr)
+	}
+
+	if details == "" {
+		return err
+	}
+
+	return errors.Wrapf(err, "%s", strings.TrimRight(details, "\n"))
+}
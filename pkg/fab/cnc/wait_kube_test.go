@@ -0,0 +1,244 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnc
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+func Test_WaitKubeHydrate(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     WaitKube
+		result WaitKube
+		error  bool
+	}{
+		{
+			name: "deployment",
+			op:   WaitKube{Name: "deployment/cert-manager"},
+			result: WaitKube{
+				Name: "cert-manager", Namespace: "default", APIVersion: "apps/v1", Kind: "Deployment", Condition: "Available",
+			},
+		},
+		{
+			name: "daemonset",
+			op:   WaitKube{Name: "daemonset/das-boot-seeder"},
+			result: WaitKube{
+				Name: "das-boot-seeder", Namespace: "default", APIVersion: "apps/v1", Kind: "DaemonSet", Special: WaitKubeRollout,
+			},
+		},
+		{
+			name: "helmchart",
+			op:   WaitKube{Name: "helmchart/zot", Namespace: "kube-system"},
+			result: WaitKube{
+				Name: "zot", Namespace: "kube-system", APIVersion: "helm.cattle.io/v1", Kind: "HelmChart", Special: WaitKubeHelmChart,
+			},
+		},
+		{
+			name: "shortcut-custom-condition",
+			op:   WaitKube{Name: "controlagent/control-1", Condition: "Applied=False"},
+			result: WaitKube{
+				Name: "control-1", Namespace: "default", APIVersion: "agent.githedgehog.com/v1alpha2", Kind: "ControlAgent", Condition: "Applied=False",
+			},
+		},
+		{
+			name: "gvk-jsonpath",
+			op:   WaitKube{Name: "test", APIVersion: "v1", Kind: "Pod", JSONPath: ".status.phase", JSONPathValue: "Running"},
+			result: WaitKube{
+				Name: "test", Namespace: "default", APIVersion: "v1", Kind: "Pod", JSONPath: ".status.phase", JSONPathValue: "Running",
+			},
+		},
+		{
+			name:  "empty",
+			op:    WaitKube{},
+			error: true,
+		},
+		{
+			name:  "unknown-shortcut",
+			op:    WaitKube{Name: "pod/test"},
+			error: true,
+		},
+		{
+			name:  "shortcut-and-kind",
+			op:    WaitKube{Name: "deployment/test", Kind: "Deployment"},
+			error: true,
+		},
+		{
+			name:  "no-kind",
+			op:    WaitKube{Name: "test"},
+			error: true,
+		},
+		{
+			name:  "invalid-jsonpath",
+			op:    WaitKube{Name: "test", APIVersion: "v1", Kind: "Pod", JSONPath: ".status[.phase"},
+			error: true,
+		},
+		{
+			name:  "unknown-special",
+			op:    WaitKube{Name: "test", APIVersion: "v1", Kind: "Pod", Special: "magic"},
+			error: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op.Hydrate()
+			if tt.error && err == nil {
+				t.Errorf("Hydrate() expected error, got nil")
+			}
+			if !tt.error && err != nil {
+				t.Errorf("Hydrate() expected no error, got %v", err)
+			}
+			if tt.error {
+				return
+			}
+
+			tt.result.Timeout = 10 * time.Minute
+			tt.result.TimeoutResource = 10 * time.Minute
+			tt.result.Interval = 3 * time.Second
+			if tt.op != tt.result {
+				t.Errorf("Hydrate() expected %+v, got %+v", tt.result, tt.op)
+			}
+
+			// hydrated op should stay the same after saving and loading the recipe
+			again := tt.op
+			if err := again.Hydrate(); err != nil || again != tt.op {
+				t.Errorf("Hydrate() isn't idempotent, got %+v (err %v)", again, err)
+			}
+		})
+	}
+}
+
+func Test_checkCondition(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"status": map[string]any{
+			"conditions": []any{
+				map[string]any{"type": "Available", "status": "True"},
+				map[string]any{"type": "Progressing", "status": "False", "reason": "Stuck"},
+			},
+		},
+	}}
+
+	tests := []struct {
+		condition string
+		result    bool
+	}{
+		{condition: "Available", result: true},
+		{condition: "available", result: true},
+		{condition: "Available=True", result: true},
+		{condition: "Available=False", result: false},
+		{condition: "Progressing", result: false},
+		{condition: "Progressing=False", result: true},
+		{condition: "Missing", result: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			result, msg := checkCondition(obj, tt.condition)
+			if result != tt.result {
+				t.Errorf("checkCondition(%s) expected %t, got %t (%s)", tt.condition, tt.result, result, msg)
+			}
+		})
+	}
+}
+
+func Test_WaitKubeEnvtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS isn't set, skipping envtest")
+	}
+
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("error starting envtest: %v", err)
+	}
+	defer func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("error stopping envtest: %v", err)
+		}
+	}()
+
+	kube, err := client.New(cfg, client.Options{})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	ctx := context.Background()
+
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetNamespace("default")
+	cm.SetName("test")
+	if err := unstructured.SetNestedField(cm.Object, "ready", "data", "state"); err != nil {
+		t.Fatalf("error setting data: %v", err)
+	}
+	if err := kube.Create(ctx, cm); err != nil {
+		t.Fatalf("error creating configmap: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		op    WaitKube
+		error string
+	}{
+		{
+			name: "jsonpath-match",
+			op:   WaitKube{Name: "test", APIVersion: "v1", Kind: "ConfigMap", JSONPath: ".data.state", JSONPathValue: "ready"},
+		},
+		{
+			name:  "jsonpath-mismatch",
+			op:    WaitKube{Name: "test", APIVersion: "v1", Kind: "ConfigMap", JSONPath: ".data.state", JSONPathValue: "done"},
+			error: "to be ready",
+		},
+		{
+			name:  "missing",
+			op:    WaitKube{Name: "missing", APIVersion: "v1", Kind: "ConfigMap"},
+			error: "to exist",
+		},
+		{
+			name:  "deployment-condition",
+			op:    WaitKube{Name: "deployment/test"},
+			error: "to exist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.op.Timeout = 2 * time.Second
+			tt.op.TimeoutResource = 2 * time.Second
+			tt.op.Interval = 100 * time.Millisecond
+			if err := tt.op.Hydrate(); err != nil {
+				t.Fatalf("Hydrate() expected no error, got %v", err)
+			}
+
+			err := tt.op.Wait(ctx, kube)
+			if tt.error == "" && err != nil {
+				t.Errorf("Wait() expected no error, got %v", err)
+			}
+			if tt.error != "" && (err == nil || !strings.Contains(err.Error(), tt.error)) {
+				t.Errorf("Wait() expected error containing %q, got %v", tt.error, err)
+			}
+		})
+	}
+}
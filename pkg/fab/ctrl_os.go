@@ -22,9 +22,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"go.githedgehog.com/fabric/api/meta"
-	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
 	"go.githedgehog.com/fabric/pkg/wiring"
 	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	"go.githedgehog.com/fabricator/pkg/fab/vlab"
 )
 
 const (
@@ -42,7 +42,10 @@ type ControlOS struct {
 	PasswordHash string `json:"passwordHash,omitempty"`
 }
 
-var _ cnc.Component = (*ControlOS)(nil)
+var (
+	_ cnc.Component       = (*ControlOS)(nil)
+	_ cnc.SecretsProvider = (*ControlOS)(nil)
+)
 
 func (cfg *ControlOS) Name() string {
 	return "control-os"
@@ -52,6 +55,10 @@ func (cfg *ControlOS) IsEnabled(_ cnc.Preset) bool {
 	return true
 }
 
+func (cfg *ControlOS) SecretFields() []string {
+	return []string{"passwordHash"}
+}
+
 func (cfg *ControlOS) Flags() []cli.Flag {
 	return []cli.Flag{
 		&cli.StringFlag{
@@ -64,14 +71,15 @@ func (cfg *ControlOS) Flags() []cli.Flag {
 	}
 }
 
-func (cfg *ControlOS) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
+func (cfg *ControlOS) Hydrate(_ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent) error {
 	// TODO add ignition template to the config?
 
 	return nil
 }
 
-func (cfg *ControlOS) Build(_ string, _ cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, data *wiring.Data, run cnc.AddBuildOp, _ cnc.AddRunOp) error {
-	hostname, err := getControlNodeName(data)
+func (cfg *ControlOS) Build(_ string, preset cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, data *wiring.Data, run cnc.AddBuildOp, _ cnc.AddRunOp) error {
+	k3s := K3sConfig(get)
+	nodes, err := k3s.controlNodes(preset, data)
 	if err != nil {
 		return err
 	}
@@ -90,35 +98,77 @@ func (cfg *ControlOS) Build(_ string, _ cnc.Preset, _ meta.FabricMode, get cnc.G
 		return errors.Errorf("no authorized keys or password found for control node, you'll not be able to login")
 	}
 
-	controlVIP := ControlVIP + ControlVIPMask
-
-	run(BundleControlOS, Stage, "ignition-control",
-		&cnc.FileGenerate{
-			File: cnc.File{
-				Name: ControlOSIgnition,
-			},
-			Content: cnc.IgnitionFromButaneTemplate(controlButaneTemplate,
-				"cfg", cfg,
-				"username", username,
-				"hostname", hostname,
-				"authorizedKeys", authorizedKeys,
-				"ports", buildControlPorts(data),
-				"controlVIP", controlVIP,
-				"passwordHash", cfg.PasswordHash,
-			),
-		})
+	// in kube-vip mode VIP is managed by the kube-vip itself
+	controlVIP := ""
+	if k3s.VIPMode == VIPModeLoopback {
+		controlVIP = BaseConfig(get).ControlVIP + ControlVIPMask
+	}
+
+	for _, node := range nodes {
+		opName := "ignition-control"
+		if node.Join {
+			opName = "ignition-" + node.Name
+		}
+
+		run(BundleControlOS, Stage, opName,
+			&cnc.FileGenerate{
+				File: cnc.File{
+					Name: ControlOSIgnitionFor(node.Name, node.Join),
+				},
+				Content: cnc.IgnitionFromButaneTemplate(controlButaneTemplate,
+					"cfg", cfg,
+					"username", username,
+					"hostname", node.Name,
+					"authorizedKeys", authorizedKeys,
+					"ports", buildControlPorts(data, node.Name),
+					"controlVIP", controlVIP,
+					"clusterIface", node.Iface,
+					"clusterIP", node.IP,
+					"passwordHash", cfg.PasswordHash,
+				),
+			})
+	}
 
 	return nil
 }
 
+// ControlOSIgnitionFor returns ignition file name for the control node, the first one is kept as it always was
+func ControlOSIgnitionFor(name string, join bool) string {
+	if !join {
+		return ControlOSIgnition
+	}
+
+	return fmt.Sprintf("%s.ignition.json", name)
+}
+
+// getControlNodes returns names of the control nodes sorted by name, the first one is initializing the cluster and the
+// rest are joining it
+func getControlNodes(data *wiring.Data) ([]string, error) {
+	nodes := vlab.ControlNodes(data)
+	if len(nodes) == 0 {
+		return nil, errors.New("no control node found")
+	}
+
+	return nodes, nil
+}
+
+// getControlNodeName returns name of the control node initializing the cluster
 func getControlNodeName(data *wiring.Data) (string, error) {
-	for _, server := range data.Server.All() {
-		if server.Spec.Type == wiringapi.ServerTypeControl {
-			return server.Name, nil
-		}
+	nodes, err := getControlNodes(data)
+	if err != nil {
+		return "", err
+	}
+
+	return nodes[0], nil
+}
+
+// controlInstallBundle returns installer bundle for the control node
+func controlInstallBundle(node k3sNode) cnc.Bundle {
+	if node.Join {
+		return BundleControlJoin(node.Name)
 	}
 
-	return "", errors.New("no control node found")
+	return BundleControlInstall
 }
 
 type renderPort struct {
@@ -129,11 +179,12 @@ type renderPort struct {
 	MAC        string
 }
 
-func buildControlPorts(data *wiring.Data) []renderPort {
+// buildControlPorts returns management ports of the control node
+func buildControlPorts(data *wiring.Data, node string) []renderPort {
 	res := []renderPort{}
 
 	for idx, conn := range data.Connection.All() {
-		if conn.Spec.Management == nil {
+		if conn.Spec.Management == nil || conn.Spec.Management.Link.Server.DeviceName() != node {
 			continue
 		}
 
@@ -0,0 +1,383 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fab
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	helm "github.com/k3s-io/helm-controller/pkg/apis/helm.cattle.io/v1"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"go.githedgehog.com/fabric/api/meta"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	"sigs.k8s.io/yaml"
+)
+
+// Stage names to be used in custom components
+var CustomStages = map[string]cnc.Stage{
+	"prep":     StageInstall0Prep,
+	"k3s-zot":  StageInstall1K3sZot,
+	"misc":     StageInstall2Misc,
+	"fabric":   StageInstall3Fabric,
+	"das-boot": StageInstall4DasBoot,
+	"final":    StageInstall9Reloader,
+}
+
+const CustomStageDefault = "final"
+
+// Bundles custom components could add ops to
+var CustomBundles = []cnc.Bundle{BundleControlInstall, BundleServerInstall}
+
+// Custom is a user-defined component declared in YAML that syncs images and charts into the registry, installs
+// HelmCharts with templated values and files, and waits for things to be ready
+type Custom struct {
+	ComponentName string       `json:"name,omitempty"`
+	Presets       []cnc.Preset `json:"presets,omitempty"`
+	CustomPlacement
+	Images     []CustomOCI       `json:"images,omitempty"`
+	Charts     []CustomOCI       `json:"charts,omitempty"`
+	Files      []CustomFiles     `json:"files,omitempty"`
+	HelmCharts []CustomHelmChart `json:"helmCharts,omitempty"`
+	Waits      []CustomWait      `json:"waits,omitempty"`
+}
+
+// CustomPlacement is a bundle and stage to add ops to, it's inherited from the component if not set
+type CustomPlacement struct {
+	Bundle string `json:"bundle,omitempty"`
+	Stage  string `json:"stage,omitempty"`
+}
+
+// CustomOCI is an image or chart synced into the registry on the control node
+type CustomOCI struct {
+	CustomPlacement
+	Name   string  `json:"name,omitempty"`
+	Ref    cnc.Ref `json:"ref,omitempty"`
+	Target cnc.Ref `json:"target,omitempty"`
+}
+
+// CustomFiles are files downloaded from OCI artifact and installed to the node
+type CustomFiles struct {
+	CustomPlacement
+	Name  string       `json:"name,omitempty"`
+	Ref   cnc.Ref      `json:"ref,omitempty"`
+	Files []CustomFile `json:"files,omitempty"`
+}
+
+type CustomFile struct {
+	Name          string      `json:"name,omitempty"`
+	InstallTarget string      `json:"installTarget,omitempty"`
+	InstallName   string      `json:"installName,omitempty"`
+	InstallMode   os.FileMode `json:"installMode,omitempty"`
+}
+
+// CustomHelmChart is a HelmChart installed from the chart synced into the registry, values are the template with
+// access to base config (.base), synced images refs in registry by name (.images) and control VIP (.controlVIP)
+type CustomHelmChart struct {
+	CustomPlacement
+	Name            string `json:"name,omitempty"`
+	Namespace       string `json:"namespace,omitempty"`
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+	// Chart is a name of the chart from the charts list
+	Chart  string `json:"chart,omitempty"`
+	Values string `json:"values,omitempty"`
+}
+
+// CustomWait waits for the kube object to be ready, name could be in form of "deployment/name"
+type CustomWait struct {
+	CustomPlacement
+	cnc.WaitKube
+}
+
+var _ cnc.Component = (*Custom)(nil)
+
+func (cfg *Custom) Name() string {
+	return cfg.ComponentName
+}
+
+func (cfg *Custom) IsEnabled(preset cnc.Preset) bool {
+	return len(cfg.Presets) == 0 || slices.Contains(cfg.Presets, preset)
+}
+
+func (cfg *Custom) Flags() []cli.Flag {
+	return nil
+}
+
+func (cfg *Custom) Hydrate(_ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent) error {
+	if cfg.Bundle == "" {
+		cfg.Bundle = BundleControlInstall.Name
+	}
+	if cfg.Stage == "" {
+		cfg.Stage = CustomStageDefault
+	}
+
+	for idx := range cfg.HelmCharts {
+		chart := &cfg.HelmCharts[idx]
+		if chart.Namespace == "" {
+			chart.Namespace = "default"
+		}
+		if chart.TargetNamespace == "" {
+			chart.TargetNamespace = chart.Namespace
+		}
+	}
+
+	return nil
+}
+
+// placement returns bundle and stage for the item falling back to the component ones
+func (cfg *Custom) placement(item CustomPlacement) (cnc.Bundle, cnc.Stage, error) {
+	bundleName := item.Bundle
+	if bundleName == "" {
+		bundleName = cfg.Bundle
+	}
+	stageName := item.Stage
+	if stageName == "" {
+		stageName = cfg.Stage
+	}
+
+	idx := slices.IndexFunc(CustomBundles, func(b cnc.Bundle) bool { return b.Name == bundleName })
+	if idx < 0 {
+		names := []string{}
+		for _, bundle := range CustomBundles {
+			names = append(names, bundle.Name)
+		}
+
+		return cnc.Bundle{}, 0, errors.Errorf("unknown bundle %s (supported: %s)", bundleName, strings.Join(names, ", "))
+	}
+
+	stage, exist := CustomStages[stageName]
+	if !exist {
+		names := []string{}
+		for name := range CustomStages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return cnc.Bundle{}, 0, errors.Errorf("unknown stage %s (supported: %s)", stageName, strings.Join(names, ", "))
+	}
+
+	return CustomBundles[idx], stage, nil
+}
+
+func (cfg *Custom) Validate(_ string, _ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent, _ *wiring.Data) error {
+	names := map[string]bool{}
+	check := func(kind, name string, placement CustomPlacement, controlOnly bool) error {
+		if name == "" {
+			return errors.Errorf("%s name is empty", kind)
+		}
+		if names[kind+"/"+name] {
+			return errors.Errorf("duplicate %s %s", kind, name)
+		}
+		names[kind+"/"+name] = true
+
+		bundle, _, err := cfg.placement(placement)
+		if err != nil {
+			return errors.Wrapf(err, "invalid placement of %s %s", kind, name)
+		}
+		if controlOnly && bundle != BundleControlInstall {
+			return errors.Errorf("%s %s could only be added to bundle %s", kind, name, BundleControlInstall.Name)
+		}
+
+		return nil
+	}
+
+	for _, image := range cfg.Images {
+		if err := check("image", image.Name, image.CustomPlacement, true); err != nil {
+			return err
+		}
+	}
+	for _, chart := range cfg.Charts {
+		if err := check("chart", chart.Name, chart.CustomPlacement, true); err != nil {
+			return err
+		}
+	}
+	for _, files := range cfg.Files {
+		if err := check("files", files.Name, files.CustomPlacement, false); err != nil {
+			return err
+		}
+		if len(files.Files) == 0 {
+			return errors.Errorf("no files specified for files %s", files.Name)
+		}
+	}
+	for _, chart := range cfg.HelmCharts {
+		if err := check("helm chart", chart.Name, chart.CustomPlacement, true); err != nil {
+			return err
+		}
+		if !names["chart/"+chart.Chart] {
+			return errors.Errorf("chart %s of helm chart %s isn't in the charts list", chart.Chart, chart.Name)
+		}
+	}
+	for idx, wait := range cfg.Waits {
+		if err := check("wait", fmt.Sprintf("%d", idx), wait.CustomPlacement, true); err != nil {
+			return err
+		}
+		if wait.Name == "" {
+			return errors.Errorf("object name is empty for wait %d", idx)
+		}
+	}
+
+	return nil
+}
+
+func (cfg *Custom) Build(_ string, _ cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, _ *wiring.Data, run cnc.AddBuildOp, install cnc.AddRunOp) error {
+	source := BaseConfig(get).Source
+	target := BaseConfig(get).Target
+	targetInCluster := BaseConfig(get).TargetInCluster
+
+	images := map[string]cnc.Ref{}
+	for _, image := range cfg.Images {
+		bundle, stage, err := cfg.placement(image.CustomPlacement)
+		if err != nil {
+			return err
+		}
+
+		ref := image.Ref.Fallback(source)
+		imageTarget := image.Target.Fallback(target)
+		images[image.Name] = imageTarget.Fallback(ref)
+
+		run(bundle, stage, cfg.opName("image", image.Name),
+			&cnc.SyncOCI{
+				Ref:    ref,
+				Target: imageTarget,
+			})
+	}
+
+	charts := map[string]cnc.Ref{}
+	for _, chart := range cfg.Charts {
+		bundle, stage, err := cfg.placement(chart.CustomPlacement)
+		if err != nil {
+			return err
+		}
+
+		ref := chart.Ref.Fallback(source)
+		// in-cluster location of the chart pushed to its target, name and tag are inherited from the source ref
+		charts[chart.Name] = chart.Target.Fallback(targetInCluster, ref)
+
+		run(bundle, stage, cfg.opName("chart", chart.Name),
+			&cnc.SyncOCI{
+				Ref:    ref,
+				Target: chart.Target.Fallback(target),
+			})
+	}
+
+	for _, files := range cfg.Files {
+		bundle, stage, err := cfg.placement(files.CustomPlacement)
+		if err != nil {
+			return err
+		}
+
+		op := &cnc.FilesORAS{
+			Ref: files.Ref.Fallback(source),
+		}
+		for _, file := range files.Files {
+			op.Files = append(op.Files, cnc.File{
+				Name:          file.Name,
+				InstallTarget: file.InstallTarget,
+				InstallName:   file.InstallName,
+				InstallMode:   file.InstallMode,
+			})
+		}
+
+		run(bundle, stage, cfg.opName("files", files.Name), op)
+	}
+
+	for _, chart := range cfg.HelmCharts {
+		bundle, stage, err := cfg.placement(chart.CustomPlacement)
+		if err != nil {
+			return err
+		}
+
+		ref := charts[chart.Chart]
+		name := cfg.opName("install", chart.Name)
+
+		run(bundle, stage, name,
+			&cnc.FileGenerate{
+				File: cnc.File{
+					Name:          name + ".yaml",
+					InstallTarget: K3sManifestsDir,
+					InstallName:   "hh-" + name + ".yaml",
+				},
+				Content: cnc.FromKubeObjects(
+					cnc.KubeHelmChart(chart.Name, chart.Namespace, helm.HelmChartSpec{
+						TargetNamespace: chart.TargetNamespace,
+						Chart:           OCIScheme + ref.RepoName(),
+						Version:         ref.Tag,
+						RepoCA:          ZotConfig(get).TLS.CA.Cert,
+					}, cnc.FromTemplate(chart.Values,
+						"base", BaseConfig(get),
+						"images", images,
+						"controlVIP", BaseConfig(get).ControlVIP,
+					)),
+				),
+			})
+	}
+
+	for idx, wait := range cfg.Waits {
+		bundle, stage, err := cfg.placement(wait.CustomPlacement)
+		if err != nil {
+			return err
+		}
+
+		op := wait.WaitKube
+		install(bundle, stage, cfg.opName("wait", fmt.Sprintf("%d", idx)), &op)
+	}
+
+	return nil
+}
+
+// opName prefixes op name with the component name, so they don't conflict with built-in ones
+func (cfg *Custom) opName(kind, name string) string {
+	return "custom-" + cfg.ComponentName + "-" + kind + "-" + name
+}
+
+// LoadCustomComponents loads custom components from all YAML files in the dir, missing dir means no components
+func LoadCustomComponents(dir string) ([]cnc.Component, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading components dir %s", dir)
+	}
+
+	res := []cnc.Component{}
+	for _, entry := range entries {
+		if entry.IsDir() || !slices.Contains([]string{".yaml", ".yml"}, filepath.Ext(entry.Name())) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading component %s", entry.Name())
+		}
+
+		comp := &Custom{}
+		if err := yaml.UnmarshalStrict(data, comp); err != nil {
+			return nil, errors.Wrapf(err, "error parsing component %s", entry.Name())
+		}
+		if comp.ComponentName == "" {
+			return nil, errors.Errorf("component name is empty in %s", entry.Name())
+		}
+
+		res = append(res, comp)
+	}
+
+	return res, nil
+}
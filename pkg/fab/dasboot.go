@@ -40,8 +40,6 @@ var dasBootSeederValuesTemplate string
 var dasBootRegCtrlValuesTemplate string
 
 type DasBoot struct {
-	cnc.NoValidationComponent
-
 	Ref             cnc.Ref    `json:"ref,omitempty"`
 	RsyslogChartRef cnc.Ref    `json:"rsyslogChartRef,omitempty"`
 	RsyslogImageRef cnc.Ref    `json:"rsyslogImageRef,omitempty"`
@@ -68,7 +66,11 @@ type DasBootTLS struct {
 	Config   cnc.KeyPair `json:"config,omitempty"`
 }
 
-var _ cnc.Component = (*DasBoot)(nil)
+var (
+	_ cnc.Component       = (*DasBoot)(nil)
+	_ cnc.CertProvider    = (*DasBoot)(nil)
+	_ cnc.SecretsProvider = (*DasBoot)(nil)
+)
 
 func (cfg *DasBoot) Name() string {
 	return "das-boot"
@@ -90,7 +92,7 @@ func (cfg *DasBoot) Flags() []cli.Flag {
 	}
 }
 
-func (cfg *DasBoot) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
+func (cfg *DasBoot) Hydrate(_ cnc.Preset, _ meta.FabricMode, get cnc.GetComponent) error {
 	cfg.Ref = cfg.Ref.Fallback(RefDasBootVersion)
 	cfg.RsyslogChartRef = cfg.RsyslogChartRef.Fallback(RefDasBootRsyslogChart)
 	cfg.RsyslogImageRef = cfg.RsyslogImageRef.Fallback(RefDasBootRsyslogImage)
@@ -105,42 +107,81 @@ func (cfg *DasBoot) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
 	cfg.SONiCCampusRef = cfg.SONiCCampusRef.Fallback(RefSonicBCMCampus)
 	cfg.SONiCVSRef = cfg.SONiCVSRef.Fallback(RefSonicBCMVS)
 
-	err := cfg.TLS.ServerCA.Ensure("DAS BOOT Server CA", nil, KeyUsageCA, nil, nil, nil) // TODO key usage
+	err := cnc.EnsureCerts(cfg.Certs(get))
 	if err != nil {
-		return errors.Wrapf(err, "error ensuring OCI Repo CA") // TODO
+		return errors.Wrap(err, "error ensuring DAS BOOT certs")
 	}
 
-	err = cfg.TLS.Server.Ensure("localhost", &cfg.TLS.ServerCA, KeyUsageServer, nil,
-		[]string{ControlVIP},
-		[]string{"das-boot-seeder.default.svc.cluster.local"}, // TODO
-	) // TODO config and key usage
-	if err != nil {
-		return errors.Wrap(err, "error ensuring OCI Repo Certs") // TODO
+	if cfg.ClusterIP == "" {
+		cfg.ClusterIP = DasBootSeederClusterIP
 	}
 
-	err = cfg.TLS.ClientCA.Ensure("DAS BOOT Client CA", nil, KeyUsageCA, nil, nil, nil) // TODO key usage
-	if err != nil {
-		return errors.Wrapf(err, "error ensuring OCI Repo CA") // TODO
-	}
+	return nil
+}
 
-	err = cfg.TLS.ConfigCA.Ensure("DAS BOOT Config Signatures CA", nil, KeyUsageCA, nil, nil, nil) // TODO key usage
+func (cfg *DasBoot) Validate(basedir string, _ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent, data *wiring.Data) error {
+	platforms, err := LoadPlatforms(basedir)
 	if err != nil {
-		return errors.Wrapf(err, "error ensuring OCI Repo CA") // TODO
+		return errors.Wrap(err, "error loading platforms")
 	}
 
-	err = cfg.TLS.Config.Ensure("localhost", &cfg.TLS.ConfigCA, KeyUsageServer, nil, nil, nil) // TODO config and key usage
-	if err != nil {
-		return errors.Wrap(err, "error ensuring OCI Repo Certs") // TODO
-	}
+	return errors.Wrap(platforms.ValidateSwitches(data), "error validating switch platforms")
+}
 
-	if cfg.ClusterIP == "" {
-		cfg.ClusterIP = DasBootSeederClusterIP
+func (cfg *DasBoot) SecretFields() []string {
+	res := []string{}
+	for _, keyPair := range []string{"serverCA", "server", "clientCA", "configCA", "config"} {
+		res = append(res, cnc.KeyPairSecretFields("tls."+keyPair)...)
 	}
 
-	return nil
+	return res
+}
+
+func (cfg *DasBoot) Certs(get cnc.GetComponent) []cnc.CertSpec {
+	return []cnc.CertSpec{
+		{
+			Name:     "DAS BOOT Server CA",
+			KeyPair:  &cfg.TLS.ServerCA,
+			CN:       "DAS BOOT Server CA",
+			Subject:  BaseConfig(get).CertSubject,
+			KeyUsage: KeyUsageCA, // TODO key usage
+		},
+		{
+			Name:     "DAS BOOT Server",
+			KeyPair:  &cfg.TLS.Server,
+			Parent:   &cfg.TLS.ServerCA,
+			CN:       "localhost",
+			Subject:  BaseConfig(get).CertSubject,
+			KeyUsage: KeyUsageServer, // TODO config and key usage
+			IPs:      []string{BaseConfig(get).ControlVIP},
+			DNSNames: []string{"das-boot-seeder.default.svc.cluster.local"}, // TODO
+		},
+		{
+			Name:     "DAS BOOT Client CA",
+			KeyPair:  &cfg.TLS.ClientCA,
+			CN:       "DAS BOOT Client CA",
+			Subject:  BaseConfig(get).CertSubject,
+			KeyUsage: KeyUsageCA, // TODO key usage
+		},
+		{
+			Name:     "DAS BOOT Config Signatures CA",
+			KeyPair:  &cfg.TLS.ConfigCA,
+			CN:       "DAS BOOT Config Signatures CA",
+			Subject:  BaseConfig(get).CertSubject,
+			KeyUsage: KeyUsageCA, // TODO key usage
+		},
+		{
+			Name:     "DAS BOOT Config Signatures",
+			KeyPair:  &cfg.TLS.Config,
+			Parent:   &cfg.TLS.ConfigCA,
+			CN:       "localhost",
+			Subject:  BaseConfig(get).CertSubject,
+			KeyUsage: KeyUsageServer, // TODO config and key usage
+		},
+	}
 }
 
-func (cfg *DasBoot) Build(_ string, preset cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, _ *wiring.Data, run cnc.AddBuildOp, install cnc.AddRunOp) error {
+func (cfg *DasBoot) Build(basedir string, preset cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, _ *wiring.Data, run cnc.AddBuildOp, install cnc.AddRunOp) error {
 	cfg.RsyslogImageRef = cfg.RsyslogImageRef.Fallback(BaseConfig(get).Source)
 	cfg.RsyslogChartRef = cfg.RsyslogChartRef.Fallback(BaseConfig(get).Source)
 	cfg.NTPImageRef = cfg.NTPImageRef.Fallback(BaseConfig(get).Source)
@@ -212,122 +253,131 @@ func (cfg *DasBoot) Build(_ string, preset cnc.Preset, _ meta.FabricMode, get cn
 			Target: target,
 		})
 
-	run(BundleControlInstall, StageInstall4DasBoot, "das-boot-install",
-		&cnc.FileGenerate{
-			File: cnc.File{
-				Name:          "dasboot-install.yaml",
-				InstallTarget: "/var/lib/rancher/k3s/server/manifests",
-				InstallName:   "hh-dasboot-install.yaml",
-			},
-			Content: cnc.FromKubeObjects(
-				cnc.KubeHelmChart("das-boot-rsyslog", "default", helm.HelmChartSpec{
-					TargetNamespace: "default",
-					Chart:           OCIScheme + targetInCluster.Fallback(cfg.RsyslogChartRef).RepoName(),
-					Version:         cfg.RsyslogChartRef.Tag,
-					RepoCA:          ZotConfig(get).TLS.CA.Cert,
-				}, cnc.FromTemplate(dasBootRsyslogValuesTemplate,
-					"ref", target.Fallback(cfg.RsyslogImageRef),
-					"nodePort", DasBootSyslogNodePort,
-				)),
-				cnc.KubeHelmChart("das-boot-ntp", "default", helm.HelmChartSpec{
-					TargetNamespace: "default",
-					Chart:           OCIScheme + targetInCluster.Fallback(cfg.NTPChartRef).RepoName(),
-					Version:         cfg.NTPChartRef.Tag,
-					RepoCA:          ZotConfig(get).TLS.CA.Cert,
-				}, cnc.FromTemplate(dasBootNtpValuesTemplate,
-					"ref", target.Fallback(cfg.NTPImageRef),
-					"nodePort", DasBootNTPNodePort,
-					"hostNetwork", "true",
-					"ntpServers", cfg.NTPServers,
-				)),
-				cnc.KubeHelmChart("das-boot-crds", "default", helm.HelmChartSpec{
-					TargetNamespace: "default",
-					Chart:           OCIScheme + targetInCluster.Fallback(cfg.CRDsChartRef).RepoName(),
-					Version:         cfg.CRDsChartRef.Tag,
-					RepoCA:          ZotConfig(get).TLS.CA.Cert,
-					FailurePolicy:   "abort", // very important not to re-install crd charts
-				}, cnc.FromValue("")),
-				cnc.KubeHelmChart("das-boot-seeder", "default", helm.HelmChartSpec{
-					TargetNamespace: "default",
-					Chart:           OCIScheme + targetInCluster.Fallback(cfg.SeederChartRef).RepoName(),
-					Version:         cfg.SeederChartRef.Tag,
-					RepoCA:          ZotConfig(get).TLS.CA.Cert,
-				}, cnc.FromTemplate(dasBootSeederValuesTemplate,
-					"ref", target.Fallback(cfg.SeederImageRef),
-					"controlVIP", ControlVIP,
-					"ntpNodePort", DasBootNTPNodePort,
-					"syslogNodePort", DasBootSyslogNodePort,
-				)),
-				cnc.KubeHelmChart("das-boot-registration-controller", "default", helm.HelmChartSpec{
-					TargetNamespace: "default",
-					Chart:           OCIScheme + targetInCluster.Fallback(cfg.RegCtrlChartRef).RepoName(),
-					Version:         cfg.RegCtrlChartRef.Tag,
-					RepoCA:          ZotConfig(get).TLS.CA.Cert,
-				}, cnc.FromTemplate(dasBootRegCtrlValuesTemplate, "ref", target.Fallback(cfg.RegCtrlImageRef))),
-				cnc.KubeSecret("das-boot-server-cert", "default", map[string]string{
-					"cert.pem": cfg.TLS.Server.Cert,
-					"key.pem":  cfg.TLS.Server.Key,
-				}),
-				cnc.KubeSecret("das-boot-config-cert", "default", map[string]string{
-					"cert.pem": cfg.TLS.Config.Cert,
-					"key.pem":  cfg.TLS.Config.Key,
-				}),
-				cnc.KubeSecret("das-boot-client-ca", "default", map[string]string{
-					"cert.pem": cfg.TLS.ClientCA.Cert,
-					"key.pem":  cfg.TLS.ClientCA.Key,
-				}),
-				cnc.KubeSecret("das-boot-server-ca", "default", map[string]string{
-					"cert.pem": cfg.TLS.ServerCA.Cert,
-				}),
-				cnc.KubeSecret("das-boot-config-ca", "default", map[string]string{
-					"cert.pem": cfg.TLS.ConfigCA.Cert,
-				}),
-				cnc.KubeSecret("oci-ca", "default", map[string]string{ // TODO rename
-					"cert.pem": ZotConfig(get).TLS.CA.Cert,
-				}),
-			),
-		})
+	dasBootInstall := &cnc.FileGenerate{
+		File: cnc.File{
+			Name:          "dasboot-install.yaml",
+			InstallTarget: "/var/lib/rancher/k3s/server/manifests",
+			InstallName:   "hh-dasboot-install.yaml",
+		},
+		Content: cnc.FromKubeObjects(
+			cnc.KubeHelmChart("das-boot-rsyslog", "default", helm.HelmChartSpec{
+				TargetNamespace: "default",
+				Chart:           OCIScheme + targetInCluster.Fallback(cfg.RsyslogChartRef).RepoName(),
+				Version:         cfg.RsyslogChartRef.Tag,
+				RepoCA:          ZotConfig(get).TLS.CA.Cert,
+			}, cnc.FromTemplate(dasBootRsyslogValuesTemplate,
+				"ref", target.Fallback(cfg.RsyslogImageRef),
+				"nodePort", DasBootSyslogNodePort,
+			)),
+			cnc.KubeHelmChart("das-boot-ntp", "default", helm.HelmChartSpec{
+				TargetNamespace: "default",
+				Chart:           OCIScheme + targetInCluster.Fallback(cfg.NTPChartRef).RepoName(),
+				Version:         cfg.NTPChartRef.Tag,
+				RepoCA:          ZotConfig(get).TLS.CA.Cert,
+			}, cnc.FromTemplate(dasBootNtpValuesTemplate,
+				"ref", target.Fallback(cfg.NTPImageRef),
+				"nodePort", DasBootNTPNodePort,
+				"hostNetwork", "true",
+				"ntpServers", cfg.NTPServers,
+			)),
+			cnc.KubeHelmChart("das-boot-crds", "default", helm.HelmChartSpec{
+				TargetNamespace: "default",
+				Chart:           OCIScheme + targetInCluster.Fallback(cfg.CRDsChartRef).RepoName(),
+				Version:         cfg.CRDsChartRef.Tag,
+				RepoCA:          ZotConfig(get).TLS.CA.Cert,
+				FailurePolicy:   "abort", // very important not to re-install crd charts
+			}, cnc.FromValue("")),
+			cnc.KubeHelmChart("das-boot-seeder", "default", helm.HelmChartSpec{
+				TargetNamespace: "default",
+				Chart:           OCIScheme + targetInCluster.Fallback(cfg.SeederChartRef).RepoName(),
+				Version:         cfg.SeederChartRef.Tag,
+				RepoCA:          ZotConfig(get).TLS.CA.Cert,
+			}, cnc.FromTemplate(dasBootSeederValuesTemplate,
+				"ref", target.Fallback(cfg.SeederImageRef),
+				"controlVIP", BaseConfig(get).ControlVIP,
+				"ntpNodePort", DasBootNTPNodePort,
+				"syslogNodePort", DasBootSyslogNodePort,
+			)),
+			cnc.KubeHelmChart("das-boot-registration-controller", "default", helm.HelmChartSpec{
+				TargetNamespace: "default",
+				Chart:           OCIScheme + targetInCluster.Fallback(cfg.RegCtrlChartRef).RepoName(),
+				Version:         cfg.RegCtrlChartRef.Tag,
+				RepoCA:          ZotConfig(get).TLS.CA.Cert,
+			}, cnc.FromTemplate(dasBootRegCtrlValuesTemplate, "ref", target.Fallback(cfg.RegCtrlImageRef))),
+			cnc.KubeSecret("das-boot-server-cert", "default", map[string]string{
+				"cert.pem": cfg.TLS.Server.FullChain(),
+				"key.pem":  cfg.TLS.Server.Key,
+			}),
+			cnc.KubeSecret("das-boot-config-cert", "default", map[string]string{
+				"cert.pem": cfg.TLS.Config.Cert,
+				"key.pem":  cfg.TLS.Config.Key,
+			}),
+			cnc.KubeSecret("das-boot-client-ca", "default", map[string]string{
+				"cert.pem": cfg.TLS.ClientCA.Cert,
+				"key.pem":  cfg.TLS.ClientCA.Key,
+			}),
+			cnc.KubeSecret("das-boot-server-ca", "default", map[string]string{
+				"cert.pem": cfg.TLS.ServerCA.Cert,
+			}),
+			cnc.KubeSecret("das-boot-config-ca", "default", map[string]string{
+				"cert.pem": cfg.TLS.ConfigCA.Cert,
+			}),
+			cnc.KubeSecret("oci-ca", "default", map[string]string{ // TODO rename
+				"cert.pem": ZotConfig(get).TLS.CA.Cert,
+			}),
+		),
+	}
 
-	for _, srcTargetsPair := range RefONIESrcTargetsPairs {
-		for _, srcTargetsPairTarget := range srcTargetsPair.targets {
-			run(BundleControlInstall, StageInstall4DasBoot, fmt.Sprintf("honie-%s", strings.ReplaceAll(srcTargetsPairTarget.Name, "/", "-")),
-				&cnc.SyncOCI{
-					Ref:    srcTargetsPair.src.Fallback(source, RefHONIEVersion),
-					Target: srcTargetsPairTarget.Fallback(target, RefONIETargetVersion),
-				})
-		}
+	// certs update is re-applying the same manifest with the new secrets and restarting the seeder
+	for _, bundle := range []cnc.Bundle{BundleControlInstall, BundleControlCertsUpdate} {
+		run(bundle, StageInstall4DasBoot, "das-boot-install", dasBootInstall)
 	}
 
-	for _, sonicTarget := range RefSonicTargetsBase {
-		run(BundleControlInstall, StageInstall4DasBoot, fmt.Sprintf("das-boot-bin-%s", strings.ReplaceAll(sonicTarget.Name, "/", "-")),
+	platforms, err := LoadPlatforms(basedir)
+	if err != nil {
+		return errors.Wrap(err, "error loading platforms")
+	}
+
+	sonicRefs := map[SONiCFlavor]cnc.Ref{
+		SONiCFlavorBase:   cfg.SONiCBaseRef,
+		SONiCFlavorCampus: cfg.SONiCCampusRef,
+		SONiCFlavorVS:     cfg.SONiCVSRef,
+	}
+
+	for _, platform := range platforms.Platforms {
+		if platform.ONIEUpdater == "" {
+			continue
+		}
+
+		onieTarget := cnc.Ref{Name: "onie/onie-updater-" + platform.Platform}
+		run(BundleControlInstall, StageInstall4DasBoot, fmt.Sprintf("honie-%s", strings.ReplaceAll(onieTarget.Name, "/", "-")),
 			&cnc.SyncOCI{
-				Ref:    cfg.SONiCBaseRef,
-				Target: target.Fallback(RefSonicTargetVersion, sonicTarget),
+				Ref:    cnc.Ref{Name: platform.ONIEUpdater}.Fallback(source, RefHONIEVersion),
+				Target: onieTarget.Fallback(target, RefONIETargetVersion),
 			})
 	}
-	for _, sonicTarget := range RefSonicTargetsCampus {
+
+	for _, platform := range platforms.Platforms {
+		// VS image is only useful in VLAB
+		if platform.SONiC == SONiCFlavorVS && preset != PresetVLAB {
+			continue
+		}
+
+		sonicTarget := cnc.Ref{Name: "sonic/" + platform.Platform}
 		run(BundleControlInstall, StageInstall4DasBoot, fmt.Sprintf("das-boot-bin-%s", strings.ReplaceAll(sonicTarget.Name, "/", "-")),
 			&cnc.SyncOCI{
-				Ref:    cfg.SONiCCampusRef,
+				Ref:    sonicRefs[platform.SONiC],
 				Target: target.Fallback(RefSonicTargetVersion, sonicTarget),
 			})
 	}
 
-	if preset == PresetVLAB {
-		for _, sonicTarget := range RefSonicTargetsVS {
-			run(BundleControlInstall, StageInstall4DasBoot, fmt.Sprintf("das-boot-bin-%s", strings.ReplaceAll(sonicTarget.Name, "/", "-")),
-				&cnc.SyncOCI{
-					Ref:    cfg.SONiCVSRef,
-					Target: target.Fallback(RefSonicTargetVersion, sonicTarget),
-				})
-		}
-	}
-
 	install(BundleControlInstall, StageInstall4DasBoot, "das-boot-seeder-wait",
 		&cnc.WaitKube{
 			Name: "daemonset/das-boot-seeder",
 		})
 
+	addCertsUpdate(install, StageInstall4DasBoot, "das-boot-seeder", "hh-dasboot-install.yaml", "daemonset/das-boot-seeder")
+
 	install(BundleControlInstall, StageInstall4DasBoot, "das-boot-reg-ctrl-wait",
 		&cnc.WaitKube{
 			Name: "deployment/das-boot-registration-controller",
@@ -335,3 +385,7 @@ func (cfg *DasBoot) Build(_ string, preset cnc.Preset, _ meta.FabricMode, get cn
 
 	return nil
 }
+
+func DasBootConfig(get cnc.GetComponent) *DasBoot {
+	return get((&DasBoot{}).Name()).(*DasBoot)
+}
@@ -20,6 +20,7 @@ import (
 	_ "embed"
 	"fmt"
 	"log/slog"
+	"math"
 	"slices"
 
 	helm "github.com/k3s-io/helm-controller/pkg/apis/helm.cattle.io/v1"
@@ -54,6 +55,19 @@ type Fabric struct {
 	BaseVPCCommunity         string  `json:"baseVPCCommunity,omitempty"`
 	ServerFacingMTUOffset    uint    `json:"serverFacingMTUOffset,omitempty"`
 	DHCPServer               string  `json:"dhcpServer,omitempty"`
+
+	VPCIRBVLANRanges     []meta.VLANRange `json:"vpcIRBVLANRanges,omitempty"`
+	VPCPeeringVLANRanges []meta.VLANRange `json:"vpcPeeringVLANRanges,omitempty"`
+	ReservedSubnets      []string         `json:"reservedSubnets,omitempty"`
+	VPCLoopbackSubnet    string           `json:"vpcLoopbackSubnet,omitempty"`
+	FabricMTU            uint16           `json:"fabricMTU,omitempty"`
+	ESLAGMACBase         string           `json:"eslagMACBase,omitempty"`
+	ESLAGESIPrefix       string           `json:"eslagESIPrefix,omitempty"`
+
+	vpcIRBVLANs     cli.StringSlice
+	vpcPeeringVLANs cli.StringSlice
+	reservedSubnets cli.StringSlice
+	fabricMTU       uint
 }
 
 var _ cnc.Component = (*Fabric)(nil)
@@ -89,10 +103,52 @@ func (cfg *Fabric) Flags() []cli.Flag {
 			Destination: &cfg.DHCPServer,
 			Value:       string(meta.DHCPModeHedgehog),
 		},
+		&cli.StringSliceFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "vpc-irb-vlans",
+			Usage:       "VLAN `RANGES` (from-to) to allocate VPC IRB VLANs from (default: " + vlanRangeString(VPCIRBVLANRange) + ")",
+			Destination: &cfg.vpcIRBVLANs,
+		},
+		&cli.StringSliceFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "vpc-peering-vlans",
+			Usage:       "VLAN `RANGES` (from-to) to allocate VPC peering VLANs from (default: " + vlanRangeString(VPCPeeringVLANRange) + ")",
+			Destination: &cfg.vpcPeeringVLANs,
+		},
+		&cli.StringSliceFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "reserved-subnet",
+			Usage:       "additional `SUBNETS` that couldn't be used by VPCs, k3s and fabric subnets are always reserved (default: " + VLABSubnet + ")",
+			Destination: &cfg.reservedSubnets,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "vpc-loopback-subnet",
+			Usage:       "`SUBNET` to allocate VPC loopback workaround IPs from (default: " + VPCLoopbackSubnet + ")",
+			Destination: &cfg.VPCLoopbackSubnet,
+		},
+		&cli.UintFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "fabric-mtu",
+			Usage:       fmt.Sprintf("MTU for the fabric links (default: %d)", FabricMTU),
+			Destination: &cfg.fabricMTU,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "eslag-mac-base",
+			Usage:       "base `MAC` for ESLAG (default: " + ESLAGMACBase + ")",
+			Destination: &cfg.ESLAGMACBase,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "eslag-esi-prefix",
+			Usage:       "`PREFIX` for ESLAG ESIs (default: " + ESLAGESIPrefix + ")",
+			Destination: &cfg.ESLAGESIPrefix,
+		},
 	}
 }
 
-func (cfg *Fabric) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
+func (cfg *Fabric) Hydrate(_ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent) error {
 	cfg.Ref = cfg.Ref.Fallback(RefFabricVersion)
 	cfg.FabricAPIChartRef = cfg.FabricAPIChartRef.Fallback(RefFabricAPIChart)
 	cfg.FabricChartRef = cfg.FabricChartRef.Fallback(RefFabricChart)
@@ -109,45 +165,97 @@ func (cfg *Fabric) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
 		return errors.Errorf("invalid dhcp server mode %q", cfg.DHCPServer)
 	}
 
+	irbVLANs, err := parseVLANRanges(cfg.vpcIRBVLANs.Value())
+	if err != nil {
+		return errors.Wrapf(err, "error parsing vpc irb vlans")
+	}
+	if len(irbVLANs) > 0 {
+		cfg.VPCIRBVLANRanges = irbVLANs
+	}
+	if len(cfg.VPCIRBVLANRanges) == 0 {
+		cfg.VPCIRBVLANRanges = []meta.VLANRange{VPCIRBVLANRange}
+	}
+
+	peeringVLANs, err := parseVLANRanges(cfg.vpcPeeringVLANs.Value())
+	if err != nil {
+		return errors.Wrapf(err, "error parsing vpc peering vlans")
+	}
+	if len(peeringVLANs) > 0 {
+		cfg.VPCPeeringVLANRanges = peeringVLANs
+	}
+	if len(cfg.VPCPeeringVLANRanges) == 0 {
+		cfg.VPCPeeringVLANRanges = []meta.VLANRange{VPCPeeringVLANRange}
+	}
+
+	if len(cfg.ReservedSubnets) == 0 {
+		cfg.ReservedSubnets = []string{VLABSubnet}
+	}
+	for _, subnet := range cfg.reservedSubnets.Value() {
+		if !slices.Contains(cfg.ReservedSubnets, subnet) {
+			cfg.ReservedSubnets = append(cfg.ReservedSubnets, subnet)
+		}
+	}
+
+	if cfg.VPCLoopbackSubnet == "" {
+		cfg.VPCLoopbackSubnet = VPCLoopbackSubnet
+	}
+
+	if cfg.fabricMTU > 0 {
+		if cfg.fabricMTU > math.MaxUint16 {
+			return errors.Errorf("invalid fabric mtu %d", cfg.fabricMTU)
+		}
+		cfg.FabricMTU = uint16(cfg.fabricMTU)
+	}
+	if cfg.FabricMTU == 0 {
+		cfg.FabricMTU = FabricMTU
+	}
+
+	if cfg.ESLAGMACBase == "" {
+		cfg.ESLAGMACBase = ESLAGMACBase
+	}
+	if cfg.ESLAGESIPrefix == "" {
+		cfg.ESLAGESIPrefix = ESLAGESIPrefix
+	}
+
 	return nil
 }
 
 func (cfg *Fabric) buildFabricConfig(fabricMode meta.FabricMode, get cnc.GetComponent, users []meta.UserCreds) *meta.FabricConfig {
-	target := BaseConfig(get).Target
+	base := BaseConfig(get)
+	target := base.Target
 
 	return &meta.FabricConfig{
-		ControlVIP:  ControlVIP + ControlVIPMask,
-		APIServer:   fmt.Sprintf("%s:%d", ControlVIP, K3sAPIPort),
-		AgentRepo:   target.Fallback(cfg.AgentRef).RepoName(),
-		AgentRepoCA: ZotConfig(get).TLS.CA.Cert,
-		VPCIRBVLANRanges: []meta.VLANRange{
-			{From: 3000, To: 3999}, // TODO make configurable
-		},
-		VPCPeeringVLANRanges: []meta.VLANRange{
-			{From: 100, To: 999}, // TODO only 500 needed? make configurable
-		},
-		VPCPeeringDisabled: false,
-		ReservedSubnets: []string{ // TODO make configurable
+		ControlVIP:           base.ControlVIP + ControlVIPMask,
+		APIServer:            fmt.Sprintf("%s:%d", base.ControlVIP, K3sAPIPort),
+		AgentRepo:            target.Fallback(cfg.AgentRef).RepoName(),
+		AgentRepoCA:          ZotConfig(get).TLS.CA.Cert,
+		VPCIRBVLANRanges:     cfg.VPCIRBVLANRanges,
+		VPCPeeringVLANRanges: cfg.VPCPeeringVLANRanges,
+		VPCPeeringDisabled:   false,
+		ReservedSubnets: append([]string{
 			K3sConfig(get).ClusterCIDR,
 			K3sConfig(get).ServiceCIDR,
-			"172.30.0.0/16", // Fabric subnet // TODO make configurable
-			"172.31.0.0/16", // VLAB subnet // TODO make configurable
-		},
+			base.Subnet,
+		}, cfg.ReservedSubnets...),
 		Users:                 users,
 		DHCPMode:              meta.DHCPMode(cfg.DHCPServer),
 		DHCPDConfigMap:        "fabric-dhcp-server-config",
 		DHCPDConfigKey:        "dhcpd.conf",
 		FabricMode:            fabricMode,
 		BaseVPCCommunity:      cfg.BaseVPCCommunity,
-		VPCLoopbackSubnet:     "172.30.240.0/20", // TODO make configurable
-		FabricMTU:             9100,              // TODO make configurable
+		VPCLoopbackSubnet:     cfg.VPCLoopbackSubnet,
+		FabricMTU:             cfg.FabricMTU,
 		ServerFacingMTUOffset: uint16(cfg.ServerFacingMTUOffset),
-		ESLAGMACBase:          "f2:00:00:00:00:00", // TODO make configurable
-		ESLAGESIPrefix:        "00:f2:00:00:",      // TODO make configurable
+		ESLAGMACBase:          cfg.ESLAGMACBase,
+		ESLAGESIPrefix:        cfg.ESLAGESIPrefix,
 	}
 }
 
 func (cfg *Fabric) Validate(_ string, _ cnc.Preset, fabricMode meta.FabricMode, get cnc.GetComponent, wiring *wiringlib.Data) error {
+	if err := validateAddressing(get, cfg, wiring); err != nil {
+		return errors.Wrapf(err, "error validating addressing")
+	}
+
 	fabricCfg := cfg.buildFabricConfig(fabricMode, get, []meta.UserCreds{})
 
 	if err := wiringlib.ValidateFabric(context.TODO(), wiring.Native, fabricCfg); err != nil {
@@ -157,7 +265,7 @@ func (cfg *Fabric) Validate(_ string, _ cnc.Preset, fabricMode meta.FabricMode,
 	return nil
 }
 
-func (cfg *Fabric) Build(_ string, _ cnc.Preset, fabricMode meta.FabricMode, get cnc.GetComponent, wiring *wiringlib.Data, run cnc.AddBuildOp, install cnc.AddRunOp) error {
+func (cfg *Fabric) Build(_ string, preset cnc.Preset, fabricMode meta.FabricMode, get cnc.GetComponent, wiring *wiringlib.Data, run cnc.AddBuildOp, install cnc.AddRunOp) error {
 	cfg.FabricAPIChartRef = cfg.FabricAPIChartRef.Fallback(cfg.Ref, BaseConfig(get).Source)
 	cfg.FabricChartRef = cfg.FabricChartRef.Fallback(cfg.Ref, BaseConfig(get).Source)
 	cfg.FabricImageRef = cfg.FabricImageRef.Fallback(cfg.Ref, BaseConfig(get).Source)
@@ -172,9 +280,9 @@ func (cfg *Fabric) Build(_ string, _ cnc.Preset, fabricMode meta.FabricMode, get
 	target := BaseConfig(get).Target
 	targetInCluster := BaseConfig(get).TargetInCluster
 
-	controlNodeName, err := getControlNodeName(wiring)
+	nodes, err := K3sConfig(get).controlNodes(preset, wiring)
 	if err != nil {
-		return errors.Wrap(err, "error getting control node name")
+		return errors.Wrap(err, "error getting control nodes")
 	}
 
 	wiringData := &bytes.Buffer{}
@@ -237,23 +345,36 @@ func (cfg *Fabric) Build(_ string, _ cnc.Preset, fabricMode meta.FabricMode, get
 			Target: target,
 		})
 
-	run(BundleControlInstall, StageInstall3Fabric, "fabric-control-agent",
-		&cnc.FilesORAS{
-			Ref: cfg.ControlAgentRef,
-			Files: []cnc.File{
-				{
-					Name:          "agent",
-					InstallTarget: "/opt/hedgehog/bin",
-					InstallMode:   0o755,
+	// control agent is running on every control node
+	for _, node := range nodes {
+		bundle := controlInstallBundle(node)
+
+		run(bundle, StageInstall3Fabric, "fabric-control-agent",
+			&cnc.FilesORAS{
+				Ref: cfg.ControlAgentRef,
+				Files: []cnc.File{
+					{
+						Name:          "agent",
+						InstallTarget: "/opt/hedgehog/bin",
+						InstallMode:   0o755,
+					},
 				},
-			},
-		})
-
-	install(BundleControlInstall, StageInstall3Fabric, "fabric-control-agent-install",
-		&cnc.ExecCommand{
-			Name: "/opt/hedgehog/bin/agent",
-			Args: []string{"install", "--control", "--agent-path", "/opt/hedgehog/bin/agent", "--agent-user", "root"},
-		})
+			})
+
+		install(bundle, StageInstall3Fabric, "fabric-control-agent-install",
+			&cnc.ExecCommand{
+				Name: "/opt/hedgehog/bin/agent",
+				Args: []string{"install", "--control", "--agent-path", "/opt/hedgehog/bin/agent", "--agent-user", "root"},
+			})
+
+		// fabric is already installed by the init node when join nodes are installed
+		if node.Join {
+			install(bundle, StageInstall3Fabric, "control-agent-wait",
+				&cnc.WaitKube{
+					Name: "controlagent/" + node.Name,
+				})
+		}
+	}
 
 	var dhcp cnc.KubeObjectProvider
 	if cfg.DHCPServer == "isc" {
@@ -350,7 +471,7 @@ func (cfg *Fabric) Build(_ string, _ cnc.Preset, fabricMode meta.FabricMode, get
 
 	install(BundleControlInstall, StageInstall3Fabric, "control-agent-wait",
 		&cnc.WaitKube{
-			Name: "controlagent/" + controlNodeName,
+			Name: "controlagent/" + nodes[0].Name,
 		})
 
 	return nil
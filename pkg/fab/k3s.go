@@ -15,33 +15,64 @@
 package fab
 
 import (
+	cryptorand "crypto/rand"
 	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
 	"slices"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"go.githedgehog.com/fabric/api/meta"
-	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
 	"go.githedgehog.com/fabric/pkg/wiring"
 	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	"go.githedgehog.com/fabricator/pkg/fab/vlab"
 )
 
 //go:embed k3s_config.tmpl.yaml
 var k3sConfigTemplate string
 
-type K3s struct {
-	cnc.NoValidationComponent
+//go:embed k3s_kube_vip.tmpl.yaml
+var k3sKubeVIPTemplate string
+
+const (
+	// VIPModeLoopback configures control VIP on the loopback of every control node, each switch reaches the one it's
+	// attached to over the management link
+	VIPModeLoopback = "loopback"
+	// VIPModeKubeVIP makes control VIP float between control nodes using kube-vip in ARP mode on the VIP interface
+	VIPModeKubeVIP = "kube-vip"
+)
 
+var VIPModes = []string{VIPModeLoopback, VIPModeKubeVIP}
+
+type K3s struct {
 	Ref         cnc.Ref  `json:"ref,omitempty"`
 	ClusterCIDR string   `json:"clusterCIDR,omitempty"`
 	ServiceCIDR string   `json:"serviceCIDR,omitempty"`
 	ClusterDNS  string   `json:"clusterDNS,omitempty"`
 	TLSSAN      []string `json:"tlsSAN,omitempty"`
 
-	tlsSAN cli.StringSlice
+	// Token is a shared secret used by control nodes to join the cluster
+	Token string `json:"token,omitempty"`
+	// ClusterInterface is the interface used for traffic between control nodes, should be named the same on all of them
+	ClusterInterface string `json:"clusterInterface,omitempty"`
+	// NodeIPs are control node IPs (with prefix) on the cluster interface by node name
+	NodeIPs      map[string]string `json:"nodeIPs,omitempty"`
+	VIPMode      string            `json:"vipMode,omitempty"`
+	VIPInterface string            `json:"vipInterface,omitempty"`
+	KubeVIPRef   cnc.Ref           `json:"kubeVIPRef,omitempty"`
+
+	tlsSAN  cli.StringSlice
+	nodeIPs cli.StringSlice
 }
 
-var _ cnc.Component = (*K3s)(nil)
+var (
+	_ cnc.Component       = (*K3s)(nil)
+	_ cnc.BundleProvider  = (*K3s)(nil)
+	_ cnc.SecretsProvider = (*K3s)(nil)
+)
 
 func (cfg *K3s) Name() string {
 	return "k3s"
@@ -51,6 +82,10 @@ func (cfg *K3s) IsEnabled(_ cnc.Preset) bool {
 	return true
 }
 
+func (cfg *K3s) SecretFields() []string {
+	return []string{"token"}
+}
+
 func (cfg *K3s) Flags() []cli.Flag {
 	return []cli.Flag{
 		&cli.StringSliceFlag{
@@ -59,11 +94,54 @@ func (cfg *K3s) Flags() []cli.Flag {
 			EnvVars:     []string{"HHFAB_TLS_SAN"},
 			Destination: &cfg.tlsSAN,
 		},
+		&cli.StringFlag{
+			Name:        "cluster-cidr",
+			Usage:       "K8s pod network `CIDR` on the control node (default: " + ControlKubeClusterCIDR + ")",
+			EnvVars:     []string{"HHFAB_CLUSTER_CIDR"},
+			Destination: &cfg.ClusterCIDR,
+		},
+		&cli.StringFlag{
+			Name:        "service-cidr",
+			Usage:       "K8s service network `CIDR` on the control node (default: " + ControlKubeServiceCIDR + ")",
+			EnvVars:     []string{"HHFAB_SERVICE_CIDR"},
+			Destination: &cfg.ServiceCIDR,
+		},
+		&cli.StringFlag{
+			Name:        "cluster-dns",
+			Usage:       "K8s cluster DNS `IP`, should be inside of the service CIDR (default: " + ControlKubeClusterDNS + ")",
+			EnvVars:     []string{"HHFAB_CLUSTER_DNS"},
+			Destination: &cfg.ClusterDNS,
+		},
+		&cli.StringFlag{
+			Name:        "cluster-iface",
+			Usage:       "control node `INTERFACE` used for traffic between control nodes (multi control node setup only)",
+			EnvVars:     []string{"HHFAB_CLUSTER_IFACE"},
+			Destination: &cfg.ClusterInterface,
+		},
+		&cli.StringSliceFlag{
+			Name:        "control-node-ip",
+			Usage:       "control node IP on the cluster interface in form of `NAME=IP/PREFIX` (multi control node setup only)",
+			EnvVars:     []string{"HHFAB_CONTROL_NODE_IP"},
+			Destination: &cfg.nodeIPs,
+		},
+		&cli.StringFlag{
+			Name:        "vip-mode",
+			Usage:       "control VIP `MODE`, one of " + strings.Join(VIPModes, ", ") + " (default: " + VIPModeLoopback + ")",
+			EnvVars:     []string{"HHFAB_VIP_MODE"},
+			Destination: &cfg.VIPMode,
+		},
+		&cli.StringFlag{
+			Name:        "vip-iface",
+			Usage:       "control node `INTERFACE` to announce control VIP on in kube-vip mode (default: cluster interface)",
+			EnvVars:     []string{"HHFAB_VIP_IFACE"},
+			Destination: &cfg.VIPInterface,
+		},
 	}
 }
 
-func (cfg *K3s) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
+func (cfg *K3s) Hydrate(_ cnc.Preset, _ meta.FabricMode, get cnc.GetComponent) error {
 	cfg.Ref = cfg.Ref.Fallback(RefK3s)
+	cfg.KubeVIPRef = cfg.KubeVIPRef.Fallback(RefKubeVIP)
 
 	if cfg.ClusterCIDR == "" {
 		cfg.ClusterCIDR = ControlKubeClusterCIDR
@@ -80,88 +158,257 @@ func (cfg *K3s) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
 	for _, tlsSAN := range append([]string{
 		"127.0.0.1",
 		"kube-fabric.local",
-		ControlVIP,
+		BaseConfig(get).ControlVIP,
 	}, cfg.tlsSAN.Value()...) {
 		if !slices.Contains(cfg.TLSSAN, tlsSAN) {
 			cfg.TLSSAN = append(cfg.TLSSAN, tlsSAN)
 		}
 	}
 
+	if cfg.Token == "" {
+		token := make([]byte, 32)
+		if _, err := cryptorand.Read(token); err != nil {
+			return errors.Wrapf(err, "error generating k3s token")
+		}
+
+		cfg.Token = hex.EncodeToString(token)
+	}
+
+	for _, nodeIP := range cfg.nodeIPs.Value() {
+		name, ip, ok := strings.Cut(nodeIP, "=")
+		if !ok || name == "" || ip == "" {
+			return errors.Errorf("invalid control node ip %q, expected NAME=IP/PREFIX", nodeIP)
+		}
+
+		if cfg.NodeIPs == nil {
+			cfg.NodeIPs = map[string]string{}
+		}
+		cfg.NodeIPs[name] = ip
+	}
+
+	if cfg.VIPMode == "" {
+		cfg.VIPMode = VIPModeLoopback
+	}
+	if !slices.Contains(VIPModes, cfg.VIPMode) {
+		return errors.Errorf("invalid vip mode %q, should be one of %s", cfg.VIPMode, strings.Join(VIPModes, ", "))
+	}
+
 	return nil
 }
 
-func (cfg *K3s) Build(_ string, _ cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, wiring *wiring.Data, run cnc.AddBuildOp, install cnc.AddRunOp) error {
-	cfg.Ref = cfg.Ref.Fallback(BaseConfig(get).Source)
+func (cfg *K3s) Validate(_ string, preset cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent, data *wiring.Data) error {
+	nodes, err := getControlNodes(data)
+	if err != nil {
+		return err
+	}
 
-	run(BundleControlInstall, StageInstall0Prep, "k3s-airgap-files",
-		&cnc.FilesORAS{
-			Ref: cfg.Ref,
-			Files: []cnc.File{
-				{
-					Name:          "k3s-install.sh",
-					InstallTarget: "/opt/bin",
-					InstallMode:   0o755,
-				},
-				{
-					Name:          "k3s",
-					InstallTarget: "/opt/bin",
-					InstallMode:   0o755,
-				},
-				{
-					Name:          "k3s-airgap-images-amd64.tar.gz",
-					InstallTarget: "/var/lib/rancher/k3s/agent/images",
-				},
-			},
-		})
+	for name, ip := range cfg.NodeIPs {
+		if !slices.Contains(nodes, name) {
+			return errors.Errorf("control node ip specified for unknown control node %s", name)
+		}
+		if _, err := netip.ParsePrefix(ip); err != nil {
+			return errors.Wrapf(err, "invalid ip %q for control node %s, expected IP/PREFIX", ip, name)
+		}
+	}
+
+	// VLAB is providing defaults for the network between control nodes
+	if len(nodes) > 1 && preset != PresetVLAB {
+		if cfg.NodeIPs[nodes[0]] == "" {
+			return errors.Errorf("control node ip is required for the first control node %s to join others to it", nodes[0])
+		}
+	}
 
-	controlNodeName, err := cfg.ControlNodeName(wiring)
+	vlabDefaults := preset == PresetVLAB && len(nodes) > 1
+	if cfg.VIPMode == VIPModeKubeVIP && cfg.VIPInterface == "" && cfg.ClusterInterface == "" && !vlabDefaults {
+		return errors.Errorf("vip or cluster interface is required for vip mode %s", VIPModeKubeVIP)
+	}
+
+	return nil
+}
+
+// k3sNode is a per control node data used to render k3s config and ignition
+type k3sNode struct {
+	Name  string
+	Join  bool
+	IP    string // with prefix
+	Iface string
+}
+
+// controlNodes returns control nodes with the VLAB defaults applied for the network between them
+func (cfg *K3s) controlNodes(preset cnc.Preset, data *wiring.Data) ([]k3sNode, error) {
+	names, err := getControlNodes(data)
 	if err != nil {
-		return errors.Wrap(err, "error getting control node name")
-	}
-
-	run(BundleControlInstall, StageInstall0Prep, "k3s-config",
-		&cnc.FileGenerate{
-			File: cnc.File{
-				Name:          "k3s-config.yaml",
-				InstallTarget: "/etc/rancher/k3s",
-				InstallName:   "config.yaml",
-			},
-			Content: cnc.FromTemplate(k3sConfigTemplate,
-				"cfg", cfg,
-				"controlNodeName", controlNodeName,
-			),
-		})
+		return nil, err
+	}
+
+	iface := cfg.ClusterInterface
+	if preset == PresetVLAB && len(names) > 1 && iface == "" {
+		iface, err = vlab.ControlClusterIface(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting vlab control cluster interface")
+		}
+	}
 
-	install(BundleControlInstall, StageInstall1K3sZot, "k3s-airgap-install",
-		&cnc.ExecCommand{
-			Name: "k3s-install.sh",
-			Args: []string{"--disable=servicelb,traefik"},
-			Env: []string{
-				"INSTALL_K3S_SKIP_DOWNLOAD=true",
-				"INSTALL_K3S_BIN_DIR=/opt/bin",
-			},
+	nodes := []k3sNode{}
+	for idx, name := range names {
+		ip := cfg.NodeIPs[name]
+		if preset == PresetVLAB && len(names) > 1 && ip == "" {
+			ip = vlab.ControlClusterIP(idx)
+		}
+
+		nodes = append(nodes, k3sNode{
+			Name:  name,
+			Join:  idx > 0,
+			IP:    ip,
+			Iface: iface,
 		})
+	}
 
-	return nil
+	return nodes, nil
 }
 
-func (cfg *K3s) ControlNodeName(data *wiring.Data) (string, error) {
-	name := ""
-	for _, server := range data.Server.All() {
-		if server.Spec.Type == wiringapi.ServerTypeControl {
-			if name != "" {
-				return "", errors.New("multiple control nodes found")
-			}
-
-			name = server.Name
+// vipInterface returns interface to announce control VIP on in kube-vip mode
+func (cfg *K3s) vipInterface(nodes []k3sNode) string {
+	if cfg.VIPInterface != "" {
+		return cfg.VIPInterface
+	}
+
+	return nodes[0].Iface
+}
+
+func (cfg *K3s) Bundles(preset cnc.Preset, data *wiring.Data) ([]cnc.Bundle, error) {
+	nodes, err := cfg.controlNodes(preset, data)
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := []cnc.Bundle{}
+	for _, node := range nodes {
+		if node.Join {
+			bundles = append(bundles, BundleControlJoin(node.Name), BundleControlJoinCertsUpdate(node.Name))
 		}
 	}
 
-	if name == "" {
-		return "", errors.New("no control node found")
+	return bundles, nil
+}
+
+func (cfg *K3s) Build(_ string, preset cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, wiring *wiring.Data, run cnc.AddBuildOp, install cnc.AddRunOp) error {
+	cfg.Ref = cfg.Ref.Fallback(BaseConfig(get).Source)
+	cfg.KubeVIPRef = cfg.KubeVIPRef.Fallback(BaseConfig(get).Source)
+
+	nodes, err := cfg.controlNodes(preset, wiring)
+	if err != nil {
+		return errors.Wrap(err, "error getting control nodes")
 	}
 
-	return name, nil
+	server := ""
+	if len(nodes) > 1 {
+		joinTo := strings.SplitN(nodes[0].IP, "/", 2)[0]
+		if cfg.VIPMode == VIPModeKubeVIP {
+			joinTo = BaseConfig(get).ControlVIP
+		}
+
+		server = fmt.Sprintf("https://%s:%d", joinTo, K3sAPIPort)
+	}
+
+	for _, node := range nodes {
+		bundle := controlInstallBundle(node)
+
+		run(bundle, StageInstall0Prep, "k3s-airgap-files",
+			&cnc.FilesORAS{
+				Ref: cfg.Ref,
+				Files: []cnc.File{
+					{
+						Name:          "k3s-install.sh",
+						InstallTarget: "/opt/bin",
+						InstallMode:   0o755,
+					},
+					{
+						Name:          "k3s",
+						InstallTarget: "/opt/bin",
+						InstallMode:   0o755,
+					},
+					{
+						Name:          "k3s-airgap-images-amd64.tar.gz",
+						InstallTarget: "/var/lib/rancher/k3s/agent/images",
+					},
+				},
+			})
+
+		nodeServer := ""
+		if node.Join {
+			nodeServer = server
+		}
+
+		run(bundle, StageInstall0Prep, "k3s-config",
+			&cnc.FileGenerate{
+				File: cnc.File{
+					Name:          "k3s-config.yaml",
+					InstallTarget: "/etc/rancher/k3s",
+					InstallName:   "config.yaml",
+				},
+				Content: cnc.FromTemplate(k3sConfigTemplate,
+					"cfg", cfg,
+					"controlNodeName", node.Name,
+					"nodeIP", strings.SplitN(node.IP, "/", 2)[0],
+					"iface", node.Iface,
+					"server", nodeServer,
+				),
+			})
+
+		if cfg.VIPMode == VIPModeKubeVIP {
+			run(bundle, StageInstall0Prep, "kube-vip-airgap-files",
+				&cnc.FilesORAS{
+					Ref: cfg.KubeVIPRef,
+					Files: []cnc.File{
+						{
+							Name:          "kube-vip-airgap-images-amd64.tar.gz",
+							InstallTarget: "/var/lib/rancher/k3s/agent/images",
+						},
+					},
+				})
+
+			run(bundle, StageInstall0Prep, "kube-vip-manifest",
+				&cnc.FileGenerate{
+					File: cnc.File{
+						Name:          "kube-vip.yaml",
+						InstallTarget: "/var/lib/rancher/k3s/agent/pod-manifests",
+					},
+					Content: cnc.FromTemplate(k3sKubeVIPTemplate,
+						"ref", cfg.KubeVIPRef,
+						"vip", BaseConfig(get).ControlVIP,
+						"iface", cfg.vipInterface(nodes),
+						"port", K3sAPIPort,
+					),
+				})
+		}
+
+		install(bundle, StageInstall1K3sZot, "k3s-airgap-install",
+			&cnc.ExecCommand{
+				Name: "k3s-install.sh",
+				Args: []string{"--disable=servicelb,traefik"},
+				Env: []string{
+					"INSTALL_K3S_SKIP_DOWNLOAD=true",
+					"INSTALL_K3S_BIN_DIR=/opt/bin",
+				},
+				Undo: &cnc.ExecCommand{
+					Name: "/opt/bin/k3s-uninstall.sh",
+				},
+			})
+
+		if node.Join {
+			install(bundle, StageInstall1K3sZot, "k3s-node-wait",
+				&cnc.WaitKube{
+					Name: "node/" + node.Name,
+				})
+		}
+	}
+
+	return nil
+}
+
+func (cfg *K3s) ControlNodeName(data *wiring.Data) (string, error) {
+	return getControlNodeName(data)
 }
 
 func K3sConfig(get cnc.GetComponent) *K3s {
@@ -0,0 +1,114 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fab
+
+import (
+	"testing"
+
+	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_K3sValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		preset   cnc.Preset
+		controls []string
+		cfg      K3s
+		err      bool
+	}{
+		{name: "single", preset: PresetBM, controls: []string{"control-1"}},
+		{name: "no-control", preset: PresetBM, err: true},
+		{
+			name:     "multi-first-ip",
+			preset:   PresetBM,
+			controls: []string{"control-1", "control-2"},
+			cfg:      K3s{NodeIPs: map[string]string{"control-1": "10.10.0.1/24"}},
+		},
+		{
+			name:     "multi-no-first-ip",
+			preset:   PresetBM,
+			controls: []string{"control-1", "control-2"},
+			cfg:      K3s{NodeIPs: map[string]string{"control-2": "10.10.0.2/24"}},
+			err:      true,
+		},
+		{name: "multi-vlab-defaults", preset: PresetVLAB, controls: []string{"control-1", "control-2"}},
+		{
+			name:     "unknown-node",
+			preset:   PresetBM,
+			controls: []string{"control-1"},
+			cfg:      K3s{NodeIPs: map[string]string{"control-3": "10.10.0.3/24"}},
+			err:      true,
+		},
+		{
+			name:     "ip-without-prefix",
+			preset:   PresetBM,
+			controls: []string{"control-1"},
+			cfg:      K3s{NodeIPs: map[string]string{"control-1": "10.10.0.1"}},
+			err:      true,
+		},
+		{
+			name:     "kube-vip-no-iface",
+			preset:   PresetBM,
+			controls: []string{"control-1"},
+			cfg:      K3s{VIPMode: VIPModeKubeVIP},
+			err:      true,
+		},
+		{
+			name:     "kube-vip-cluster-iface",
+			preset:   PresetBM,
+			controls: []string{"control-1"},
+			cfg:      K3s{VIPMode: VIPModeKubeVIP, ClusterInterface: "enp2s2"},
+		},
+		{name: "kube-vip-vlab-defaults", preset: PresetVLAB, controls: []string{"control-1", "control-2"}, cfg: K3s{VIPMode: VIPModeKubeVIP}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := wiring.New()
+			if err != nil {
+				t.Fatalf("error creating wiring data: %v", err)
+			}
+
+			for _, name := range test.controls {
+				err = data.Add(&wiringapi.Server{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       wiringapi.KindServer,
+						APIVersion: wiringapi.GroupVersion.String(),
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name: name,
+					},
+					Spec: wiringapi.ServerSpec{
+						Type: wiringapi.ServerTypeControl,
+					},
+				})
+				if err != nil {
+					t.Fatalf("error adding server: %v", err)
+				}
+			}
+
+			err = test.cfg.Validate("", test.preset, "", nil, data)
+			if test.err && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -60,7 +60,7 @@ func (cfg *Misc) Flags() []cli.Flag {
 	return nil
 }
 
-func (cfg *Misc) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
+func (cfg *Misc) Hydrate(_ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent) error {
 	cfg.K9sRef = cfg.K9sRef.Fallback(RefK9s)
 	cfg.RBACProxyImageRef = cfg.RBACProxyImageRef.Fallback(RefRBACProxy)
 
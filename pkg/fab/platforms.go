@@ -0,0 +1,191 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fab
+
+import (
+	_ "embed"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed platforms.yaml
+var platformsCatalog []byte
+
+// PlatformsFile is an optional file in the basedir with platforms added to the built-in catalog or replacing ones
+// with the same name
+const PlatformsFile = "platforms.yaml"
+
+// PlatformsVersion is the only supported version of the platforms catalog
+const PlatformsVersion = 1
+
+type SONiCFlavor string
+
+const (
+	SONiCFlavorBase   SONiCFlavor = "base"
+	SONiCFlavorCampus SONiCFlavor = "campus"
+	SONiCFlavorVS     SONiCFlavor = "vs"
+)
+
+var SONiCFlavors = []SONiCFlavor{SONiCFlavorBase, SONiCFlavorCampus, SONiCFlavorVS}
+
+type Platforms struct {
+	Version   int        `json:"version"`
+	Platforms []Platform `json:"platforms"`
+}
+
+type Platform struct {
+	// Name is referenced by the switch profile
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Platform is the ONIE platform, it's used to name ONIE updater and SONiC image served by DAS BOOT
+	Platform string `json:"platform"`
+	// ONIEUpdater is the HONIE updater image to serve for the platform, no updater is served if it's empty
+	ONIEUpdater string                 `json:"onieUpdater,omitempty"`
+	SONiC       SONiCFlavor            `json:"sonic"`
+	Roles       []wiringapi.SwitchRole `json:"roles"`
+}
+
+// LoadPlatforms returns the built-in platforms catalog merged with the one from the basedir (if it exists)
+func LoadPlatforms(basedir string) (*Platforms, error) {
+	platforms, err := parsePlatforms(platformsCatalog)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing built-in platforms")
+	}
+
+	if basedir != "" {
+		data, err := os.ReadFile(filepath.Join(basedir, PlatformsFile))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "error reading %s", PlatformsFile)
+		}
+		if err == nil {
+			overrides, err := parsePlatforms(data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing %s", PlatformsFile)
+			}
+
+			for _, platform := range overrides.Platforms {
+				if idx := slices.IndexFunc(platforms.Platforms, func(p Platform) bool { return p.Name == platform.Name }); idx >= 0 {
+					platforms.Platforms[idx] = platform
+				} else {
+					platforms.Platforms = append(platforms.Platforms, platform)
+				}
+			}
+		}
+	}
+
+	return platforms, errors.Wrapf(platforms.Validate(), "error validating platforms")
+}
+
+func parsePlatforms(data []byte) (*Platforms, error) {
+	platforms := &Platforms{}
+	if err := yaml.UnmarshalStrict(data, platforms); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling")
+	}
+	if platforms.Version != PlatformsVersion {
+		return nil, errors.Errorf("unsupported version %d, expected %d", platforms.Version, PlatformsVersion)
+	}
+
+	return platforms, nil
+}
+
+func (p *Platforms) Validate() error {
+	names := map[string]bool{}
+	onie := map[string]bool{}
+
+	for _, platform := range p.Platforms {
+		if platform.Name == "" {
+			return errors.Errorf("platform name is empty")
+		}
+		if names[platform.Name] {
+			return errors.Errorf("duplicate platform %s", platform.Name)
+		}
+		names[platform.Name] = true
+
+		// ONIE platform is used to name artifacts, so it should be unique
+		if platform.Platform == "" {
+			return errors.Errorf("ONIE platform is empty for platform %s", platform.Name)
+		}
+		if onie[platform.Platform] {
+			return errors.Errorf("duplicate ONIE platform %s for platform %s", platform.Platform, platform.Name)
+		}
+		onie[platform.Platform] = true
+
+		if !slices.Contains(SONiCFlavors, platform.SONiC) {
+			return errors.Errorf("invalid SONiC flavor %q for platform %s, expected one of %v", platform.SONiC, platform.Name, SONiCFlavors)
+		}
+
+		if len(platform.Roles) == 0 {
+			return errors.Errorf("no roles for platform %s", platform.Name)
+		}
+		for _, role := range platform.Roles {
+			if !slices.Contains(wiringapi.SwitchRoles, role) {
+				return errors.Errorf("invalid role %s for platform %s", role, platform.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Names returns names of all platforms in the catalog
+func (p *Platforms) Names() []string {
+	res := []string{}
+	for _, platform := range p.Platforms {
+		res = append(res, platform.Name)
+	}
+
+	return res
+}
+
+func (p *Platforms) Get(name string) *Platform {
+	for idx := range p.Platforms {
+		if p.Platforms[idx].Name == name {
+			return &p.Platforms[idx]
+		}
+	}
+
+	return nil
+}
+
+// ValidateSwitches checks that all switches are referencing known platforms by the profile and have roles supported by
+// them, wirings created before the catalog should be updated to use the catalog names or platforms with the names they
+// use should be added to the platforms.yaml in the basedir
+func (p *Platforms) ValidateSwitches(data *wiring.Data) error {
+	for _, sw := range data.Switch.All() {
+		if sw.Spec.Profile == "" {
+			return errors.Errorf("platform (profile) not set for switch %s, should be one of %s or added to %s in the basedir",
+				sw.Name, strings.Join(p.Names(), ", "), PlatformsFile)
+		}
+
+		platform := p.Get(sw.Spec.Profile)
+		if platform == nil {
+			return errors.Errorf("unknown platform (profile) %s for switch %s, should be one of %s or added to %s in the basedir",
+				sw.Spec.Profile, sw.Name, strings.Join(p.Names(), ", "), PlatformsFile)
+		}
+
+		if !slices.Contains(platform.Roles, sw.Spec.Role) {
+			return errors.Errorf("role %s isn't supported by platform %s for switch %s", sw.Spec.Role, platform.Name, sw.Name)
+		}
+	}
+
+	return nil
+}
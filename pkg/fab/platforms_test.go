@@ -0,0 +1,236 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fab
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_PlatformsValidate(t *testing.T) {
+	valid := Platform{
+		Name:     "test",
+		Platform: "x86_64-test-r0",
+		SONiC:    SONiCFlavorBase,
+		Roles:    []wiringapi.SwitchRole{wiringapi.SwitchRoleServerLeaf},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(p *Platform)
+		other  *Platform
+		err    bool
+	}{
+		{name: "valid"},
+		{name: "empty-name", modify: func(p *Platform) { p.Name = "" }, err: true},
+		{name: "empty-onie-platform", modify: func(p *Platform) { p.Platform = "" }, err: true},
+		{name: "invalid-flavor", modify: func(p *Platform) { p.SONiC = "unknown" }, err: true},
+		{name: "no-roles", modify: func(p *Platform) { p.Roles = nil }, err: true},
+		{name: "invalid-role", modify: func(p *Platform) { p.Roles = []wiringapi.SwitchRole{"unknown"} }, err: true},
+		{name: "duplicate-name", other: &Platform{Name: "test", Platform: "x86_64-other-r0"}, err: true},
+		{name: "duplicate-onie-platform", other: &Platform{Name: "other", Platform: "x86_64-test-r0"}, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			platform := valid
+			if test.modify != nil {
+				test.modify(&platform)
+			}
+
+			platforms := &Platforms{Version: PlatformsVersion, Platforms: []Platform{platform}}
+			if test.other != nil {
+				other := *test.other
+				other.SONiC = SONiCFlavorBase
+				other.Roles = valid.Roles
+				platforms.Platforms = append(platforms.Platforms, other)
+			}
+
+			err := platforms.Validate()
+			if test.err && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_LoadPlatforms(t *testing.T) {
+	builtin, err := LoadPlatforms("")
+	if err != nil {
+		t.Fatalf("error loading built-in platforms: %v", err)
+	}
+	if builtin.Get("vs") == nil {
+		t.Fatalf("built-in platform vs not found")
+	}
+
+	tests := []struct {
+		name      string
+		overrides string
+		err       bool
+		check     func(t *testing.T, platforms *Platforms)
+	}{
+		{
+			name: "no-file",
+			check: func(t *testing.T, platforms *Platforms) {
+				if len(platforms.Platforms) != len(builtin.Platforms) {
+					t.Errorf("expected %d platforms, got %d", len(builtin.Platforms), len(platforms.Platforms))
+				}
+			},
+		},
+		{
+			name: "replace",
+			overrides: `version: 1
+platforms:
+  - name: vs
+    platform: x86_64-kvm_x86_64-r0
+    sonic: vs
+    roles: [server-leaf]
+`,
+			check: func(t *testing.T, platforms *Platforms) {
+				if len(platforms.Platforms) != len(builtin.Platforms) {
+					t.Errorf("expected %d platforms, got %d", len(builtin.Platforms), len(platforms.Platforms))
+				}
+				if vs := platforms.Get("vs"); vs == nil || vs.ONIEUpdater != "" || len(vs.Roles) != 1 {
+					t.Errorf("platform vs isn't replaced: %#v", vs)
+				}
+			},
+		},
+		{
+			name: "add",
+			overrides: `version: 1
+platforms:
+  - name: test
+    platform: x86_64-test-r0
+    sonic: base
+    roles: [spine]
+`,
+			check: func(t *testing.T, platforms *Platforms) {
+				if len(platforms.Platforms) != len(builtin.Platforms)+1 {
+					t.Errorf("expected %d platforms, got %d", len(builtin.Platforms)+1, len(platforms.Platforms))
+				}
+				if platforms.Get("test") == nil {
+					t.Errorf("platform test isn't added")
+				}
+			},
+		},
+		{
+			name:      "unsupported-version",
+			overrides: "version: 2\nplatforms: []\n",
+			err:       true,
+		},
+		{
+			name: "duplicate-onie-platform",
+			overrides: `version: 1
+platforms:
+  - name: test
+    platform: x86_64-kvm_x86_64-r0
+    sonic: base
+    roles: [spine]
+`,
+			err: true,
+		},
+		{
+			name:      "unknown-field",
+			overrides: "version: 1\nplatforms: []\nunknown: true\n",
+			err:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			basedir := t.TempDir()
+			if test.overrides != "" {
+				if err := os.WriteFile(filepath.Join(basedir, PlatformsFile), []byte(test.overrides), 0o644); err != nil {
+					t.Fatalf("error writing %s: %v", PlatformsFile, err)
+				}
+			}
+
+			platforms, err := LoadPlatforms(basedir)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			test.check(t, platforms)
+		})
+	}
+}
+
+func Test_PlatformsValidateSwitches(t *testing.T) {
+	platforms, err := LoadPlatforms("")
+	if err != nil {
+		t.Fatalf("error loading built-in platforms: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		profile string
+		role    wiringapi.SwitchRole
+		err     bool
+	}{
+		{name: "valid", profile: "vs", role: wiringapi.SwitchRoleServerLeaf},
+		{name: "no-profile", role: wiringapi.SwitchRoleServerLeaf, err: true},
+		{name: "unknown-platform", profile: "unknown", role: wiringapi.SwitchRoleServerLeaf, err: true},
+		{name: "unsupported-role", profile: "edgecore-eps202", role: wiringapi.SwitchRoleSpine, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := wiring.New()
+			if err != nil {
+				t.Fatalf("error creating wiring data: %v", err)
+			}
+
+			err = data.Add(&wiringapi.Switch{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       wiringapi.KindSwitch,
+					APIVersion: wiringapi.GroupVersion.String(),
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "switch-01",
+				},
+				Spec: wiringapi.SwitchSpec{
+					Profile: test.profile,
+					Role:    test.role,
+				},
+			})
+			if err != nil {
+				t.Fatalf("error adding switch: %v", err)
+			}
+
+			err = platforms.ValidateSwitches(data)
+			if test.err && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fab
+
+import (
+	"github.com/urfave/cli/v2"
+	"go.githedgehog.com/fabric/api/meta"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+)
+
+type Preflight struct {
+	cnc.NoValidationComponent
+
+	Disabled     bool   `json:"disabled,omitempty"`
+	DiskPath     string `json:"diskPath,omitempty"`
+	MinDiskGiB   uint64 `json:"minDiskGiB,omitempty"`
+	MinMemoryMiB uint64 `json:"minMemoryMiB,omitempty"`
+	MinCPUs      int    `json:"minCPUs,omitempty"`
+	SkipTimeSync bool   `json:"skipTimeSync,omitempty"`
+}
+
+var _ cnc.Component = (*Preflight)(nil)
+
+func (cfg *Preflight) Name() string {
+	return "preflight"
+}
+
+func (cfg *Preflight) IsEnabled(_ cnc.Preset) bool {
+	return true
+}
+
+func (cfg *Preflight) Flags() []cli.Flag {
+	return nil
+}
+
+func (cfg *Preflight) Hydrate(preset cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent) error {
+	if cfg.DiskPath == "" {
+		cfg.DiskPath = "/var/lib/rancher"
+	}
+	if cfg.MinDiskGiB == 0 {
+		cfg.MinDiskGiB = 30
+	}
+	if cfg.MinMemoryMiB == 0 {
+		cfg.MinMemoryMiB = 5120
+
+		// compact VLAB control VM has 4096MiB and some of it is reserved by the kernel
+		if preset == PresetVLAB {
+			cfg.MinMemoryMiB = 3584
+		}
+	}
+	if cfg.MinCPUs == 0 {
+		cfg.MinCPUs = 4
+	}
+
+	return nil
+}
+
+func (cfg *Preflight) Build(_ string, preset cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, wiring *wiring.Data, _ cnc.AddBuildOp, install cnc.AddRunOp) error {
+	if cfg.Disabled {
+		return nil
+	}
+
+	k3s := K3sConfig(get)
+	nodes, err := k3s.controlNodes(preset, wiring)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		bundle := controlInstallBundle(node)
+
+		install(bundle, StageInstallPreflight, "preflight-disk",
+			&cnc.PreflightDisk{
+				Path:       cfg.DiskPath,
+				MinFreeGiB: cfg.MinDiskGiB,
+			})
+
+		install(bundle, StageInstallPreflight, "preflight-resources",
+			&cnc.PreflightResources{
+				MinMemoryMiB: cfg.MinMemoryMiB,
+				MinCPUs:      cfg.MinCPUs,
+			})
+
+		ifaces := []cnc.PreflightInterface{}
+		ifaceNames := []string{}
+		for _, port := range buildControlPorts(wiring, node.Name) {
+			ifaces = append(ifaces, cnc.PreflightInterface{
+				Name: port.PortName,
+				MAC:  port.MAC,
+			})
+			ifaceNames = append(ifaceNames, port.PortName)
+		}
+
+		vips := []string{}
+		if k3s.VIPMode == VIPModeLoopback {
+			vips = append(vips, BaseConfig(get).ControlVIP)
+		}
+
+		install(bundle, StageInstallPreflight, "preflight-routes",
+			&cnc.PreflightRoutes{
+				Subnets:          []string{k3s.ClusterCIDR, k3s.ServiceCIDR},
+				IPs:              vips,
+				IgnoreInterfaces: ifaceNames,
+			})
+
+		install(bundle, StageInstallPreflight, "preflight-interfaces",
+			&cnc.PreflightInterfaces{
+				Interfaces: ifaces,
+			})
+
+		// VLAB VMs are using host clock and aren't always able to reach NTP servers
+		if !cfg.SkipTimeSync && preset != PresetVLAB {
+			install(bundle, StageInstallPreflight, "preflight-time-sync",
+				&cnc.PreflightTimeSync{})
+		}
+
+		install(bundle, StageInstallPreflight, "preflight-ports",
+			&cnc.PreflightPorts{
+				Ports: []int{K3sAPIPort, ZotNodePort},
+			})
+	}
+
+	return nil
+}
@@ -0,0 +1,323 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc/bin"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	DefaultUser    = "core"
+	DefaultSSHPort = 22
+	KubeconfigPath = "/etc/rancher/k3s/k3s.yaml"
+	SSHWaitTimeout = 5 * time.Minute
+)
+
+// ExecFunc runs the command (ssh or scp) locally, output should only be shown if not quiet
+type ExecFunc func(ctx context.Context, quiet bool, name string, args ...string) error
+
+// Target is a host reachable over ssh to upload and run installer bundles on
+type Target struct {
+	User string
+	Host string
+	Port int
+	// Key is an optional identity file, ssh defaults and agent are used if empty
+	Key string
+	// Jump is a list of jump hosts (user@host[:port]) passed to ssh as ProxyJump
+	Jump []string
+	// Options are additional ssh options passed to both ssh and scp, e.g. to disable host key checking
+	Options []string
+	// Exec is used to run ssh and scp, DefaultExec is used if nil
+	Exec ExecFunc
+}
+
+// ParseTarget parses target in form of [user@]host[:port], ipv6 addresses should be in brackets if port is specified
+func ParseTarget(target string) (*Target, error) {
+	if target == "" {
+		return nil, errors.New("target is empty")
+	}
+
+	t := &Target{
+		User: DefaultUser,
+		Port: DefaultSSHPort,
+	}
+
+	host := target
+	if user, rest, ok := strings.Cut(target, "@"); ok {
+		if user == "" {
+			return nil, errors.Errorf("empty user in target %s", target)
+		}
+
+		t.User = user
+		host = rest
+	}
+
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, errors.Errorf("invalid port in target %s", target)
+		}
+
+		host = h
+		t.Port = port
+	} else if strings.Count(host, ":") == 1 {
+		return nil, errors.Errorf("invalid target %s", target)
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "" || strings.ContainsAny(host, "@/ ") {
+		return nil, errors.Errorf("invalid host in target %s", target)
+	}
+
+	t.Host = host
+
+	return t, nil
+}
+
+func (t *Target) String() string {
+	return fmt.Sprintf("%s@%s", t.User, net.JoinHostPort(t.Host, strconv.Itoa(t.Port)))
+}
+
+func (t *Target) exec(ctx context.Context, quiet bool, name string, args ...string) error {
+	if t.Exec != nil {
+		return t.Exec(ctx, quiet, name, args...)
+	}
+
+	return DefaultExec(ctx, quiet, name, args...)
+}
+
+// args returns common ssh/scp args, portFlag is -p for ssh and -P for scp
+func (t *Target) args(portFlag string) []string {
+	args := append([]string{}, t.Options...)
+	args = append(args, portFlag, strconv.Itoa(t.Port))
+	if t.Key != "" {
+		args = append(args, "-i", t.Key)
+	}
+	if len(t.Jump) > 0 {
+		args = append(args, "-J", strings.Join(t.Jump, ","))
+	}
+
+	return args
+}
+
+func (t *Target) remote(path string) string {
+	host := t.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	return t.User + "@" + host + ":" + path
+}
+
+func (t *Target) SSH(ctx context.Context, quiet bool, command string) error {
+	args := append(t.args("-p"), t.User+"@"+t.Host, command)
+
+	return t.exec(ctx, quiet, "ssh", args...)
+}
+
+func (t *Target) Upload(ctx context.Context, quiet bool, from, to string) error {
+	args := append(t.args("-P"), "-r", from, t.remote(to))
+
+	return t.exec(ctx, quiet, "scp", args...)
+}
+
+func (t *Target) Download(ctx context.Context, quiet bool, from, to string) error {
+	args := append(t.args("-P"), "-r", t.remote(from), to)
+
+	return t.exec(ctx, quiet, "scp", args...)
+}
+
+// WaitSSH waits for the target to be reachable over ssh
+func (t *Target) WaitSSH(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := t.SSH(ctx, true, "hostname")
+		if err == nil {
+			return nil
+		}
+
+		slog.Debug("Can't ssh to target", "target", t.String(), "error", err)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "error waiting for ssh")
+		}
+	}
+}
+
+// InstallBundle verifies installer bundle archive packed in the basedir, uploads it to the target together with the
+// checksums and recipe runner, unpacks it and runs the recipe there
+func (t *Target) InstallBundle(ctx context.Context, basedir, bundle string) error {
+	archive, _, err := cnc.FindBundleArchive(basedir, bundle)
+	if err != nil {
+		return errors.Wrap(err, "error finding installer archive")
+	}
+	sums := filepath.Join(basedir, cnc.ChecksumsFile)
+
+	err = cnc.VerifyChecksum(archive, sums)
+	if err != nil {
+		return errors.Wrap(err, "error verifying installer archive")
+	}
+
+	slog.Info("Uploading installer", "target", t.String(), "installer", bundle)
+	// recipe runner is uploaded separately to verify and unpack the archive on the target
+	for _, file := range []string{archive, sums, filepath.Join(basedir, bundle, bin.RecipeBinName)} {
+		err = t.Upload(ctx, false, file, "~/")
+		if err != nil {
+			return errors.Wrapf(err, "error uploading installer file %s", filepath.Base(file))
+		}
+	}
+	slog.Debug("Installer uploaded", "target", t.String(), "installer", bundle)
+
+	slog.Info("Running installer", "target", t.String(), "installer", bundle)
+	output := cnc.OutputText
+	if cnc.IsJSONOutput() {
+		output = cnc.OutputJSON
+	}
+	installCmd := fmt.Sprintf("./%s unpack --sums %s %s && cd %s && sudo ./%s --output %s run",
+		bin.RecipeBinName, cnc.ChecksumsFile, filepath.Base(archive), bundle, bin.RecipeBinName, output)
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		installCmd += " -v"
+	}
+	err = t.SSH(ctx, false, installCmd)
+	if err != nil {
+		return errors.Wrap(err, "error running installer")
+	}
+
+	return nil
+}
+
+// FetchKubeconfig downloads k3s kubeconfig from the target, server address is replaced with the target host unless
+// it's a localhost (e.g. port forwarded VM)
+func (t *Target) FetchKubeconfig(ctx context.Context, to string) error {
+	err := t.Download(ctx, true, KubeconfigPath, to)
+	if err != nil {
+		return errors.Wrapf(err, "error downloading kubeconfig")
+	}
+
+	if t.Host == "127.0.0.1" || t.Host == "localhost" || t.Host == "::1" {
+		return nil
+	}
+
+	if _, err := os.Stat(to); os.IsNotExist(err) {
+		// dry run
+		return nil
+	}
+
+	kubeconfig, err := clientcmd.LoadFromFile(to)
+	if err != nil {
+		return errors.Wrapf(err, "error loading kubeconfig")
+	}
+
+	for name, cluster := range kubeconfig.Clusters {
+		server, err := url.Parse(cluster.Server)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing server url for cluster %s", name)
+		}
+
+		port := server.Port()
+		if port == "" {
+			port = "443"
+		}
+		server.Host = net.JoinHostPort(t.Host, port)
+		cluster.Server = server.String()
+	}
+
+	return errors.Wrapf(clientcmd.WriteToFile(*kubeconfig, to), "error writing kubeconfig")
+}
+
+// Install waits for the target to be reachable over ssh, installs the bundle packed in the basedir on it and fetches
+// kubeconfig into the basedir if requested
+func Install(ctx context.Context, basedir string, target *Target, bundle string, kubeconfig bool) error {
+	start := time.Now()
+
+	cnc.EmitEvent(cnc.Event{Kind: cnc.EventKindInstall, Status: cnc.EventStatusStart, Bundle: bundle, Name: target.String()})
+	err := install(ctx, basedir, target, bundle, kubeconfig)
+	cnc.EmitDone(cnc.Event{Kind: cnc.EventKindInstall, Bundle: bundle, Name: target.String()}, start, err)
+
+	return err
+}
+
+func install(ctx context.Context, basedir string, target *Target, bundle string, kubeconfig bool) error {
+	start := time.Now()
+
+	slog.Info("Installing", "target", target.String(), "bundle", bundle, "jump", strings.Join(target.Jump, ","))
+
+	waitCtx, cancel := context.WithTimeout(ctx, SSHWaitTimeout)
+	defer cancel()
+
+	err := target.WaitSSH(waitCtx, 5*time.Second)
+	if err != nil {
+		return errors.Wrapf(err, "error connecting to %s", target.String())
+	}
+
+	err = target.InstallBundle(ctx, basedir, bundle)
+	if err != nil {
+		return err
+	}
+
+	if kubeconfig {
+		path := filepath.Join(basedir, "kubeconfig.yaml")
+
+		err = target.FetchKubeconfig(ctx, path)
+		if err != nil {
+			return errors.Wrapf(err, "error fetching kubeconfig")
+		}
+
+		slog.Info("Kubeconfig saved", "path", path)
+	}
+
+	slog.Info("Installed", "target", target.String(), "bundle", bundle, "took", time.Since(start))
+
+	return nil
+}
+
+// DefaultExec runs command streaming its output line by line to the stdout or to the logs in JSON output mode
+func DefaultExec(ctx context.Context, quiet bool, name string, args ...string) error {
+	slog.Debug("Running command", "name", name, "args", strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, name, args...)
+
+	var out io.Writer = io.Discard
+	if !quiet || slog.Default().Enabled(ctx, slog.LevelDebug) {
+		var flush func()
+		out, flush = cnc.CommandOutput(name)
+		defer flush()
+	}
+
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	return errors.Wrapf(cmd.Run(), "error running %s", name)
+}
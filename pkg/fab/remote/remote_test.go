@@ -0,0 +1,59 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"testing"
+)
+
+func Test_ParseTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		user   string
+		host   string
+		port   int
+		error  bool
+	}{
+		{target: "control-1", user: "core", host: "control-1", port: 22},
+		{target: "admin@control-1", user: "admin", host: "control-1", port: 22},
+		{target: "admin@172.30.1.1:2222", user: "admin", host: "172.30.1.1", port: 2222},
+		{target: "[fd00::1]:2222", user: "core", host: "fd00::1", port: 2222},
+		{target: "fd00::1", user: "core", host: "fd00::1", port: 22},
+		{target: "", error: true},
+		{target: "@control-1", error: true},
+		{target: "admin@", error: true},
+		{target: "control-1:", error: true},
+		{target: "control-1:ssh", error: true},
+		{target: "control-1:70000", error: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			result, err := ParseTarget(tt.target)
+			if tt.error && err == nil {
+				t.Errorf("ParseTarget(%s) expected error, got nil", tt.target)
+			}
+			if !tt.error && err != nil {
+				t.Errorf("ParseTarget(%s) expected no error, got %v", tt.target, err)
+			}
+			if tt.error || err != nil {
+				return
+			}
+			if result.User != tt.user || result.Host != tt.host || result.Port != tt.port {
+				t.Errorf("ParseTarget(%s) expected %s@%s:%d, got %s@%s:%d", tt.target, tt.user, tt.host, tt.port, result.User, result.Host, result.Port)
+			}
+		})
+	}
+}
@@ -39,7 +39,10 @@ type ServerOS struct {
 	ToolboxRef   cnc.Ref `json:"toolboxRef,omitempty"`
 }
 
-var _ cnc.Component = (*ServerOS)(nil)
+var (
+	_ cnc.Component       = (*ServerOS)(nil)
+	_ cnc.SecretsProvider = (*ServerOS)(nil)
+)
 
 func (cfg *ServerOS) Name() string {
 	return "server-os"
@@ -49,6 +52,10 @@ func (cfg *ServerOS) IsEnabled(preset cnc.Preset) bool {
 	return preset == PresetVLAB
 }
 
+func (cfg *ServerOS) SecretFields() []string {
+	return []string{"passwordHash"}
+}
+
 func (cfg *ServerOS) Flags() []cli.Flag {
 	return []cli.Flag{
 		&cli.StringFlag{
@@ -61,7 +68,7 @@ func (cfg *ServerOS) Flags() []cli.Flag {
 	}
 }
 
-func (cfg *ServerOS) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
+func (cfg *ServerOS) Hydrate(_ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent) error {
 	cfg.ToolboxRef = cfg.ToolboxRef.Fallback(RefToolbox)
 
 	return nil
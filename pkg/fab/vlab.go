@@ -45,7 +45,7 @@ func (cfg *VLAB) Flags() []cli.Flag {
 	return nil
 }
 
-func (cfg *VLAB) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
+func (cfg *VLAB) Hydrate(_ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent) error {
 	cfg.ONIERef = cfg.ONIERef.Fallback(RefVLABONIE)
 	cfg.FlatcarRef = cfg.FlatcarRef.Fallback(RefVLABFlarcar)
 	cfg.EEPROMEditRef = cfg.EEPROMEditRef.Fallback(RefVLABEEPROMEdit)
@@ -27,6 +27,7 @@ import (
 	"github.com/manifoldco/promptui"
 	"github.com/pkg/errors"
 	"go.githedgehog.com/fabric/pkg/wiring"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -39,6 +40,9 @@ const (
 	IfPortNull       = IfPortBase + 9000
 	IfPortVMIDMult   = 100
 	IfPortPortIDMult = 1
+
+	ControlClusterMcast = "230.0.0.1:39500" // shared network between control VMs
+	ControlClusterNet   = "172.31.255"
 )
 
 var RequiredCommands = []string{
@@ -73,6 +77,9 @@ type ServiceConfig struct {
 	ServerInstaller   string
 	FilesDir          string
 	SSHKey            string
+	// ignitions and installers for the control nodes joining the cluster by name
+	ControlJoinIgnitions  map[string]string
+	ControlJoinInstallers map[string]string
 }
 
 func Load(cfg *ServiceConfig) (*Service, error) {
@@ -108,6 +115,12 @@ func Load(cfg *ServiceConfig) (*Service, error) {
 		return nil, errors.Wrapf(err, "error creating VM manager")
 	}
 
+	for _, name := range ControlNodes(cfg.Wiring)[1:] {
+		if cfg.ControlJoinIgnitions[name] == "" || cfg.ControlJoinInstallers[name] == "" {
+			return nil, errors.Errorf("control ignition or installer is not specified for joining control node %s", name)
+		}
+	}
+
 	svc := &Service{
 		cfg:  cfg,
 		mngr: mngr,
@@ -117,6 +130,16 @@ func Load(cfg *ServiceConfig) (*Service, error) {
 }
 
 func (svc *Service) StartServer(killStaleVMs bool, charNBDDev string, installComplete bool, runComplete string, onReady []string) error {
+	start := time.Now()
+
+	cnc.EmitEvent(cnc.Event{Kind: cnc.EventKindVLAB, Status: cnc.EventStatusStart})
+	err := svc.startServer(killStaleVMs, charNBDDev, installComplete, runComplete, onReady)
+	cnc.EmitDone(cnc.Event{Kind: cnc.EventKindVLAB}, start, err)
+
+	return err
+}
+
+func (svc *Service) startServer(killStaleVMs bool, charNBDDev string, installComplete bool, runComplete string, onReady []string) error {
 	svc.cfg.CharNBDDev = charNBDDev
 	svc.cfg.InstallComplete = installComplete
 	svc.cfg.RunComplete = runComplete
@@ -280,9 +303,19 @@ func (svc *Service) vmSelector(name string, mode string, msg string) (*VM, error
 }
 
 func (svc *Service) findJumpForSwitch(name string) (string, string, error) {
+	// switch is reachable from the control node it's directly attached to, or from the first one otherwise
+	controlName := ""
+	for _, conn := range svc.cfg.Wiring.Connection.All() {
+		if conn.Spec.Management != nil && conn.Spec.Management.Link.Switch.DeviceName() == name {
+			controlName = conn.Spec.Management.Link.Server.DeviceName()
+
+			break
+		}
+	}
+
 	var controlVM *VM
 	for _, vm := range svc.mngr.sortedVMs() {
-		if vm.Type == VMTypeControl {
+		if vm.Type == VMTypeControl && (controlName == "" || vm.Name == controlName) {
 			controlVM = vm
 
 			break
@@ -124,6 +124,8 @@ type VM struct {
 	Basedir    string
 	Config     VMConfig
 	Interfaces map[int]VMInterface
+	// Join is true for the control nodes joining the cluster initialized by the first one
+	Join bool
 
 	Ready     fileMarker
 	Installed fileMarker
@@ -167,26 +169,34 @@ func NewVMManager(cfg *Config, data *wiring.Data, basedir string, size string, r
 
 	vmID := 0
 
-	for _, server := range data.Server.All() {
-		if server.Spec.Type != wiringapi.ServerTypeControl {
-			continue
+	controls := ControlNodes(data)
+	if len(controls) == 0 {
+		return nil, errors.Errorf("control node is required")
+	}
+
+	for _, name := range controls {
+		if mngr.vms[name] != nil {
+			return nil, errors.Errorf("duplicate server/switch name: %s", name)
 		}
 
-		if mngr.vms[server.Name] != nil {
-			return nil, errors.Errorf("duplicate server/switch name: %s", server.Name)
+		// only the first control node (initializing the cluster) is exposed to the host
+		hostfwd := fmt.Sprintf("hostfwd=tcp:0.0.0.0:%d-:22", sshPortFor(vmID))
+		if vmID == 0 {
+			hostfwd += fmt.Sprintf(",hostfwd=tcp:0.0.0.0:%d-:6443,hostfwd=tcp:0.0.0.0:%d-:31000", KubePort, RegistryPort)
 		}
 
-		mngr.vms[server.Name] = &VM{
+		mngr.vms[name] = &VM{
 			ID:     vmID,
-			Name:   server.Name,
+			Name:   name,
 			Type:   VMTypeControl,
 			Config: cfg.VMs.Control,
+			Join:   vmID > 0,
 			Interfaces: map[int]VMInterface{
 				0: {
 					Connection: "host",
 					// TODO optionally make control node isolated using ",restrict=yes"
-					Netdev: fmt.Sprintf("user,hostfwd=tcp:0.0.0.0:%d-:22,hostfwd=tcp:0.0.0.0:%d-:6443,hostfwd=tcp:0.0.0.0:%d-:31000,hostname=%s,domainname=local,dnssearch=local,net=172.31.%d.0/24,dhcpstart=172.31.%d.10",
-						sshPortFor(vmID), KubePort, RegistryPort, server.Name, vmID, vmID),
+					Netdev: fmt.Sprintf("user,%s,hostname=%s,domainname=local,dnssearch=local,net=172.31.%d.0/24,dhcpstart=172.31.%d.10",
+						hostfwd, name, vmID, vmID),
 				},
 			},
 		}
@@ -194,13 +204,6 @@ func NewVMManager(cfg *Config, data *wiring.Data, basedir string, size string, r
 		vmID++
 	}
 
-	if vmID == 0 {
-		return nil, errors.Errorf("control node is required")
-	}
-	if vmID > 1 {
-		return nil, errors.Errorf("multiple control nodes not support")
-	}
-
 	for _, server := range data.Server.All() {
 		if server.Spec.Type != wiringapi.ServerTypeDefault {
 			continue
@@ -347,6 +350,21 @@ func NewVMManager(cfg *Config, data *wiring.Data, basedir string, size string, r
 		}
 	}
 
+	// all control nodes are connected to the single shared network used by the k3s cluster
+	if len(controls) > 1 {
+		clusterPortID, err := ControlClusterPortID(data)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range controls {
+			mngr.vms[name].Interfaces[clusterPortID] = VMInterface{
+				Connection: "control-cluster",
+				Netdev:     "socket,mcast=" + ControlClusterMcast + ",localaddr=127.0.0.1",
+			}
+		}
+	}
+
 	// fill gaps in interfaces
 	for _, vm := range mngr.vms {
 		if vm.Type == VMTypeSwitchHW {
@@ -455,6 +473,18 @@ func (mngr *VMManager) sortedVMs() []*VM {
 	return vms
 }
 
+// controlVMs returns either the first control VM or the ones joining the cluster
+func (mngr *VMManager) controlVMs(join bool) []*VM {
+	res := []*VM{}
+	for _, vm := range mngr.sortedVMs() {
+		if vm.Type == VMTypeControl && vm.Join == join {
+			res = append(res, vm)
+		}
+	}
+
+	return res
+}
+
 func (mngr *VMManager) LogOverview() {
 	for _, vm := range mngr.sortedVMs() {
 		if vm.Type == VMTypeSwitchHW {
@@ -497,6 +527,56 @@ func sshPortFor(vmID int) int {
 	return SSHPortBase + vmID
 }
 
+// ControlNodes returns names of the control nodes sorted by name, the first one is initializing the cluster
+func ControlNodes(data *wiring.Data) []string {
+	res := []string{}
+	for _, server := range data.Server.All() {
+		if server.Spec.Type == wiringapi.ServerTypeControl {
+			res = append(res, server.Name)
+		}
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// ControlClusterPortID returns the interface used for the network between control VMs, it's the first one after all
+// management ports of all control nodes, so it's named the same way on all of them
+func ControlClusterPortID(data *wiring.Data) (int, error) {
+	maxPortID := 0
+	for _, conn := range data.Connection.All() {
+		if conn.Spec.Management == nil {
+			continue
+		}
+
+		portID, err := portIDForName(conn.Spec.Management.Link.Server.LocalPortName())
+		if err != nil {
+			return -1, err
+		}
+		if portID > maxPortID {
+			maxPortID = portID
+		}
+	}
+
+	return maxPortID + 1, nil
+}
+
+// ControlClusterIface returns the name of the control VM interface connected to the network between control VMs
+func ControlClusterIface(data *wiring.Data) (string, error) {
+	portID, err := ControlClusterPortID(data)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("enp2s%d", portID), nil
+}
+
+// ControlClusterIP returns the IP (with prefix) of the control VM with the given index on the network between them
+func ControlClusterIP(idx int) string {
+	return fmt.Sprintf("%s.%d/24", ControlClusterNet, 10+idx)
+}
+
 func portIDForName(name string) (int, error) {
 	if strings.HasPrefix(name, "Management0") {
 		return 0, nil
@@ -0,0 +1,100 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vlab
+
+import (
+	"fmt"
+	"testing"
+
+	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ControlClusterPortID(t *testing.T) {
+	tests := []struct {
+		name   string
+		ports  []string
+		result int
+		error  bool
+	}{
+		{
+			name:   "no-mgmt",
+			result: 1,
+		},
+		{
+			name:   "single-control",
+			ports:  []string{"control-1/enp2s1", "control-1/enp2s2"},
+			result: 3,
+		},
+		{
+			name:   "multi-control",
+			ports:  []string{"control-1/enp2s1", "control-2/enp2s1", "control-1/enp2s2", "control-2/enp2s2", "control-1/enp2s3"},
+			result: 4,
+		},
+		{
+			name:  "unsupported",
+			ports: []string{"control-1/eth0"},
+			error: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := wiring.New()
+			if err != nil {
+				t.Fatalf("error creating wiring data: %v", err)
+			}
+
+			for idx, port := range tt.ports {
+				spec := wiringapi.ConnectionSpec{
+					Management: &wiringapi.ConnMgmt{
+						Link: wiringapi.ConnMgmtLink{
+							Server: wiringapi.ConnMgmtLinkServer{BasePortName: wiringapi.BasePortName{Port: port}},
+							Switch: wiringapi.ConnMgmtLinkSwitch{
+								BasePortName: wiringapi.BasePortName{Port: fmt.Sprintf("switch-%d/Management0", idx)},
+								ONIEPortName: "eth0",
+							},
+						},
+					},
+				}
+
+				err := data.Add(&wiringapi.Connection{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       wiringapi.KindConnection,
+						APIVersion: wiringapi.GroupVersion.String(),
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name: spec.GenerateName(),
+					},
+					Spec: spec,
+				})
+				if err != nil {
+					t.Fatalf("error adding connection: %v", err)
+				}
+			}
+
+			result, err := ControlClusterPortID(data)
+			if tt.error && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.error && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.error && result != tt.result {
+				t.Errorf("ControlClusterPortID() = %v, want %v", result, tt.result)
+			}
+		})
+	}
+}
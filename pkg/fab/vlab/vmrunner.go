@@ -28,6 +28,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
+	"go.githedgehog.com/fabricator/pkg/fab/cnc"
+	"go.githedgehog.com/fabricator/pkg/fab/remote"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -88,6 +90,10 @@ func (vm *VM) RunVM(ctx context.Context, svcCfg *ServiceConfig) func() error {
 
 		slog.Info("Running VM", "id", vm.ID, "name", vm.Name, "type", vm.Type)
 
+		start := time.Now()
+		event := cnc.Event{Kind: cnc.EventKindVM, Status: cnc.EventStatusStart, Name: vm.Name, Op: string(vm.Type)}
+		cnc.EmitEvent(event)
+
 		args := []string{
 			"qemu-system-x86_64",
 			"-name", vm.Name,
@@ -159,7 +165,10 @@ func (vm *VM) RunVM(ctx context.Context, svcCfg *ServiceConfig) func() error {
 			args = append(args, "-device", device)
 		}
 
-		return errors.Wrapf(execCmd(ctx, svcCfg, vm.Basedir, true, "sudo", []string{}, args...), "error running vm")
+		err := execCmd(ctx, svcCfg, vm.Basedir, true, "sudo", []string{}, args...)
+		cnc.EmitDone(event, start, err)
+
+		return errors.Wrapf(err, "error running vm")
 	}
 }
 
@@ -174,91 +183,49 @@ func (vm *VM) RunInstall(ctx context.Context, svc *Service) func() error {
 			return nil
 		}
 
-		if vm.Installed.Is() {
-			slog.Debug("VM is already installed", "name", vm.Name)
-		} else {
-			slog.Info("Installing VM", "name", vm.Name, "type", vm.Type)
-
-			timeout := 10 * time.Minute // TODO
-			if len(svcCfg.OnReady) > 0 {
-				timeout = 60 * time.Minute // TODO
-			}
-
-			ctx, cancel := context.WithTimeoutCause(ctx, timeout, errors.New("controller installation timed out")) // TODO
-			defer cancel()
-
-			slog.Debug("Waiting for VM ssh", "name", vm.Name, "type", vm.Type)
-
-			ticker := time.NewTicker(5 * time.Second) // TODO
-			defer ticker.Stop()
-
-		loop: // oops, some goto :)
-			for {
-				select {
-				case <-ticker.C:
-					err := vm.ssh(ctx, svcCfg, true, "hostname")
-					if err != nil {
-						// just waiting
-						slog.Debug("Can't ssh to VM", "name", vm.Name, "type", vm.Type, "error", err)
-					} else {
-						break loop
-					}
-				case <-ctx.Done():
-					return errors.Wrapf(ctx.Err(), "error waiting for ssh")
-				}
-			}
-			slog.Info("VM ssh is available", "name", vm.Name, "type", vm.Type)
-
-			// TODO k3s really don't like when we don't have default route
-			// err := vm.ssh(ctx, "sudo ip route add default via 10.100.0.2 dev eth0")
-			// if err != nil {
-			// 	return errors.Wrap(err, "error setting default route")
-			// }
+		event := cnc.Event{Kind: cnc.EventKindVMInstall, Name: vm.Name, Op: string(vm.Type)}
 
-			installerPath := svcCfg.ControlInstaller
-			if vm.Type == VMTypeServer {
-				installerPath = svcCfg.ServerInstaller
+		// joining control nodes could only be installed after the cluster is initialized by the first one
+		if vm.Join {
+			slog.Info("Waiting for the first control node to be installed before joining", "name", vm.Name)
+			if err := waitInstalled(ctx, svc.mngr.controlVMs(false)); err != nil {
+				return err
 			}
-			installer := filepath.Base(installerPath)
-
-			slog.Info("Uploading installer", "name", vm.Name, "type", vm.Type, "installer", installer)
-			err := vm.upload(ctx, svcCfg, false, installerPath+".tgz", "~/")
-			if err != nil {
-				return errors.Wrap(err, "error uploading installer")
-			}
-			slog.Debug("Installer uploaded", "name", vm.Name, "type", vm.Type, "installer", installer)
+		}
 
-			slog.Info("Running installer on VM", "name", vm.Name, "type", vm.Type, "installer", installer)
-			installCmd := fmt.Sprintf("tar xzf %s.tgz && cd %s && sudo ./hhfab-recipe run", installer, installer)
-			if slog.Default().Enabled(ctx, slog.LevelDebug) {
-				installCmd += " -v"
-			}
-			err = vm.ssh(ctx, svcCfg, false, installCmd)
-			if err != nil {
-				return errors.Wrap(err, "error installing vm")
-			}
+		if vm.Installed.Is() {
+			slog.Debug("VM is already installed", "name", vm.Name)
 
-			err = vm.download(ctx, svcCfg, true, "/etc/rancher/k3s/k3s.yaml", filepath.Join(svcCfg.Basedir, "kubeconfig.yaml"))
-			if err != nil {
-				return errors.Wrapf(err, "error downloading kubeconfig")
-			}
+			event.Status = cnc.EventStatusSkip
+			cnc.EmitEvent(event)
+		} else {
+			start := time.Now()
 
-			slog.Info("VM installed", "name", vm.Name, "type", vm.Type, "installer", installer)
+			event.Status = cnc.EventStatusStart
+			cnc.EmitEvent(event)
 
-			err = vm.Installed.Mark()
+			err := vm.install(ctx, svcCfg)
+			cnc.EmitDone(event, start, err)
 			if err != nil {
-				return errors.Wrapf(err, "error marking vm as installed")
+				return err
 			}
 		}
 
-		if vm.Type != VMTypeControl {
+		if vm.Type != VMTypeControl || vm.Join {
 			return nil
 		}
 
+		if joins := svc.mngr.controlVMs(true); len(joins) > 0 {
+			slog.Info("Waiting for the rest of control nodes to join", "nodes", len(joins))
+			if err := waitInstalled(ctx, joins); err != nil {
+				return err
+			}
+		}
+
 		if svcCfg.InstallComplete {
 			// TODO do graceful shutdown
 			slog.Info("Exiting after control node installation as requested")
-			os.Exit(0)
+			exitVLAB(nil)
 		}
 
 		if svcCfg.RunComplete != "" {
@@ -268,19 +235,19 @@ func (vm *VM) RunInstall(ctx context.Context, svc *Service) func() error {
 				"KUBECONFIG=" + filepath.Join(svcCfg.Basedir, "kubeconfig.yaml"),
 			}); err != nil {
 				slog.Error("error running script after control node installation", "error", err)
-				os.Exit(1)
+				exitVLAB(err)
 			}
 
 			// TODO do graceful shutdown
 			slog.Info("Exiting after script succeded (after control node installation) as requested")
-			os.Exit(0)
+			exitVLAB(nil)
 		}
 
 		if len(svcCfg.OnReady) > 0 {
 			slog.Info("Waiting for all switches to get ready as requested and run commands after that")
 			if err := waitForSwitchesReady(ctx, svcCfg); err != nil {
 				slog.Error("error waiting switches are ready", "error", err)
-				os.Exit(1)
+				exitVLAB(err)
 			}
 		}
 
@@ -292,7 +259,7 @@ func (vm *VM) RunInstall(ctx context.Context, svc *Service) func() error {
 					Type: VPCSetupTypeVPCPerServer,
 				}); err != nil {
 					slog.Error("error running setup-vpcs after switches are ready", "error", err)
-					os.Exit(1)
+					exitVLAB(err)
 				}
 			} else if strings.HasPrefix(cmd, "setup-peerings:") {
 				slog.Info("Running setup-peerings after switches are ready as requested")
@@ -308,7 +275,7 @@ func (vm *VM) RunInstall(ctx context.Context, svc *Service) func() error {
 				slog.Info("Exiting after switches are ready as requested")
 
 				// TODO do graceful shutdown
-				os.Exit(0)
+				exitVLAB(nil)
 			} else if cmd != "noop" {
 				slog.Info("Running script after switches are ready as requested")
 
@@ -316,7 +283,7 @@ func (vm *VM) RunInstall(ctx context.Context, svc *Service) func() error {
 					"KUBECONFIG=" + filepath.Join(svcCfg.Basedir, "kubeconfig.yaml"),
 				}); err != nil {
 					slog.Error("error running script after switches are ready", "error", err)
-					os.Exit(1)
+					exitVLAB(err)
 				}
 			}
 		}
@@ -334,6 +301,101 @@ func (vm *VM) RunInstall(ctx context.Context, svc *Service) func() error {
 	}
 }
 
+// waitInstalled waits for all VMs to be marked as installed
+func waitInstalled(ctx context.Context, vms []*VM) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		installed := true
+		for _, vm := range vms {
+			if !vm.Installed.Is() {
+				installed = false
+
+				break
+			}
+		}
+		if installed {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "error waiting for control nodes to be installed")
+		}
+	}
+}
+
+// exitVLAB emits the final vlab event and exits, it's used when vlab is requested to exit after some step
+func exitVLAB(err error) {
+	if err != nil {
+		cnc.EmitEvent(cnc.Event{Kind: cnc.EventKindVLAB, Status: cnc.EventStatusError, Error: err.Error()})
+		os.Exit(1)
+	}
+
+	cnc.EmitEvent(cnc.Event{Kind: cnc.EventKindVLAB, Status: cnc.EventStatusFinish})
+	os.Exit(0)
+}
+
+func (vm *VM) install(ctx context.Context, svcCfg *ServiceConfig) error {
+	slog.Info("Installing VM", "name", vm.Name, "type", vm.Type)
+
+	timeout := 10 * time.Minute // TODO
+	if len(svcCfg.OnReady) > 0 {
+		timeout = 60 * time.Minute // TODO
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, errors.New("controller installation timed out")) // TODO
+	defer cancel()
+
+	slog.Debug("Waiting for VM ssh", "name", vm.Name, "type", vm.Type)
+
+	target := vm.remote(svcCfg)
+
+	err := target.WaitSSH(ctx, 5*time.Second) // TODO
+	if err != nil {
+		return err
+	}
+	slog.Info("VM ssh is available", "name", vm.Name, "type", vm.Type)
+
+	// TODO k3s really don't like when we don't have default route
+	// err := vm.ssh(ctx, "sudo ip route add default via 10.100.0.2 dev eth0")
+	// if err != nil {
+	// 	return errors.Wrap(err, "error setting default route")
+	// }
+
+	installerPath := svcCfg.ControlInstaller
+	if vm.Type == VMTypeServer {
+		installerPath = svcCfg.ServerInstaller
+	} else if vm.Join {
+		installerPath = svcCfg.ControlJoinInstallers[vm.Name]
+	}
+	installer := filepath.Base(installerPath)
+
+	err = target.InstallBundle(ctx, filepath.Dir(installerPath), installer)
+	if err != nil {
+		return errors.Wrap(err, "error installing vm")
+	}
+
+	// only the first control node is exposed to the host
+	if vm.Type == VMTypeControl && !vm.Join {
+		err = target.FetchKubeconfig(ctx, filepath.Join(svcCfg.Basedir, "kubeconfig.yaml"))
+		if err != nil {
+			return errors.Wrapf(err, "error fetching kubeconfig")
+		}
+	}
+
+	slog.Info("VM installed", "name", vm.Name, "type", vm.Type, "installer", installer)
+
+	err = vm.Installed.Mark()
+	if err != nil {
+		return errors.Wrapf(err, "error marking vm as installed")
+	}
+
+	return nil
+}
+
 func (vm *VM) Prepare(ctx context.Context, svcCfg *ServiceConfig) error {
 	if svcCfg.DryRun {
 		slog.Debug("Skipping VM preparation in dry-run mode", "name", vm.Name)
@@ -359,7 +421,9 @@ func (vm *VM) Prepare(ctx context.Context, svcCfg *ServiceConfig) error {
 		files["efi_code.fd"] = filepath.Join(svcCfg.FilesDir, "flatcar_efi_code.fd")
 		files["efi_vars.fd"] = filepath.Join(svcCfg.FilesDir, "flatcar_efi_vars.fd")
 
-		if vm.Type == VMTypeControl {
+		if vm.Type == VMTypeControl && vm.Join {
+			files["ignition.json"] = svcCfg.ControlJoinIgnitions[vm.Name]
+		} else if vm.Type == VMTypeControl {
 			files["ignition.json"] = svcCfg.ControlIgnition
 		} else {
 			files["ignition.json"] = filepath.Join(svcCfg.ServerIgnitionDir, fmt.Sprintf("%s.ignition.json", vm.Name))
@@ -451,6 +515,7 @@ func (vm *VM) copyFiles(ctx context.Context, names map[string]string) error {
 
 		p := mpb.New(
 			mpb.WithWidth(60),
+			mpb.WithOutput(cnc.ProgressOutput()),
 		)
 
 		info, err := fromFile.Stat()
@@ -488,39 +553,18 @@ func (vm *VM) copyFiles(ctx context.Context, names map[string]string) error {
 	return nil
 }
 
-func (vm *VM) ssh(ctx context.Context, svcCfg *ServiceConfig, quiet bool, command string) error {
-	args := append(SSHQuietFlags,
-		"-p", fmt.Sprintf("%d", vm.sshPort()),
-		"-i", svcCfg.SSHKey,
-		"core@127.0.0.1",
-		command,
-	)
-
-	return execCmd(ctx, svcCfg, "", quiet, "ssh", []string{}, args...)
-}
-
-func (vm *VM) upload(ctx context.Context, svcCfg *ServiceConfig, quiet bool, from, to string) error {
-	args := append(SSHQuietFlags,
-		"-P", fmt.Sprintf("%d", vm.sshPort()),
-		"-i", svcCfg.SSHKey,
-		"-r",
-		from,
-		"core@127.0.0.1:"+to,
-	)
-
-	return execCmd(ctx, svcCfg, "", quiet, "scp", []string{}, args...)
-}
-
-func (vm *VM) download(ctx context.Context, svcCfg *ServiceConfig, quiet bool, from, to string) error {
-	args := append(SSHQuietFlags,
-		"-P", fmt.Sprintf("%d", vm.sshPort()),
-		"-i", svcCfg.SSHKey,
-		"-r",
-		"core@127.0.0.1:"+from,
-		to,
-	)
-
-	return execCmd(ctx, svcCfg, "", quiet, "scp", []string{}, args...)
+// remote returns ssh target for the VM, it's available on the localhost using port forwarding
+func (vm *VM) remote(svcCfg *ServiceConfig) *remote.Target {
+	return &remote.Target{
+		User:    "core",
+		Host:    "127.0.0.1",
+		Port:    vm.sshPort(),
+		Key:     svcCfg.SSHKey,
+		Options: SSHQuietFlags,
+		Exec: func(ctx context.Context, quiet bool, name string, args ...string) error {
+			return execCmd(ctx, svcCfg, "", quiet, name, []string{}, args...)
+		},
+	}
 }
 
 func execCmd(ctx context.Context, svcCfg *ServiceConfig, basedir string, quiet bool, name string, env []string, args ...string) error {
@@ -549,7 +593,10 @@ func execCmd(ctx context.Context, svcCfg *ServiceConfig, basedir string, quiet b
 	outputs := []io.Writer{logFile}
 
 	if !quiet || slog.Default().Enabled(ctx, slog.LevelDebug) {
-		outputs = append(outputs, os.Stdout)
+		out, flush := cnc.CommandOutput(name)
+		defer flush()
+
+		outputs = append(outputs, out)
 	}
 
 	cmd.Stdout = io.MultiWriter(outputs...)
@@ -43,6 +43,7 @@ type Builder struct {
 	ChainControlLink  bool            // true if not all switches attached directly to control node
 	External          bool            // true if virtual external should be applied
 	ControlLinksCount uint8           // number of control links to generate
+	ControlNodesCount uint8           // number of control nodes to generate, switches are attached to them round-robin
 	SpinesCount       uint8           // number of spines to generate
 	FabricLinksCount  uint8           // number of links for each spine <> leaf pair
 	MCLAGLeafsCount   uint8           // number of MCLAG server-leafs to generate
@@ -54,6 +55,7 @@ type Builder struct {
 
 	data         *wiring.Data
 	ifaceTracker map[string]uint8 // next available interface ID for each switch
+	controlID    uint8            // control node to attach next switch to
 }
 
 func (b *Builder) Build() (*wiring.Data, error) {
@@ -103,6 +105,10 @@ func (b *Builder) Build() (*wiring.Data, error) {
 		return nil, errors.Errorf("unsupported fabric mode %s", b.FabricMode)
 	}
 
+	if b.ControlNodesCount == 0 {
+		b.ControlNodesCount = 1
+	}
+
 	if b.MCLAGSessionLinks == 0 {
 		b.MCLAGSessionLinks = 2
 	}
@@ -205,11 +211,13 @@ func (b *Builder) Build() (*wiring.Data, error) {
 		return nil, err
 	}
 
-	if _, err := b.createServer(Control, wiringapi.ServerSpec{
-		Type:        wiringapi.ServerTypeControl,
-		Description: "Control node",
-	}); err != nil {
-		return nil, err
+	for controlID := uint8(1); controlID <= b.ControlNodesCount; controlID++ {
+		if _, err := b.createServer(fmt.Sprintf("control-%d", controlID), wiringapi.ServerSpec{
+			Type:        wiringapi.ServerTypeControl,
+			Description: "Control node",
+		}); err != nil {
+			return nil, err
+		}
 	}
 
 	switchID := uint8(1) // switch ID counter
@@ -698,6 +706,13 @@ func (b *Builder) nextControlPort(serverName string) string {
 	return portName
 }
 
+func (b *Builder) nextControlNode() string {
+	name := fmt.Sprintf("control-%d", b.controlID%b.ControlNodesCount+1)
+	b.controlID++
+
+	return name
+}
+
 func (b *Builder) nextServerPort(serverName string) string {
 	ifaceID := b.ifaceTracker[serverName]
 	portName := fmt.Sprintf("%s/enp2s%d", serverName, ifaceID+1) // value for VLAB
@@ -812,7 +827,7 @@ func (b *Builder) createManagementConnection(switchName string) (*wiringapi.Conn
 		Management: &wiringapi.ConnMgmt{
 			Link: wiringapi.ConnMgmtLink{
 				Server: wiringapi.ConnMgmtLinkServer{
-					BasePortName: wiringapi.BasePortName{Port: b.nextControlPort(Control)},
+					BasePortName: wiringapi.BasePortName{Port: b.nextControlPort(b.nextControlNode())},
 				},
 				Switch: wiringapi.ConnMgmtLinkSwitch{
 					BasePortName: wiringapi.BasePortName{Port: fmt.Sprintf("%s/Management0", switchName)},
@@ -831,7 +846,7 @@ func (b *Builder) createControlConnection(switchName string) (*wiringapi.Connect
 		Management: &wiringapi.ConnMgmt{
 			Link: wiringapi.ConnMgmtLink{
 				Server: wiringapi.ConnMgmtLinkServer{
-					BasePortName: wiringapi.BasePortName{Port: b.nextControlPort(Control)},
+					BasePortName: wiringapi.BasePortName{Port: b.nextControlPort(b.nextControlNode())},
 				},
 				Switch: wiringapi.ConnMgmtLinkSwitch{
 					BasePortName: wiringapi.BasePortName{Port: port},
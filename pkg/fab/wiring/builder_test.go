@@ -0,0 +1,62 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wiring
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_NextControlNode(t *testing.T) {
+	tests := []struct {
+		name     string
+		controls uint8
+		want     []string
+	}{
+		{
+			name:     "single",
+			controls: 1,
+			want:     []string{"control-1/enp2s1", "control-1/enp2s2", "control-1/enp2s3"},
+		},
+		{
+			name:     "round-robin",
+			controls: 2,
+			want:     []string{"control-1/enp2s1", "control-2/enp2s1", "control-1/enp2s2", "control-2/enp2s2", "control-1/enp2s3"},
+		},
+		{
+			name:     "more-controls-than-switches",
+			controls: 3,
+			want:     []string{"control-1/enp2s1", "control-2/enp2s1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &Builder{
+				ControlNodesCount: test.controls,
+				ifaceTracker:      map[string]uint8{},
+			}
+
+			got := []string{}
+			for range test.want {
+				got = append(got, b.nextControlPort(b.nextControlNode()))
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("control ports = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
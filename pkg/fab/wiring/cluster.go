@@ -0,0 +1,71 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wiring
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	vpcapi "go.githedgehog.com/fabric/api/vpc/v1alpha2"
+	wiringapi "go.githedgehog.com/fabric/api/wiring/v1alpha2"
+	"go.githedgehog.com/fabric/pkg/wiring"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LoadFromCluster loads wiring objects from the cluster as it's the source of truth for the running fabric
+func LoadFromCluster(ctx context.Context, kube client.Reader) (*wiring.Data, error) {
+	data, err := wiring.New()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating wiring data")
+	}
+
+	for _, list := range []client.ObjectList{
+		&vpcapi.IPv4NamespaceList{},
+		&wiringapi.VLANNamespaceList{},
+		&wiringapi.RackList{},
+		&wiringapi.SwitchGroupList{},
+		&wiringapi.SwitchList{},
+		&wiringapi.ServerList{},
+		&wiringapi.ConnectionList{},
+	} {
+		if err := kube.List(ctx, list); err != nil {
+			return nil, errors.Wrapf(err, "error listing %T", list)
+		}
+
+		objs, err := apimeta.ExtractList(list)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error extracting %T", list)
+		}
+
+		for _, obj := range objs {
+			kobj, ok := obj.(client.Object)
+			if !ok {
+				return nil, errors.Errorf("unexpected object %T", obj)
+			}
+
+			// objects are re-created in the in-memory wiring
+			kobj.SetResourceVersion("")
+			kobj.SetUID("")
+			kobj.SetManagedFields(nil)
+
+			if err := data.Add(kobj); err != nil {
+				return nil, errors.Wrapf(err, "error adding %T %s", obj, kobj.GetName())
+			}
+		}
+	}
+
+	return data, nil
+}
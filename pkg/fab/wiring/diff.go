@@ -0,0 +1,494 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wiring
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.githedgehog.com/fabric/pkg/wiring"
+)
+
+type ChangeType string
+
+const (
+	ChangeAdded   ChangeType = "added"
+	ChangeRemoved ChangeType = "removed"
+	ChangeChanged ChangeType = "changed"
+)
+
+const (
+	KindIPv4Namespace = "IPv4Namespace"
+	KindVLANNamespace = "VLANNamespace"
+	KindRack          = "Rack"
+	KindSwitchGroup   = "SwitchGroup"
+	KindSwitch        = "Switch"
+	KindServer        = "Server"
+	KindConnection    = "Connection"
+)
+
+// Change is a single added, removed or changed wiring object, unsafe reasons are set for changes that fabric
+// controller can't apply to the running switches
+type Change struct {
+	Kind     string     `json:"kind"`
+	Name     string     `json:"name"`
+	Type     ChangeType `json:"type"`
+	Fields   []string   `json:"fields,omitempty"`
+	Unsafe   []string   `json:"unsafe,omitempty"`
+	Switches []string   `json:"switches,omitempty"`
+}
+
+func (c Change) IsUnsafe() bool {
+	return len(c.Unsafe) > 0
+}
+
+type PlanAction string
+
+const (
+	PlanCreate PlanAction = "create"
+	PlanUpdate PlanAction = "update"
+	PlanDelete PlanAction = "delete"
+)
+
+// PlanStep is a single step of the apply plan, steps are ordered so that objects are created before the connections
+// referencing them and deleted after
+type PlanStep struct {
+	Action PlanAction `json:"action"`
+	Kind   string     `json:"kind"`
+	Name   string     `json:"name"`
+	Unsafe bool       `json:"unsafe,omitempty"`
+}
+
+type Diff struct {
+	Changes []Change   `json:"changes"`
+	Plan    []PlanStep `json:"plan"`
+}
+
+func (d *Diff) IsEmpty() bool {
+	return len(d.Changes) == 0
+}
+
+func (d *Diff) IsUnsafe() bool {
+	return slices.ContainsFunc(d.Changes, Change.IsUnsafe)
+}
+
+// switch spec fields that are baked into the switch config on provisioning
+var unsafeSwitchFields = []string{"role", "asn", "ip", "vtepIP", "protocolIP"}
+
+// DiffPaths loads wiring from files (or fabricator basedirs) and compares them
+func DiffPaths(fromPath, toPath string) (*Diff, error) {
+	from, err := loadDiffWiring(fromPath)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := loadDiffWiring(toPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return DiffData(from, to)
+}
+
+func loadDiffWiring(path string) (*wiring.Data, error) {
+	if path == "" {
+		return nil, errors.Errorf("wiring path is not specified")
+	}
+
+	// fabricator basedir contains config next to the wiring, so only wiring file is loaded from it
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		if _, err := os.Stat(filepath.Join(path, "wiring.yaml")); err == nil {
+			path = filepath.Join(path, "wiring.yaml")
+		}
+	}
+
+	data, err := wiring.New()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating wiring data")
+	}
+	err = wiring.LoadDataFrom(path, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading wiring data from %s", path)
+	}
+
+	return data, nil
+}
+
+// DiffData compares all objects of two wiring diagrams and builds the minimal apply plan, only switches, servers and
+// connections could be changed live, any change of other objects is unsafe
+func DiffData(from, to *wiring.Data) (*Diff, error) {
+	diff := &Diff{}
+
+	fromIPv4NSs, toIPv4NSs := map[string]any{}, map[string]any{}
+	for _, ns := range from.IPv4Namespace.All() {
+		fromIPv4NSs[ns.Name] = ns.Spec
+	}
+	for _, ns := range to.IPv4Namespace.All() {
+		toIPv4NSs[ns.Name] = ns.Spec
+	}
+
+	fromVLANNSs, toVLANNSs := map[string]any{}, map[string]any{}
+	for _, ns := range from.VLANNamespace.All() {
+		fromVLANNSs[ns.Name] = ns.Spec
+	}
+	for _, ns := range to.VLANNamespace.All() {
+		toVLANNSs[ns.Name] = ns.Spec
+	}
+
+	fromRacks, toRacks := map[string]any{}, map[string]any{}
+	for _, rack := range from.Rack.All() {
+		fromRacks[rack.Name] = rack.Spec
+	}
+	for _, rack := range to.Rack.All() {
+		toRacks[rack.Name] = rack.Spec
+	}
+
+	fromGroups, toGroups := map[string]any{}, map[string]any{}
+	for _, sg := range from.SwitchGroup.All() {
+		fromGroups[sg.Name] = sg.Spec
+	}
+	for _, sg := range to.SwitchGroup.All() {
+		toGroups[sg.Name] = sg.Spec
+	}
+
+	fromSwitches, toSwitches := map[string]any{}, map[string]any{}
+	for _, sw := range from.Switch.All() {
+		fromSwitches[sw.Name] = sw.Spec
+	}
+	for _, sw := range to.Switch.All() {
+		toSwitches[sw.Name] = sw.Spec
+	}
+
+	fromServers, toServers := map[string]any{}, map[string]any{}
+	for _, srv := range from.Server.All() {
+		fromServers[srv.Name] = srv.Spec
+	}
+	for _, srv := range to.Server.All() {
+		toServers[srv.Name] = srv.Spec
+	}
+
+	fromConns, toConns := map[string]any{}, map[string]any{}
+	connSwitches := map[string][]string{}
+	for _, data := range []struct {
+		wiring *wiring.Data
+		conns  map[string]any
+	}{{from, fromConns}, {to, toConns}} {
+		for _, conn := range data.wiring.Connection.All() {
+			data.conns[conn.Name] = conn.Spec
+
+			sws, _, _, _, err := conn.Spec.Endpoints()
+			if err != nil {
+				return nil, errors.Wrapf(err, "error getting endpoints for connection %s", conn.Name)
+			}
+			for _, sw := range sws {
+				if !slices.Contains(connSwitches[conn.Name], sw) {
+					connSwitches[conn.Name] = append(connSwitches[conn.Name], sw)
+				}
+			}
+		}
+	}
+
+	for _, kind := range []struct {
+		kind     string
+		from, to map[string]any
+		unsafe   func(fields []string) []string
+	}{
+		{KindIPv4Namespace, fromIPv4NSs, toIPv4NSs, unsafeAnyChanges(KindIPv4Namespace)},
+		{KindVLANNamespace, fromVLANNSs, toVLANNSs, unsafeAnyChanges(KindVLANNamespace)},
+		{KindRack, fromRacks, toRacks, unsafeAnyChanges(KindRack)},
+		{KindSwitchGroup, fromGroups, toGroups, unsafeAnyChanges(KindSwitchGroup)},
+		{KindSwitch, fromSwitches, toSwitches, unsafeSwitchChanges},
+		{KindServer, fromServers, toServers, unsafeServerChanges},
+		{KindConnection, fromConns, toConns, unsafeConnectionChanges},
+	} {
+		changes, err := diffObjects(kind.kind, kind.from, kind.to, kind.unsafe)
+		if err != nil {
+			return nil, err
+		}
+
+		for idx := range changes {
+			change := &changes[idx]
+			switch change.Kind {
+			case KindIPv4Namespace, KindVLANNamespace, KindRack, KindSwitchGroup:
+				// added and removed objects aren't checked by diffObjects
+				change.Unsafe = kind.unsafe(nil)
+			case KindSwitch:
+				change.Switches = []string{change.Name}
+			case KindConnection:
+				change.Switches = connSwitches[change.Name]
+				sort.Strings(change.Switches)
+			}
+		}
+
+		diff.Changes = append(diff.Changes, changes...)
+	}
+
+	diff.Plan = buildPlan(diff.Changes)
+
+	return diff, nil
+}
+
+func diffObjects(kind string, from, to map[string]any, unsafe func([]string) []string) ([]Change, error) {
+	names := []string{}
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, exist := from[name]; !exist {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []Change{}
+	for _, name := range names {
+		fromSpec, inFrom := from[name]
+		toSpec, inTo := to[name]
+
+		switch {
+		case !inFrom:
+			changes = append(changes, Change{Kind: kind, Name: name, Type: ChangeAdded})
+		case !inTo:
+			changes = append(changes, Change{Kind: kind, Name: name, Type: ChangeRemoved})
+		default:
+			fields, err := changedSpecFields(fromSpec, toSpec)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error comparing %s %s", kind, name)
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			changes = append(changes, Change{Kind: kind, Name: name, Type: ChangeChanged, Fields: fields, Unsafe: unsafe(fields)})
+		}
+	}
+
+	return changes, nil
+}
+
+// changedSpecFields returns json paths of all fields that differ between specs
+func changedSpecFields(from, to any) ([]string, error) {
+	fromMap, err := toGeneric(from)
+	if err != nil {
+		return nil, err
+	}
+	toMap, err := toGeneric(to)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := changedFields("", fromMap, toMap)
+	sort.Strings(fields)
+
+	return fields, nil
+}
+
+func toGeneric(obj any) (any, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling")
+	}
+
+	var res any
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling")
+	}
+
+	return res, nil
+}
+
+func changedFields(path string, from, to any) []string {
+	fromMap, fromIsMap := from.(map[string]any)
+	toMap, toIsMap := to.(map[string]any)
+	if fromIsMap && toIsMap {
+		res := []string{}
+		for key, value := range fromMap {
+			res = append(res, changedFields(joinPath(path, key), value, toMap[key])...)
+		}
+		for key, value := range toMap {
+			if _, exist := fromMap[key]; !exist {
+				res = append(res, changedFields(joinPath(path, key), nil, value)...)
+			}
+		}
+
+		return res
+	}
+
+	fromList, fromIsList := from.([]any)
+	toList, toIsList := to.([]any)
+	if fromIsList && toIsList && len(fromList) == len(toList) {
+		res := []string{}
+		for idx := range fromList {
+			res = append(res, changedFields(fmt.Sprintf("%s[%d]", path, idx), fromList[idx], toList[idx])...)
+		}
+
+		return res
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return nil
+	}
+
+	return []string{path}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// fieldName returns the last element of the json path without list index
+func fieldName(path string) string {
+	if idx := strings.LastIndex(path, "."); idx >= 0 {
+		path = path[idx+1:]
+	}
+	if idx := strings.Index(path, "["); idx >= 0 {
+		path = path[:idx]
+	}
+
+	return path
+}
+
+// unsafeAnyChanges returns unsafe reason for any change of the objects that aren't applied live by fabric controller
+func unsafeAnyChanges(kind string) func([]string) []string {
+	return func([]string) []string {
+		return []string{kind + " changes can't be applied live"}
+	}
+}
+
+func unsafeSwitchChanges(fields []string) []string {
+	res := []string{}
+	for _, field := range fields {
+		if slices.Contains(unsafeSwitchFields, field) {
+			res = append(res, field+" change requires switch re-provisioning")
+		}
+	}
+
+	return res
+}
+
+func unsafeServerChanges(fields []string) []string {
+	res := []string{}
+	for _, field := range fields {
+		if field == "type" {
+			res = append(res, "server type (role) change requires re-install")
+		}
+	}
+
+	return res
+}
+
+func unsafeConnectionChanges(fields []string) []string {
+	res := []string{}
+
+	for _, field := range fields {
+		if name := fieldName(field); name == "ip" || strings.HasSuffix(name, "IP") {
+			res = append(res, field+" change requires re-provisioning of the connected switches")
+		}
+	}
+
+	// top level spec fields are connection types, so whole one added or removed means type change
+	if slices.ContainsFunc(fields, func(field string) bool { return !strings.ContainsAny(field, ".[") }) {
+		res = append(res, "connection type change requires re-creating it")
+	}
+
+	return res
+}
+
+func buildPlan(changes []Change) []PlanStep {
+	plan := []PlanStep{}
+
+	add := func(action PlanAction, changeType ChangeType, kinds ...string) {
+		for _, kind := range kinds {
+			for _, change := range changes {
+				if change.Kind != kind || change.Type != changeType {
+					continue
+				}
+
+				plan = append(plan, PlanStep{Action: action, Kind: change.Kind, Name: change.Name, Unsafe: change.IsUnsafe()})
+			}
+		}
+	}
+
+	// connections are referencing switches and servers, so they are created after and deleted before them, same for
+	// switches referencing namespaces, racks and groups
+	add(PlanCreate, ChangeAdded, KindIPv4Namespace, KindVLANNamespace, KindRack, KindSwitchGroup)
+	add(PlanUpdate, ChangeChanged, KindIPv4Namespace, KindVLANNamespace, KindRack, KindSwitchGroup)
+	add(PlanCreate, ChangeAdded, KindSwitch, KindServer)
+	add(PlanUpdate, ChangeChanged, KindSwitch, KindServer)
+	add(PlanDelete, ChangeRemoved, KindConnection)
+	add(PlanUpdate, ChangeChanged, KindConnection)
+	add(PlanCreate, ChangeAdded, KindConnection)
+	add(PlanDelete, ChangeRemoved, KindServer, KindSwitch)
+	add(PlanDelete, ChangeRemoved, KindSwitchGroup, KindRack, KindVLANNamespace, KindIPv4Namespace)
+
+	return plan
+}
+
+// Print writes human readable diff and apply plan
+func (d *Diff) Print(w io.Writer) error {
+	if d.IsEmpty() {
+		_, err := fmt.Fprintln(w, "No changes")
+
+		return errors.Wrapf(err, "error printing diff")
+	}
+
+	sb := &strings.Builder{}
+
+	sb.WriteString("Changes:\n")
+	for _, change := range d.Changes {
+		sign := map[ChangeType]string{ChangeAdded: "+", ChangeRemoved: "-", ChangeChanged: "~"}[change.Type]
+		fmt.Fprintf(sb, "  %s %s %s", sign, strings.ToLower(change.Kind), change.Name)
+		if len(change.Switches) > 0 && change.Kind == KindConnection {
+			fmt.Fprintf(sb, " (switches: %s)", strings.Join(change.Switches, ", "))
+		}
+		sb.WriteString("\n")
+
+		for _, field := range change.Fields {
+			fmt.Fprintf(sb, "      %s\n", field)
+		}
+		for _, reason := range change.Unsafe {
+			fmt.Fprintf(sb, "      UNSAFE: %s\n", reason)
+		}
+	}
+
+	sb.WriteString("\nPlan:\n")
+	for idx, step := range d.Plan {
+		fmt.Fprintf(sb, "  %d. %s %s %s", idx+1, step.Action, strings.ToLower(step.Kind), step.Name)
+		if step.Unsafe {
+			sb.WriteString(" (UNSAFE, can't be applied live)")
+		}
+		sb.WriteString("\n")
+	}
+
+	if d.IsUnsafe() {
+		sb.WriteString("\nSome changes can't be applied live by the fabric controller, affected switches should be re-provisioned\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+
+	return errors.Wrapf(err, "error printing diff")
+}
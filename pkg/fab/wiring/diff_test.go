@@ -0,0 +1,162 @@
+// Copyright 2023 Hedgehog
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wiring
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_DiffObjects(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		from   map[string]any
+		to     map[string]any
+		unsafe func([]string) []string
+		want   []Change
+	}{
+		{
+			name:   "same",
+			kind:   KindSwitch,
+			from:   map[string]any{"leaf-01": map[string]any{"role": "server-leaf", "asn": 65101}},
+			to:     map[string]any{"leaf-01": map[string]any{"role": "server-leaf", "asn": 65101}},
+			unsafe: unsafeSwitchChanges,
+			want:   []Change{},
+		},
+		{
+			name:   "added-removed",
+			kind:   KindServer,
+			from:   map[string]any{"server-01": map[string]any{}},
+			to:     map[string]any{"server-02": map[string]any{}},
+			unsafe: unsafeServerChanges,
+			want: []Change{
+				{Kind: KindServer, Name: "server-01", Type: ChangeRemoved},
+				{Kind: KindServer, Name: "server-02", Type: ChangeAdded},
+			},
+		},
+		{
+			name:   "switch-safe",
+			kind:   KindSwitch,
+			from:   map[string]any{"leaf-01": map[string]any{"description": "old", "groups": []any{"mclag-1"}}},
+			to:     map[string]any{"leaf-01": map[string]any{"description": "new", "groups": []any{"mclag-1"}}},
+			unsafe: unsafeSwitchChanges,
+			want: []Change{
+				{Kind: KindSwitch, Name: "leaf-01", Type: ChangeChanged, Fields: []string{"description"}, Unsafe: []string{}},
+			},
+		},
+		{
+			name:   "switch-unsafe",
+			kind:   KindSwitch,
+			from:   map[string]any{"leaf-01": map[string]any{"role": "server-leaf", "asn": 65101}},
+			to:     map[string]any{"leaf-01": map[string]any{"role": "server-leaf", "asn": 65102}},
+			unsafe: unsafeSwitchChanges,
+			want: []Change{
+				{
+					Kind: KindSwitch, Name: "leaf-01", Type: ChangeChanged, Fields: []string{"asn"},
+					Unsafe: []string{"asn change requires switch re-provisioning"},
+				},
+			},
+		},
+		{
+			name: "connection-ip",
+			kind: KindConnection,
+			from: map[string]any{"conn": map[string]any{"fabric": map[string]any{"links": []any{
+				map[string]any{"spine": map[string]any{"port": "spine-01/E1/1", "ip": "172.30.30.0/31"}},
+			}}}},
+			to: map[string]any{"conn": map[string]any{"fabric": map[string]any{"links": []any{
+				map[string]any{"spine": map[string]any{"port": "spine-01/E1/1", "ip": "172.30.30.2/31"}},
+			}}}},
+			unsafe: unsafeConnectionChanges,
+			want: []Change{
+				{
+					Kind: KindConnection, Name: "conn", Type: ChangeChanged, Fields: []string{"fabric.links[0].spine.ip"},
+					Unsafe: []string{"fabric.links[0].spine.ip change requires re-provisioning of the connected switches"},
+				},
+			},
+		},
+		{
+			name:   "connection-type",
+			kind:   KindConnection,
+			from:   map[string]any{"conn": map[string]any{"unbundled": map[string]any{}}},
+			to:     map[string]any{"conn": map[string]any{"bundled": map[string]any{}}},
+			unsafe: unsafeConnectionChanges,
+			want: []Change{
+				{
+					Kind: KindConnection, Name: "conn", Type: ChangeChanged, Fields: []string{"bundled", "unbundled"},
+					Unsafe: []string{"connection type change requires re-creating it"},
+				},
+			},
+		},
+		{
+			name:   "vlan-namespace",
+			kind:   KindVLANNamespace,
+			from:   map[string]any{"default": map[string]any{"ranges": []any{map[string]any{"from": 1000, "to": 2999}}}},
+			to:     map[string]any{"default": map[string]any{"ranges": []any{map[string]any{"from": 1000, "to": 1999}}}},
+			unsafe: unsafeAnyChanges(KindVLANNamespace),
+			want: []Change{
+				{
+					Kind: KindVLANNamespace, Name: "default", Type: ChangeChanged, Fields: []string{"ranges[0].to"},
+					Unsafe: []string{"VLANNamespace changes can't be applied live"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := diffObjects(test.kind, test.from, test.to, test.unsafe)
+			if err != nil {
+				t.Fatalf("error diffing objects: %v", err)
+			}
+
+			if !reflect.DeepEqual(changes, test.want) {
+				t.Errorf("changes mismatch:\ngot  %#v\nwant %#v", changes, test.want)
+			}
+		})
+	}
+}
+
+func Test_BuildPlan(t *testing.T) {
+	changes := []Change{
+		{Kind: KindSwitchGroup, Name: "mclag-2", Type: ChangeAdded, Unsafe: []string{"group"}},
+		{Kind: KindIPv4Namespace, Name: "old", Type: ChangeRemoved, Unsafe: []string{"ns"}},
+		{Kind: KindSwitch, Name: "leaf-02", Type: ChangeAdded},
+		{Kind: KindSwitch, Name: "leaf-03", Type: ChangeRemoved},
+		{Kind: KindSwitch, Name: "leaf-01", Type: ChangeChanged, Unsafe: []string{"asn"}},
+		{Kind: KindServer, Name: "server-02", Type: ChangeAdded},
+		{Kind: KindServer, Name: "server-03", Type: ChangeRemoved},
+		{Kind: KindConnection, Name: "conn-02", Type: ChangeAdded},
+		{Kind: KindConnection, Name: "conn-03", Type: ChangeRemoved},
+		{Kind: KindConnection, Name: "conn-01", Type: ChangeChanged},
+	}
+
+	want := []PlanStep{
+		{Action: PlanCreate, Kind: KindSwitchGroup, Name: "mclag-2", Unsafe: true},
+		{Action: PlanCreate, Kind: KindSwitch, Name: "leaf-02"},
+		{Action: PlanCreate, Kind: KindServer, Name: "server-02"},
+		{Action: PlanUpdate, Kind: KindSwitch, Name: "leaf-01", Unsafe: true},
+		{Action: PlanDelete, Kind: KindConnection, Name: "conn-03"},
+		{Action: PlanUpdate, Kind: KindConnection, Name: "conn-01"},
+		{Action: PlanCreate, Kind: KindConnection, Name: "conn-02"},
+		{Action: PlanDelete, Kind: KindServer, Name: "server-03"},
+		{Action: PlanDelete, Kind: KindSwitch, Name: "leaf-03"},
+		{Action: PlanDelete, Kind: KindIPv4Namespace, Name: "old", Unsafe: true},
+	}
+
+	if plan := buildPlan(changes); !reflect.DeepEqual(plan, want) {
+		t.Errorf("plan mismatch:\ngot  %#v\nwant %#v", plan, want)
+	}
+}
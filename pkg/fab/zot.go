@@ -16,6 +16,8 @@ package fab
 
 import (
 	_ "embed"
+	"fmt"
+	"log/slog"
 	"time"
 
 	helm "github.com/k3s-io/helm-controller/pkg/apis/helm.cattle.io/v1"
@@ -32,10 +34,12 @@ import (
 var zotValuesTemplate string
 
 type Zot struct {
-	cnc.NoValidationComponent
-
 	Ref cnc.Ref `json:"ref,omitempty"`
 	TLS ZotTLS  `json:"tls,omitempty"`
+	// Replicas > 1 requires StorageClass with ReadWriteMany support shared between control nodes
+	Replicas     int    `json:"replicas,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	StorageSize  string `json:"storageSize,omitempty"`
 }
 
 type ZotTLS struct {
@@ -43,7 +47,11 @@ type ZotTLS struct {
 	Server cnc.KeyPair `json:"server,omitempty"`
 }
 
-var _ cnc.Component = (*Zot)(nil)
+var (
+	_ cnc.Component       = (*Zot)(nil)
+	_ cnc.CertProvider    = (*Zot)(nil)
+	_ cnc.SecretsProvider = (*Zot)(nil)
+)
 
 func (cfg *Zot) Name() string {
 	return "zot"
@@ -54,105 +62,185 @@ func (cfg *Zot) IsEnabled(_ cnc.Preset) bool {
 }
 
 func (cfg *Zot) Flags() []cli.Flag {
-	return nil
+	return []cli.Flag{
+		&cli.IntFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "zot-replicas",
+			Usage:       "number of registry replicas, more than one requires shared storage class (default: 1)",
+			Destination: &cfg.Replicas,
+		},
+		&cli.StringFlag{
+			Category:    cfg.Name() + CategoryConfigBaseSuffix,
+			Name:        "zot-storage-class",
+			Usage:       "storage `CLASS` for the registry, should support ReadWriteMany if replicated (default: k3s local path)",
+			Destination: &cfg.StorageClass,
+		},
+	}
 }
 
-func (cfg *Zot) Hydrate(_ cnc.Preset, _ meta.FabricMode) error {
+func (cfg *Zot) Hydrate(_ cnc.Preset, _ meta.FabricMode, get cnc.GetComponent) error {
 	cfg.Ref = cfg.Ref.Fallback(RefZot)
 
-	err := cfg.TLS.CA.Ensure(OCIRepoCACN, nil, KeyUsageCA, nil, nil, nil)
-	if err != nil {
-		return errors.Wrapf(err, "error ensuring OCI Repo CA")
+	if cfg.Replicas == 0 {
+		cfg.Replicas = 1
+	}
+	if cfg.StorageSize == "" {
+		cfg.StorageSize = "30Gi"
 	}
 
-	err = cfg.TLS.Server.Ensure(OCIRepoServerCN, &cfg.TLS.CA, KeyUsageServer, nil,
-		[]string{ControlVIP}, []string{"registry.local", "registry.default", "registry.default.svc.cluster.local"})
-	if err != nil {
-		return errors.Wrap(err, "error ensuring OCI Repo Certs")
+	return errors.Wrap(cnc.EnsureCerts(cfg.Certs(get)), "error ensuring OCI Repo certs")
+}
+
+func (cfg *Zot) Certs(get cnc.GetComponent) []cnc.CertSpec {
+	return []cnc.CertSpec{
+		{
+			Name:     "OCI Repo CA",
+			KeyPair:  &cfg.TLS.CA,
+			CN:       OCIRepoCACN,
+			Subject:  BaseConfig(get).CertSubject,
+			KeyUsage: KeyUsageCA,
+		},
+		{
+			Name:     "OCI Repo Server",
+			KeyPair:  &cfg.TLS.Server,
+			Parent:   &cfg.TLS.CA,
+			CN:       OCIRepoServerCN,
+			Subject:  BaseConfig(get).CertSubject,
+			KeyUsage: KeyUsageServer,
+			IPs:      []string{BaseConfig(get).ControlVIP},
+			DNSNames: []string{"registry.local", "registry.default", "registry.default.svc.cluster.local"},
+		},
+	}
+}
+
+func (cfg *Zot) SecretFields() []string {
+	return append(cnc.KeyPairSecretFields("tls.ca"), cnc.KeyPairSecretFields("tls.server")...)
+}
+
+func (cfg *Zot) Validate(_ string, _ cnc.Preset, _ meta.FabricMode, _ cnc.GetComponent, _ *wiring.Data) error {
+	if cfg.Replicas < 1 {
+		return errors.Errorf("registry replicas should be positive, got %d", cfg.Replicas)
+	}
+	if cfg.Replicas > 1 && cfg.StorageClass == "" {
+		return errors.Errorf("registry storage class with ReadWriteMany support is required for %d replicas", cfg.Replicas)
 	}
 
 	return nil
 }
 
-func (cfg *Zot) Build(_ string, _ cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, _ *wiring.Data, run cnc.AddBuildOp, install cnc.AddRunOp) error {
+func (cfg *Zot) Build(_ string, preset cnc.Preset, _ meta.FabricMode, get cnc.GetComponent, data *wiring.Data, run cnc.AddBuildOp, install cnc.AddRunOp) error {
 	cfg.Ref = cfg.Ref.Fallback(BaseConfig(get).Source)
 
-	run(BundleControlInstall, StageInstall0Prep, "zot-airgap-files",
-		&cnc.FilesORAS{
-			Ref: cfg.Ref.Fallback(BaseConfig(get).Source),
-			Files: []cnc.File{
-				{
-					Name:          "zot-airgap-images-amd64.tar.gz", // TODO try to switch to full image, maybe have UI
-					InstallTarget: "/var/lib/rancher/k3s/agent/images",
-				},
-				{
-					Name:          "zot.tgz", // TODO rename to zot-chart.tgz
-					InstallTarget: "/var/lib/rancher/k3s/server/static/charts",
-					InstallName:   "hh-zot-chart.tgz",
-				},
-			},
-		})
-
-	run(BundleControlInstall, StageInstall0Prep, "zot-install",
-		&cnc.FileGenerate{
-			File: cnc.File{
-				Name:          "zot-install.yaml",
-				InstallTarget: "/var/lib/rancher/k3s/server/manifests",
-				InstallName:   "hh-zot-install.yaml",
-			},
-			Content: cnc.FromKubeObjects(
-				cnc.KubeHelmChart("zot", "default", helm.HelmChartSpec{
-					TargetNamespace: "default",
-					Chart:           "https://%{KUBERNETES_API}%/static/charts/hh-zot-chart.tgz",
-				}, cnc.FromTemplate(zotValuesTemplate, "ref", RefZotTargetImage.Fallback(cfg.Ref))),
-				cnc.KubeService("registry", "default", core.ServiceSpec{
-					Type: core.ServiceTypeNodePort,
-					Ports: []core.ServicePort{
-						{
-							Name:       "zot",
-							Port:       5000,
-							NodePort:   int32(ZotNodePort),
-							TargetPort: intstr.FromString("zot"),
-							Protocol:   core.ProtocolTCP,
-						},
+	nodes, err := K3sConfig(get).controlNodes(preset, data)
+	if err != nil {
+		return err
+	}
+	if len(nodes) > 1 && cfg.Replicas == 1 {
+		slog.Warn("Registry isn't replicated, it'll be unavailable if the control node running it fails", "controlNodes", len(nodes))
+	}
+
+	// registry can't serve its own image, so it's side-loaded on every control node it could be scheduled on and the
+	// chart is served by any of the API servers
+	for _, node := range nodes {
+		run(controlInstallBundle(node), StageInstall0Prep, "zot-airgap-files",
+			&cnc.FilesORAS{
+				Ref: cfg.Ref.Fallback(BaseConfig(get).Source),
+				Files: []cnc.File{
+					{
+						Name:          "zot-airgap-images-amd64.tar.gz", // TODO try to switch to full image, maybe have UI
+						InstallTarget: "/var/lib/rancher/k3s/agent/images",
+					},
+					{
+						Name:          "zot.tgz", // TODO rename to zot-chart.tgz
+						InstallTarget: "/var/lib/rancher/k3s/server/static/charts",
+						InstallName:   "hh-zot-chart.tgz",
 					},
-					Selector: map[string]string{
-						"app.kubernetes.io/instance": "zot",
-						"app.kubernetes.io/name":     "zot",
+				},
+			})
+	}
+
+	zotInstall := &cnc.FileGenerate{
+		File: cnc.File{
+			Name:          "zot-install.yaml",
+			InstallTarget: "/var/lib/rancher/k3s/server/manifests",
+			InstallName:   "hh-zot-install.yaml",
+		},
+		Content: cnc.FromKubeObjects(
+			cnc.KubeHelmChart("zot", "default", helm.HelmChartSpec{
+				TargetNamespace: "default",
+				Chart:           "https://%{KUBERNETES_API}%/static/charts/hh-zot-chart.tgz",
+			}, cnc.FromTemplate(zotValuesTemplate,
+				"cfg", cfg,
+				"ref", RefZotTargetImage.Fallback(cfg.Ref),
+			)),
+			cnc.KubeService("registry", "default", core.ServiceSpec{
+				Type: core.ServiceTypeNodePort,
+				Ports: []core.ServicePort{
+					{
+						Name:       "zot",
+						Port:       5000,
+						NodePort:   int32(ZotNodePort),
+						TargetPort: intstr.FromString("zot"),
+						Protocol:   core.ProtocolTCP,
 					},
-				}),
-				cnc.KubeSecret("zot-secret", "default", map[string]string{
-					"cert.pem": cfg.TLS.Server.Cert,
-					"key.pem":  cfg.TLS.Server.Key,
-				}),
-			),
-		})
-
-	run(BundleControlInstall, StageInstall0Prep, "zot-ca-file",
-		&cnc.FileGenerate{
-			File: cnc.File{
-				Name:          "zot-ca.crt",
-				InstallTarget: "/etc/ssl/certs",
-				InstallName:   "hh-registry-ca.pem",
-			},
-			Content: cnc.FromValue(cfg.TLS.CA.Cert),
-		})
-
-	install(BundleControlInstall, StageInstall0Prep, "zot-ca-install",
-		&cnc.ExecCommand{
-			Name: "update-ca-certificates",
-			Args: []string{"|", "grep", "-v", "=\\>"}, // don't print all cert names
-		})
-
-	install(BundleControlInstall, StageInstall1K3sZot, "zot-wait",
-		&cnc.WaitURL{
-			URL: ZotCheckURL,
-			Wait: cnc.WaitParams{
-				Delay:    10 * time.Second,
-				Interval: 5 * time.Second,
-				Attempts: 120, // ~10min
-			},
-		})
+				},
+				Selector: map[string]string{
+					"app.kubernetes.io/instance": "zot",
+					"app.kubernetes.io/name":     "zot",
+				},
+			}),
+			cnc.KubeSecret("zot-secret", "default", map[string]string{
+				"cert.pem": cfg.TLS.Server.FullChain(),
+				"key.pem":  cfg.TLS.Server.Key,
+			}),
+		),
+	}
+
+	// certs update is re-applying the same manifest with the new secret and restarting the registry
+	for _, bundle := range []cnc.Bundle{BundleControlInstall, BundleControlCertsUpdate} {
+		run(bundle, StageInstall0Prep, "zot-install", zotInstall)
+	}
+
+	// every control node is pulling images from the registry, so CA is installed and refreshed after rotation on all
+	bundles := []cnc.Bundle{BundleControlCertsUpdate}
+	for _, node := range nodes {
+		bundles = append(bundles, controlInstallBundle(node))
+		if node.Join {
+			bundles = append(bundles, BundleControlJoinCertsUpdate(node.Name))
+		}
+	}
+
+	for _, bundle := range bundles {
+		run(bundle, StageInstall0Prep, "zot-ca-file",
+			&cnc.FileGenerate{
+				File: cnc.File{
+					Name:          "zot-ca.crt",
+					InstallTarget: "/etc/ssl/certs",
+					InstallName:   "hh-registry-ca.pem",
+				},
+				Content: cnc.FromValue(cfg.TLS.CA.Cert),
+			})
+
+		install(bundle, StageInstall0Prep, "zot-ca-install",
+			&cnc.ExecCommand{
+				Name: "update-ca-certificates",
+				Args: []string{"|", "grep", "-v", "=\\>"}, // don't print all cert names
+			})
+	}
+
+	addCertsUpdate(install, StageInstall1K3sZot, "zot", "hh-zot-install.yaml", "deployment/zot")
+
+	for _, bundle := range []cnc.Bundle{BundleControlInstall, BundleControlCertsUpdate} {
+		install(bundle, StageInstall1K3sZot, "zot-wait",
+			&cnc.WaitURL{
+				URL: fmt.Sprintf("https://%s:%d/v2/_catalog", BaseConfig(get).ControlVIP, ZotNodePort),
+				Wait: cnc.WaitParams{
+					Delay:    10 * time.Second,
+					Interval: 5 * time.Second,
+					Attempts: 120, // ~10min
+				},
+			})
+	}
 
 	return nil
 }